@@ -228,11 +228,14 @@ def ensure_binaries():
             cmd_names.append(cmd_name)
     cmd_names.sort()
 
-    # Build the binaries:
+    # Build the binaries, including the version, so that the services can
+    # report it:
+    version = getattr(argv, "version", None) or "latest"
+    ldflags = "-X main.version={version}".format(version=version)
     for cmd_name in cmd_names:
         say("Building binary '{name}'".format(name=cmd_name))
         cmd_path = "{path}/cmd/{name}".format(path=IMPORT_PATH, name=cmd_name)
-        go_tool("go", "install", cmd_path)
+        go_tool("go", "install", "-ldflags", ldflags, cmd_path)
 
     # Build the result:
     go_bin = ensure_go_bin()
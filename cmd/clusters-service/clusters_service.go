@@ -1,29 +1,38 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
-	_ "github.com/lib/pq"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	pgsql "github.com/container-mgmt/dedicated-portal/pkg/sql"
 	"github.com/segmentio/ksuid"
 )
 
-// ClustersService performs operations on clusters.
+// ClustersService performs operations on clusters. The operations are
+// canceled, and their spans are part of the trace, of the given context.
 type ClustersService interface {
-	List(args ListArguments) (clusters ClustersResult, err error)
-	Create(name string) (result Cluster, err error)
-	Get(uuid string) (result Cluster, err error)
+	List(ctx context.Context, args ListArguments) (clusters ClustersResult, err error)
+	Create(ctx context.Context, spec Cluster) (result Cluster, err error)
+	Get(ctx context.Context, uuid string) (result Cluster, err error)
+	Usage(ctx context.Context, customerID string) (result QuotaUsage, err error)
 }
 
 // GenericClustersService is a ClusterService placeholder implementation.
 type GenericClustersService struct {
-	connectionUrl string
+	db     *sql.DB
+	quotas QuotaClient
 }
 
 // ListArguments are arguments relevant for listing objects.
 type ListArguments struct {
 	Page int
 	Size int
+
+	// CustomerID restricts the list to the clusters of the given customer.
+	// If it is empty the clusters of all the customers are listed.
+	CustomerID string
 }
 
 // ClustersResult is a result for a List request of Clusters.
@@ -36,75 +45,167 @@ type ClustersResult struct {
 
 // Cluster represents an OpenShift cluster.
 type Cluster struct {
-	Name string `json:"name,omitempty"`
-	UUID string `json:"id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	UUID         string `json:"id,omitempty"`
+	CustomerID   string `json:"customer_id,omitempty"`
+	Region       string `json:"region,omitempty"`
+	InstanceType string `json:"instance_type,omitempty"`
+	Nodes        int    `json:"nodes"`
+}
+
+// InvalidClusterError is returned when the specification of a cluster isn't
+// valid.
+type InvalidClusterError struct {
+	Reason string
+}
+
+func (e *InvalidClusterError) Error() string {
+	return fmt.Sprintf("invalid cluster: %s", e.Reason)
+}
+
+// ClusterNotFoundError is returned when the requested cluster doesn't exist.
+type ClusterNotFoundError struct {
+	UUID string
 }
 
-// NewClustersService Creates a new ClustersService.
-func NewClustersService(connectionUrl string) ClustersService {
+func (e *ClusterNotFoundError) Error() string {
+	return fmt.Sprintf("cluster '%s' doesn't exist", e.UUID)
+}
+
+// NewClustersService Creates a new ClustersService that stores the clusters
+// in the database of the given connection pool. If the quota client isn't
+// nil it will be used to check the quota of the customer before creating
+// clusters.
+func NewClustersService(db *sql.DB, quotas QuotaClient) ClustersService {
 	service := new(GenericClustersService)
-	service.connectionUrl = connectionUrl
+	service.db = db
+	service.quotas = quotas
 	return service
 }
 
 // List returns lists of clusters.
-func (cs GenericClustersService) List(args ListArguments) (result ClustersResult, err error) {
-	result.Items = make([]Cluster, 0)
-	db, err := sql.Open("postgres", cs.connectionUrl)
+func (cs GenericClustersService) List(ctx context.Context, args ListArguments) (result ClustersResult, err error) {
+	var items []Cluster
+	var total int
+	err = pgsql.Retry(ctx, func() error {
+		items, err = cs.list(ctx, args)
+		if err != nil {
+			return err
+		}
+		total, err = cs.count(ctx, args)
+		return err
+	})
 	if err != nil {
-		return ClustersResult{}, fmt.Errorf("Error openning connection: %v", err)
+		return ClustersResult{}, err
 	}
-	defer db.Close()
-	rows, err := db.Query(`SELECT uuid, name
+	result.Items = items
+	result.Total = total
+	result.Page = args.Page
+	result.Size = len(result.Items)
+	return result, nil
+}
+
+// count returns the number of clusters in all the pages of the list.
+func (cs GenericClustersService) count(ctx context.Context, args ListArguments) (total int, err error) {
+	err = cs.db.QueryRowContext(ctx, `SELECT count(*)
 		FROM clusters
+		WHERE $1 = '' OR customer_id = $1`,
+		args.CustomerID,
+	).Scan(&total)
+	return total, err
+}
+
+func (cs GenericClustersService) list(ctx context.Context, args ListArguments) (items []Cluster, err error) {
+	items = make([]Cluster, 0)
+	rows, err := cs.db.QueryContext(ctx, `SELECT uuid, name, customer_id, region, instance_type, nodes
+		FROM clusters
+		WHERE $3 = '' OR customer_id = $3
 		ORDER BY uuid
 		LIMIT $1
 		OFFSET $2`,
 		args.Size,
 		args.Page*args.Size,
+		args.CustomerID,
 	)
 	if err != nil {
-		return ClustersResult{}, fmt.Errorf("Error executing query: %v", err)
+		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var uuid, name string
-		err = rows.Scan(&uuid, &name)
+		var cluster Cluster
+		err = rows.Scan(
+			&cluster.UUID,
+			&cluster.Name,
+			&cluster.CustomerID,
+			&cluster.Region,
+			&cluster.InstanceType,
+			&cluster.Nodes,
+		)
 		if err != nil {
-			return ClustersResult{}, err
+			return nil, err
 		}
-		result.Items = append(result.Items, Cluster{
-			Name: name,
-			UUID: uuid,
-		})
+		items = append(items, cluster)
 	}
 	err = rows.Err() // get any error encountered during iteration
 	if err != nil {
-		return ClustersResult{}, err
+		return nil, err
 	}
-	result.Page = args.Page
-	result.Size = len(result.Items)
-	return result, nil
+	return items, nil
 }
 
-// Create saves a new cluster definition in the Database
-func (cs GenericClustersService) Create(name string) (result Cluster, err error) {
+// Create saves a new cluster definition in the Database. If the service has a
+// quota client the quota of the customer is checked first, and the cluster is
+// rejected if it would exceed it. The cluster is then registered as owned by
+// the customer in the customers service, and deleted if that fails.
+func (cs GenericClustersService) Create(ctx context.Context, spec Cluster) (result Cluster, err error) {
+	if spec.Nodes < 0 {
+		return Cluster{}, &InvalidClusterError{Reason: "number of nodes can't be negative"}
+	}
+	var quota *Quota
+	if cs.quotas != nil {
+		if spec.CustomerID == "" {
+			return Cluster{}, &InvalidClusterError{Reason: "customer identifier is mandatory"}
+		}
+		quota, err = cs.quotas.GetQuota(ctx, spec.CustomerID)
+		if err != nil {
+			return Cluster{}, err
+		}
+	}
 	uuid, err := ksuid.NewRandom()
 	if err != nil {
 		return Cluster{}, err
 	}
-	db, err := sql.Open("postgres", cs.connectionUrl)
+	tx, err := cs.db.BeginTx(ctx, nil)
 	if err != nil {
 		return Cluster{}, err
 	}
-	defer db.Close()
-	stmt, err := db.Prepare(`INSERT INTO clusters (uuid, name)
-		VALUES ($1, $2)`)
-	if err != nil {
-		return Cluster{}, err
+	defer tx.Rollback()
+	if quota != nil {
+		// Serialize the creation of clusters of the same customer, so that
+		// concurrent requests can't exceed the quota together:
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, spec.CustomerID)
+		if err != nil {
+			return Cluster{}, err
+		}
+		usage, err := usageOf(ctx, tx, spec.CustomerID)
+		if err != nil {
+			return Cluster{}, err
+		}
+		err = quota.Check(usage, spec)
+		if err != nil {
+			return Cluster{}, err
+		}
 	}
-	defer stmt.Close()
-	queryResult, err := stmt.Exec(uuid, name)
+	queryResult, err := tx.ExecContext(ctx, `INSERT INTO clusters
+		(uuid, name, customer_id, region, instance_type, nodes)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		uuid.String(),
+		spec.Name,
+		spec.CustomerID,
+		spec.Region,
+		spec.InstanceType,
+		spec.Nodes,
+	)
 	if err != nil {
 		return Cluster{}, err
 	}
@@ -117,23 +218,79 @@ func (cs GenericClustersService) Create(name string) (result Cluster, err error)
 			inserted,
 		)
 	}
-	return Cluster{
-		Name: name,
-		UUID: fmt.Sprintf("%s", uuid),
-	}, nil
+	err = tx.Commit()
+	if err != nil {
+		return Cluster{}, err
+	}
+	if cs.quotas != nil {
+		// The customers service is the authority on the ownership of the
+		// clusters, so the cluster is registered there after committing,
+		// without holding the lock or the connection during the request,
+		// and it is deleted if that fails:
+		err = cs.quotas.AddOwnedCluster(ctx, spec.CustomerID, uuid.String())
+		if err != nil {
+			_, deleteErr := cs.db.ExecContext(ctx, `DELETE FROM clusters WHERE uuid = $1`, uuid.String())
+			if deleteErr != nil {
+				logging.Errorf("Can't delete cluster '%s' that couldn't be registered: %v", uuid, deleteErr)
+			}
+			return Cluster{}, err
+		}
+	}
+	result = spec
+	result.UUID = uuid.String()
+	return result, nil
 }
 
-// Get returns a single cluster by id
-func (cs GenericClustersService) Get(uuid string) (result Cluster, err error) {
-	db, err := sql.Open("postgres", cs.connectionUrl)
+// Get returns a single cluster by id, or ClusterNotFoundError if it doesn't
+// exist.
+func (cs GenericClustersService) Get(ctx context.Context, uuid string) (result Cluster, err error) {
+	err = pgsql.Retry(ctx, func() error {
+		return cs.db.QueryRowContext(ctx, `SELECT uuid, name, customer_id, region, instance_type, nodes
+			FROM clusters
+			WHERE uuid = $1`,
+			uuid,
+		).Scan(
+			&result.UUID,
+			&result.Name,
+			&result.CustomerID,
+			&result.Region,
+			&result.InstanceType,
+			&result.Nodes,
+		)
+	})
+	if err == sql.ErrNoRows {
+		return Cluster{}, &ClusterNotFoundError{UUID: uuid}
+	}
 	if err != nil {
 		return Cluster{}, err
 	}
-	defer db.Close()
-	var name string
-	err = db.QueryRow("SELECT uuid, name FROM clusters	WHERE uuid = $1", uuid).Scan(&uuid, &name)
+	return result, nil
+}
+
+// Usage returns the resources currently used by the clusters of a customer.
+func (cs GenericClustersService) Usage(ctx context.Context, customerID string) (result QuotaUsage, err error) {
+	err = pgsql.Retry(ctx, func() error {
+		result, err = usageOf(ctx, cs.db, customerID)
+		return err
+	})
+	return result, err
+}
+
+// queryRower is the part of the sql.DB and sql.Tx types used to run queries
+// that return a single row.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func usageOf(ctx context.Context, db queryRower, customerID string) (result QuotaUsage, err error) {
+	result.CustomerID = customerID
+	err = db.QueryRowContext(ctx, `SELECT count(*), coalesce(sum(nodes), 0)
+		FROM clusters
+		WHERE customer_id = $1`,
+		customerID,
+	).Scan(&result.Clusters, &result.Nodes)
 	if err != nil {
-		return Cluster{}, err
+		return QuotaUsage{}, err
 	}
-	return Cluster{uuid, name}, nil
+	return result, nil
 }
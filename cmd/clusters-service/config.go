@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/config"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/sql"
+	"github.com/spf13/cobra"
+)
+
+// Config is the configuration of the clusters service. It is loaded from
+// the configuration file, the environment and the command line, in that
+// order of precedence.
+type Config struct {
+	Logging          config.Logging         `yaml:"logging"`
+	Database         config.Database        `yaml:"database"`
+	Migrations       MigrationsConfig       `yaml:"migrations"`
+	MigrateOnStart   bool                   `yaml:"migrate_on_start" env:"MIGRATE_ON_START" flag:"migrate-on-start" help:"Apply the pending database migrations before serving. Disable when the migrations are applied by a separate job with the 'migrate up' command."`
+	CustomersService CustomersServiceConfig `yaml:"customers_service"`
+	Auth             config.Auth            `yaml:"auth"`
+	RateLimits       config.RateLimits      `yaml:"rate_limits"`
+	TLS              config.TLS             `yaml:"tls"`
+	Tracing          config.Tracing         `yaml:"tracing"`
+	Shutdown         config.Shutdown        `yaml:"shutdown"`
+}
+
+// CustomersServiceConfig is the location of the customers service, used to
+// enforce the quotas and to verify the API keys of service accounts.
+type CustomersServiceConfig struct {
+	URL       string `yaml:"url" env:"CUSTOMERS_SERVICE_URL" flag:"customers-service-url" help:"URL of the customers service. If empty quotas aren't enforced and API keys aren't accepted."`
+	TokenFile string `yaml:"token_file" env:"CUSTOMERS_SERVICE_TOKEN_FILE" flag:"customers-service-token-file" help:"File containing the bearer token sent to the customers service."`
+}
+
+// MigrationsConfig is the configuration of the migrations of the database
+// schema.
+type MigrationsConfig struct {
+	Dir         string        `yaml:"dir" env:"MIGRATIONS_DIR" flag:"migrations-dir" help:"Directory containing the database migrations. If empty the migrations compiled into the binary are used."`
+	WaitTimeout time.Duration `yaml:"wait_timeout" env:"MIGRATIONS_WAIT_TIMEOUT" flag:"migrations-wait-timeout" help:"Maximum time waiting for the database to accept connections before running the migrations."`
+	LockTimeout time.Duration `yaml:"lock_timeout" env:"MIGRATIONS_LOCK_TIMEOUT" flag:"migrations-lock-timeout" help:"Maximum time waiting for other replica that is running the migrations to finish."`
+}
+
+// defaultConfig returns the configuration used when nothing else is given.
+// Creating clusters is expensive, so it has its own lower rate limit.
+func defaultConfig() Config {
+	database := config.DefaultDatabase()
+	database.ApplicationName = "clusters-service"
+	return Config{
+		Logging: config.Logging{
+			Level: "info",
+		},
+		Database: database,
+		Migrations: MigrationsConfig{
+			WaitTimeout: sql.DefaultWaitTimeout,
+			LockTimeout: sql.DefaultLockTimeout,
+		},
+		MigrateOnStart: true,
+		RateLimits: config.RateLimits{
+			Read:    "100/s",
+			Write:   "10/s",
+			Routes:  []string{"POST /api/clusters_mgmt/v1/clusters=10/m"},
+			Address: "200/s",
+		},
+		TLS:      config.DefaultTLS(),
+		Tracing:  config.DefaultTracing("clusters-service"),
+		Shutdown: config.DefaultShutdown(),
+	}
+}
+
+// clustersConfig is the configuration of the command that is running. The
+// defaults are used to create the flags.
+var clustersConfig = defaultConfig()
+
+// addConfigFlags adds to the command the flags of the given parts of the
+// configuration.
+func addConfigFlags(cmd *cobra.Command, parts ...interface{}) {
+	for _, part := range parts {
+		err := config.AddFlags(cmd.Flags(), part)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// loadConfig loads the configuration of the command from the configuration
+// file, the environment and the flags of the command, and sets the log level.
+// The log level of the 'serve' command can also be changed later using the
+// API.
+func loadConfig(cmd *cobra.Command) {
+	err := config.Load(&clustersConfig, configFile, cmd.Flags())
+	if err != nil {
+		logging.Fatalf("Can't load configuration: %v", err)
+	}
+	level, err := logging.ParseLevel(clustersConfig.Logging.Level)
+	if err != nil {
+		logging.Fatalf("Can't set log level: %v", err)
+	}
+	logging.SetLevel(level)
+}
+
+// reloadConfig returns a function that loads the configuration again, from
+// the same file, environment and flags, and applies the parts that can
+// change while the service runs. Currently that is only the log level, the
+// rest is used when the service is restarted.
+func reloadConfig(cmd *cobra.Command) func() error {
+	return func() error {
+		reloaded := defaultConfig()
+		err := config.Load(&reloaded, configFile, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		level, err := logging.ParseLevel(reloaded.Logging.Level)
+		if err != nil {
+			return err
+		}
+		logging.SetLevel(level)
+		return nil
+	}
+}
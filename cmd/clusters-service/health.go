@@ -0,0 +1,50 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/health"
+	pgsql "github.com/container-mgmt/dedicated-portal/pkg/sql"
+)
+
+// monitoringMaxOpenConns is the size of the connection pool used by the
+// health checks and the metrics.
+const monitoringMaxOpenConns = 2
+
+// openMonitoringDatabase opens the connection pool used by the health checks
+// and the metrics, separate from the connections of the clusters service, so
+// that they still work when all those connections are busy.
+func openMonitoringDatabase(config pgsql.ConnectionConfig) (*sql.DB, error) {
+	config.MaxOpenConns = monitoringMaxOpenConns
+	config.MaxIdleConns = monitoringMaxOpenConns
+	return pgsql.Open(config)
+}
+
+// newHealthChecker creates the readiness checks of the service: the
+// connection to the database and the version of its schema.
+func newHealthChecker(db *sql.DB, migrations fs.FS) *health.Checker {
+	checker := health.NewChecker()
+	checker.Add("database", health.DatabaseCheck(db))
+	checker.Add("schema", func(ctx context.Context) error {
+		return pgsql.CheckSchemaFS(ctx, db, migrations)
+	})
+	return checker
+}
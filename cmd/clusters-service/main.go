@@ -17,43 +17,43 @@ limitations under the License.
 package main
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/container-mgmt/dedicated-portal/pkg/signals"
-	"github.com/container-mgmt/dedicated-portal/pkg/sql"
+	"github.com/container-mgmt/dedicated-portal/pkg/config"
+	"github.com/spf13/cobra"
 )
 
-func main() {
-	// Set up signals so we handle the first shutdown signal gracefully:
-	stopCh := signals.SetupHandler()
-	url := ConnectionURL()
-	err := sql.EnsureSchema(
-		"/usr/local/share/clusters-service/migrations",
-		url,
-	)
-	if err != nil {
-		panic(err)
+var (
+	// Main command:
+	rootCmd = &cobra.Command{
+		Use:  "clusters-service",
+		Long: "A service that manages clusters.",
 	}
-	service := NewClustersService(url)
-	fmt.Println("Created cluster service.")
 
-	// This is temporary and should be replaced with reading from the queue
-	server := NewServer(stopCh, service)
-	err = server.start()
-	if err != nil {
-		panic(fmt.Sprintf("Error starting server: %v", err))
-	}
-	fmt.Println("Created server.")
+	// configFile is the YAML file containing the configuration of all the
+	// commands.
+	configFile string
+)
 
-	fmt.Println("Waiting for stop signal")
-	<-stopCh // wait until requested to stop.
+func init() {
+	rootCmd.PersistentFlags().StringVar(
+		&configFile,
+		"config",
+		os.Getenv(config.FileEnv),
+		"YAML file containing the configuration. Environment variable "+config.FileEnv+".",
+	)
+
+	// Register the subcommands:
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(versionCmd)
 }
 
-// ConnectionURL generates a connection string from the environment.
-func ConnectionURL() string {
-	return fmt.Sprintf("postgres://%s:%s@localhost:5432/%s?sslmode=disable",
-		os.Getenv("POSTGRESQL_USER"),
-		os.Getenv("POSTGRESQL_PASSWORD"),
-		os.Getenv("POSTGRESQL_DATABASE"))
+func main() {
+	// Execute the root command:
+	rootCmd.SetArgs(os.Args[1:])
+	err := rootCmd.Execute()
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
+)
+
+// metricsQueryTimeout is the maximum time spent calculating the business
+// metrics each time that they are collected.
+const metricsQueryTimeout = 5 * time.Second
+
+// newMetricsRegistry creates the registry with the metrics of the database
+// pool used by the service and of the clusters stored in the database. The
+// clusters are counted using the monitoring pool.
+func newMetricsRegistry(db *sql.DB, monitoringDB *sql.DB) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	dbMetrics := metrics.NewDBMetrics(registry)
+	dbMetrics.Add("clusters", db)
+	dbMetrics.Add("monitoring", monitoringDB)
+	// The totals are calculated with a query each time that the metrics are
+	// collected:
+	registry.NewGaugeFunc(
+		"clusters",
+		"Number of clusters.",
+		nil,
+		func() []metrics.Sample {
+			clusters, _, err := countClusters(monitoringDB)
+			if err != nil {
+				logging.Errorf("Error counting clusters: %v", err)
+				return nil
+			}
+			return []metrics.Sample{{Value: float64(clusters)}}
+		},
+	)
+	registry.NewGaugeFunc(
+		"cluster_nodes",
+		"Total number of nodes of all the clusters.",
+		nil,
+		func() []metrics.Sample {
+			_, nodes, err := countClusters(monitoringDB)
+			if err != nil {
+				logging.Errorf("Error counting cluster nodes: %v", err)
+				return nil
+			}
+			return []metrics.Sample{{Value: float64(nodes)}}
+		},
+	)
+	return registry
+}
+
+// countClusters returns the number of clusters and the total number of
+// nodes.
+func countClusters(db *sql.DB) (clusters int, nodes int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsQueryTimeout)
+	defer cancel()
+	err = db.QueryRowContext(ctx, `SELECT count(*), coalesce(sum(nodes), 0) FROM clusters`).Scan(&clusters, &nodes)
+	return
+}
+
+// instrumentedClustersService is a clusters service that measures the
+// duration of the operations of another, and records them as client spans of
+// the trace of the request.
+type instrumentedClustersService struct {
+	service ClustersService
+	backend string
+	metrics *metrics.StoreMetrics
+}
+
+// newInstrumentedClustersService wraps the service so that the duration of
+// its operations is recorded in the given metrics, with the given backend
+// name.
+func newInstrumentedClustersService(service ClustersService, backend string,
+	storeMetrics *metrics.StoreMetrics) ClustersService {
+	return &instrumentedClustersService{
+		service: service,
+		backend: backend,
+		metrics: storeMetrics,
+	}
+}
+
+func (s *instrumentedClustersService) List(ctx context.Context,
+	args ListArguments) (result ClustersResult, err error) {
+	ctx, span := s.start(ctx, "list")
+	defer s.observe(span, "list", time.Now(), &err)
+	return s.service.List(ctx, args)
+}
+
+func (s *instrumentedClustersService) Create(ctx context.Context, spec Cluster) (result Cluster, err error) {
+	ctx, span := s.start(ctx, "create")
+	defer s.observe(span, "create", time.Now(), &err)
+	return s.service.Create(ctx, spec)
+}
+
+func (s *instrumentedClustersService) Get(ctx context.Context, uuid string) (result Cluster, err error) {
+	ctx, span := s.start(ctx, "get")
+	defer s.observe(span, "get", time.Now(), &err)
+	return s.service.Get(ctx, uuid)
+}
+
+func (s *instrumentedClustersService) Usage(ctx context.Context,
+	customerID string) (result QuotaUsage, err error) {
+	ctx, span := s.start(ctx, "usage")
+	defer s.observe(span, "usage", time.Now(), &err)
+	return s.service.Usage(ctx, customerID)
+}
+
+// start starts the span of an operation.
+func (s *instrumentedClustersService) start(ctx context.Context, operation string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, s.backend+" "+operation, tracing.SpanKindClient)
+	span.SetAttribute("db.system", s.backend)
+	span.SetAttribute("db.operation", operation)
+	return ctx, span
+}
+
+// observe records the result of an operation and ends its span. Clusters
+// that don't exist are a normal result, not a failure of the datastore.
+func (s *instrumentedClustersService) observe(span *tracing.Span, operation string, start time.Time, err *error) {
+	result := *err
+	if _, ok := result.(*ClusterNotFoundError); ok {
+		result = nil
+	}
+	s.metrics.Observe(s.backend, operation, start, result)
+	span.SetError(result)
+	span.End()
+}
@@ -0,0 +1,276 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/sql"
+	"github.com/spf13/cobra"
+)
+
+var migrateDownArgs struct {
+	all bool
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage the database schema",
+	Long: "Apply or roll back the migrations of the database schema. This " +
+		"allows running the migrations as a separate job before the service " +
+		"is updated.",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up [STEPS]",
+	Short: "Apply migrations",
+	Long:  "Apply the given number of pending migrations, all of them by default.",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runMigrateUp,
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [STEPS]",
+	Short: "Roll back migrations",
+	Long: "Roll back the given number of migrations, one by default. Use " +
+		"--all to roll back all of them, which deletes all the data.",
+	Args: cobra.MaximumNArgs(1),
+	Run:  runMigrateDown,
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the version of the schema",
+	Args:  cobra.NoArgs,
+	Run:   runMigrateStatus,
+}
+
+var migrateGotoCmd = &cobra.Command{
+	Use:   "goto VERSION",
+	Short: "Apply or roll back migrations till the given version",
+	Args:  cobra.ExactArgs(1),
+	Run:   runMigrateGoto,
+}
+
+var migrateForceCmd = &cobra.Command{
+	Use:   "force VERSION",
+	Short: "Set the version of the schema without running migrations",
+	Long: "Set the version of the schema and clear the dirty flag, without " +
+		"running any migration. Use it after fixing manually a migration " +
+		"that failed. The version -1 means that no migration is applied.",
+	Args: cobra.ExactArgs(1),
+	Run:  runMigrateForce,
+}
+
+func init() {
+	migrateDownCmd.Flags().BoolVar(
+		&migrateDownArgs.all,
+		"all",
+		false,
+		"Roll back all the migrations.",
+	)
+	for _, cmd := range []*cobra.Command{
+		migrateUpCmd,
+		migrateDownCmd,
+		migrateGotoCmd,
+		migrateStatusCmd,
+		migrateForceCmd,
+	} {
+		addConfigFlags(cmd, &clustersConfig.Logging, &clustersConfig.Database, &clustersConfig.Migrations)
+		migrateCmd.AddCommand(cmd)
+	}
+}
+
+func runMigrateUp(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	steps := parseSteps(args)
+	migrator := openMigrator()
+	defer migrator.Close()
+	var err error
+	if steps == 0 {
+		err = migrator.Up(context.Background())
+	} else {
+		err = migrator.Steps(context.Background(), steps)
+	}
+	if err == sql.ErrNoChange {
+		logging.Infof("Schema is already fully migrated")
+		return
+	}
+	if err != nil {
+		logging.Fatalf("Can't apply migrations: %v", err)
+	}
+	logVersion(migrator)
+}
+
+func runMigrateDown(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	steps := parseSteps(args)
+	if migrateDownArgs.all && steps != 0 {
+		logging.Fatalf("The number of steps can't be given with --all")
+	}
+	if steps == 0 {
+		steps = 1
+	}
+	migrator := openMigrator()
+	defer migrator.Close()
+	var err error
+	if migrateDownArgs.all {
+		err = migrator.Down(context.Background())
+	} else {
+		err = migrator.Steps(context.Background(), -steps)
+	}
+	if err == sql.ErrNoChange {
+		logging.Infof("There are no migrations to roll back")
+		return
+	}
+	if err != nil {
+		logging.Fatalf("Can't roll back migrations: %v", err)
+	}
+	logVersion(migrator)
+}
+
+func runMigrateGoto(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	version, err := strconv.ParseUint(args[0], 10, 0)
+	if err != nil {
+		logging.Fatalf("The version must be a positive integer, but it is '%s'", args[0])
+	}
+	migrator := openMigrator()
+	defer migrator.Close()
+	err = migrator.Goto(context.Background(), uint(version))
+	if err == sql.ErrNoChange {
+		logging.Infof("Schema already has version %d", version)
+		return
+	}
+	if err != nil {
+		logging.Fatalf("Can't migrate to version %d: %v", version, err)
+	}
+	logVersion(migrator)
+}
+
+func runMigrateStatus(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	migrator := openMigrator()
+	defer migrator.Close()
+	latest, err := migrator.LatestVersion()
+	if err != nil {
+		logging.Fatalf("Can't read migrations: %v", err)
+	}
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		logging.Fatalf("Can't get schema version: %v", err)
+	}
+	fmt.Printf("Version: %d\n", version)
+	fmt.Printf("Latest: %d\n", latest)
+	fmt.Printf("Dirty: %v\n", dirty)
+	switch {
+	case dirty:
+		fmt.Printf("Status: %v\n", &sql.DirtyError{Version: version})
+	case version < latest:
+		fmt.Printf("Status: pending migrations\n")
+	case version > latest:
+		fmt.Printf("Status: schema is newer than the migrations\n")
+	default:
+		fmt.Printf("Status: up to date\n")
+	}
+}
+
+func runMigrateForce(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	version, err := strconv.Atoi(args[0])
+	if err != nil {
+		logging.Fatalf("The version must be -1 or a positive integer, but it is '%s'", args[0])
+	}
+	migrator := openMigrator()
+	defer migrator.Close()
+	err = migrator.Force(context.Background(), version)
+	if err != nil {
+		logging.Fatalf("Can't force schema version: %v", err)
+	}
+	logVersion(migrator)
+}
+
+// parseSteps returns the number of steps given in the arguments, or zero if
+// it isn't given.
+func parseSteps(args []string) int {
+	if len(args) == 0 {
+		return 0
+	}
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps <= 0 {
+		logging.Fatalf("The number of steps must be a positive integer, but it is '%s'", args[0])
+	}
+	return steps
+}
+
+// embeddedMigrations contains the migrations of the database schema,
+// compiled into the binary so that it doesn't depend on files installed with
+// it.
+//
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// migrationsFS returns the file system containing the migrations: the
+// directory given in the configuration, if any, or else the migrations
+// embedded in the binary.
+func migrationsFS() fs.FS {
+	if clustersConfig.Migrations.Dir != "" {
+		return os.DirFS(clustersConfig.Migrations.Dir)
+	}
+	fsys, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// migratorConfig returns the configuration of the migrator of the database
+// given in the configuration.
+func migratorConfig() sql.MigratorConfig {
+	return sql.MigratorConfig{
+		FS:          migrationsFS(),
+		URL:         clustersConfig.Database.ConnectionURL(),
+		WaitTimeout: clustersConfig.Migrations.WaitTimeout,
+		LockTimeout: clustersConfig.Migrations.LockTimeout,
+	}
+}
+
+// openMigrator creates the migrator, waiting for the database to be
+// available.
+func openMigrator() *sql.Migrator {
+	migrator, err := sql.NewMigrator(context.Background(), migratorConfig())
+	if err != nil {
+		logging.Fatalf("Can't open migrations: %v", err)
+	}
+	return migrator
+}
+
+// logVersion writes the version of the schema to the log.
+func logVersion(migrator *sql.Migrator) {
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		logging.Warnf("Can't get schema version: %v", err)
+		return
+	}
+	logging.Infof("Schema version is %d, dirty %v", version, dirty)
+}
@@ -0,0 +1,6 @@
+DROP INDEX clusters_customer_id_idx;
+ALTER TABLE clusters
+DROP COLUMN customer_id,
+DROP COLUMN region,
+DROP COLUMN instance_type,
+DROP COLUMN nodes;
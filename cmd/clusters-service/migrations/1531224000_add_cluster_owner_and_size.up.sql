@@ -0,0 +1,6 @@
+ALTER TABLE clusters
+ADD COLUMN customer_id text NOT NULL DEFAULT '',
+ADD COLUMN region text NOT NULL DEFAULT '',
+ADD COLUMN instance_type text NOT NULL DEFAULT '',
+ADD COLUMN nodes integer NOT NULL DEFAULT 0;
+CREATE INDEX clusters_customer_id_idx ON clusters (customer_id);
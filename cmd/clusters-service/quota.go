@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
+)
+
+// Quota represents the limits on the resources that a customer can use, as
+// returned by the customers service. Limits that aren't set (nil maximums or
+// empty lists) aren't enforced.
+type Quota struct {
+	CustomerID           string   `json:"customer_id"`
+	MaxClusters          *int64   `json:"max_clusters,omitempty"`
+	MaxNodes             *int64   `json:"max_nodes,omitempty"`
+	AllowedRegions       []string `json:"allowed_regions"`
+	AllowedInstanceTypes []string `json:"allowed_instance_types"`
+}
+
+// QuotaUsage represents the resources currently used by a customer.
+type QuotaUsage struct {
+	CustomerID string `json:"customer_id"`
+	Clusters   int64  `json:"clusters"`
+	Nodes      int64  `json:"nodes"`
+}
+
+// QuotaClient retrieves the quotas of customers from the customers service,
+// and registers there the clusters that they own.
+type QuotaClient interface {
+	// GetQuota returns the quota of the customer, or UnknownCustomerError if
+	// the customer doesn't exist.
+	GetQuota(ctx context.Context, customerID string) (*Quota, error)
+
+	// AddOwnedCluster records in the customers service that the customer
+	// owns the cluster, or returns UnknownCustomerError if the customer
+	// doesn't exist.
+	AddOwnedCluster(ctx context.Context, customerID string, clusterID string) error
+}
+
+// QuotaExceededError is returned when creating a cluster would exceed the
+// maximum number of clusters or nodes of the customer.
+type QuotaExceededError struct {
+	CustomerID string
+	Resource   string
+	Limit      int64
+	Used       int64
+	Requested  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf(
+		"quota exceeded for customer '%s': %d %s requested, %d already used, limit is %d",
+		e.CustomerID, e.Requested, e.Resource, e.Used, e.Limit,
+	)
+}
+
+// QuotaNotAllowedError is returned when a cluster requests a region or an
+// instance type that the quota of the customer doesn't allow.
+type QuotaNotAllowedError struct {
+	CustomerID string
+	Field      string
+	Value      string
+	Allowed    []string
+}
+
+func (e *QuotaNotAllowedError) Error() string {
+	return fmt.Sprintf(
+		"%s '%s' isn't allowed for customer '%s', allowed values are: %s",
+		e.Field, e.Value, e.CustomerID, strings.Join(e.Allowed, ", "),
+	)
+}
+
+// UnknownCustomerError is returned when a cluster is requested for a
+// customer that doesn't exist.
+type UnknownCustomerError struct {
+	CustomerID string
+}
+
+func (e *UnknownCustomerError) Error() string {
+	return fmt.Sprintf("customer '%s' doesn't exist", e.CustomerID)
+}
+
+// Check verifies that a cluster with the given specification can be created
+// by a customer that currently uses the given resources.
+func (quota *Quota) Check(usage QuotaUsage, spec Cluster) error {
+	if quota.MaxClusters != nil && usage.Clusters+1 > *quota.MaxClusters {
+		return &QuotaExceededError{
+			CustomerID: quota.CustomerID,
+			Resource:   "clusters",
+			Limit:      *quota.MaxClusters,
+			Used:       usage.Clusters,
+			Requested:  1,
+		}
+	}
+	if quota.MaxNodes != nil && usage.Nodes+int64(spec.Nodes) > *quota.MaxNodes {
+		return &QuotaExceededError{
+			CustomerID: quota.CustomerID,
+			Resource:   "nodes",
+			Limit:      *quota.MaxNodes,
+			Used:       usage.Nodes,
+			Requested:  int64(spec.Nodes),
+		}
+	}
+	if len(quota.AllowedRegions) > 0 && !contains(quota.AllowedRegions, spec.Region) {
+		return &QuotaNotAllowedError{
+			CustomerID: quota.CustomerID,
+			Field:      "region",
+			Value:      spec.Region,
+			Allowed:    quota.AllowedRegions,
+		}
+	}
+	if len(quota.AllowedInstanceTypes) > 0 && !contains(quota.AllowedInstanceTypes, spec.InstanceType) {
+		return &QuotaNotAllowedError{
+			CustomerID: quota.CustomerID,
+			Field:      "instance type",
+			Value:      spec.InstanceType,
+			Allowed:    quota.AllowedInstanceTypes,
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPQuotaClient is a QuotaClient that retrieves the quotas from the REST
+// API of the customers service.
+type HTTPQuotaClient struct {
+	baseURL   string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewHTTPQuotaClient creates a new quota client for the customers service
+// running at the given URL, for example
+// 'http://customers-service:8000'. If the token file isn't empty the bearer
+// token is read from it before each request, so that it can be renewed
+// without restarting the service.
+func NewHTTPQuotaClient(baseURL string, tokenFile string) *HTTPQuotaClient {
+	client := new(HTTPQuotaClient)
+	client.baseURL = strings.TrimRight(baseURL, "/")
+	client.tokenFile = tokenFile
+	client.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: tracing.NewTransport(nil, nil),
+	}
+	return client
+}
+
+// GetQuota retrieves the quota of the customer from the customers service,
+// sending the trace context of the given context.
+func (c *HTTPQuotaClient) GetQuota(ctx context.Context, customerID string) (*Quota, error) {
+	address := fmt.Sprintf(
+		"%s/api/customers_mgmt/v1/customers/%s/quota",
+		c.baseURL, url.PathEscape(customerID),
+	)
+	request, err := c.newRequest(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving quota: %v", err)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving quota: %v", err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, &UnknownCustomerError{CustomerID: customerID}
+	default:
+		return nil, fmt.Errorf(
+			"Error retrieving quota: customers service responded with status %d",
+			response.StatusCode,
+		)
+	}
+	quota := new(Quota)
+	err = json.NewDecoder(response.Body).Decode(quota)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding quota: %v", err)
+	}
+	return quota, nil
+}
+
+// AddOwnedCluster registers the cluster as owned by the customer in the
+// customers service, sending the trace context of the given context.
+func (c *HTTPQuotaClient) AddOwnedCluster(ctx context.Context, customerID string, clusterID string) error {
+	address := fmt.Sprintf(
+		"%s/api/customers_mgmt/v1/customers/%s/owned_clusters",
+		c.baseURL, url.PathEscape(customerID),
+	)
+	body, err := json.Marshal(map[string]string{"cluster_id": clusterID})
+	if err != nil {
+		return fmt.Errorf("Error registering owned cluster: %v", err)
+	}
+	request, err := c.newRequest(ctx, http.MethodPost, address, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error registering owned cluster: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("Error registering owned cluster: %v", err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return &UnknownCustomerError{CustomerID: customerID}
+	default:
+		return fmt.Errorf(
+			"Error registering owned cluster: customers service responded with status %d",
+			response.StatusCode,
+		)
+	}
+}
+
+// newRequest creates a request for the customers service, with the bearer
+// token read from the token file, if any.
+func (c *HTTPQuotaClient) newRequest(ctx context.Context, method string, address string,
+	body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, address, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading token: %v", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return request.WithContext(ctx), nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func TestQuotaCheck(t *testing.T) {
+	quota := Quota{
+		CustomerID:           "customer",
+		MaxClusters:          int64Ptr(2),
+		MaxNodes:             int64Ptr(10),
+		AllowedRegions:       []string{"us-east-1"},
+		AllowedInstanceTypes: []string{"m4.large"},
+	}
+	spec := Cluster{
+		Name:         "test",
+		CustomerID:   "customer",
+		Region:       "us-east-1",
+		InstanceType: "m4.large",
+		Nodes:        4,
+	}
+
+	err := quota.Check(QuotaUsage{Clusters: 1, Nodes: 6}, spec)
+	if err != nil {
+		t.Errorf("expected cluster to fit in the quota, got %v", err)
+	}
+
+	err = quota.Check(QuotaUsage{Clusters: 2, Nodes: 0}, spec)
+	if exceeded, ok := err.(*QuotaExceededError); !ok || exceeded.Resource != "clusters" {
+		t.Errorf("expected clusters quota to be exceeded, got %v", err)
+	}
+
+	err = quota.Check(QuotaUsage{Clusters: 1, Nodes: 7}, spec)
+	if exceeded, ok := err.(*QuotaExceededError); !ok || exceeded.Resource != "nodes" {
+		t.Errorf("expected nodes quota to be exceeded, got %v", err)
+	}
+
+	other := spec
+	other.Region = "eu-west-1"
+	err = quota.Check(QuotaUsage{}, other)
+	if notAllowed, ok := err.(*QuotaNotAllowedError); !ok || notAllowed.Field != "region" {
+		t.Errorf("expected region not to be allowed, got %v", err)
+	}
+
+	other = spec
+	other.InstanceType = "m4.xlarge"
+	err = quota.Check(QuotaUsage{}, other)
+	if notAllowed, ok := err.(*QuotaNotAllowedError); !ok || notAllowed.Field != "instance type" {
+		t.Errorf("expected instance type not to be allowed, got %v", err)
+	}
+}
+
+func TestQuotaCheckUnlimited(t *testing.T) {
+	quota := Quota{CustomerID: "customer"}
+	spec := Cluster{Name: "test", CustomerID: "customer", Region: "any", Nodes: 1000}
+	err := quota.Check(QuotaUsage{Clusters: 1000, Nodes: 1000}, spec)
+	if err != nil {
+		t.Errorf("expected unlimited quota to accept the cluster, got %v", err)
+	}
+}
+
+func TestHTTPQuotaClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/customers_mgmt/v1/customers/known/quota":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"customer_id": "known", "max_clusters": 3, "allowed_regions": ["us-east-1"]}`)
+		case "/api/customers_mgmt/v1/customers/broken/quota":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewHTTPQuotaClient(server.URL+"/", "")
+
+	quota, err := client.GetQuota(context.Background(), "known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.MaxClusters == nil || *quota.MaxClusters != 3 {
+		t.Errorf("expected max clusters to be 3, got %v", quota.MaxClusters)
+	}
+	if quota.MaxNodes != nil {
+		t.Errorf("expected max nodes to be unlimited, got %d", *quota.MaxNodes)
+	}
+	if len(quota.AllowedRegions) != 1 || quota.AllowedRegions[0] != "us-east-1" {
+		t.Errorf("expected allowed regions to be [us-east-1], got %v", quota.AllowedRegions)
+	}
+
+	_, err = client.GetQuota(context.Background(), "unknown")
+	if _, ok := err.(*UnknownCustomerError); !ok {
+		t.Errorf("expected unknown customer error, got %v", err)
+	}
+
+	_, err = client.GetQuota(context.Background(), "broken")
+	if err == nil {
+		t.Errorf("expected error when the customers service fails")
+	}
+}
+
+func TestHTTPQuotaClientSendsToken(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"customer_id": "known"}`)
+	}))
+	defer server.Close()
+	file, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprintln(file, "my-token")
+	file.Close()
+	client := NewHTTPQuotaClient(server.URL, file.Name())
+
+	_, err = client.GetQuota(context.Background(), "known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer my-token" {
+		t.Errorf("expected authorization header 'Bearer my-token', got '%s'", authorization)
+	}
+}
+
+func TestHTTPQuotaClientAddOwnedCluster(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/customers_mgmt/v1/customers/known/owned_clusters":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"id": "known"}`)
+		case "/api/customers_mgmt/v1/customers/owned/owned_clusters":
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewHTTPQuotaClient(server.URL, "")
+
+	err := client.AddOwnedCluster(context.Background(), "known", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["cluster_id"] != "cluster" {
+		t.Errorf("expected cluster identifier 'cluster' to be sent, got %v", body)
+	}
+
+	err = client.AddOwnedCluster(context.Background(), "unknown", "cluster")
+	if _, ok := err.(*UnknownCustomerError); !ok {
+		t.Errorf("expected unknown customer error, got %v", err)
+	}
+
+	err = client.AddOwnedCluster(context.Background(), "owned", "cluster")
+	if err == nil {
+		t.Errorf("expected error when the cluster is owned by other customer")
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/container-mgmt/dedicated-portal/pkg/config"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+	"github.com/container-mgmt/dedicated-portal/pkg/signals"
+	"github.com/container-mgmt/dedicated-portal/pkg/sql"
+	"github.com/container-mgmt/dedicated-portal/pkg/tlsconfig"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
+	"github.com/spf13/cobra"
+)
+
+var serveArgs struct {
+	printConfig bool
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the clusters service",
+	Long: "Serve the REST API of the clusters service. By default the pending " +
+		"database migrations are applied before starting.",
+	Args: cobra.NoArgs,
+	Run:  runServe,
+}
+
+func init() {
+	flags := serveCmd.Flags()
+	flags.BoolVar(
+		&serveArgs.printConfig,
+		"print-config",
+		false,
+		"Print the effective configuration, with the secrets redacted, and exit.",
+	)
+	addConfigFlags(serveCmd, &clustersConfig)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	cfg := &clustersConfig
+	if serveArgs.printConfig {
+		err := config.Print(os.Stdout, cfg)
+		if err != nil {
+			logging.Fatalf("Can't print configuration: %v", err)
+		}
+		return
+	}
+
+	// Set up signals so we handle the first shutdown signal gracefully. The
+	// components register the functions that release their resources in the
+	// shutdown hooks, and the functions that reload their configuration when
+	// SIGHUP is received in the reloader:
+	ctx, cancel := signals.Context(context.Background())
+	defer cancel()
+	stopCh := ctx.Done()
+	hooks := signals.NewShutdownHooks()
+	reloader := signals.NewReloader()
+	reloader.Add("configuration", reloadConfig(cmd))
+
+	// Spans are exported only when an exporter is configured:
+	tracer, err := tracing.New(cfg.Tracing.Config())
+	if err != nil {
+		panic(fmt.Sprintf("Error creating tracer: %v", err))
+	}
+	tracing.SetDefault(tracer)
+	hooks.Add("tracer", tracingShutdownTimeout, tracer.Shutdown)
+
+	// The schema is migrated here unless that is done by a separate job, in
+	// which case it is only checked that this binary knows its version:
+	if cfg.MigrateOnStart {
+		err = sql.EnsureSchema(ctx, migratorConfig())
+		if err != nil {
+			panic(fmt.Sprintf("Error migrating database: %v", err))
+		}
+	} else {
+		err = sql.CheckSchemaVersion(ctx, migratorConfig())
+		if err != nil {
+			panic(fmt.Sprintf("Error checking database schema: %v", err))
+		}
+	}
+	// Quotas are enforced only when the location of the customers service is
+	// known:
+	var quotas QuotaClient
+	customersURL := cfg.CustomersService.URL
+	if customersURL != "" {
+		quotas = NewHTTPQuotaClient(customersURL, cfg.CustomersService.TokenFile)
+	} else {
+		logging.Warnf("The URL of the customers service isn't set, quotas won't be enforced.")
+	}
+	connection := cfg.Database.Connection()
+	db, err := sql.Open(connection)
+	if err != nil {
+		panic(fmt.Sprintf("Error opening database: %v", err))
+	}
+	hooks.Add("database", 0, func(context.Context) error {
+		return db.Close()
+	})
+	monitoringDB, err := openMonitoringDatabase(connection)
+	if err != nil {
+		panic(fmt.Sprintf("Error opening database: %v", err))
+	}
+	hooks.Add("monitoring database", 0, func(context.Context) error {
+		return monitoringDB.Close()
+	})
+	registry := newMetricsRegistry(db, monitoringDB)
+	service := newInstrumentedClustersService(
+		NewClustersService(db, quotas),
+		"sql",
+		metrics.NewStoreMetrics(registry),
+	)
+	logging.Infof("Created cluster service.")
+
+	// Requests are authenticated only when the JSON web key set is known. API
+	// keys of service accounts are verified by the customers service, if its
+	// location is known:
+	var verifier auth.TokenVerifier
+	var policy *authz.Policy
+	if cfg.Auth.Enabled() {
+		tokens, err := auth.NewJWTVerifier(auth.JWTConfig{
+			KeysFile: cfg.Auth.JWKSFile,
+			KeysURL:  cfg.Auth.JWKSURL,
+			Issuer:   cfg.Auth.Issuer,
+			Audience: cfg.Auth.Audience,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("Error creating token verifier: %v", err))
+		}
+		policy, err = authz.LoadPolicy(cfg.Auth.PolicyFile)
+		if err != nil {
+			panic(fmt.Sprintf("Error loading authorization policy: %v", err))
+		}
+		var apiKeys auth.TokenVerifier
+		if customersURL != "" {
+			apiKeys = auth.NewRemoteVerifier(strings.TrimRight(customersURL, "/") + "/api/customers_mgmt/v1/identity")
+		}
+		verifier = auth.NewCombinedVerifier(tokens, apiKeys)
+	} else {
+		logging.Warnf("No JSON web key set given, authentication is disabled.")
+	}
+
+	limits, err := cfg.RateLimits.Config()
+	if err != nil {
+		panic(fmt.Sprintf("Error loading rate limits: %v", err))
+	}
+
+	tlsConfig, reloadTLS, err := tlsconfig.NewReloadable(cfg.TLS.Config())
+	if err != nil {
+		panic(fmt.Sprintf("Error loading TLS configuration: %v", err))
+	}
+	if tlsConfig != nil {
+		reloader.Add("TLS certificates", reloadTLS)
+	}
+	go reloader.Run(ctx)
+
+	// Readiness is reported as failed as soon as the stop signal is received,
+	// and the service keeps serving requests during the drain delay, so that
+	// the load balancers stop sending new requests before it exits:
+	checker := newHealthChecker(monitoringDB, migrationsFS())
+	checker.StopOn(stopCh)
+
+	// This is temporary and should be replaced with reading from the queue.
+	// Serving blocks until the stop signal is received and the requests in
+	// progress have finished:
+	server := NewServer(stopCh, service, verifier, policy, limits)
+	serveErr := server.serve(tlsConfig, checker, registry, tracer, cfg.Shutdown.ServeConfig())
+	if serveErr != nil {
+		logging.Errorf("Error serving: %v", serveErr)
+	}
+
+	// Export the pending spans and close the databases. The hooks log their
+	// own failures:
+	hooks.Run()
+	if serveErr != nil {
+		os.Exit(1)
+	}
+	logging.Infof("Stopped.")
+}
+
+// tracingShutdownTimeout is the maximum time spent exporting the pending spans
+// when the service stops.
+const tracingShutdownTimeout = 5 * time.Second
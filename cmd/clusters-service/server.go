@@ -1,14 +1,18 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"crypto/tls"
 	"net/http"
-	"os"
-	"strconv"
 
-	"github.com/gorilla/handlers"
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/container-mgmt/dedicated-portal/pkg/health"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+	"github.com/container-mgmt/dedicated-portal/pkg/ratelimit"
+	"github.com/container-mgmt/dedicated-portal/pkg/signals"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
 	"github.com/gorilla/mux"
 )
 
@@ -18,106 +22,177 @@ import (
 type Server struct {
 	stopCh         <-chan struct{}
 	clusterService ClustersService
+	verifier       auth.TokenVerifier
+	policy         *authz.Policy
+	limits         *ratelimit.Config
 }
 
-// NewServer creates a new server.
-func NewServer(stopCh <-chan struct{}, clusterService ClustersService) *Server {
+// NewServer creates a new server. Requests are authenticated with the given
+// token verifier and authorized with the given policy, or not authenticated
+// nor authorized at all if they are nil. The rate of the requests of each
+// client is restricted with the given limits, if they aren't nil.
+func NewServer(stopCh <-chan struct{}, clusterService ClustersService,
+	verifier auth.TokenVerifier, policy *authz.Policy, limits *ratelimit.Config) *Server {
 	server := new(Server)
 	server.stopCh = stopCh
 	server.clusterService = clusterService
+	server.verifier = verifier
+	server.policy = policy
+	server.limits = limits
 	return server
 }
 
-func (s Server) start() error {
+// serve serves the API, the health endpoints and the metrics, using TLS if
+// the given configuration isn't nil, until the stop channel of the server is
+// closed, and then shuts down gracefully as described by the serve
+// configuration. The requests are measured with the metrics of the given
+// registry, and traced with the given tracer, if it isn't nil.
+func (s Server) serve(tlsConfig *tls.Config, checker *health.Checker, registry *metrics.Registry,
+	tracer *tracing.Tracer, config signals.ServeConfig) error {
 	// Create the main router:
+	httpMetrics := metrics.NewHTTPMetrics(registry)
 	mainRouter := mux.NewRouter()
+	mainRouter.Use(tracing.Middleware(tracer))
+	mainRouter.Use(httpMetrics.Middleware)
+	mainRouter.NotFoundHandler = httpMetrics.Middleware(http.NotFoundHandler())
+	mainRouter.HandleFunc("/healthz", checker.ServeLive).Methods("GET")
+	mainRouter.HandleFunc("/readyz", checker.ServeReady).Methods("GET")
+	mainRouter.Handle("/metrics", registry).Methods("GET")
 
 	// Create the API router:
+	levelHandler := logging.LevelHandler(logging.Default())
 	apiRouter := mainRouter.PathPrefix("/api/clusters_mgmt/v1").Subrouter()
-	apiRouter.HandleFunc("/clusters", s.listClusters).Methods("GET")
-	apiRouter.HandleFunc("/clusters", s.createCluster).Methods("POST")
-	apiRouter.HandleFunc("/clusters/{uuid}", s.getCluster).Methods("GET")
+	apiRouter.Use(api.Negotiate)
+	if s.limits != nil {
+		apiRouter.Use(ratelimit.AddressMiddleware(*s.limits))
+	}
+	if s.verifier != nil {
+		apiRouter.Use(auth.Middleware(s.verifier))
+	}
+	if s.limits != nil {
+		apiRouter.Use(ratelimit.Middleware(*s.limits))
+	}
+	apiRouter.Handle("/clusters", s.authorize("clusters:list", s.listClusters)).Methods("GET")
+	apiRouter.Handle("/clusters", s.authorize("clusters:create", s.createCluster)).Methods("POST")
+	apiRouter.Handle("/clusters/{uuid}", s.authorize("clusters:get", s.getCluster)).Methods("GET")
+	apiRouter.Handle("/customers/{id}/usage", s.authorize("usage:get", s.getUsage)).Methods("GET")
+	apiRouter.Handle("/log_level", s.authorize("log_level:get", levelHandler.ServeHTTP)).Methods("GET")
+	apiRouter.Handle("/log_level", s.authorize("log_level:update", levelHandler.ServeHTTP)).Methods("PUT")
 
-	// Enable the access log:
-	loggedRouter := handlers.LoggingHandler(os.Stdout, mainRouter)
+	// Assign identifiers to the requests and enable the access log:
+	loggedRouter := logging.Middleware(logging.Default())(mainRouter)
 
-	fmt.Println("Listening.")
-	go http.ListenAndServe(":8000", loggedRouter)
-	return nil
+	server := &http.Server{
+		Addr:      ":8000",
+		Handler:   loggedRouter,
+		TLSConfig: tlsConfig,
+	}
+	return signals.Serve(s.stopCh, server, config)
+}
+
+// authorize wraps the handler so that it is called only if the caller can
+// perform the given action.
+func (s Server) authorize(action string, handler http.HandlerFunc) http.Handler {
+	return authz.NewHandler(s.policy, action, handler)
 }
 
 func (s Server) listClusters(w http.ResponseWriter, r *http.Request) {
-	page, err := getQueryParamInt("page", 0, r)
+	page, err := api.ParsePage(r)
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%v", err)})
+		api.WriteError(w, api.StatusOf(err), err)
 		return
 	}
-	size, err := getQueryParamInt("size", 100, r)
-	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%v", err)})
-		return
+	// Callers that can only access their own organization see only the
+	// clusters of that organization:
+	args := ListArguments{Page: page.Page, Size: page.Size}
+	access := authz.AccessFromContext(r.Context())
+	if !access.All() {
+		args.CustomerID = access.Organization()
 	}
-	results, err := s.clusterService.List(ListArguments{Page: page, Size: size})
+	results, err := s.clusterService.List(r.Context(), args)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("%v", err)})
+		api.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSONResponse(w, http.StatusOK, results)
+	api.WriteJSON(w, http.StatusOK, api.NewList(page.Page, int64(results.Total), results.Items))
 }
 
 func (s Server) createCluster(w http.ResponseWriter, r *http.Request) {
-	bytes, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("%v", err)})
-		return
-	}
 	var spec Cluster
-	err = json.Unmarshal(bytes, &spec)
+	err := api.DecodeJSON(w, r, &spec)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("%v", err)})
+		api.WriteError(w, api.StatusOf(err), err)
 		return
 	}
 	if spec.UUID != "" {
-		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": "uuid must be empty"})
+		api.WriteErrorf(w, http.StatusBadRequest, "uuid must be empty")
 		return
 	}
-	result, err := s.clusterService.Create(spec.Name)
+	// Callers that can only access their own organization can only create
+	// clusters for it. Other customers are reported as not existing, so that
+	// their existence isn't revealed:
+	access := authz.AccessFromContext(r.Context())
+	if !access.All() {
+		if spec.CustomerID == "" {
+			spec.CustomerID = access.Organization()
+		}
+		if !access.Allows(spec.CustomerID) {
+			api.WriteError(w, http.StatusNotFound, &UnknownCustomerError{CustomerID: spec.CustomerID})
+			return
+		}
+	}
+	result, err := s.clusterService.Create(r.Context(), spec)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		api.WriteError(w, createClusterErrorCode(err), err)
 		return
 	}
-	writeJSONResponse(w, http.StatusCreated, result)
+	api.WriteJSON(w, http.StatusCreated, result)
 }
 
-func (s Server) getCluster(w http.ResponseWriter, r *http.Request) {
-	uuid := mux.Vars(r)["uuid"]
-	if uuid == "" {
-		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": "no uuid provided"})
+// createClusterErrorCode returns the HTTP status code that corresponds to an
+// error returned by the Create method of the clusters service.
+func createClusterErrorCode(err error) int {
+	switch err.(type) {
+	case *InvalidClusterError:
+		return http.StatusBadRequest
+	case *QuotaExceededError:
+		return http.StatusForbidden
+	case *QuotaNotAllowedError, *UnknownCustomerError:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	customerID := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(customerID) {
+		api.WriteError(w, http.StatusNotFound, &UnknownCustomerError{CustomerID: customerID})
 		return
 	}
-	cluster, err := s.clusterService.Get(uuid)
+	usage, err := s.clusterService.Usage(r.Context(), customerID)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("%v", err)})
+		api.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSONResponse(w, http.StatusOK, cluster)
+	api.WriteJSON(w, http.StatusOK, usage)
 }
 
-func getQueryParamInt(param string, defaultValue int, r *http.Request) (value int, err error) {
-	valueString, ok := r.URL.Query()[param]
-
-	if !ok || len(valueString) < 1 {
-		return defaultValue, nil
+func (s Server) getCluster(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	cluster, err := s.clusterService.Get(r.Context(), uuid)
+	// Clusters of other organizations are reported as not existing, so that
+	// their existence isn't revealed:
+	if err == nil && !authz.AccessFromContext(r.Context()).Allows(cluster.CustomerID) {
+		err = &ClusterNotFoundError{UUID: uuid}
 	}
-	var result int64
-	// This needs to be ParseInt and not Atoi because the interface asks for int64
-	result, err = strconv.ParseInt(valueString[0], 10, 32)
-	return int(result), err
-}
-
-func writeJSONResponse(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.MarshalIndent(payload, "", "  ")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	w.Write(response)
+	if _, ok := err.(*ClusterNotFoundError); ok {
+		api.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, cluster)
 }
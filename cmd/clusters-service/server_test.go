@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+)
+
+// fakeClustersService is a clusters service that keeps the clusters in
+// memory, used to test the handlers of the server.
+type fakeClustersService struct {
+	clusters []Cluster
+	lastList ListArguments
+}
+
+func (f *fakeClustersService) List(ctx context.Context, args ListArguments) (ClustersResult, error) {
+	f.lastList = args
+	result := ClustersResult{Items: make([]Cluster, 0)}
+	for _, cluster := range f.clusters {
+		if args.CustomerID == "" || cluster.CustomerID == args.CustomerID {
+			result.Items = append(result.Items, cluster)
+		}
+	}
+	result.Size = len(result.Items)
+	return result, nil
+}
+
+func (f *fakeClustersService) Create(ctx context.Context, spec Cluster) (Cluster, error) {
+	spec.UUID = "new"
+	f.clusters = append(f.clusters, spec)
+	return spec, nil
+}
+
+func (f *fakeClustersService) Get(ctx context.Context, uuid string) (Cluster, error) {
+	for _, cluster := range f.clusters {
+		if cluster.UUID == uuid {
+			return cluster, nil
+		}
+	}
+	return Cluster{}, &ClusterNotFoundError{UUID: uuid}
+}
+
+func (f *fakeClustersService) Usage(ctx context.Context, customerID string) (QuotaUsage, error) {
+	return QuotaUsage{CustomerID: customerID}, nil
+}
+
+// newTestServer creates a server with the default policy and a fake service
+// containing one cluster for each of the customers 'org-1' and 'org-2'.
+func newTestServer(t *testing.T) (*Server, *fakeClustersService) {
+	policy, err := authz.LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &fakeClustersService{
+		clusters: []Cluster{
+			{UUID: "cluster-1", CustomerID: "org-1"},
+			{UUID: "cluster-2", CustomerID: "org-2"},
+		},
+	}
+	return NewServer(nil, service, nil, policy, nil), service
+}
+
+// serveAs sends the request to the handler of the given action, as a caller
+// with the given role and organization.
+func serveAs(s *Server, action string, handler http.HandlerFunc, role, organization string,
+	request *http.Request) *httptest.ResponseRecorder {
+	identity := &auth.Identity{
+		Subject: "user",
+		Claims: map[string]interface{}{
+			"roles":  role,
+			"org_id": organization,
+		},
+	}
+	request = request.WithContext(auth.ContextWithIdentity(request.Context(), identity))
+	recorder := httptest.NewRecorder()
+	s.authorize(action, handler).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestListClustersScopedToOrganization(t *testing.T) {
+	server, service := newTestServer(t)
+	request := httptest.NewRequest("GET", "/api/clusters_mgmt/v1/clusters", nil)
+	recorder := serveAs(server, "clusters:list", server.listClusters, "customer", "org-1", request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", recorder.Code)
+	}
+	var result ClustersResult
+	err := json.Unmarshal(recorder.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 1 || result.Items[0].UUID != "cluster-1" {
+		t.Errorf("expected only cluster 'cluster-1', got %+v", result.Items)
+	}
+
+	request = httptest.NewRequest("GET", "/api/clusters_mgmt/v1/clusters", nil)
+	recorder = serveAs(server, "clusters:list", server.listClusters, "support-readonly", "", request)
+	if recorder.Code != http.StatusOK || service.lastList.CustomerID != "" {
+		t.Errorf("expected support to list all the clusters, got status %d and arguments %+v",
+			recorder.Code, service.lastList)
+	}
+}
+
+func TestCreateClusterForOtherOrganization(t *testing.T) {
+	server, service := newTestServer(t)
+	request := httptest.NewRequest("POST", "/api/clusters_mgmt/v1/clusters",
+		strings.NewReader(`{"name": "mine", "customer_id": "org-2"}`))
+	recorder := serveAs(server, "clusters:create", server.createCluster, "customer", "org-1", request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for other organization, got %d", recorder.Code)
+	}
+
+	request = httptest.NewRequest("POST", "/api/clusters_mgmt/v1/clusters",
+		strings.NewReader(`{"name": "mine"}`))
+	recorder = serveAs(server, "clusters:create", server.createCluster, "customer", "org-1", request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", recorder.Code)
+	}
+	created := service.clusters[len(service.clusters)-1]
+	if created.CustomerID != "org-1" {
+		t.Errorf("expected cluster to be created for 'org-1', got '%s'", created.CustomerID)
+	}
+}
+
+func TestCreateClusterForbidden(t *testing.T) {
+	server, _ := newTestServer(t)
+	request := httptest.NewRequest("POST", "/api/clusters_mgmt/v1/clusters",
+		strings.NewReader(`{"name": "mine"}`))
+	recorder := serveAs(server, "clusters:create", server.createCluster, "support-readonly", "", request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for read only role, got %d", recorder.Code)
+	}
+}
+
+func TestClustersBadRequests(t *testing.T) {
+	server, _ := newTestServer(t)
+	request := httptest.NewRequest("GET", "/api/clusters_mgmt/v1/clusters?size=5000", nil)
+	recorder := serveAs(server, "clusters:list", server.listClusters, "customer", "org-1", request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for too large page, got %d", recorder.Code)
+	}
+
+	request = httptest.NewRequest("POST", "/api/clusters_mgmt/v1/clusters",
+		strings.NewReader(`{"name": "mine", "color": "blue"}`))
+	recorder = serveAs(server, "clusters:create", server.createCluster, "customer", "org-1", request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown field, got %d", recorder.Code)
+	}
+}
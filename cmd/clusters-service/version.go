@@ -0,0 +1,47 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/sql"
+	"github.com/spf13/cobra"
+)
+
+// version is the version of the service. It is set when building the binary
+// with the linker flag '-X main.version=...'.
+var version = "unknown"
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version",
+	Args:  cobra.NoArgs,
+	Run:   runVersion,
+}
+
+func runVersion(cmd *cobra.Command, args []string) {
+	fmt.Println(version)
+	// The migrations compiled into the binary determine the schema version
+	// that it expects:
+	schema, err := sql.LatestVersionFS(migrationsFS())
+	if err != nil {
+		fmt.Printf("schema: unknown (%v)\n", err)
+		return
+	}
+	fmt.Printf("schema: %d\n", schema)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/config"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// Config is the configuration of the customers service. It is loaded from
+// the configuration file, the environment and the flags of the command, in
+// that order of precedence. Each command adds the flags of the parts that it
+// uses.
+type Config struct {
+	Host               string            `yaml:"host" flag:"host" help:"The IP address or host name of the server."`
+	Port               int               `yaml:"port" flag:"port" help:"The port number of the server."`
+	Store              string            `yaml:"store" flag:"store" help:"The datastore of the customers, one of 'sql', 'etcd' or 'memory'."`
+	DualWriteStore     string            `yaml:"dual_write_store" flag:"dual-write-store" help:"Additional datastore where changes to customers will also be written, to keep it in sync while migrating to it. Empty to disable."`
+	NotificationsTopic string            `yaml:"notifications_topic" flag:"notifications-topic" help:"The name of the topic listening to notifications, for example: customers.notifications"`
+	Logging            config.Logging    `yaml:"logging"`
+	Database           config.Database   `yaml:"database"`
+	Etcd               EtcdConfig        `yaml:"etcd"`
+	Auth               config.Auth       `yaml:"auth"`
+	RateLimits         config.RateLimits `yaml:"rate_limits"`
+	TLS                config.TLS        `yaml:"tls"`
+	Tracing            config.Tracing    `yaml:"tracing"`
+	Shutdown           config.Shutdown   `yaml:"shutdown"`
+}
+
+// EtcdConfig is the location of the etcd datastore.
+type EtcdConfig struct {
+	Endpoint string `yaml:"endpoint" env:"ETCD_ENDPOINT" flag:"etcd-endpoint" help:"The endpoint of the etcd datastore."`
+}
+
+// defaultConfig returns the configuration used when nothing else is given.
+// There is no default database password, it must be given explicitly.
+func defaultConfig() Config {
+	database := config.DefaultDatabase()
+	database.Name = "customers"
+	database.User = "postgres"
+	database.ApplicationName = "customers-service"
+	return Config{
+		Host:               "0.0.0.0",
+		Port:               8000,
+		Store:              storeSQL,
+		NotificationsTopic: "customers.notifications",
+		Logging: config.Logging{
+			Level: "info",
+		},
+		Database: database,
+		Etcd: EtcdConfig{
+			Endpoint: defaultEtcdEndpoint,
+		},
+		Auth: config.Auth{
+			Audience: "customers-service",
+		},
+		RateLimits: config.RateLimits{
+			Read:    "100/s",
+			Write:   "10/s",
+			Address: "200/s",
+		},
+		TLS:      config.DefaultTLS(),
+		Tracing:  config.DefaultTracing("customers-service"),
+		Shutdown: config.DefaultShutdown(),
+	}
+}
+
+// Validate checks the datastores.
+func (c *Config) Validate() error {
+	for _, store := range []string{c.Store, c.DualWriteStore} {
+		switch store {
+		case "", storeSQL, storeEtcd, storeMemory:
+		default:
+			return fmt.Errorf("unknown datastore '%s', valid datastores are '%s', '%s' and '%s'",
+				store, storeSQL, storeEtcd, storeMemory)
+		}
+	}
+	if c.DualWriteStore != "" && c.DualWriteStore == c.Store {
+		return fmt.Errorf("the dual write datastore must be different to '%s'", c.Store)
+	}
+	return nil
+}
+
+// customersConfig is the configuration of the command that is running. The
+// defaults are used to create the flags.
+var customersConfig = defaultConfig()
+
+// addConfigFlags adds to the command the flags of the given parts of the
+// configuration.
+func addConfigFlags(cmd *cobra.Command, parts ...interface{}) {
+	for _, part := range parts {
+		err := config.AddFlags(cmd.Flags(), part)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// loadConfig loads the configuration of the command from the configuration
+// file, the environment and the flags of the command, and sets the log level.
+// The log level of the 'serve' command can also be changed later using the
+// API, or reloading the configuration.
+func loadConfig(cmd *cobra.Command) {
+	err := config.Load(&customersConfig, configFile, cmd.Flags())
+	if err != nil {
+		logging.Fatalf("Can't load configuration: %v", err)
+	}
+	level, err := logging.ParseLevel(customersConfig.Logging.Level)
+	if err != nil {
+		logging.Fatalf("Can't set log level: %v", err)
+	}
+	logging.SetLevel(level)
+}
+
+// reloadConfig returns a function that loads the configuration again, from
+// the same file, environment and flags, and applies the parts that can
+// change while the service runs. Currently that is only the log level, the
+// rest is used when the service is restarted.
+func reloadConfig(cmd *cobra.Command) func() error {
+	return func() error {
+		reloaded := defaultConfig()
+		err := config.Load(&reloaded, configFile, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		level, err := logging.ParseLevel(reloaded.Logging.Level)
+		if err != nil {
+			return err
+		}
+		logging.SetLevel(level)
+		return nil
+	}
+}
@@ -16,10 +16,194 @@ limitations under the License.
 
 package main
 
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Possible values of the status of a customer.
+const (
+	CustomerStatusActive    = "active"
+	CustomerStatusSuspended = "suspended"
+	CustomerStatusDisabled  = "disabled"
+)
+
 // Customer struct is the internatl object representing information on
 // a single Customer.
 type Customer struct {
-	ID            string   `json:"id"`
-	Name          string   `json:"name"`
-	OwnedClusters []string `json:"owned_clusters"`
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	OrganizationName string    `json:"organization_name,omitempty"`
+	ContactPhone     string    `json:"contact_phone,omitempty"`
+	BillingAccountID string    `json:"billing_account_id,omitempty"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	OwnedClusters    []string  `json:"owned_clusters"`
+}
+
+// ValidationError is returned by the customers services when the supplied
+// customer is not valid.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// DuplicateEmailError is returned by the customers services when the email of
+// the supplied customer is already used by another customer.
+type DuplicateEmailError struct {
+	Email string
+}
+
+func (e *DuplicateEmailError) Error() string {
+	return fmt.Sprintf("a customer with email '%s' already exists", e.Email)
+}
+
+// ClusterOwnedError is returned by the customers services when one of the
+// clusters of the supplied customer is already owned by other customer.
+type ClusterOwnedError struct {
+	ClusterID  string
+	CustomerID string
+}
+
+func (e *ClusterOwnedError) Error() string {
+	return fmt.Sprintf("cluster '%s' is already owned by customer '%s'", e.ClusterID, e.CustomerID)
+}
+
+// ownedClustersAddition returns a copy of the customer that also owns the
+// given clusters, or nil if the customer already owns all of them.
+func (customer *Customer) ownedClustersAddition(clusterIDs []string) (*Customer, error) {
+	owned := append(make([]string, 0, len(customer.OwnedClusters)+len(clusterIDs)),
+		customer.OwnedClusters...)
+	for _, clusterID := range clusterIDs {
+		if clusterID == "" {
+			return nil, &ValidationError{Field: "cluster_id", Reason: "cluster identifier is empty"}
+		}
+		if !containsString(owned, clusterID) {
+			owned = append(owned, clusterID)
+		}
+	}
+	if len(owned) == len(customer.OwnedClusters) {
+		return nil, nil
+	}
+	result := *customer
+	result.OwnedClusters = owned
+	result.UpdatedAt = time.Now().UTC()
+	return &result, nil
+}
+
+// conflictWith checks that the email and the clusters of the customer aren't
+// used by the other customer, which is ignored if it has the same identifier.
+func (customer *Customer) conflictWith(other *Customer) error {
+	if other.ID == customer.ID {
+		return nil
+	}
+	if strings.EqualFold(other.Email, customer.Email) {
+		return &DuplicateEmailError{Email: customer.Email}
+	}
+	for _, cluster := range customer.OwnedClusters {
+		if containsString(other.OwnedClusters, cluster) {
+			return &ClusterOwnedError{ClusterID: cluster, CustomerID: other.ID}
+		}
+	}
+	return nil
+}
+
+// Validate checks that the customer has all the mandatory fields and that
+// they have a valid format.
+func (customer *Customer) Validate() error {
+	if customer.Name == "" {
+		return &ValidationError{Field: "name", Reason: "name is mandatory"}
+	}
+	if customer.Email == "" {
+		return &ValidationError{Field: "email", Reason: "email is mandatory"}
+	}
+	address, err := mail.ParseAddress(customer.Email)
+	if err != nil || address.Address != customer.Email {
+		return &ValidationError{
+			Field:  "email",
+			Reason: fmt.Sprintf("'%s' is not a valid email address", customer.Email),
+		}
+	}
+	switch customer.Status {
+	case CustomerStatusActive, CustomerStatusSuspended, CustomerStatusDisabled:
+	default:
+		return &ValidationError{
+			Field:  "status",
+			Reason: fmt.Sprintf("unknown status '%s'", customer.Status),
+		}
+	}
+	clusters := make(map[string]bool, len(customer.OwnedClusters))
+	for _, cluster := range customer.OwnedClusters {
+		if cluster == "" {
+			return &ValidationError{Field: "owned_clusters", Reason: "cluster identifier is empty"}
+		}
+		if clusters[cluster] {
+			return &ValidationError{
+				Field:  "owned_clusters",
+				Reason: fmt.Sprintf("cluster '%s' appears more than once", cluster),
+			}
+		}
+		clusters[cluster] = true
+	}
+	return nil
+}
+
+// newCustomer prepares a customer to be stored by one of the customers
+// services: it fills the identifier, the defaults and the timestamps, and
+// then validates the result.
+func newCustomer(id string, customer Customer) (*Customer, error) {
+	now := time.Now().UTC()
+	result := customer
+	result.ID = id
+	result.CreatedAt = now
+	result.UpdatedAt = now
+	if result.Status == "" {
+		result.Status = CustomerStatusActive
+	}
+	if result.OwnedClusters == nil {
+		result.OwnedClusters = make([]string, 0)
+	}
+	err := result.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// replacementCustomer prepares a customer that will replace an existing one
+// (or that will be created with its own identifier if there is no existing
+// customer). Timestamps that aren't supplied are preserved from the existing
+// customer or set to the current time. The result is validated.
+func replacementCustomer(customer Customer, existing *Customer) (*Customer, error) {
+	now := time.Now().UTC()
+	result := customer
+	if result.CreatedAt.IsZero() {
+		if existing != nil {
+			result.CreatedAt = existing.CreatedAt
+		} else {
+			result.CreatedAt = now
+		}
+	}
+	if result.UpdatedAt.IsZero() {
+		result.UpdatedAt = now
+	}
+	if result.Status == "" {
+		result.Status = CustomerStatusActive
+	}
+	if result.OwnedClusters == nil {
+		result.OwnedClusters = make([]string, 0)
+	}
+	err := result.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
@@ -0,0 +1,426 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// CustomerFilter is a parsed search expression used to select customers. The
+// expression is a list of conditions joined with the 'and' keyword, where
+// each condition compares one field of the customer with a single quoted
+// value, for example:
+//
+//	name ~ 'acme' and status = 'active' and created_at >= '2018-01-01'
+//
+// The supported fields and operators are:
+//
+//	id                = (equal)
+//	name              = (equal), ~ (contains, case insensitive)
+//	email             = (equal, case insensitive), ~ (contains, case insensitive)
+//	status            = (equal), != (not equal)
+//	created_at        <, <=, >, >= (RFC 3339 time or YYYY-MM-DD date)
+//	owned_clusters    contains (owns the cluster with the given identifier)
+type CustomerFilter struct {
+	Conditions []*CustomerCondition
+}
+
+// CustomerCondition is a single condition of a customer filter.
+type CustomerCondition struct {
+	Field    string
+	Operator string
+	Value    string
+
+	// time is the parsed value of conditions on timestamp fields.
+	time time.Time
+
+	// values are the identifiers selected by the conditions created by
+	// RestrictToIDs.
+	values []string
+}
+
+// Names of the filter operators.
+const (
+	filterOpEqual          = "="
+	filterOpNotEqual       = "!="
+	filterOpContains       = "~"
+	filterOpLess           = "<"
+	filterOpLessOrEqual    = "<="
+	filterOpGreater        = ">"
+	filterOpGreaterOrEqual = ">="
+	filterOpHas            = "contains"
+
+	// filterOpIn isn't supported in search expressions, it is only used by
+	// the conditions created by RestrictToIDs.
+	filterOpIn = "in"
+)
+
+// filterOperators contains the operators supported by each field.
+var filterOperators = map[string][]string{
+	"id":             {filterOpEqual},
+	"name":           {filterOpEqual, filterOpContains},
+	"email":          {filterOpEqual, filterOpContains},
+	"status":         {filterOpEqual, filterOpNotEqual},
+	"created_at":     {filterOpLess, filterOpLessOrEqual, filterOpGreater, filterOpGreaterOrEqual},
+	"owned_clusters": {filterOpHas},
+}
+
+// FilterSyntaxError is returned when a search expression can't be parsed.
+type FilterSyntaxError struct {
+	Position int
+	Reason   string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("invalid search expression at position %d: %s", e.Position, e.Reason)
+}
+
+// ParseCustomerFilter parses a search expression. An empty expression
+// results in a nil filter, that selects all the customers.
+func ParseCustomerFilter(text string) (*CustomerFilter, error) {
+	tokens, err := tokenizeFilter(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	filter := new(CustomerFilter)
+	for len(tokens) > 0 {
+		if len(filter.Conditions) > 0 {
+			if tokens[0].kind != filterTokenWord || strings.ToLower(tokens[0].text) != "and" {
+				return nil, &FilterSyntaxError{Position: tokens[0].position, Reason: "expected 'and'"}
+			}
+			tokens = tokens[1:]
+		}
+		var condition *CustomerCondition
+		condition, tokens, err = parseFilterCondition(tokens, len(text))
+		if err != nil {
+			return nil, err
+		}
+		filter.Conditions = append(filter.Conditions, condition)
+	}
+	return filter, nil
+}
+
+func parseFilterCondition(tokens []filterToken, end int) (*CustomerCondition, []filterToken, error) {
+	if len(tokens) < 3 {
+		position := end
+		if len(tokens) > 0 {
+			position = tokens[len(tokens)-1].position
+		}
+		return nil, nil, &FilterSyntaxError{Position: position, Reason: "incomplete condition"}
+	}
+	field, operator, value := tokens[0], tokens[1], tokens[2]
+	if field.kind != filterTokenWord {
+		return nil, nil, &FilterSyntaxError{Position: field.position, Reason: "expected field name"}
+	}
+	operators, ok := filterOperators[field.text]
+	if !ok {
+		return nil, nil, &FilterSyntaxError{
+			Position: field.position,
+			Reason:   fmt.Sprintf("unknown field '%s'", field.text),
+		}
+	}
+	if operator.kind != filterTokenOperator && operator.kind != filterTokenWord {
+		return nil, nil, &FilterSyntaxError{Position: operator.position, Reason: "expected operator"}
+	}
+	if !containsString(operators, operator.text) {
+		return nil, nil, &FilterSyntaxError{
+			Position: operator.position,
+			Reason: fmt.Sprintf(
+				"operator '%s' isn't supported for field '%s'",
+				operator.text, field.text,
+			),
+		}
+	}
+	if value.kind != filterTokenString {
+		return nil, nil, &FilterSyntaxError{Position: value.position, Reason: "expected quoted value"}
+	}
+	condition := &CustomerCondition{
+		Field:    field.text,
+		Operator: operator.text,
+		Value:    value.text,
+	}
+	if condition.Field == "created_at" {
+		parsed, err := parseFilterTime(value.text)
+		if err != nil {
+			return nil, nil, &FilterSyntaxError{
+				Position: value.position,
+				Reason:   fmt.Sprintf("'%s' isn't a valid time", value.text),
+			}
+		}
+		condition.time = parsed
+	}
+	return condition, tokens[3:], nil
+}
+
+func parseFilterTime(text string) (time.Time, error) {
+	result, err := time.Parse(time.RFC3339, text)
+	if err == nil {
+		return result, nil
+	}
+	return time.Parse("2006-01-02", text)
+}
+
+// RestrictToID returns a copy of the filter that, in addition to the
+// conditions of the filter, only selects the customer with the given
+// identifier.
+func (filter *CustomerFilter) RestrictToID(id string) *CustomerFilter {
+	result := new(CustomerFilter)
+	if filter != nil {
+		result.Conditions = append(result.Conditions, filter.Conditions...)
+	}
+	result.Conditions = append(result.Conditions, &CustomerCondition{
+		Field:    "id",
+		Operator: filterOpEqual,
+		Value:    id,
+	})
+	return result
+}
+
+// RestrictToIDs returns a copy of the filter that, in addition to the
+// conditions of the filter, only selects the customers with the given
+// identifiers.
+func (filter *CustomerFilter) RestrictToIDs(ids []string) *CustomerFilter {
+	result := new(CustomerFilter)
+	if filter != nil {
+		result.Conditions = append(result.Conditions, filter.Conditions...)
+	}
+	result.Conditions = append(result.Conditions, &CustomerCondition{
+		Field:    "id",
+		Operator: filterOpIn,
+		values:   ids,
+	})
+	return result
+}
+
+// ToSQL translates the filter into a condition that can be used in the
+// 'where' clause of a query on the customers table. The values of the filter
+// are returned as query arguments, numbered starting with the given index.
+func (filter *CustomerFilter) ToSQL(firstArg int) (clause string, args []interface{}) {
+	if filter == nil || len(filter.Conditions) == 0 {
+		return "true", nil
+	}
+	clauses := make([]string, len(filter.Conditions))
+	args = make([]interface{}, len(filter.Conditions))
+	for i, condition := range filter.Conditions {
+		placeholder := fmt.Sprintf("$%d", firstArg+i)
+		switch {
+		case condition.Operator == filterOpContains:
+			clauses[i] = fmt.Sprintf(`%s ilike %s escape '\'`, condition.Field, placeholder)
+			args[i] = "%" + escapeLikePattern(condition.Value) + "%"
+		case condition.Operator == filterOpHas:
+			clauses[i] = fmt.Sprintf(`exists (
+				select 1 from owned_clusters
+				where owned_clusters.customer_id = customers.id
+				and owned_clusters.cluster_id = %s)`,
+				placeholder)
+			args[i] = condition.Value
+		case condition.Field == "email" && condition.Operator == filterOpEqual:
+			// Emails are unique ignoring case, so they are also compared
+			// ignoring case:
+			clauses[i] = fmt.Sprintf("lower(email) = lower(%s)", placeholder)
+			args[i] = condition.Value
+		case condition.Operator == filterOpIn:
+			clauses[i] = fmt.Sprintf("%s = any(%s)", condition.Field, placeholder)
+			args[i] = pq.Array(condition.values)
+		case condition.Field == "created_at":
+			clauses[i] = fmt.Sprintf("%s %s %s", condition.Field, condition.Operator, placeholder)
+			args[i] = condition.time
+		default:
+			clauses[i] = fmt.Sprintf("%s %s %s", condition.Field, sqlOperator(condition.Operator), placeholder)
+			args[i] = condition.Value
+		}
+	}
+	return strings.Join(clauses, " and "), args
+}
+
+func sqlOperator(operator string) string {
+	if operator == filterOpNotEqual {
+		return "<>"
+	}
+	return operator
+}
+
+func escapeLikePattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
+
+// Matches checks if the customer satisfies all the conditions of the filter.
+// A nil filter matches all the customers.
+func (filter *CustomerFilter) Matches(customer *Customer) bool {
+	if filter == nil {
+		return true
+	}
+	for _, condition := range filter.Conditions {
+		if !condition.matches(customer) {
+			return false
+		}
+	}
+	return true
+}
+
+func (condition *CustomerCondition) matches(customer *Customer) bool {
+	switch condition.Field {
+	case "id":
+		if condition.Operator == filterOpIn {
+			return containsString(condition.values, customer.ID)
+		}
+		return customer.ID == condition.Value
+	case "name":
+		return matchText(condition.Operator, customer.Name, condition.Value)
+	case "email":
+		if condition.Operator == filterOpEqual {
+			return strings.EqualFold(customer.Email, condition.Value)
+		}
+		return matchText(condition.Operator, customer.Email, condition.Value)
+	case "status":
+		equal := customer.Status == condition.Value
+		if condition.Operator == filterOpNotEqual {
+			return !equal
+		}
+		return equal
+	case "created_at":
+		switch condition.Operator {
+		case filterOpLess:
+			return customer.CreatedAt.Before(condition.time)
+		case filterOpLessOrEqual:
+			return !customer.CreatedAt.After(condition.time)
+		case filterOpGreater:
+			return customer.CreatedAt.After(condition.time)
+		case filterOpGreaterOrEqual:
+			return !customer.CreatedAt.Before(condition.time)
+		}
+	case "owned_clusters":
+		return containsString(customer.OwnedClusters, condition.Value)
+	}
+	return false
+}
+
+func matchText(operator string, text string, value string) bool {
+	if operator == filterOpContains {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(value))
+	}
+	return text == value
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Kinds of the tokens of a search expression.
+const (
+	filterTokenWord = iota
+	filterTokenOperator
+	filterTokenString
+)
+
+type filterToken struct {
+	kind     int
+	text     string
+	position int
+}
+
+func tokenizeFilter(text string) ([]filterToken, error) {
+	runes := []rune(text)
+	tokens := make([]filterToken, 0)
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, filterToken{
+				kind:     filterTokenWord,
+				text:     string(runes[start:i]),
+				position: start,
+			})
+		case strings.ContainsRune("=!~<>", r):
+			start := i
+			for i < len(runes) && strings.ContainsRune("=!~<>", runes[i]) {
+				i++
+			}
+			operator := string(runes[start:i])
+			switch operator {
+			case filterOpEqual, filterOpNotEqual, filterOpContains, filterOpLess,
+				filterOpLessOrEqual, filterOpGreater, filterOpGreaterOrEqual:
+			default:
+				return nil, &FilterSyntaxError{
+					Position: start,
+					Reason:   fmt.Sprintf("unknown operator '%s'", operator),
+				}
+			}
+			tokens = append(tokens, filterToken{
+				kind:     filterTokenOperator,
+				text:     operator,
+				position: start,
+			})
+		case r == '\'':
+			// Strings are delimited by single quotes, and a single quote inside
+			// the string is written as two single quotes:
+			start := i
+			i++
+			value := make([]rune, 0)
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						value = append(value, '\'')
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				value = append(value, runes[i])
+				i++
+			}
+			if !closed {
+				return nil, &FilterSyntaxError{Position: start, Reason: "unterminated string"}
+			}
+			tokens = append(tokens, filterToken{
+				kind:     filterTokenString,
+				text:     string(value),
+				position: start,
+			})
+		default:
+			return nil, &FilterSyntaxError{
+				Position: i,
+				Reason:   fmt.Sprintf("unexpected character '%c'", r),
+			}
+		}
+	}
+	return tokens, nil
+}
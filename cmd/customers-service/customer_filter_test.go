@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCustomerFilterEmpty(t *testing.T) {
+	filter, err := ParseCustomerFilter("  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter != nil {
+		t.Errorf("expected nil filter for empty expression, got %+v", filter)
+	}
+	clause, args := filter.ToSQL(1)
+	if clause != "true" || len(args) != 0 {
+		t.Errorf("expected nil filter to select everything, got '%s' %v", clause, args)
+	}
+}
+
+func TestParseCustomerFilterErrors(t *testing.T) {
+	expressions := []string{
+		"name",
+		"name ~",
+		"name ~ acme",
+		"name ~ 'acme",
+		"phone = '1234'",
+		"status ~ 'active'",
+		"name = 'a' or email = 'b'",
+		"name = 'a' email = 'b'",
+		"created_at > 'yesterday'",
+		"name => 'a'",
+		"name = 'a' and",
+		"name = 'a'; drop table customers",
+	}
+	for _, expression := range expressions {
+		_, err := ParseCustomerFilter(expression)
+		if _, ok := err.(*FilterSyntaxError); !ok {
+			t.Errorf("expected syntax error for '%s', got %v", expression, err)
+		}
+	}
+}
+
+func TestCustomerFilterToSQL(t *testing.T) {
+	filter, err := ParseCustomerFilter(
+		"name ~ 'o''brien_%' AND status != 'disabled' and " +
+			"created_at >= '2018-01-02' and owned_clusters contains 'c1'",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clause, args := filter.ToSQL(3)
+	expectedClause := `name ilike $3 escape '\' and status <> $4 and created_at >= $5 and exists (
+				select 1 from owned_clusters
+				where owned_clusters.customer_id = customers.id
+				and owned_clusters.cluster_id = $6)`
+	if clause != expectedClause {
+		t.Errorf("unexpected clause:\n%s", clause)
+	}
+	expectedArgs := []interface{}{
+		`%o'brien\_\%%`,
+		"disabled",
+		time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC),
+		"c1",
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected arguments %v, got %v", expectedArgs, args)
+	}
+}
+
+func TestCustomerFilterMatches(t *testing.T) {
+	customer := &Customer{
+		Name:          "ACME Corp",
+		Email:         "admin@acme.com",
+		Status:        CustomerStatusActive,
+		CreatedAt:     time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC),
+		OwnedClusters: []string{"c1", "c2"},
+	}
+	tests := map[string]bool{
+		"name ~ 'acme'":                                  true,
+		"name = 'acme'":                                  false,
+		"name = 'ACME Corp'":                             true,
+		"email ~ '@ACME.'":                               true,
+		"email = 'Admin@ACME.com'":                       true,
+		"email = 'admin@acme'":                           false,
+		"status = 'active'":                              true,
+		"status != 'active'":                             false,
+		"created_at > '2018-06-01'":                      true,
+		"created_at < '2018-06-01T12:00:00Z'":            false,
+		"created_at <= '2018-06-01T12:00:00Z'":           true,
+		"created_at >= '2018-06-02'":                     false,
+		"owned_clusters contains 'c2'":                   true,
+		"owned_clusters contains 'c3'":                   false,
+		"name ~ 'acme' and owned_clusters contains 'c1'": true,
+		"name ~ 'acme' and status = 'suspended'":         false,
+	}
+	for expression, expected := range tests {
+		filter, err := ParseCustomerFilter(expression)
+		if err != nil {
+			t.Errorf("can't parse '%s': %v", expression, err)
+			continue
+		}
+		if filter.Matches(customer) != expected {
+			t.Errorf("expected '%s' to return %v", expression, expected)
+		}
+	}
+}
+
+func TestRestrictToID(t *testing.T) {
+	customer := &Customer{ID: "c1", Name: "ACME Corp", Status: CustomerStatusActive}
+	filter, err := ParseCustomerFilter("name ~ 'acme'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.RestrictToID("c1").Matches(customer) {
+		t.Errorf("expected restricted filter to match customer 'c1'")
+	}
+	if filter.RestrictToID("c2").Matches(customer) {
+		t.Errorf("expected restricted filter not to match customer 'c1'")
+	}
+	if len(filter.Conditions) != 1 {
+		t.Errorf("expected original filter not to change, got %d conditions", len(filter.Conditions))
+	}
+	var empty *CustomerFilter
+	clause, args := empty.RestrictToID("c1").ToSQL(1)
+	if clause != "id = $1" || len(args) != 1 || args[0] != "c1" {
+		t.Errorf("expected clause 'id = $1' with argument 'c1', got '%s' with %v", clause, args)
+	}
+}
+
+func TestCustomerFilterEmailToSQL(t *testing.T) {
+	filter, err := ParseCustomerFilter("email = 'Admin@ACME.com'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clause, args := filter.ToSQL(1)
+	if clause != "lower(email) = lower($1)" || len(args) != 1 || args[0] != "Admin@ACME.com" {
+		t.Errorf("expected clause 'lower(email) = lower($1)', got '%s' with %v", clause, args)
+	}
+}
+
+func TestRestrictToIDs(t *testing.T) {
+	var filter *CustomerFilter
+	restricted := filter.RestrictToIDs([]string{"c1", "c2"})
+	for _, test := range []struct {
+		id      string
+		matches bool
+	}{
+		{"c1", true},
+		{"c2", true},
+		{"c3", false},
+	} {
+		if restricted.Matches(&Customer{ID: test.id}) != test.matches {
+			t.Errorf("expected match of customer '%s' to be %v", test.id, test.matches)
+		}
+	}
+	clause, args := restricted.ToSQL(3)
+	if clause != "id = any($3)" || len(args) != 1 {
+		t.Errorf("expected clause 'id = any($3)' with one argument, got '%s' with %v", clause, args)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		customer Customer
+		field    string
+	}{
+		{Customer{Name: "a", Email: "a@example.com", Status: CustomerStatusActive}, ""},
+		{Customer{Name: "a", Email: "a@example.com", Status: CustomerStatusSuspended}, ""},
+		{Customer{Email: "a@example.com", Status: CustomerStatusActive}, "name"},
+		{Customer{Name: "a", Status: CustomerStatusActive}, "email"},
+		{Customer{Name: "a", Email: "not-an-email", Status: CustomerStatusActive}, "email"},
+		{Customer{Name: "a", Email: "A <a@example.com>", Status: CustomerStatusActive}, "email"},
+		{Customer{Name: "a", Email: "a@example.com", Status: "unknown"}, "status"},
+	}
+	for _, test := range tests {
+		err := test.customer.Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("expected customer %+v to be valid, got %v", test.customer, err)
+			}
+			continue
+		}
+		validationErr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("expected validation error for customer %+v, got %v", test.customer, err)
+			continue
+		}
+		if validationErr.Field != test.field {
+			t.Errorf("expected invalid field to be %s instead it was %s", test.field, validationErr.Field)
+		}
+	}
+}
+
+func TestNewCustomerDefaults(t *testing.T) {
+	result, err := newCustomer("id", Customer{Name: "a", Email: "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "id" {
+		t.Errorf("expected id to be 'id' instead it was '%s'", result.ID)
+	}
+	if result.Status != CustomerStatusActive {
+		t.Errorf("expected status to be %s instead it was %s", CustomerStatusActive, result.Status)
+	}
+	if result.OwnedClusters == nil {
+		t.Errorf("expected owned clusters to be an empty list")
+	}
+	if result.CreatedAt.IsZero() || !result.CreatedAt.Equal(result.UpdatedAt) {
+		t.Errorf("expected creation and update timestamps to be set and equal")
+	}
+}
@@ -1,9 +1,60 @@
 create database customers;
 create table customers (
-  id             text not null unique primary key,
-  name           text not null,
+  id                  text not null unique primary key,
+  name                text not null,
+  email               text not null,
+  organization_name   text not null default '',
+  contact_phone       text not null default '',
+  billing_account_id  text not null default '',
+  status              text not null default 'active',
+  created_at          timestamp with time zone not null default now(),
+  updated_at          timestamp with time zone not null default now()
 );
+create unique index customers_email_idx on customers (lower(email));
 create table owned_clusters (
   customer_id  text not null references customers (id),
   cluster_id   text not null unique
 );
+create table organizations (
+  id          text not null unique primary key,
+  name        text not null,
+  created_at  timestamp with time zone not null default now(),
+  updated_at  timestamp with time zone not null default now()
+);
+create table users (
+  id          text not null unique primary key,
+  email       text not null,
+  name        text not null default '',
+  created_at  timestamp with time zone not null default now()
+);
+create unique index users_email_idx on users (lower(email));
+create table memberships (
+  organization_id  text not null references organizations (id),
+  user_id          text not null references users (id),
+  role             text not null,
+  created_at       timestamp with time zone not null default now(),
+  primary key (organization_id, user_id)
+);
+create table quotas (
+  customer_id             text not null unique primary key,
+  max_clusters            bigint,
+  max_nodes               bigint,
+  allowed_regions         text[] not null default '{}',
+  allowed_instance_types  text[] not null default '{}',
+  updated_at              timestamp with time zone not null default now()
+);
+create table service_accounts (
+  id               text not null unique primary key,
+  name             text not null,
+  organization_id  text not null,
+  roles            text[] not null default '{}',
+  scopes           text[],
+  key_id           text not null unique,
+  key_hash         text not null,
+  expires_at       timestamp with time zone,
+  created_at       timestamp with time zone not null default now(),
+  rotated_at       timestamp with time zone not null default now(),
+  last_used_at     timestamp with time zone,
+  revoked_at       timestamp with time zone
+);
+create index service_accounts_organization_idx on service_accounts (organization_id);
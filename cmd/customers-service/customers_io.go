@@ -0,0 +1,301 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats supported by the export and import commands.
+const (
+	formatJSONLines = "jsonl"
+	formatCSV       = "csv"
+)
+
+// maxJSONLineSize is the maximum size in bytes of a line of the JSON Lines
+// format. Each line contains a customer, and the default limit of the
+// scanner, 64 KiB, is too small for customers that own many clusters.
+const maxJSONLineSize = 16 * 1024 * 1024
+
+// csvHeader contains the names of the columns of the CSV format. The owned
+// clusters are stored in a single column, separated by semicolons.
+var csvHeader = []string{
+	"id",
+	"name",
+	"email",
+	"organization_name",
+	"contact_phone",
+	"billing_account_id",
+	"status",
+	"created_at",
+	"updated_at",
+	"owned_clusters",
+}
+
+// customerWriter writes customers to a stream using one of the supported
+// formats.
+type customerWriter interface {
+	// Write writes a single customer.
+	Write(customer *Customer) error
+
+	// Flush writes any buffered data to the underlying stream.
+	Flush() error
+}
+
+// customerReader reads customers from a stream using one of the supported
+// formats.
+type customerReader interface {
+	// Read reads the next customer, and returns the number of the line where
+	// it was read from. At the end of the stream it returns io.EOF. Errors
+	// in a single record are returned as *recordError, and can be skipped
+	// calling Read again. Other errors mean that the stream can't be read.
+	Read() (customer *Customer, line int, err error)
+}
+
+// recordError is returned by customer readers when a single record can't be
+// decoded.
+type recordError struct {
+	err error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+// newCustomerWriter creates a writer for the given format.
+func newCustomerWriter(format string, out io.Writer) (customerWriter, error) {
+	switch format {
+	case formatJSONLines:
+		writer := bufio.NewWriter(out)
+		return &jsonLinesCustomerWriter{
+			buffer:  writer,
+			encoder: json.NewEncoder(writer),
+		}, nil
+	case formatCSV:
+		return &csvCustomerWriter{
+			writer: csv.NewWriter(out),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s', valid formats are '%s' and '%s'",
+			format, formatJSONLines, formatCSV)
+	}
+}
+
+// newCustomerReader creates a reader for the given format.
+func newCustomerReader(format string, in io.Reader) (customerReader, error) {
+	switch format {
+	case formatJSONLines:
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+		return &jsonLinesCustomerReader{
+			scanner: scanner,
+		}, nil
+	case formatCSV:
+		reader := csv.NewReader(in)
+		reader.FieldsPerRecord = -1
+		return &csvCustomerReader{
+			reader: reader,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s', valid formats are '%s' and '%s'",
+			format, formatJSONLines, formatCSV)
+	}
+}
+
+type jsonLinesCustomerWriter struct {
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (w *jsonLinesCustomerWriter) Write(customer *Customer) error {
+	return w.encoder.Encode(customer)
+}
+
+func (w *jsonLinesCustomerWriter) Flush() error {
+	return w.buffer.Flush()
+}
+
+type jsonLinesCustomerReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonLinesCustomerReader) Read() (*Customer, int, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		customer := new(Customer)
+		err := json.Unmarshal([]byte(text), customer)
+		if err != nil {
+			return nil, r.line, &recordError{err: err}
+		}
+		return customer, r.line, nil
+	}
+	err := r.scanner.Err()
+	if err != nil {
+		return nil, r.line, err
+	}
+	return nil, r.line, io.EOF
+}
+
+type csvCustomerWriter struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvCustomerWriter) Write(customer *Customer) error {
+	if !w.wroteHeader {
+		err := w.writer.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	return w.writer.Write([]string{
+		customer.ID,
+		customer.Name,
+		customer.Email,
+		customer.OrganizationName,
+		customer.ContactPhone,
+		customer.BillingAccountID,
+		customer.Status,
+		formatCSVTime(customer.CreatedAt),
+		formatCSVTime(customer.UpdatedAt),
+		strings.Join(customer.OwnedClusters, ";"),
+	})
+}
+
+func (w *csvCustomerWriter) Flush() error {
+	// Write the header even if there are no customers, so that the result
+	// can always be imported:
+	if !w.wroteHeader {
+		err := w.writer.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func formatCSVTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339Nano)
+}
+
+// csvCustomerReader reads customers from CSV data. The first record must be
+// a header containing the names of the columns, as in csvHeader. Only the
+// 'name' and 'email' columns are mandatory. Line numbers are actually record
+// numbers, which are different when values contain new lines.
+type csvCustomerReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+func (r *csvCustomerReader) Read() (*Customer, int, error) {
+	if r.columns == nil {
+		err := r.readHeader()
+		if err != nil {
+			return nil, r.line, err
+		}
+	}
+	record, err := r.reader.Read()
+	r.line++
+	if err == io.EOF {
+		return nil, r.line, io.EOF
+	}
+	if _, ok := err.(*csv.ParseError); ok {
+		return nil, r.line, &recordError{err: err}
+	}
+	if err != nil {
+		return nil, r.line, err
+	}
+	value := func(column string) string {
+		index, ok := r.columns[column]
+		if !ok || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+	customer := &Customer{
+		ID:               value("id"),
+		Name:             value("name"),
+		Email:            value("email"),
+		OrganizationName: value("organization_name"),
+		ContactPhone:     value("contact_phone"),
+		BillingAccountID: value("billing_account_id"),
+		Status:           value("status"),
+		OwnedClusters:    make([]string, 0),
+	}
+	customer.CreatedAt, err = parseCSVTime(value("created_at"))
+	if err != nil {
+		return nil, r.line, &recordError{err: fmt.Errorf("invalid created_at: %v", err)}
+	}
+	customer.UpdatedAt, err = parseCSVTime(value("updated_at"))
+	if err != nil {
+		return nil, r.line, &recordError{err: fmt.Errorf("invalid updated_at: %v", err)}
+	}
+	for _, cluster := range strings.Split(value("owned_clusters"), ";") {
+		cluster = strings.TrimSpace(cluster)
+		if cluster != "" {
+			customer.OwnedClusters = append(customer.OwnedClusters, cluster)
+		}
+	}
+	return customer, r.line, nil
+}
+
+func (r *csvCustomerReader) readHeader() error {
+	header, err := r.reader.Read()
+	r.line++
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("can't read header: %v", err)
+	}
+	columns := make(map[string]int)
+	for index, name := range header {
+		columns[strings.TrimSpace(name)] = index
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("header doesn't contain the mandatory column '%s'", required)
+		}
+	}
+	r.columns = columns
+	return nil
+}
+
+func parseCSVTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
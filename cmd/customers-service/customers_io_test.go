@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCustomersRoundTrip(t *testing.T) {
+	created := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	customers := []*Customer{
+		{
+			ID:               "id0",
+			Name:             "Customer, with comma",
+			Email:            "c0@example.com",
+			OrganizationName: "Example Inc.",
+			ContactPhone:     "+1 555 0100",
+			BillingAccountID: "ba-0",
+			Status:           CustomerStatusActive,
+			CreatedAt:        created,
+			UpdatedAt:        created.Add(time.Hour),
+			OwnedClusters:    []string{"cluster0", "cluster1"},
+		},
+		{
+			ID:            "id1",
+			Name:          "Customer \"quoted\"",
+			Email:         "c1@example.com",
+			Status:        CustomerStatusSuspended,
+			CreatedAt:     created,
+			UpdatedAt:     created,
+			OwnedClusters: []string{},
+		},
+	}
+	for _, format := range []string{formatJSONLines, formatCSV} {
+		buffer := new(bytes.Buffer)
+		writer, err := newCustomerWriter(format, buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, customer := range customers {
+			err = writer.Write(customer)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = writer.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := newCustomerReader(format, buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range customers {
+			actual, _, err := reader.Read()
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s: expected %+v, got %+v", format, expected, actual)
+			}
+		}
+		_, _, err = reader.Read()
+		if err != io.EOF {
+			t.Errorf("%s: expected end of input, got %v", format, err)
+		}
+	}
+}
+
+func TestReadLongJSONLine(t *testing.T) {
+	clusters := make([]string, 10000)
+	for i := range clusters {
+		clusters[i] = fmt.Sprintf("cluster-%05d", i)
+	}
+	expected := &Customer{
+		ID:            "id0",
+		Name:          "many clusters",
+		Email:         "c0@example.com",
+		Status:        CustomerStatusActive,
+		OwnedClusters: clusters,
+	}
+	buffer := new(bytes.Buffer)
+	err := json.NewEncoder(buffer).Encode(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() <= 64*1024 {
+		t.Fatalf("expected the line to be longer than 64 KiB, it is %d bytes", buffer.Len())
+	}
+
+	reader, err := newCustomerReader(formatJSONLines, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, _, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.OwnedClusters) != len(clusters) {
+		t.Errorf("expected %d owned clusters, got %d", len(clusters), len(actual.OwnedClusters))
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := newCustomerWriter("xml", new(bytes.Buffer))
+	if err == nil {
+		t.Errorf("expected error for unknown output format")
+	}
+	_, err = newCustomerReader("xml", new(bytes.Buffer))
+	if err == nil {
+		t.Errorf("expected error for unknown input format")
+	}
+}
+
+func TestImportDryRunReportsLines(t *testing.T) {
+	input := strings.Join([]string{
+		`{"id": "a", "name": "a", "email": "a@example.com"}`,
+		`{"id": "b", "name": "b", "email": "not-an-email"}`,
+		`this isn't json`,
+		``,
+		`{"id": "a", "name": "c", "email": "c@example.com"}`,
+		`{"name": "d", "email": "A@example.com"}`,
+		`{"name": "e", "email": "e@example.com"}`,
+	}, "\n")
+	reader, err := newCustomerReader(formatJSONLines, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors := new(bytes.Buffer)
+	report, err := importCustomers(nil, reader, errors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.succeeded != 2 || report.failed != 4 {
+		t.Errorf("expected 2 succeeded and 4 failed, got %+v", report)
+	}
+	for _, prefix := range []string{"line 2:", "line 3:", "line 5:", "line 6:"} {
+		if !strings.Contains(errors.String(), prefix) {
+			t.Errorf("expected errors to contain '%s', got:\n%s", prefix, errors.String())
+		}
+	}
+}
+
+func TestImportCSVRequiresHeader(t *testing.T) {
+	reader, err := newCustomerReader(formatCSV, strings.NewReader("id,name\nx,y\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = importCustomers(nil, reader, new(bytes.Buffer))
+	if err == nil {
+		t.Errorf("expected error for header without email column")
+	}
+}
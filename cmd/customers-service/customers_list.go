@@ -18,7 +18,7 @@ package main
 
 // CustomersList struct is the internal object representing a list of Customers.
 type CustomersList struct {
-	Page  int64       `json:"page, omitempty"`
+	Page  int64       `json:"page"`
 	Size  int64       `json:"size"`
 	Total int64       `json:"total"`
 	Items []*Customer `json:"items"`
@@ -16,25 +16,51 @@ limitations under the License.
 
 package main
 
+import (
+	"context"
+	"fmt"
+)
+
 // CustomersService is an interface exposing a set of operations required for
 // running and operating the customers of the Openshift Dedicated Portal.
 type CustomersService interface {
+	// The operations are canceled, and their spans are part of the trace, of
+	// the given context.
 
 	// List returns a pointer to CustomerList or error in case some error occurred.
 	// If list arguments are provided list will return the intended customers list.
-	// If nil is supplied list will return all customers.
-	List(args *ListArguments) (*CustomersList, error)
+	// If nil is supplied list will return all customers, up to the default
+	// limit. Pages after the last one are empty, and negative page numbers or
+	// sizes are rejected with ValidationError.
+	List(ctx context.Context, args *ListArguments) (*CustomersList, error)
 
 	// Add creates a customer and returns the newly created customer or error
 	// in case some error occurred.
 	// It receives a Customer object with its Name and (possibly) OwnedClusters,
 	// and creates a new Customer based on the supplied Customer parameter.
-	Add(customer Customer) (*Customer, error)
+	// It fails with DuplicateEmailError if the email is already used, and with
+	// ClusterOwnedError if any of the clusters is owned by other customer.
+	Add(ctx context.Context, customer Customer) (*Customer, error)
+
+	// Upsert creates or replaces the customer with the ID of the supplied
+	// customer, including its owned clusters, and returns the stored
+	// customer. If the supplied customer doesn't have an ID a new one is
+	// generated. Timestamps that aren't supplied are preserved from the
+	// existing customer, or set to the current time.
+	Upsert(ctx context.Context, customer Customer) (*Customer, error)
+
+	// AddOwnedClusters records that the customer with the given id owns the
+	// clusters, and returns the updated customer. Adding a cluster that the
+	// customer already owns does nothing. It fails with NotFoundError if the
+	// customer doesn't exist, and with ClusterOwnedError if any of the
+	// clusters is owned by other customer, in which case none of them is
+	// added.
+	AddOwnedClusters(ctx context.Context, id string, clusterIDs []string) (*Customer, error)
 
 	// Get returns a pointer to customer with id supplied or error if an
 	// error occurred.
 	// If no such customer exist Get returns nil pointer and nil error.
-	Get(id string) (*Customer, error)
+	Get(ctx context.Context, id string) (*Customer, error)
 
 	// Close closes the service.
 	Close()
@@ -44,4 +70,33 @@ type CustomersService interface {
 type ListArguments struct {
 	Page int64
 	Size int64
+
+	// Filter selects the customers that will be returned. If nil all the
+	// customers are returned.
+	Filter *CustomerFilter
+}
+
+// validateListArguments checks that the page and size of the list arguments
+// aren't negative.
+func validateListArguments(args *ListArguments) error {
+	if args == nil {
+		return nil
+	}
+	if args.Page < 0 {
+		return &ValidationError{Field: "page", Reason: fmt.Sprintf("%d is negative", args.Page)}
+	}
+	if args.Size < 0 {
+		return &ValidationError{Field: "size", Reason: fmt.Sprintf("%d is negative", args.Size)}
+	}
+	return nil
+}
+
+// pageAndSize returns the page and size requested by the given list
+// arguments, or the first page with the default size if no arguments are
+// supplied.
+func pageAndSize(args *ListArguments) (page int64, size int64) {
+	if args == nil {
+		return 0, defaultLimit
+	}
+	return args.Page, args.Size
 }
@@ -0,0 +1,536 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// customersServiceFactory creates an empty customers service for one of the
+// conformance tests. It should skip the test if the service can't be
+// created, for example because the datastore isn't available.
+type customersServiceFactory func(t *testing.T) CustomersService
+
+// runCustomersServiceConformance runs the tests that every implementation of
+// the CustomersService interface has to pass. Each test gets a new empty
+// service from the factory.
+func runCustomersServiceConformance(t *testing.T, factory customersServiceFactory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, service CustomersService)
+	}{
+		{"AddAndGet", testConformanceAddAndGet},
+		{"GetMissing", testConformanceGetMissing},
+		{"AddInvalid", testConformanceAddInvalid},
+		{"AddDuplicateEmail", testConformanceAddDuplicateEmail},
+		{"AddDuplicateClusters", testConformanceAddDuplicateClusters},
+		{"Upsert", testConformanceUpsert},
+		{"AddOwnedClusters", testConformanceAddOwnedClusters},
+		{"ListEmpty", testConformanceListEmpty},
+		{"ListNilArguments", testConformanceListNilArguments},
+		{"ListPagination", testConformanceListPagination},
+		{"ListZeroSize", testConformanceListZeroSize},
+		{"ListNegativeArguments", testConformanceListNegativeArguments},
+		{"ListFilter", testConformanceListFilter},
+		{"ConcurrentAdd", testConformanceConcurrentAdd},
+		{"ConcurrentUpsert", testConformanceConcurrentUpsert},
+	}
+	for _, test := range tests {
+		run := test.run
+		t.Run(test.name, func(t *testing.T) {
+			service := factory(t)
+			defer service.Close()
+			run(t, service)
+		})
+	}
+}
+
+// addConformanceCustomers adds the given number of customers, with unique
+// names, emails and clusters.
+func addConformanceCustomers(t *testing.T, service CustomersService, count int) []*Customer {
+	result := make([]*Customer, count)
+	for i := 0; i < count; i++ {
+		customer, err := service.Add(context.Background(), Customer{
+			Name:          fmt.Sprintf("customer%d", i),
+			Email:         fmt.Sprintf("customer%d@example.com", i),
+			OwnedClusters: []string{fmt.Sprintf("customer%d-cluster", i)},
+		})
+		if err != nil {
+			t.Fatalf("can't add customer %d: %v", i, err)
+		}
+		result[i] = customer
+	}
+	return result
+}
+
+// checkSameCustomer checks that two customers have the same content,
+// ignoring the order of the clusters and the precision of the timestamps,
+// which depend on the datastore.
+func checkSameCustomer(t *testing.T, expected, actual *Customer) {
+	if actual == nil {
+		t.Fatalf("expected customer '%s', got nil", expected.ID)
+	}
+	if actual.ID != expected.ID ||
+		actual.Name != expected.Name ||
+		actual.Email != expected.Email ||
+		actual.OrganizationName != expected.OrganizationName ||
+		actual.ContactPhone != expected.ContactPhone ||
+		actual.BillingAccountID != expected.BillingAccountID ||
+		actual.Status != expected.Status {
+		t.Errorf("expected customer %+v, got %+v", expected, actual)
+	}
+	if !sameTime(expected.CreatedAt, actual.CreatedAt) || !sameTime(expected.UpdatedAt, actual.UpdatedAt) {
+		t.Errorf("expected timestamps %v and %v, got %v and %v",
+			expected.CreatedAt, expected.UpdatedAt, actual.CreatedAt, actual.UpdatedAt)
+	}
+	if actual.OwnedClusters == nil {
+		t.Errorf("expected owned clusters of customer '%s' not to be nil", actual.ID)
+	}
+	expectedClusters := sortedCopy(expected.OwnedClusters)
+	actualClusters := sortedCopy(actual.OwnedClusters)
+	if fmt.Sprint(expectedClusters) != fmt.Sprint(actualClusters) {
+		t.Errorf("expected clusters %v, got %v", expectedClusters, actualClusters)
+	}
+}
+
+func sameTime(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
+
+func sortedCopy(values []string) []string {
+	result := make([]string, len(values))
+	copy(result, values)
+	sort.Strings(result)
+	return result
+}
+
+func checkTotal(t *testing.T, service CustomersService, expected int64) {
+	list, err := service.List(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != expected {
+		t.Errorf("expected %d customers, got %d", expected, list.Total)
+	}
+}
+
+func testConformanceAddAndGet(t *testing.T, service CustomersService) {
+	added, err := service.Add(context.Background(), Customer{
+		Name:             "customer",
+		Email:            "customer@example.com",
+		OrganizationName: "Example Inc.",
+		ContactPhone:     "+1 555 0100",
+		BillingAccountID: "ba-1",
+		OwnedClusters:    []string{"cluster0", "cluster1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.ID == "" {
+		t.Errorf("expected added customer to have an identifier")
+	}
+	if added.Status != CustomerStatusActive {
+		t.Errorf("expected status '%s', got '%s'", CustomerStatusActive, added.Status)
+	}
+	if added.CreatedAt.IsZero() || added.UpdatedAt.IsZero() {
+		t.Errorf("expected timestamps to be set")
+	}
+	retrieved, err := service.Get(context.Background(), added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameCustomer(t, added, retrieved)
+}
+
+func testConformanceGetMissing(t *testing.T, service CustomersService) {
+	customer, err := service.Get(context.Background(), "missing")
+	if err != nil {
+		t.Errorf("expected no error for missing customer, got %v", err)
+	}
+	if customer != nil {
+		t.Errorf("expected nil for missing customer, got %+v", customer)
+	}
+}
+
+func testConformanceAddInvalid(t *testing.T, service CustomersService) {
+	invalid := []Customer{
+		{Email: "customer@example.com"},
+		{Name: "customer"},
+		{Name: "customer", Email: "not-an-email"},
+		{Name: "customer", Email: "customer@example.com", Status: "unknown"},
+	}
+	for _, customer := range invalid {
+		_, err := service.Add(context.Background(), customer)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("expected validation error for %+v, got %v", customer, err)
+		}
+	}
+	checkTotal(t, service, 0)
+}
+
+func testConformanceAddDuplicateEmail(t *testing.T, service CustomersService) {
+	first, err := service.Add(context.Background(), Customer{Name: "first", Email: "customer@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Add(context.Background(), Customer{Name: "second", Email: "Customer@Example.com"})
+	if _, ok := err.(*DuplicateEmailError); !ok {
+		t.Errorf("expected duplicate email error, got %v", err)
+	}
+	checkTotal(t, service, 1)
+	retrieved, err := service.Get(context.Background(), first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameCustomer(t, first, retrieved)
+}
+
+func testConformanceAddDuplicateClusters(t *testing.T, service CustomersService) {
+	first, err := service.Add(context.Background(), Customer{
+		Name:          "first",
+		Email:         "first@example.com",
+		OwnedClusters: []string{"cluster0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same cluster can't appear twice in the same customer:
+	_, err = service.Add(context.Background(), Customer{
+		Name:          "second",
+		Email:         "second@example.com",
+		OwnedClusters: []string{"cluster1", "cluster1"},
+	})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("expected validation error for repeated cluster, got %v", err)
+	}
+
+	// A cluster can't be owned by two customers:
+	_, err = service.Add(context.Background(), Customer{
+		Name:          "third",
+		Email:         "third@example.com",
+		OwnedClusters: []string{"cluster2", "cluster0"},
+	})
+	owned, ok := err.(*ClusterOwnedError)
+	if !ok {
+		t.Fatalf("expected cluster owned error, got %v", err)
+	}
+	if owned.ClusterID != "cluster0" || owned.CustomerID != first.ID {
+		t.Errorf("expected cluster 'cluster0' owned by '%s', got %+v", first.ID, owned)
+	}
+
+	// Failed additions shouldn't leave anything behind, so the clusters
+	// that weren't owned can still be used:
+	checkTotal(t, service, 1)
+	_, err = service.Add(context.Background(), Customer{
+		Name:          "fourth",
+		Email:         "fourth@example.com",
+		OwnedClusters: []string{"cluster1", "cluster2"},
+	})
+	if err != nil {
+		t.Errorf("expected clusters of failed additions to be available, got %v", err)
+	}
+}
+
+func testConformanceAddOwnedClusters(t *testing.T, service CustomersService) {
+	customers := addConformanceCustomers(t, service, 2)
+
+	result, err := service.AddOwnedClusters(context.Background(), customers[0].ID, []string{"new-cluster"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(result.OwnedClusters, "new-cluster") {
+		t.Errorf("expected owned clusters to contain 'new-cluster', got %v", result.OwnedClusters)
+	}
+	for _, cluster := range customers[0].OwnedClusters {
+		if !containsString(result.OwnedClusters, cluster) {
+			t.Errorf("expected owned clusters to still contain '%s', got %v", cluster, result.OwnedClusters)
+		}
+	}
+	stored, err := service.Get(context.Background(), customers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameCustomer(t, result, stored)
+
+	// Adding the same cluster again does nothing:
+	_, err = service.AddOwnedClusters(context.Background(), customers[0].ID, []string{"new-cluster"})
+	if err != nil {
+		t.Errorf("expected adding an owned cluster again to succeed, got %v", err)
+	}
+
+	// A cluster can't be owned by two customers:
+	_, err = service.AddOwnedClusters(context.Background(), customers[1].ID, []string{"new-cluster"})
+	owned, ok := err.(*ClusterOwnedError)
+	if !ok {
+		t.Fatalf("expected cluster owned error, got %v", err)
+	}
+	if owned.ClusterID != "new-cluster" || owned.CustomerID != customers[0].ID {
+		t.Errorf("expected cluster 'new-cluster' owned by '%s', got %+v", customers[0].ID, owned)
+	}
+
+	// If one of the clusters is owned by other customer none is added:
+	_, err = service.AddOwnedClusters(context.Background(), customers[1].ID,
+		[]string{"free-cluster", "new-cluster"})
+	if _, ok := err.(*ClusterOwnedError); !ok {
+		t.Fatalf("expected cluster owned error, got %v", err)
+	}
+	stored, err = service.Get(context.Background(), customers[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsString(stored.OwnedClusters, "free-cluster") {
+		t.Errorf("expected 'free-cluster' not to be owned after failed addition, got %v", stored.OwnedClusters)
+	}
+
+	_, err = service.AddOwnedClusters(context.Background(), "missing", []string{"other-cluster"})
+	if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("expected not found error for missing customer, got %v", err)
+	}
+}
+
+func testConformanceUpsert(t *testing.T, service CustomersService) {
+	created := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	inserted, err := service.Upsert(context.Background(), Customer{
+		ID:            "upserted",
+		Name:          "customer",
+		Email:         "customer@example.com",
+		CreatedAt:     created,
+		OwnedClusters: []string{"cluster0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted.ID != "upserted" || !sameTime(inserted.CreatedAt, created) {
+		t.Errorf("expected identifier and creation time to be preserved, got %+v", inserted)
+	}
+
+	// Replacing without timestamps should keep the creation time and
+	// replace the clusters:
+	replaced, err := service.Upsert(context.Background(), Customer{
+		ID:            "upserted",
+		Name:          "renamed",
+		Email:         "customer@example.com",
+		Status:        CustomerStatusSuspended,
+		OwnedClusters: []string{"cluster1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameTime(replaced.CreatedAt, created) {
+		t.Errorf("expected creation time %v to be preserved, got %v", created, replaced.CreatedAt)
+	}
+	retrieved, err := service.Get(context.Background(), "upserted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameCustomer(t, replaced, retrieved)
+	checkTotal(t, service, 1)
+
+	// The email can't be taken from other customer:
+	_, err = service.Upsert(context.Background(), Customer{
+		ID:    "other",
+		Name:  "other",
+		Email: "CUSTOMER@example.com",
+	})
+	if _, ok := err.(*DuplicateEmailError); !ok {
+		t.Errorf("expected duplicate email error, got %v", err)
+	}
+
+	// The replaced cluster is available again:
+	_, err = service.Upsert(context.Background(), Customer{
+		ID:            "other",
+		Name:          "other",
+		Email:         "other@example.com",
+		OwnedClusters: []string{"cluster0"},
+	})
+	if err != nil {
+		t.Errorf("expected replaced cluster to be available, got %v", err)
+	}
+}
+
+func testConformanceListEmpty(t *testing.T, service CustomersService) {
+	list, err := service.List(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Items == nil || len(list.Items) != 0 || list.Size != 0 || list.Total != 0 {
+		t.Errorf("expected empty list, got %+v", list)
+	}
+}
+
+func testConformanceListNilArguments(t *testing.T, service CustomersService) {
+	added := addConformanceCustomers(t, service, 3)
+	list, err := service.List(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Page != 0 || list.Size != 3 || list.Total != 3 || len(list.Items) != 3 {
+		t.Fatalf("expected page 0 with all the 3 customers, got %+v", list)
+	}
+	retrieved := make(map[string]*Customer)
+	for _, customer := range list.Items {
+		retrieved[customer.ID] = customer
+	}
+	for _, customer := range added {
+		checkSameCustomer(t, customer, retrieved[customer.ID])
+	}
+}
+
+func testConformanceListPagination(t *testing.T, service CustomersService) {
+	addConformanceCustomers(t, service, 7)
+	expectedSizes := []int64{3, 3, 1, 0, 0}
+	seen := make(map[string]bool)
+	for page, expectedSize := range expectedSizes {
+		list, err := service.List(context.Background(), &ListArguments{Page: int64(page), Size: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list.Page != int64(page) {
+			t.Errorf("expected page %d, got %d", page, list.Page)
+		}
+		if list.Size != expectedSize || int64(len(list.Items)) != expectedSize {
+			t.Errorf("expected %d items in page %d, got size %d and %d items",
+				expectedSize, page, list.Size, len(list.Items))
+		}
+		if list.Total != 7 {
+			t.Errorf("expected total 7 in page %d, got %d", page, list.Total)
+		}
+		for _, customer := range list.Items {
+			if customer == nil {
+				t.Fatalf("page %d contains nil items", page)
+			}
+			if seen[customer.ID] {
+				t.Errorf("customer '%s' appears in more than one page", customer.ID)
+			}
+			seen[customer.ID] = true
+		}
+	}
+	if len(seen) != 7 {
+		t.Errorf("expected pages to contain the 7 customers, got %d", len(seen))
+	}
+}
+
+func testConformanceListZeroSize(t *testing.T, service CustomersService) {
+	addConformanceCustomers(t, service, 2)
+	list, err := service.List(context.Background(), &ListArguments{Page: 0, Size: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Items == nil || len(list.Items) != 0 || list.Total != 2 {
+		t.Errorf("expected no items and total 2, got %+v", list)
+	}
+}
+
+func testConformanceListNegativeArguments(t *testing.T, service CustomersService) {
+	for _, args := range []*ListArguments{{Page: -1, Size: 10}, {Page: 0, Size: -1}} {
+		_, err := service.List(context.Background(), args)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("expected validation error for %+v, got %v", args, err)
+		}
+	}
+}
+
+func testConformanceListFilter(t *testing.T, service CustomersService) {
+	added := addConformanceCustomers(t, service, 12)
+	filter, err := ParseCustomerFilter("name ~ 'CUSTOMER1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := service.List(context.Background(), &ListArguments{Page: 0, Size: 2, Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Matches 'customer1', 'customer10' and 'customer11':
+	if list.Total != 3 || len(list.Items) != 2 {
+		t.Errorf("expected 2 items and total 3, got %+v", list)
+	}
+
+	filter, err = ParseCustomerFilter(fmt.Sprintf("owned_clusters contains '%s'", added[5].OwnedClusters[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err = service.List(context.Background(), &ListArguments{Page: 0, Size: 10, Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].ID != added[5].ID {
+		t.Errorf("expected only customer '%s', got %+v", added[5].ID, list.Items)
+	}
+}
+
+func testConformanceConcurrentAdd(t *testing.T, service CustomersService) {
+	const count = 20
+	var wait sync.WaitGroup
+	errs := make(chan error, count)
+	ids := make(chan string, count)
+	for i := 0; i < count; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			customer, err := service.Add(context.Background(), Customer{
+				Name:  fmt.Sprintf("concurrent%d", i),
+				Email: fmt.Sprintf("concurrent%d@example.com", i),
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids <- customer.ID
+		}(i)
+	}
+	wait.Wait()
+	close(errs)
+	close(ids)
+	for err := range errs {
+		t.Errorf("concurrent addition failed: %v", err)
+	}
+	unique := make(map[string]bool)
+	for id := range ids {
+		unique[id] = true
+	}
+	if len(unique) != count {
+		t.Errorf("expected %d different identifiers, got %d", count, len(unique))
+	}
+	checkTotal(t, service, count)
+}
+
+func testConformanceConcurrentUpsert(t *testing.T, service CustomersService) {
+	const count = 10
+	var wait sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			_, err := service.Upsert(context.Background(), Customer{
+				ID:    "concurrent",
+				Name:  fmt.Sprintf("concurrent%d", i),
+				Email: "concurrent@example.com",
+			})
+			if err != nil {
+				t.Errorf("concurrent upsert failed: %v", err)
+			}
+		}(i)
+	}
+	wait.Wait()
+	checkTotal(t, service, 1)
+}
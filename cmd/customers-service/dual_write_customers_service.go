@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// DualWriteCustomersService is a struct implementing the customer service
+// interface that reads from a primary service and writes to both a primary
+// and a secondary service. It is intended to keep two datastores in sync
+// while moving from one to the other. Failures writing to the secondary
+// service are logged, but don't fail the operation; the migrate-store
+// command can be used later to fix the differences.
+type DualWriteCustomersService struct {
+	primary   CustomersService
+	secondary CustomersService
+}
+
+// NewDualWriteCustomersService is a constructor for the
+// DualWriteCustomersService struct.
+func NewDualWriteCustomersService(primary, secondary CustomersService) *DualWriteCustomersService {
+	service := new(DualWriteCustomersService)
+	service.primary = primary
+	service.secondary = secondary
+	return service
+}
+
+// Close closes both the primary and the secondary services.
+func (service *DualWriteCustomersService) Close() {
+	service.primary.Close()
+	service.secondary.Close()
+}
+
+// Add adds the customer to the primary service, and then copies the result,
+// including the generated identifier, to the secondary service.
+func (service *DualWriteCustomersService) Add(ctx context.Context, customer Customer) (*Customer, error) {
+	result, err := service.primary.Add(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	service.copyToSecondary(ctx, result)
+	return result, nil
+}
+
+// Upsert replaces the customer in the primary service, and then copies the
+// result to the secondary service.
+func (service *DualWriteCustomersService) Upsert(ctx context.Context, customer Customer) (*Customer, error) {
+	result, err := service.primary.Upsert(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	service.copyToSecondary(ctx, result)
+	return result, nil
+}
+
+// AddOwnedClusters adds the clusters to the customer of the primary service,
+// and then copies the result to the secondary service.
+func (service *DualWriteCustomersService) AddOwnedClusters(ctx context.Context, id string,
+	clusterIDs []string) (*Customer, error) {
+	result, err := service.primary.AddOwnedClusters(ctx, id, clusterIDs)
+	if err != nil {
+		return nil, err
+	}
+	service.copyToSecondary(ctx, result)
+	return result, nil
+}
+
+// Get retrieves the customer from the primary service.
+func (service *DualWriteCustomersService) Get(ctx context.Context, id string) (*Customer, error) {
+	return service.primary.Get(ctx, id)
+}
+
+// List retrieves the customers from the primary service.
+func (service *DualWriteCustomersService) List(ctx context.Context, args *ListArguments) (*CustomersList, error) {
+	return service.primary.List(ctx, args)
+}
+
+func (service *DualWriteCustomersService) copyToSecondary(ctx context.Context, customer *Customer) {
+	_, err := service.secondary.Upsert(ctx, *customer)
+	if err != nil {
+		logging.Errorf(
+			"Can't copy customer '%s' to the secondary datastore: %v",
+			customer.ID, err,
+		)
+	}
+}
@@ -47,13 +47,19 @@ func NewEtcdCustomersService(etcdEndpoint string) (service *EtcdCustomersService
 	return service, nil
 }
 
+// Ping checks the connection to the etcd cluster.
+func (service *EtcdCustomersService) Ping(ctx context.Context) error {
+	_, err := service.cli.Get(ctx, "health")
+	return err
+}
+
 // Close closes the etcd customers service client.
 func (service *EtcdCustomersService) Close() {
 	service.cli.Close()
 }
 
 // Add adds a single customer to etcd cluster.
-func (service *EtcdCustomersService) Add(customer Customer) (*Customer, error) {
+func (service *EtcdCustomersService) Add(ctx context.Context, customer Customer) (*Customer, error) {
 	// generate customer id.
 	id, err := ksuid.NewRandom()
 
@@ -62,15 +68,17 @@ func (service *EtcdCustomersService) Add(customer Customer) (*Customer, error) {
 	}
 
 	// result is the new customer inserted into etcd.
-	result := Customer{
-		ID:   id.String(),
-		Name: customer.Name,
+	result, err := newCustomer(id.String(), customer)
+	if err != nil {
+		return nil, err
 	}
 
-	if customer.OwnedClusters == nil {
-		result.OwnedClusters = make([]string, 0)
-	} else {
-		result.OwnedClusters = customer.OwnedClusters
+	// etcd has no unique constraints, so we check that the email and the
+	// clusters aren't used by other customer scanning all the existing
+	// customers.
+	err = service.checkConflicts(ctx, result)
+	if err != nil {
+		return nil, err
 	}
 
 	// the resulting Customer is then marshal to []byte and converted to string -
@@ -81,97 +89,202 @@ func (service *EtcdCustomersService) Add(customer Customer) (*Customer, error) {
 		return nil, err
 	}
 	s := string(raw)
-	ctx := context.Background()
 	_, err = service.cli.Put(ctx, result.ID, s)
 
 	if err != nil {
 		return nil, err
 	}
-	return &result, err
+	return result, err
+}
+
+// Upsert creates or replaces a single customer in the etcd cluster.
+func (service *EtcdCustomersService) Upsert(ctx context.Context, customer Customer) (*Customer, error) {
+	if customer.ID == "" {
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		customer.ID = id.String()
+	}
+
+	response, err := service.cli.Get(ctx, customer.ID)
+	if err != nil {
+		return nil, err
+	}
+	var existing *Customer
+	if len(response.Kvs) > 0 {
+		existing = new(Customer)
+		err = json.Unmarshal(response.Kvs[0].Value, existing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := replacementCustomer(customer, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.checkConflicts(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	_, err = service.cli.Put(ctx, result.ID, string(raw))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddOwnedClusters adds clusters to the clusters owned by a customer stored in
+// the etcd cluster.
+func (service *EtcdCustomersService) AddOwnedClusters(ctx context.Context, id string,
+	clusterIDs []string) (*Customer, error) {
+	customer, err := service.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if customer == nil {
+		return nil, &NotFoundError{Kind: "customer", ID: id}
+	}
+	result, err := customer.ownedClustersAddition(clusterIDs)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return customer, nil
+	}
+
+	err = service.checkConflicts(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	_, err = service.cli.Put(ctx, result.ID, string(raw))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkConflicts checks that the email and the clusters of the given customer
+// aren't used by other customer.
+func (service *EtcdCustomersService) checkConflicts(ctx context.Context, customer *Customer) error {
+	response, err := service.cli.Get(ctx, "", clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range response.Kvs {
+		var other Customer
+		err = json.Unmarshal(kv.Value, &other)
+		if err != nil {
+			return err
+		}
+		err = customer.conflictWith(&other)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Get retrieves a single customer from etcd cluster
-func (service *EtcdCustomersService) Get(id string) (*Customer, error) {
+func (service *EtcdCustomersService) Get(ctx context.Context, id string) (*Customer, error) {
 	// retrieve customer object by it's id.
-	response, err := service.cli.Get(context.Background(), id)
+	response, err := service.cli.Get(ctx, id)
 	if err != nil {
-		// If could not find customer matching such id return nil pointer and nil error.
-		return nil, nil
+		return nil, err
 	}
 
-	// We expect only one Customer per ID since ID's are unique.
+	// If could not find customer matching such id return nil pointer and nil
+	// error. We expect only one Customer per ID since ID's are unique.
+	if response.Count == 0 {
+		return nil, nil
+	}
 	if response.Count != 1 {
 		return nil, fmt.Errorf("key %s should contain a single value, instead it contains %d values", id, response.Count)
 	}
 
 	result := new(Customer)
-	for _, ev := range response.Kvs {
-		json.Unmarshal(ev.Value, result)
+	err = json.Unmarshal(response.Kvs[0].Value, result)
+	if err != nil {
+		return nil, err
 	}
 	return result, nil
 }
 
-// List retrieves a list of current customers stored in datastore.
-func (service *EtcdCustomersService) List(args *ListArguments) (*CustomersList, error) {
+// List retrieves a list of current customers stored in datastore. etcd can't
+// evaluate the filter, so all the customers are retrieved and the ones that
+// don't match the filter are discarded before paginating.
+func (service *EtcdCustomersService) List(ctx context.Context, args *ListArguments) (*CustomersList, error) {
+	err := validateListArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
 	// We get all Customer objects by querying etcd for object with empty-prefix.
-	response, err := service.cli.Get(context.Background(), "", clientv3.WithPrefix())
+	response, err := service.cli.Get(ctx, "", clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
 
-	kvs := response.Kvs
-	customerList, err := service.paginateCustomers(args, response.Count, kvs)
+	var filter *CustomerFilter
+	if args != nil {
+		filter = args.Filter
+	}
+	customers, err := service.filterCustomers(filter, response.Kvs)
 	if err != nil {
 		return nil, err
 	}
-	return customerList, nil
+	return service.paginateCustomers(args, customers), nil
+}
+
+// filterCustomers decodes the given key-value pairs and returns the customers
+// that match the filter.
+func (service *EtcdCustomersService) filterCustomers(filter *CustomerFilter, keyValues []*mvccpb.KeyValue) ([]*Customer, error) {
+	customers := make([]*Customer, 0, len(keyValues))
+	for _, kv := range keyValues {
+		customer := new(Customer)
+		err := json.Unmarshal(kv.Value, customer)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Matches(customer) {
+			customers = append(customers, customer)
+		}
+	}
+	return customers, nil
 }
 
 // paginateCustomers returns a *CustomersList representing a single page of
 // Customers information.
-func (service *EtcdCustomersService) paginateCustomers(args *ListArguments, total int64, keyValues []*mvccpb.KeyValue) (*CustomersList, error) {
-	// if no list arguments specified - get all customers.
-	var page int64
-	var size int64
-	var firstIndex int64
-	var lastIndex int64
-
-	if args == nil {
-		page = 1
-		size = total
-		firstIndex = 0
-		lastIndex = total
-	} else {
-		page = args.Page
-		size = args.Size
-		firstIndex = size * page
-		lastIndex = size * (page + 1)
+func (service *EtcdCustomersService) paginateCustomers(args *ListArguments, customers []*Customer) *CustomersList {
+	total := int64(len(customers))
+	page, size := pageAndSize(args)
+	firstIndex := size * page
+	lastIndex := size * (page + 1)
+	if firstIndex > total {
+		firstIndex = total
 	}
-
-	items, err := service.collectCustomerItems(size, page, total, firstIndex, lastIndex, keyValues)
-	if err != nil {
-		return nil, err
+	if lastIndex > total {
+		lastIndex = total
 	}
+	items := customers[firstIndex:lastIndex]
 
 	// Return the customer list for requested page.
-	result := CustomersList{
+	return &CustomersList{
 		Items: items,
 		Page:  page,
 		Size:  int64(len(items)),
 		Total: total,
 	}
-	return &result, nil
-}
-
-func (service *EtcdCustomersService) collectCustomerItems(size, page, total, firstIndex, lastIndex int64, keyValues []*mvccpb.KeyValue) ([]*Customer, error) {
-	items := make([]*Customer, size)
-	idx := 0
-	for i := firstIndex; i < lastIndex && i < total; i++ {
-		err := json.Unmarshal(keyValues[i].Value, &items[idx])
-		idx++
-		if err != nil {
-			return nil, err
-		}
-	}
-	return items, nil
 }
@@ -19,35 +19,45 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/coreos/etcd/clientv3"
 	"os"
 	"testing"
 )
 
-var service *EtcdCustomersService
-var localTesting = false
-
-func TestMain(m *testing.M) {
-	var err error
-	if !localTesting {
-		os.Exit(0)
+// newEtcdTestService creates a customers service connected to the etcd
+// endpoint given by the CUSTOMERS_SERVICE_TEST_ETCD environment variable, and
+// removes all the existing customers. The test is skipped if the variable
+// isn't set.
+func newEtcdTestService(t *testing.T) *EtcdCustomersService {
+	endpoint := os.Getenv("CUSTOMERS_SERVICE_TEST_ETCD")
+	if endpoint == "" {
+		t.Skip("CUSTOMERS_SERVICE_TEST_ETCD isn't set")
 	}
-	service, err = NewEtcdCustomersService("localhost:2379")
+	service, err := NewEtcdCustomersService(endpoint)
 	if err != nil {
-		fmt.Printf("Could not run tests, an error occurred while trying to connect to etcd: %s\n", err)
+		t.Fatalf("Could not run tests, an error occurred while trying to connect to etcd: %s", err)
 	}
-	defer deleteAll()
-	defer service.Close()
-	os.Exit(m.Run())
+	err = deleteAllEtcd(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
 }
 
-func TestAdd(t *testing.T) {
-	deleteAll()
+func TestEtcdCustomersServiceConformance(t *testing.T) {
+	runCustomersServiceConformance(t, func(t *testing.T) CustomersService {
+		return newEtcdTestService(t)
+	})
+}
+
+func TestEtcdAdd(t *testing.T) {
+	service := newEtcdTestService(t)
+	defer service.Close()
 	customer := Customer{
-		Name: "fake-customer",
+		Name:  "fake-customer",
+		Email: "fake-customer@example.com",
 	}
-	result, err := service.Add(customer)
+	result, err := service.Add(context.Background(), customer)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -59,8 +69,9 @@ func TestAdd(t *testing.T) {
 	}
 }
 
-func TestGet(t *testing.T) {
-	deleteAll()
+func TestEtcdGet(t *testing.T) {
+	service := newEtcdTestService(t)
+	defer service.Close()
 	expected := Customer{
 		ID:            "some-fake-id",
 		Name:          "fake-customer",
@@ -81,7 +92,7 @@ func TestGet(t *testing.T) {
 		t.Fail()
 	}
 
-	result, err := service.Get(expected.ID)
+	result, err := service.Get(context.Background(), expected.ID)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -97,8 +108,9 @@ func TestGet(t *testing.T) {
 	}
 }
 
-func TestList(t *testing.T) {
-	deleteAll()
+func TestEtcdList(t *testing.T) {
+	service := newEtcdTestService(t)
+	defer service.Close()
 	items := []*Customer{
 		&Customer{
 			ID:            "some-fake-id0",
@@ -132,7 +144,7 @@ func TestList(t *testing.T) {
 			t.Fail()
 		}
 	}
-	list, err := service.List(nil)
+	list, err := service.List(context.Background(), nil)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -160,7 +172,7 @@ func TestList(t *testing.T) {
 		},
 	}
 
-	list, err = service.List(args)
+	list, err = service.List(context.Background(), args)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -181,7 +193,7 @@ func TestList(t *testing.T) {
 	}
 }
 
-func deleteAll() error {
+func deleteAllEtcd(service *EtcdCustomersService) error {
 	_, err := service.cli.Delete(context.Background(), "", clientv3.WithPrefix())
 	return err
 }
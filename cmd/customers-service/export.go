@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var exportArgs struct {
+	format   string
+	output   string
+	search   string
+	pageSize int64
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export customers",
+	Long: "Export customers and their owned clusters as JSON Lines or CSV, so " +
+		"that they can be loaded in other deployment with the import command.",
+	Run: runExport,
+}
+
+func init() {
+	flags := exportCmd.Flags()
+	flags.StringVar(
+		&exportArgs.format,
+		"format",
+		formatJSONLines,
+		fmt.Sprintf("The output format, either '%s' or '%s'.", formatJSONLines, formatCSV),
+	)
+	flags.StringVar(
+		&exportArgs.output,
+		"output",
+		"-",
+		"The file where the customers will be written, '-' for the standard output.",
+	)
+	flags.StringVar(
+		&exportArgs.search,
+		"search",
+		"",
+		"Search expression selecting the customers to export, for example: status = 'active'",
+	)
+	flags.Int64Var(
+		&exportArgs.pageSize,
+		"page-size",
+		100,
+		"The number of customers retrieved from the datastore in each request.",
+	)
+	addConfigFlags(exportCmd, &customersConfig.Logging, &customersConfig.Database)
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	if exportArgs.pageSize <= 0 {
+		logging.Fatalf("The page size must be positive, but it is %d", exportArgs.pageSize)
+	}
+	filter, err := ParseCustomerFilter(exportArgs.search)
+	if err != nil {
+		logging.Fatalf("Can't parse search expression: %v", err)
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		logging.Fatalf("Can't connect to database: %v", err)
+	}
+	defer db.Close()
+	service := NewSQLCustomersService(db)
+
+	out := os.Stdout
+	if exportArgs.output != "-" {
+		out, err = os.Create(exportArgs.output)
+		if err != nil {
+			logging.Fatalf("Can't create output file: %v", err)
+		}
+		defer out.Close()
+	}
+	writer, err := newCustomerWriter(exportArgs.format, out)
+	if err != nil {
+		logging.Fatalf("Can't create writer: %v", err)
+	}
+
+	count, err := exportCustomers(service, filter, exportArgs.pageSize, writer)
+	if err != nil {
+		logging.Fatalf("Can't export customers: %v", err)
+	}
+	logging.Infof("Exported %d customers", count)
+}
+
+// exportCustomers writes all the customers that match the filter, retrieving
+// them from the service in pages of the given size. It returns the number of
+// customers written.
+func exportCustomers(service CustomersService, filter *CustomerFilter, pageSize int64,
+	writer customerWriter) (count int64, err error) {
+	for page := int64(0); ; page++ {
+		list, err := service.List(context.Background(), &ListArguments{
+			Page:   page,
+			Size:   pageSize,
+			Filter: filter,
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, customer := range list.Items {
+			err = writer.Write(customer)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		if int64(len(list.Items)) < pageSize || (page+1)*pageSize >= list.Total {
+			break
+		}
+	}
+	return count, writer.Flush()
+}
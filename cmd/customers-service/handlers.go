@@ -17,85 +17,150 @@ limitations under the License.
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
 
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
 	"github.com/gorilla/mux"
 )
 
-func getQueryParamInt(key string, defaultValue int64, r *http.Request) (value int64, err error) {
-	valStr := r.URL.Query().Get(key)
-	if valStr == "" {
-		return defaultValue, nil
+// getListArguments extracts the page and size query parameters from the
+// request.
+func getListArguments(r *http.Request) (*ListArguments, error) {
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return nil, err
 	}
-	value, err = strconv.ParseInt(valStr, 10, 64)
-	return
+	return &ListArguments{
+		Page: int64(page.Page),
+		Size: int64(page.Size),
+	}, nil
 }
 
 func (server *Server) getCustomersList(w http.ResponseWriter, r *http.Request) {
-	var ret *CustomersList
-	var err error
-	var page int64
-	var size int64
-
 	// Get Query Parameters.
-	page, err = getQueryParamInt("page", 0, r)
+	args, err := getListArguments(r)
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error listing customers, %v", err)})
+		api.WriteErrorf(w, api.StatusOf(err), "Error listing customers, %v", err)
 		return
 	}
-
-	size, err = getQueryParamInt("size", defaultLimit, r)
+	args.Filter, err = ParseCustomerFilter(r.URL.Query().Get("search"))
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error listing customers, %v", err)})
+		api.WriteErrorf(w, http.StatusBadRequest, "Error listing customers, %v", err)
 		return
 	}
 
-	args := &ListArguments{
-		Page: page,
-		Size: size,
+	// Callers that can only access their own organization see only the
+	// customer of that organization:
+	access := authz.AccessFromContext(r.Context())
+	if !access.All() {
+		args.Filter = args.Filter.RestrictToID(access.Organization())
 	}
 
-	ret, err = server.service.List(args)
+	ret, err := server.service.List(r.Context(), args)
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error listing customers, %v", err)})
+		code := http.StatusInternalServerError
+		if _, ok := err.(*ValidationError); ok {
+			code = http.StatusBadRequest
+		}
+		api.WriteErrorf(w, code, "Error listing customers, %v", err)
 		return
 	}
-	writeJSONResponse(w, http.StatusOK, ret)
-
+	api.WriteJSON(w, http.StatusOK, api.NewList(int(ret.Page), ret.Total, ret.Items))
 }
 
 func (server *Server) addCustomer(w http.ResponseWriter, r *http.Request) {
-	decoder := json.NewDecoder(r.Body)
+	// New customers don't belong to the organization of the caller, so
+	// only callers that can access all the organizations can add them:
+	if !authz.AccessFromContext(r.Context()).All() {
+		api.WriteErrorf(w, http.StatusForbidden, "Error adding customer, only allowed for all organizations")
+		return
+	}
 	var customer Customer
-	err := decoder.Decode(&customer)
+	err := api.DecodeJSON(w, r, &customer)
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error decoding customer, %v", err)})
+		api.WriteErrorf(w, api.StatusOf(err), "Error decoding customer, %v", err)
 		return
 	}
-	ret, err := server.service.Add(customer)
+	ret, err := server.service.Add(r.Context(), customer)
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error adding customer, %v", err)})
+		api.WriteErrorf(w, addCustomerErrorCode(err), "Error adding customer, %v", err)
 	} else {
-		writeJSONResponse(w, http.StatusOK, ret)
+		api.WriteJSON(w, http.StatusOK, ret)
+	}
+}
+
+// addCustomerErrorCode returns the HTTP status code that corresponds to an
+// error returned by the Add method of the customers service.
+func addCustomerErrorCode(err error) int {
+	switch err.(type) {
+	case *DuplicateEmailError, *ClusterOwnedError:
+		return http.StatusConflict
+	case *ValidationError:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
 func (server *Server) getCustomerByID(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	ret, err := server.service.Get(id)
+	// Customers of other organizations are reported as not existing, so
+	// that their existence isn't revealed:
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error getting customer", "customer", id)
+		return
+	}
+	ret, err := server.service.Get(r.Context(), id)
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error getting customer, %v", err)})
-	} else {
-		writeJSONResponse(w, http.StatusOK, ret)
+		code := http.StatusInternalServerError
+		if _, ok := err.(*NotFoundError); ok {
+			code = http.StatusNotFound
+		}
+		api.WriteErrorf(w, code, "Error getting customer, %v", err)
+		return
+	}
+	if ret == nil {
+		writeNotFound(w, "Error getting customer", "customer", id)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+// OwnedCluster is the body of the requests that add a cluster to the clusters
+// owned by a customer.
+type OwnedCluster struct {
+	ClusterID string `json:"cluster_id"`
+}
+
+func (server *Server) addOwnedCluster(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error adding owned cluster", "customer", id)
+		return
+	}
+	var cluster OwnedCluster
+	err := api.DecodeJSON(w, r, &cluster)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error decoding owned cluster, %v", err)
+		return
+	}
+	ret, err := server.service.AddOwnedClusters(r.Context(), id, []string{cluster.ClusterID})
+	if err != nil {
+		code := addCustomerErrorCode(err)
+		if _, ok := err.(*NotFoundError); ok {
+			code = http.StatusNotFound
+		}
+		api.WriteErrorf(w, code, "Error adding owned cluster, %v", err)
+		return
 	}
+	api.WriteJSON(w, http.StatusOK, ret)
 }
 
-func writeJSONResponse(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	w.Write(response)
+// writeNotFound writes the response used for objects that don't exist, and
+// also for objects of organizations that the caller can't access, so that
+// both responses are identical.
+func writeNotFound(w http.ResponseWriter, message string, kind string, id string) {
+	err := &NotFoundError{Kind: kind, ID: id}
+	api.WriteErrorf(w, http.StatusNotFound, "%s, %v", message, err)
 }
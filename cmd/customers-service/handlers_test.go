@@ -0,0 +1,190 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/gorilla/mux"
+)
+
+// newTestServer creates a server backed by the in-memory customers service,
+// with the default authorization policy, and adds the given customers.
+func newTestServer(t *testing.T, names ...string) (*Server, []*Customer) {
+	policy, err := authz.LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewMemoryCustomersService()
+	customers := make([]*Customer, len(names))
+	for i, name := range names {
+		customers[i], err = service.Add(context.Background(), Customer{Name: name, Email: name + "@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return initServer(service, nil, nil, nil, policy), customers
+}
+
+// serveAs sends the request to the handler of the given action, as a caller
+// with the given role and organization.
+func serveAs(server *Server, action string, handler http.HandlerFunc, role, organization string,
+	request *http.Request) *httptest.ResponseRecorder {
+	return serveAsUser(server, action, handler, role, organization, "", request)
+}
+
+// serveAsUser is like serveAs, but the caller also has the given email.
+func serveAsUser(server *Server, action string, handler http.HandlerFunc, role, organization, email string,
+	request *http.Request) *httptest.ResponseRecorder {
+	identity := &auth.Identity{
+		Subject: "user",
+		Email:   email,
+		Claims: map[string]interface{}{
+			"roles":  role,
+			"org_id": organization,
+		},
+	}
+	request = request.WithContext(auth.ContextWithIdentity(request.Context(), identity))
+	recorder := httptest.NewRecorder()
+	server.authorize(action, handler).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGetCustomersListScopedToOrganization(t *testing.T) {
+	server, customers := newTestServer(t, "first", "second")
+	request := httptest.NewRequest("GET", "/api/customers_mgmt/v1/customers", nil)
+	recorder := serveAs(server, "customers:list", server.getCustomersList, "customer", customers[1].ID, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", recorder.Code)
+	}
+	var list CustomersList
+	err := json.Unmarshal(recorder.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 1 || len(list.Items) != 1 || list.Items[0].ID != customers[1].ID {
+		t.Errorf("expected only customer '%s', got %+v", customers[1].ID, list.Items)
+	}
+
+	request = httptest.NewRequest("GET", "/api/customers_mgmt/v1/customers", nil)
+	recorder = serveAs(server, "customers:list", server.getCustomersList, "support-readonly", "", request)
+	err = json.Unmarshal(recorder.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 {
+		t.Errorf("expected support to see the 2 customers, got %d", list.Total)
+	}
+}
+
+func TestAddCustomerRequiresAllOrganizations(t *testing.T) {
+	server, _ := newTestServer(t)
+	body := `{"name": "new", "email": "new@example.com"}`
+
+	request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/customers", strings.NewReader(body))
+	recorder := serveAs(server, "customers:create", server.addCustomer, "customer", "org-1", request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for customer role, got %d", recorder.Code)
+	}
+
+	request = httptest.NewRequest("POST", "/api/customers_mgmt/v1/customers", strings.NewReader(body))
+	recorder = serveAs(server, "customers:create", server.addCustomer, "admin", "", request)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status 200 for admin role, got %d", recorder.Code)
+	}
+}
+
+func TestAddOwnedCluster(t *testing.T) {
+	server, customers := newTestServer(t, "first", "second")
+
+	tests := []struct {
+		customer string
+		cluster  string
+		status   int
+	}{
+		{customers[0].ID, "cluster", http.StatusOK},
+		{customers[0].ID, "cluster", http.StatusOK},
+		{customers[1].ID, "cluster", http.StatusConflict},
+		{customers[1].ID, "", http.StatusBadRequest},
+		{"missing", "other", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		body := `{"cluster_id": "` + test.cluster + `"}`
+		request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/customers/"+test.customer+"/owned_clusters",
+			strings.NewReader(body))
+		request = mux.SetURLVars(request, map[string]string{"id": test.customer})
+		recorder := serveAs(server, "owned_clusters:create", server.addOwnedCluster, "clusters-service", "", request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d adding '%s' to '%s', got %d", test.status, test.cluster, test.customer,
+				recorder.Code)
+		}
+	}
+
+	customer, err := server.service.Get(context.Background(), customers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(customer.OwnedClusters) != 1 || customer.OwnedClusters[0] != "cluster" {
+		t.Errorf("expected 'cluster' to be added once to the owned clusters, got %v", customer.OwnedClusters)
+	}
+}
+
+// failingCustomersService is a customers service whose Get method always
+// fails, like a datastore that isn't available.
+type failingCustomersService struct {
+	CustomersService
+}
+
+func (s *failingCustomersService) Get(ctx context.Context, id string) (*Customer, error) {
+	return nil, fmt.Errorf("datastore isn't available")
+}
+
+func TestGetCustomerErrorCodes(t *testing.T) {
+	server, customers := newTestServer(t, "first")
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{customers[0].ID, http.StatusOK},
+		{"missing", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/api/customers_mgmt/v1/customers/"+test.id, nil)
+		request = mux.SetURLVars(request, map[string]string{"id": test.id})
+		recorder := serveAs(server, "customers:get", server.getCustomerByID, "admin", "", request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d getting '%s', got %d", test.status, test.id, recorder.Code)
+		}
+	}
+
+	server.service = &failingCustomersService{CustomersService: server.service}
+	request := httptest.NewRequest("GET", "/api/customers_mgmt/v1/customers/"+customers[0].ID, nil)
+	request = mux.SetURLVars(request, map[string]string{"id": customers[0].ID})
+	recorder := serveAs(server, "customers:get", server.getCustomerByID, "admin", "", request)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 when the datastore fails, got %d", recorder.Code)
+	}
+}
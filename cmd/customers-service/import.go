@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var importArgs struct {
+	format string
+	input  string
+	dryRun bool
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import customers",
+	Long: "Import customers and their owned clusters from JSON Lines or CSV, " +
+		"as written by the export command. Customers that already exist are " +
+		"replaced. Errors are reported for each line, and the lines that " +
+		"fail are skipped.",
+	Run: runImport,
+}
+
+func init() {
+	flags := importCmd.Flags()
+	flags.StringVar(
+		&importArgs.format,
+		"format",
+		formatJSONLines,
+		fmt.Sprintf("The input format, either '%s' or '%s'.", formatJSONLines, formatCSV),
+	)
+	flags.StringVar(
+		&importArgs.input,
+		"input",
+		"-",
+		"The file where the customers will be read from, '-' for the standard input.",
+	)
+	flags.BoolVar(
+		&importArgs.dryRun,
+		"dry-run",
+		false,
+		"Only validate the input, without changing the datastore.",
+	)
+	addConfigFlags(importCmd, &customersConfig.Logging, &customersConfig.Database)
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	var err error
+	in := os.Stdin
+	if importArgs.input != "-" {
+		in, err = os.Open(importArgs.input)
+		if err != nil {
+			logging.Fatalf("Can't open input file: %v", err)
+		}
+		defer in.Close()
+	}
+	reader, err := newCustomerReader(importArgs.format, in)
+	if err != nil {
+		logging.Fatalf("Can't create reader: %v", err)
+	}
+
+	var service CustomersService
+	if !importArgs.dryRun {
+		db, err := openDatabase()
+		if err != nil {
+			logging.Fatalf("Can't connect to database: %v", err)
+		}
+		defer db.Close()
+		service = NewSQLCustomersService(db)
+	}
+
+	report, err := importCustomers(service, reader, os.Stderr)
+	if err != nil {
+		logging.Fatalf("Can't import customers: %v", err)
+	}
+	if importArgs.dryRun {
+		fmt.Fprintf(os.Stderr, "Validated %d customers, %d failed\n", report.succeeded, report.failed)
+	} else {
+		fmt.Fprintf(os.Stderr, "Imported %d customers, %d failed\n", report.succeeded, report.failed)
+	}
+	if report.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// importReport contains the number of records that were imported or that
+// failed.
+type importReport struct {
+	succeeded int
+	failed    int
+}
+
+// importCustomers reads the customers and stores them using the service,
+// writing a line to the errors stream for each record that fails. If the
+// service is nil it only validates the records, without storing them. It
+// returns an error only if the input can't be read.
+func importCustomers(service CustomersService, reader customerReader,
+	errors io.Writer) (report importReport, err error) {
+	// These are used to detect duplicated customers inside the input, which
+	// is the only thing that can be checked besides the format when there
+	// is no service:
+	ids := make(map[string]int)
+	emails := make(map[string]int)
+
+	for {
+		customer, line, err := reader.Read()
+		if err == io.EOF {
+			return report, nil
+		}
+		if _, ok := err.(*recordError); ok {
+			fmt.Fprintf(errors, "line %d: %v\n", line, err)
+			report.failed++
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		err = checkImportedCustomer(customer, line, ids, emails)
+		if err == nil && service != nil {
+			_, err = service.Upsert(context.Background(), *customer)
+		}
+		if err != nil {
+			fmt.Fprintf(errors, "line %d: %v\n", line, err)
+			report.failed++
+			continue
+		}
+		report.succeeded++
+	}
+}
+
+// checkImportedCustomer validates a customer, and checks that its identifier
+// and email weren't used by previous lines.
+func checkImportedCustomer(customer *Customer, line int, ids map[string]int,
+	emails map[string]int) error {
+	_, err := replacementCustomer(*customer, nil)
+	if err != nil {
+		return err
+	}
+	if customer.ID != "" {
+		previous, ok := ids[customer.ID]
+		if ok {
+			return fmt.Errorf("id '%s' was already used in line %d", customer.ID, previous)
+		}
+		ids[customer.ID] = line
+	}
+	email := strings.ToLower(customer.Email)
+	previous, ok := emails[email]
+	if ok {
+		return fmt.Errorf("email '%s' was already used in line %d", customer.Email, previous)
+	}
+	emails[email] = line
+	return nil
+}
@@ -17,11 +17,10 @@ limitations under the License.
 package main
 
 import (
-	"flag"
 	"os"
 
+	"github.com/container-mgmt/dedicated-portal/pkg/config"
 	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
 )
 
 var (
@@ -30,26 +29,28 @@ var (
 		Use:  "customers-service",
 		Long: "A tool that can service customers.",
 	}
+
+	// configFile is the YAML file containing the configuration of all the
+	// commands.
+	configFile string
 )
 
 func init() {
-	// Send logs to the standard error stream by default:
-	flag.Set("logtostderr", "true")
-
-	// Register the options that are managed by the 'flag' package, so that they will also be parsed
-	// by the 'pflag' package:
-	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	rootCmd.PersistentFlags().StringVar(
+		&configFile,
+		"config",
+		os.Getenv(config.FileEnv),
+		"YAML file containing the configuration. Environment variable "+config.FileEnv+".",
+	)
 
 	// Register the subcommands:
 	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(migrateStoreCmd)
 }
 
 func main() {
-	// This is needed to make `glog` believe that the flags have already been parsed, otherwise
-	// every log messages is prefixed by an error message stating the the flags haven't been
-	// parsed.
-	flag.CommandLine.Parse([]string{})
-
 	// Execute the root command:
 	rootCmd.SetArgs(os.Args[1:])
 	rootCmd.Execute()
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/ksuid"
+)
+
+// MemoryCustomersService is a struct implementing the customer service
+// interface, backed by memory. The customers are lost when the process
+// finishes, so it is intended for tests and for local development.
+type MemoryCustomersService struct {
+	lock sync.Mutex
+
+	// customers contains the customers indexed by identifier, and ids
+	// contains the identifiers in the order the customers were added, which
+	// is the order used to list them.
+	customers map[string]*Customer
+	ids       []string
+}
+
+// NewMemoryCustomersService is a constructor for the MemoryCustomersService
+// struct.
+func NewMemoryCustomersService() *MemoryCustomersService {
+	service := new(MemoryCustomersService)
+	service.customers = make(map[string]*Customer)
+	service.ids = make([]string, 0)
+	return service
+}
+
+// Close does nothing, as there are no resources to release.
+func (service *MemoryCustomersService) Close() {
+}
+
+// Add adds a single customer to memory.
+func (service *MemoryCustomersService) Add(ctx context.Context, customer Customer) (*Customer, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	result, err := newCustomer(id.String(), customer)
+	if err != nil {
+		return nil, err
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	err = service.checkConflicts(result)
+	if err != nil {
+		return nil, err
+	}
+	service.store(result)
+	return copyCustomer(result), nil
+}
+
+// Upsert creates or replaces a single customer in memory.
+func (service *MemoryCustomersService) Upsert(ctx context.Context, customer Customer) (*Customer, error) {
+	if customer.ID == "" {
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		customer.ID = id.String()
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	result, err := replacementCustomer(customer, service.customers[customer.ID])
+	if err != nil {
+		return nil, err
+	}
+	err = service.checkConflicts(result)
+	if err != nil {
+		return nil, err
+	}
+	service.store(result)
+	return copyCustomer(result), nil
+}
+
+// AddOwnedClusters adds clusters to the clusters owned by a customer stored
+// in memory.
+func (service *MemoryCustomersService) AddOwnedClusters(ctx context.Context, id string,
+	clusterIDs []string) (*Customer, error) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	customer, ok := service.customers[id]
+	if !ok {
+		return nil, &NotFoundError{Kind: "customer", ID: id}
+	}
+	result, err := customer.ownedClustersAddition(clusterIDs)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return copyCustomer(customer), nil
+	}
+	err = service.checkConflicts(result)
+	if err != nil {
+		return nil, err
+	}
+	service.store(result)
+	return copyCustomer(result), nil
+}
+
+// Get retrieves a single customer from memory.
+func (service *MemoryCustomersService) Get(ctx context.Context, id string) (*Customer, error) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	customer, ok := service.customers[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyCustomer(customer), nil
+}
+
+// List retrieves a page of the customers stored in memory.
+func (service *MemoryCustomersService) List(ctx context.Context, args *ListArguments) (*CustomersList, error) {
+	err := validateListArguments(args)
+	if err != nil {
+		return nil, err
+	}
+	page, size := pageAndSize(args)
+	var filter *CustomerFilter
+	if args != nil {
+		filter = args.Filter
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	matching := make([]*Customer, 0, len(service.ids))
+	for _, id := range service.ids {
+		customer := service.customers[id]
+		if filter.Matches(customer) {
+			matching = append(matching, customer)
+		}
+	}
+	total := int64(len(matching))
+	first := page * size
+	last := first + size
+	if first > total {
+		first = total
+	}
+	if last > total {
+		last = total
+	}
+	items := make([]*Customer, 0, last-first)
+	for _, customer := range matching[first:last] {
+		items = append(items, copyCustomer(customer))
+	}
+	return &CustomersList{
+		Page:  page,
+		Size:  int64(len(items)),
+		Total: total,
+		Items: items,
+	}, nil
+}
+
+// checkConflicts checks that the email and the clusters of the customer
+// aren't used by other customers. Must be called with the lock held.
+func (service *MemoryCustomersService) checkConflicts(customer *Customer) error {
+	for _, other := range service.customers {
+		err := customer.conflictWith(other)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// store saves a copy of the customer. Must be called with the lock held.
+func (service *MemoryCustomersService) store(customer *Customer) {
+	if _, ok := service.customers[customer.ID]; !ok {
+		service.ids = append(service.ids, customer.ID)
+	}
+	service.customers[customer.ID] = copyCustomer(customer)
+}
+
+// copyCustomer returns a deep copy of the customer, so that callers can't
+// modify the stored customers.
+func copyCustomer(customer *Customer) *Customer {
+	result := *customer
+	result.OwnedClusters = make([]string, len(customer.OwnedClusters))
+	copy(result.OwnedClusters, customer.OwnedClusters)
+	return &result
+}
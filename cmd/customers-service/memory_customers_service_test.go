@@ -0,0 +1,49 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryCustomersServiceConformance(t *testing.T) {
+	runCustomersServiceConformance(t, func(t *testing.T) CustomersService {
+		return NewMemoryCustomersService()
+	})
+}
+
+func TestMemoryCustomersServiceReturnsCopies(t *testing.T) {
+	service := NewMemoryCustomersService()
+	added, err := service.Add(context.Background(), Customer{
+		Name:          "customer",
+		Email:         "customer@example.com",
+		OwnedClusters: []string{"cluster0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added.Name = "changed"
+	added.OwnedClusters[0] = "changed"
+	retrieved, err := service.Get(context.Background(), added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retrieved.Name != "customer" || retrieved.OwnedClusters[0] != "cluster0" {
+		t.Errorf("expected stored customer not to change, got %+v", retrieved)
+	}
+}
@@ -0,0 +1,365 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
+)
+
+// customerStatuses are the values of the status label of the customers
+// metric.
+var customerStatuses = []string{
+	CustomerStatusActive,
+	CustomerStatusSuspended,
+	CustomerStatusDisabled,
+}
+
+// addCustomerMetrics registers the metric with the number of customers in
+// each status, counted in the given service each time that the metrics are
+// collected.
+func addCustomerMetrics(registry *metrics.Registry, service CustomersService) {
+	registry.NewGaugeFunc(
+		"customers",
+		"Number of customers by status.",
+		[]string{"status"},
+		func() []metrics.Sample {
+			samples := make([]metrics.Sample, 0, len(customerStatuses))
+			for _, status := range customerStatuses {
+				list, err := service.List(context.Background(), &ListArguments{
+					Filter: &CustomerFilter{
+						Conditions: []*CustomerCondition{{
+							Field:    "status",
+							Operator: filterOpEqual,
+							Value:    status,
+						}},
+					},
+				})
+				if err != nil {
+					logging.Errorf("Can't count customers with status '%s': %v", status, err)
+					continue
+				}
+				samples = append(samples, metrics.Sample{
+					Labels: []string{status},
+					Value:  float64(list.Total),
+				})
+			}
+			return samples
+		},
+	)
+}
+
+// InstrumentedCustomersService is a customers service that measures the
+// duration of the operations of another, and records them as client spans of
+// the trace of the request.
+type InstrumentedCustomersService struct {
+	storeInstrumentation
+	service CustomersService
+}
+
+// NewInstrumentedCustomersService wraps the service so that the duration of
+// its operations is recorded in the given metrics, with the given backend
+// name.
+func NewInstrumentedCustomersService(service CustomersService, backend string,
+	storeMetrics *metrics.StoreMetrics) *InstrumentedCustomersService {
+	return &InstrumentedCustomersService{
+		storeInstrumentation: storeInstrumentation{
+			backend: backend,
+			metrics: storeMetrics,
+		},
+		service: service,
+	}
+}
+
+// Close closes the wrapped service.
+func (service *InstrumentedCustomersService) Close() {
+	service.service.Close()
+}
+
+// Add adds the customer to the wrapped service.
+func (service *InstrumentedCustomersService) Add(ctx context.Context, customer Customer) (result *Customer, err error) {
+	ctx, span := service.start(ctx, "add")
+	defer service.observe(span, "add", time.Now(), &err)
+	return service.service.Add(ctx, customer)
+}
+
+// Upsert creates or replaces the customer in the wrapped service.
+func (service *InstrumentedCustomersService) Upsert(ctx context.Context,
+	customer Customer) (result *Customer, err error) {
+	ctx, span := service.start(ctx, "upsert")
+	defer service.observe(span, "upsert", time.Now(), &err)
+	return service.service.Upsert(ctx, customer)
+}
+
+// AddOwnedClusters adds the clusters to the customer of the wrapped service.
+func (service *InstrumentedCustomersService) AddOwnedClusters(ctx context.Context, id string,
+	clusterIDs []string) (result *Customer, err error) {
+	ctx, span := service.start(ctx, "add_owned_clusters")
+	defer service.observe(span, "add_owned_clusters", time.Now(), &err)
+	return service.service.AddOwnedClusters(ctx, id, clusterIDs)
+}
+
+// Get returns the customer from the wrapped service.
+func (service *InstrumentedCustomersService) Get(ctx context.Context, id string) (result *Customer, err error) {
+	ctx, span := service.start(ctx, "get")
+	defer service.observe(span, "get", time.Now(), &err)
+	return service.service.Get(ctx, id)
+}
+
+// List lists the customers of the wrapped service.
+func (service *InstrumentedCustomersService) List(ctx context.Context,
+	args *ListArguments) (result *CustomersList, err error) {
+	ctx, span := service.start(ctx, "list")
+	defer service.observe(span, "list", time.Now(), &err)
+	return service.service.List(ctx, args)
+}
+
+// storeInstrumentation measures the duration of the operations of a
+// datastore, and records them as client spans of the trace of the request.
+type storeInstrumentation struct {
+	backend string
+	metrics *metrics.StoreMetrics
+}
+
+// start starts the span of an operation.
+func (i storeInstrumentation) start(ctx context.Context, operation string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, i.backend+" "+operation, tracing.SpanKindClient)
+	span.SetAttribute("db.system", i.backend)
+	span.SetAttribute("db.operation", operation)
+	return ctx, span
+}
+
+// observe records the result of an operation and ends its span.
+func (i storeInstrumentation) observe(span *tracing.Span, operation string, start time.Time, err *error) {
+	i.metrics.Observe(i.backend, operation, start, *err)
+	span.SetError(*err)
+	span.End()
+}
+
+// InstrumentedOrganizationsService is an organizations service that measures
+// the duration of the operations of another, and records them as client
+// spans of the trace of the request.
+type InstrumentedOrganizationsService struct {
+	storeInstrumentation
+	service OrganizationsService
+}
+
+// NewInstrumentedOrganizationsService wraps the service so that the duration
+// of its operations is recorded in the given metrics, with the given backend
+// name.
+func NewInstrumentedOrganizationsService(service OrganizationsService, backend string,
+	storeMetrics *metrics.StoreMetrics) *InstrumentedOrganizationsService {
+	return &InstrumentedOrganizationsService{
+		storeInstrumentation: storeInstrumentation{
+			backend: backend,
+			metrics: storeMetrics,
+		},
+		service: service,
+	}
+}
+
+// Close closes the wrapped service.
+func (service *InstrumentedOrganizationsService) Close() {
+	service.service.Close()
+}
+
+// ListOrganizations lists the organizations of the wrapped service.
+func (service *InstrumentedOrganizationsService) ListOrganizations(ctx context.Context,
+	args *ListArguments) (result *OrganizationsList, err error) {
+	ctx, span := service.start(ctx, "list_organizations")
+	defer service.observe(span, "list_organizations", time.Now(), &err)
+	return service.service.ListOrganizations(ctx, args)
+}
+
+// AddOrganization adds the organization to the wrapped service.
+func (service *InstrumentedOrganizationsService) AddOrganization(ctx context.Context,
+	organization Organization) (result *Organization, err error) {
+	ctx, span := service.start(ctx, "add_organization")
+	defer service.observe(span, "add_organization", time.Now(), &err)
+	return service.service.AddOrganization(ctx, organization)
+}
+
+// GetOrganization returns the organization from the wrapped service.
+func (service *InstrumentedOrganizationsService) GetOrganization(ctx context.Context,
+	id string) (result *Organization, err error) {
+	ctx, span := service.start(ctx, "get_organization")
+	defer service.observe(span, "get_organization", time.Now(), &err)
+	return service.service.GetOrganization(ctx, id)
+}
+
+// GetUser returns the user from the wrapped service.
+func (service *InstrumentedOrganizationsService) GetUser(ctx context.Context, id string) (result *User, err error) {
+	ctx, span := service.start(ctx, "get_user")
+	defer service.observe(span, "get_user", time.Now(), &err)
+	return service.service.GetUser(ctx, id)
+}
+
+// InviteMember adds the member to the organization in the wrapped service.
+func (service *InstrumentedOrganizationsService) InviteMember(ctx context.Context, organizationID string,
+	changer MemberChanger, invitation Invitation) (result *Membership, err error) {
+	ctx, span := service.start(ctx, "invite_member")
+	defer service.observe(span, "invite_member", time.Now(), &err)
+	return service.service.InviteMember(ctx, organizationID, changer, invitation)
+}
+
+// ListMembers lists the members of the organization in the wrapped service.
+func (service *InstrumentedOrganizationsService) ListMembers(ctx context.Context, organizationID string,
+	args *ListArguments) (result *MembershipsList, err error) {
+	ctx, span := service.start(ctx, "list_members")
+	defer service.observe(span, "list_members", time.Now(), &err)
+	return service.service.ListMembers(ctx, organizationID, args)
+}
+
+// RemoveMember removes the member from the organization in the wrapped
+// service.
+func (service *InstrumentedOrganizationsService) RemoveMember(ctx context.Context, organizationID string,
+	changer MemberChanger, userID string) (err error) {
+	ctx, span := service.start(ctx, "remove_member")
+	defer service.observe(span, "remove_member", time.Now(), &err)
+	return service.service.RemoveMember(ctx, organizationID, changer, userID)
+}
+
+// InstrumentedQuotasService is a quotas service that measures the duration of
+// the operations of another, and records them as client spans of the trace
+// of the request.
+type InstrumentedQuotasService struct {
+	storeInstrumentation
+	service QuotasService
+}
+
+// NewInstrumentedQuotasService wraps the service so that the duration of its
+// operations is recorded in the given metrics, with the given backend name.
+func NewInstrumentedQuotasService(service QuotasService, backend string,
+	storeMetrics *metrics.StoreMetrics) *InstrumentedQuotasService {
+	return &InstrumentedQuotasService{
+		storeInstrumentation: storeInstrumentation{
+			backend: backend,
+			metrics: storeMetrics,
+		},
+		service: service,
+	}
+}
+
+// Close closes the wrapped service.
+func (service *InstrumentedQuotasService) Close() {
+	service.service.Close()
+}
+
+// GetQuota returns the quota of the customer from the wrapped service.
+func (service *InstrumentedQuotasService) GetQuota(ctx context.Context, customerID string) (result *Quota, err error) {
+	ctx, span := service.start(ctx, "get_quota")
+	defer service.observe(span, "get_quota", time.Now(), &err)
+	return service.service.GetQuota(ctx, customerID)
+}
+
+// SetQuota replaces the quota of the customer in the wrapped service.
+func (service *InstrumentedQuotasService) SetQuota(ctx context.Context, customerID string,
+	quota Quota) (result *Quota, err error) {
+	ctx, span := service.start(ctx, "set_quota")
+	defer service.observe(span, "set_quota", time.Now(), &err)
+	return service.service.SetQuota(ctx, customerID, quota)
+}
+
+// InstrumentedServiceAccountsService is a service accounts service that
+// measures the duration of the operations of another, and records them as
+// client spans of the trace of the request.
+type InstrumentedServiceAccountsService struct {
+	storeInstrumentation
+	service ServiceAccountsService
+}
+
+// NewInstrumentedServiceAccountsService wraps the service so that the
+// duration of its operations is recorded in the given metrics, with the given
+// backend name.
+func NewInstrumentedServiceAccountsService(service ServiceAccountsService, backend string,
+	storeMetrics *metrics.StoreMetrics) *InstrumentedServiceAccountsService {
+	return &InstrumentedServiceAccountsService{
+		storeInstrumentation: storeInstrumentation{
+			backend: backend,
+			metrics: storeMetrics,
+		},
+		service: service,
+	}
+}
+
+// Close closes the wrapped service.
+func (service *InstrumentedServiceAccountsService) Close() {
+	service.service.Close()
+}
+
+// ListServiceAccounts lists the service accounts of the wrapped service.
+func (service *InstrumentedServiceAccountsService) ListServiceAccounts(ctx context.Context, organizationID string,
+	args *ListArguments) (result *ServiceAccountsList, err error) {
+	ctx, span := service.start(ctx, "list_service_accounts")
+	defer service.observe(span, "list_service_accounts", time.Now(), &err)
+	return service.service.ListServiceAccounts(ctx, organizationID, args)
+}
+
+// AddServiceAccount adds the service account to the wrapped service.
+func (service *InstrumentedServiceAccountsService) AddServiceAccount(ctx context.Context,
+	account ServiceAccount) (result *ServiceAccount, err error) {
+	ctx, span := service.start(ctx, "add_service_account")
+	defer service.observe(span, "add_service_account", time.Now(), &err)
+	return service.service.AddServiceAccount(ctx, account)
+}
+
+// GetServiceAccount returns the service account from the wrapped service.
+func (service *InstrumentedServiceAccountsService) GetServiceAccount(ctx context.Context,
+	id string) (result *ServiceAccount, err error) {
+	ctx, span := service.start(ctx, "get_service_account")
+	defer service.observe(span, "get_service_account", time.Now(), &err)
+	return service.service.GetServiceAccount(ctx, id)
+}
+
+// GetServiceAccountByKey returns the service account with the API key from
+// the wrapped service.
+func (service *InstrumentedServiceAccountsService) GetServiceAccountByKey(ctx context.Context,
+	keyID string) (result *ServiceAccount, err error) {
+	ctx, span := service.start(ctx, "get_service_account_by_key")
+	defer service.observe(span, "get_service_account_by_key", time.Now(), &err)
+	return service.service.GetServiceAccountByKey(ctx, keyID)
+}
+
+// RotateServiceAccountKey replaces the API key of the service account in the
+// wrapped service.
+func (service *InstrumentedServiceAccountsService) RotateServiceAccountKey(ctx context.Context, id string,
+	expiresAt *time.Time) (result *ServiceAccount, err error) {
+	ctx, span := service.start(ctx, "rotate_service_account_key")
+	defer service.observe(span, "rotate_service_account_key", time.Now(), &err)
+	return service.service.RotateServiceAccountKey(ctx, id, expiresAt)
+}
+
+// RevokeServiceAccount revokes the service account in the wrapped service.
+func (service *InstrumentedServiceAccountsService) RevokeServiceAccount(ctx context.Context,
+	id string) (result *ServiceAccount, err error) {
+	ctx, span := service.start(ctx, "revoke_service_account")
+	defer service.observe(span, "revoke_service_account", time.Now(), &err)
+	return service.service.RevokeServiceAccount(ctx, id)
+}
+
+// TouchServiceAccountKey records the use of the API key in the wrapped
+// service.
+func (service *InstrumentedServiceAccountsService) TouchServiceAccountKey(ctx context.Context, keyID string,
+	usedAt time.Time) (err error) {
+	ctx, span := service.start(ctx, "touch_service_account_key")
+	defer service.observe(span, "touch_service_account_key", time.Now(), &err)
+	return service.service.TouchServiceAccountKey(ctx, keyID, usedAt)
+}
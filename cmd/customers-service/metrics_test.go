@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+)
+
+func TestInstrumentedCustomersServiceMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	memory := NewMemoryCustomersService()
+	addCustomerMetrics(registry, memory)
+	service := NewInstrumentedCustomersService(memory, "memory", metrics.NewStoreMetrics(registry))
+	_, err := service.Add(context.Background(), Customer{Name: "a", Email: "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Add(context.Background(), Customer{Name: "b", Email: "b@example.com", Status: CustomerStatusSuspended})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Add(context.Background(), Customer{Name: "c", Email: "a@example.com"})
+	if _, ok := err.(*DuplicateEmailError); !ok {
+		t.Fatalf("expected duplicated email error, got %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	output := recorder.Body.String()
+	expected := []string{
+		`store_operation_duration_seconds_count{backend="memory",operation="add",result="success"} 2`,
+		`store_operation_duration_seconds_count{backend="memory",operation="add",result="error"} 1`,
+		`customers{status="active"} 1`,
+		`customers{status="suspended"} 1`,
+		`customers{status="disabled"} 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected metrics to contain '%s', got:\n%s", line, output)
+		}
+	}
+}
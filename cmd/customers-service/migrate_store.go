@@ -0,0 +1,371 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var migrateStoreArgs struct {
+	from           string
+	to             string
+	checkpointFile string
+	batchSize      int64
+	restart        bool
+}
+
+var migrateStoreCmd = &cobra.Command{
+	Use:   "migrate-store",
+	Short: "Copy customers from one datastore to other",
+	Long: "Copy all the customers from one datastore to other, and then verify " +
+		"that both contain the same customers. The progress is saved to a " +
+		"checkpoint file after each batch, so that an interrupted migration " +
+		"can be resumed running the command again.",
+	Run: runMigrateStore,
+}
+
+func init() {
+	flags := migrateStoreCmd.Flags()
+	flags.StringVar(
+		&migrateStoreArgs.from,
+		"from",
+		storeEtcd,
+		fmt.Sprintf("The datastore to copy from, either '%s' or '%s'.", storeSQL, storeEtcd),
+	)
+	flags.StringVar(
+		&migrateStoreArgs.to,
+		"to",
+		storeSQL,
+		fmt.Sprintf("The datastore to copy to, either '%s' or '%s'.", storeSQL, storeEtcd),
+	)
+	flags.StringVar(
+		&migrateStoreArgs.checkpointFile,
+		"checkpoint-file",
+		"migrate-store.checkpoint",
+		"The file where the progress of the migration is saved.",
+	)
+	flags.Int64Var(
+		&migrateStoreArgs.batchSize,
+		"batch-size",
+		100,
+		"The number of customers copied between checkpoints.",
+	)
+	flags.BoolVar(
+		&migrateStoreArgs.restart,
+		"restart",
+		false,
+		"Ignore the checkpoint file and copy all the customers again.",
+	)
+	addConfigFlags(migrateStoreCmd, &customersConfig.Logging, &customersConfig.Database,
+		&customersConfig.Etcd)
+}
+
+// migrationCheckpoint is the content of the checkpoint file.
+type migrationCheckpoint struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Copied int64     `json:"copied"`
+	Time   time.Time `json:"time"`
+}
+
+func runMigrateStore(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	if migrateStoreArgs.from == migrateStoreArgs.to {
+		logging.Fatalf("The source and destination datastores must be different")
+	}
+	if migrateStoreArgs.batchSize <= 0 {
+		logging.Fatalf("The batch size must be positive, but it is %d", migrateStoreArgs.batchSize)
+	}
+	// The database is needed only if one of the datastores is SQL:
+	var db *sql.DB
+	if migrateStoreArgs.from == storeSQL || migrateStoreArgs.to == storeSQL {
+		var err error
+		db, err = openDatabase()
+		if err != nil {
+			logging.Fatalf("Can't connect to database: %v", err)
+		}
+		defer db.Close()
+	}
+	from, err := openCustomersService(
+		migrateStoreArgs.from,
+		db,
+		customersConfig.Etcd.Endpoint,
+	)
+	if err != nil {
+		logging.Fatalf("Can't connect to %s datastore: %v", migrateStoreArgs.from, err)
+	}
+	defer from.Close()
+	to, err := openCustomersService(
+		migrateStoreArgs.to,
+		db,
+		customersConfig.Etcd.Endpoint,
+	)
+	if err != nil {
+		logging.Fatalf("Can't connect to %s datastore: %v", migrateStoreArgs.to, err)
+	}
+	defer to.Close()
+
+	checkpoint := &migrationCheckpoint{
+		From: migrateStoreArgs.from,
+		To:   migrateStoreArgs.to,
+	}
+	if !migrateStoreArgs.restart {
+		checkpoint, err = loadCheckpoint(migrateStoreArgs.checkpointFile, checkpoint)
+		if err != nil {
+			logging.Fatalf("Can't load checkpoint: %v", err)
+		}
+		if checkpoint.Copied > 0 {
+			logging.Infof("Resuming migration after %d customers", checkpoint.Copied)
+		}
+	}
+
+	err = copyCustomers(from, to, migrateStoreArgs.batchSize, checkpoint, func() error {
+		return saveCheckpoint(migrateStoreArgs.checkpointFile, checkpoint)
+	})
+	if err != nil {
+		logging.Fatalf("Migration failed after %d customers, run the command again to resume: %v",
+			checkpoint.Copied, err)
+	}
+	logging.Infof("Copied %d customers", checkpoint.Copied)
+
+	result, err := verifyCustomers(from, to, migrateStoreArgs.batchSize)
+	if err != nil {
+		logging.Fatalf("Can't verify migration: %v", err)
+	}
+	logging.Infof("Source contains %d customers with checksum %s", result.sourceCount, result.sourceChecksum)
+	logging.Infof("Destination contains %d customers with checksum %s", result.destinationCount, result.destinationChecksum)
+	if !result.ok() {
+		for _, id := range result.mismatches {
+			logging.Errorf("Customer '%s' is different in the source and destination", id)
+		}
+		logging.Fatalf("Verification failed, %d customers are different", len(result.mismatches))
+	}
+
+	// The migration is complete, so the next run should start from the
+	// beginning:
+	err = os.Remove(migrateStoreArgs.checkpointFile)
+	if err != nil && !os.IsNotExist(err) {
+		logging.Warnf("Can't remove checkpoint file: %v", err)
+	}
+	logging.Infof("Migration completed and verified")
+}
+
+// loadCheckpoint loads the checkpoint file, if it exists. If it doesn't exist
+// it returns the initial checkpoint.
+func loadCheckpoint(file string, initial *migrationCheckpoint) (*migrationCheckpoint, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return initial, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := new(migrationCheckpoint)
+	err = json.Unmarshal(data, checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse '%s': %v", file, err)
+	}
+	if checkpoint.From != initial.From || checkpoint.To != initial.To {
+		return nil, fmt.Errorf(
+			"'%s' belongs to a migration from %s to %s, use --restart to ignore it",
+			file, checkpoint.From, checkpoint.To,
+		)
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint writes the checkpoint to a temporary file and then renames
+// it, so that an interruption can't leave a partially written file.
+func saveCheckpoint(file string, checkpoint *migrationCheckpoint) error {
+	checkpoint.Time = time.Now().UTC()
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// copyCustomers copies customers from one service to the other in batches,
+// starting after the number of customers already copied according to the
+// checkpoint. After each batch it updates the checkpoint and calls the save
+// function. Customers are upserted, so copying the same batch twice is
+// harmless.
+func copyCustomers(from, to CustomersService, batchSize int64,
+	checkpoint *migrationCheckpoint, save func() error) error {
+	for {
+		// The offset of the checkpoint may not be a multiple of the batch
+		// size, if the batch size was changed between runs, so pages are
+		// computed with a size that makes the offset a page boundary:
+		page, size := checkpointPage(checkpoint.Copied, batchSize)
+		list, err := from.List(context.Background(), &ListArguments{Page: page, Size: size})
+		if err != nil {
+			return err
+		}
+		for _, customer := range list.Items {
+			_, err = to.Upsert(context.Background(), *customer)
+			if err != nil {
+				return fmt.Errorf("can't copy customer '%s': %v", customer.ID, err)
+			}
+		}
+		checkpoint.Copied += int64(len(list.Items))
+		err = save()
+		if err != nil {
+			return fmt.Errorf("can't save checkpoint: %v", err)
+		}
+		if int64(len(list.Items)) < size || checkpoint.Copied >= list.Total {
+			return nil
+		}
+	}
+}
+
+// checkpointPage returns a page number and size such that the page starts
+// exactly at the given offset, using the preferred size when possible.
+func checkpointPage(offset int64, preferred int64) (page int64, size int64) {
+	if offset%preferred == 0 {
+		return offset / preferred, preferred
+	}
+	// Use the largest divisor of the offset not larger than the preferred
+	// size, so that the remaining customers are copied using batches as
+	// large as possible:
+	for size = preferred; size > 1; size-- {
+		if offset%size == 0 {
+			break
+		}
+	}
+	return offset / size, size
+}
+
+// verificationResult contains the result of comparing the customers of two
+// services.
+type verificationResult struct {
+	sourceCount         int64
+	sourceChecksum      string
+	destinationCount    int64
+	destinationChecksum string
+	mismatches          []string
+}
+
+func (r *verificationResult) ok() bool {
+	return r.sourceCount == r.destinationCount &&
+		r.sourceChecksum == r.destinationChecksum &&
+		len(r.mismatches) == 0
+}
+
+// verifyCustomers compares the customers of the two services, calculating
+// the number of customers and a checksum for each of them, and the
+// identifiers of the customers that are different or that are missing in
+// one of the services.
+func verifyCustomers(from, to CustomersService, batchSize int64) (*verificationResult, error) {
+	sourceHashes, err := hashCustomers(from, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("can't read source: %v", err)
+	}
+	destinationHashes, err := hashCustomers(to, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("can't read destination: %v", err)
+	}
+	result := &verificationResult{
+		sourceCount:         int64(len(sourceHashes)),
+		sourceChecksum:      combineHashes(sourceHashes),
+		destinationCount:    int64(len(destinationHashes)),
+		destinationChecksum: combineHashes(destinationHashes),
+	}
+	for id, hash := range sourceHashes {
+		if destinationHashes[id] != hash {
+			result.mismatches = append(result.mismatches, id)
+		}
+	}
+	for id := range destinationHashes {
+		if _, ok := sourceHashes[id]; !ok {
+			result.mismatches = append(result.mismatches, id)
+		}
+	}
+	sort.Strings(result.mismatches)
+	return result, nil
+}
+
+// hashCustomers returns a map containing the hash of each customer of the
+// service, indexed by identifier.
+func hashCustomers(service CustomersService, batchSize int64) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for page := int64(0); ; page++ {
+		list, err := service.List(context.Background(), &ListArguments{Page: page, Size: batchSize})
+		if err != nil {
+			return nil, err
+		}
+		for _, customer := range list.Items {
+			hash, err := customerHash(customer)
+			if err != nil {
+				return nil, err
+			}
+			hashes[customer.ID] = hash
+		}
+		if int64(len(list.Items)) < batchSize || (page+1)*batchSize >= list.Total {
+			return hashes, nil
+		}
+	}
+}
+
+// customerHash calculates a hash of the customer that doesn't depend on the
+// representation details of the datastores: the order of the clusters, and
+// the time zone and sub-microsecond precision of the timestamps.
+func customerHash(customer *Customer) (string, error) {
+	normalized := *customer
+	normalized.CreatedAt = customer.CreatedAt.UTC().Truncate(time.Microsecond)
+	normalized.UpdatedAt = customer.UpdatedAt.UTC().Truncate(time.Microsecond)
+	normalized.OwnedClusters = make([]string, len(customer.OwnedClusters))
+	copy(normalized.OwnedClusters, customer.OwnedClusters)
+	sort.Strings(normalized.OwnedClusters)
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// combineHashes calculates a single checksum from the hashes of the
+// customers, independent of the order in which they were retrieved.
+func combineHashes(hashes map[string]string) string {
+	ids := make([]string, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	sum := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(sum, "%s:%s\n", id, hashes[id])
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
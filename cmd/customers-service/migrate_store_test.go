@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sliceCustomersService is a minimal customers service that keeps the
+// customers in a slice, in insertion order.
+type sliceCustomersService struct {
+	customers []*Customer
+	failAfter int
+}
+
+func (s *sliceCustomersService) List(ctx context.Context, args *ListArguments) (*CustomersList, error) {
+	first := args.Page * args.Size
+	last := first + args.Size
+	total := int64(len(s.customers))
+	if first > total {
+		first = total
+	}
+	if last > total {
+		last = total
+	}
+	items := s.customers[first:last]
+	return &CustomersList{Page: args.Page, Size: int64(len(items)), Total: total, Items: items}, nil
+}
+
+func (s *sliceCustomersService) Add(ctx context.Context, customer Customer) (*Customer, error) {
+	return s.Upsert(ctx, customer)
+}
+
+func (s *sliceCustomersService) Upsert(ctx context.Context, customer Customer) (*Customer, error) {
+	if s.failAfter > 0 && len(s.customers) >= s.failAfter {
+		return nil, fmt.Errorf("failure injected after %d customers", s.failAfter)
+	}
+	for i, existing := range s.customers {
+		if existing.ID == customer.ID {
+			s.customers[i] = &customer
+			return &customer, nil
+		}
+	}
+	s.customers = append(s.customers, &customer)
+	return &customer, nil
+}
+
+func (s *sliceCustomersService) AddOwnedClusters(ctx context.Context, id string,
+	clusterIDs []string) (*Customer, error) {
+	customer, err := s.Get(ctx, id)
+	if err != nil || customer == nil {
+		return nil, err
+	}
+	customer.OwnedClusters = append(customer.OwnedClusters, clusterIDs...)
+	return customer, nil
+}
+
+func (s *sliceCustomersService) Get(ctx context.Context, id string) (*Customer, error) {
+	for _, customer := range s.customers {
+		if customer.ID == id {
+			return customer, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *sliceCustomersService) Close() {
+}
+
+func newSliceCustomersService(count int) *sliceCustomersService {
+	service := new(sliceCustomersService)
+	for i := 0; i < count; i++ {
+		service.customers = append(service.customers, &Customer{
+			ID:            fmt.Sprintf("id%03d", i),
+			Name:          fmt.Sprintf("customer%d", i),
+			Email:         fmt.Sprintf("customer%d@example.com", i),
+			Status:        CustomerStatusActive,
+			CreatedAt:     time.Date(2018, 1, 1, 0, 0, i, 0, time.UTC),
+			UpdatedAt:     time.Date(2018, 1, 1, 0, 0, i, 0, time.UTC),
+			OwnedClusters: []string{fmt.Sprintf("cluster%d", i)},
+		})
+	}
+	return service
+}
+
+func TestCheckpointPage(t *testing.T) {
+	tests := []struct {
+		offset, preferred, page, size int64
+	}{
+		{0, 10, 0, 10},
+		{20, 10, 2, 10},
+		{15, 10, 3, 5},
+		{7, 10, 1, 7},
+		{13, 10, 13, 1},
+	}
+	for _, test := range tests {
+		page, size := checkpointPage(test.offset, test.preferred)
+		if page != test.page || size != test.size {
+			t.Errorf("expected page %d and size %d for offset %d, got %d and %d",
+				test.page, test.size, test.offset, page, size)
+		}
+		if page*size != test.offset {
+			t.Errorf("page %d of size %d doesn't start at offset %d", page, size, test.offset)
+		}
+	}
+}
+
+func TestCopyCustomersResumes(t *testing.T) {
+	from := newSliceCustomersService(25)
+	to := &sliceCustomersService{failAfter: 12}
+	checkpoint := &migrationCheckpoint{From: "a", To: "b"}
+	saves := 0
+	save := func() error {
+		saves++
+		return nil
+	}
+
+	// The first run fails in the second batch, so the checkpoint should
+	// contain only the first batch:
+	err := copyCustomers(from, to, 10, checkpoint, save)
+	if err == nil {
+		t.Fatalf("expected the first run to fail")
+	}
+	if checkpoint.Copied != 10 {
+		t.Fatalf("expected checkpoint after 10 customers, got %d", checkpoint.Copied)
+	}
+
+	// The second run should resume from the checkpoint:
+	to.failAfter = 0
+	err = copyCustomers(from, to, 10, checkpoint, save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Copied != 25 {
+		t.Errorf("expected 25 customers copied, got %d", checkpoint.Copied)
+	}
+	if saves != 3 {
+		t.Errorf("expected 3 checkpoints, got %d", saves)
+	}
+
+	result, err := verifyCustomers(from, to, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ok() {
+		t.Errorf("expected verification to succeed, got %+v", result)
+	}
+}
+
+func TestVerifyCustomersDetectsDifferences(t *testing.T) {
+	from := newSliceCustomersService(5)
+	to := newSliceCustomersService(6)
+	changed := *to.customers[2]
+	changed.Name = "changed"
+	to.customers[2] = &changed
+
+	result, err := verifyCustomers(from, to, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ok() {
+		t.Fatalf("expected verification to fail")
+	}
+	if result.sourceCount != 5 || result.destinationCount != 6 {
+		t.Errorf("expected counts 5 and 6, got %d and %d", result.sourceCount, result.destinationCount)
+	}
+	if len(result.mismatches) != 2 || result.mismatches[0] != "id002" || result.mismatches[1] != "id005" {
+		t.Errorf("expected mismatches id002 and id005, got %v", result.mismatches)
+	}
+}
+
+func TestCustomerHashIgnoresRepresentation(t *testing.T) {
+	location := time.FixedZone("test", 3600)
+	created := time.Date(2018, 1, 1, 10, 0, 0, 123456789, time.UTC)
+	a := &Customer{
+		ID:            "id",
+		CreatedAt:     created,
+		UpdatedAt:     created,
+		OwnedClusters: []string{"c1", "c2"},
+	}
+	b := &Customer{
+		ID:            "id",
+		CreatedAt:     created.In(location).Truncate(time.Microsecond),
+		UpdatedAt:     created.In(location),
+		OwnedClusters: []string{"c2", "c1"},
+	}
+	hashA, err := customerHash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := customerHash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA != hashB {
+		t.Errorf("expected equivalent customers to have the same hash")
+	}
+	if a.OwnedClusters[0] != "c1" || b.OwnedClusters[0] != "c2" {
+		t.Errorf("hashing shouldn't modify the customers")
+	}
+}
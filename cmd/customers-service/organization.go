@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"time"
+)
+
+// Possible roles of a member of an organization.
+const (
+	MemberRoleOwner  = "owner"
+	MemberRoleAdmin  = "admin"
+	MemberRoleMember = "member"
+)
+
+// Organization struct is the internal object representing the company of a
+// customer, that groups users and owns clusters. Each customer has at most
+// one organization, and it has the identifier of the customer, so that the
+// organization of the callers in the authorization policy is also the
+// identifier of their customer. The owned clusters are the clusters owned by
+// the customer, which are kept with the identifier shared by the customer and
+// the organization, so they belong to the organization and not to any of its
+// users.
+type Organization struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	OwnedClusters []string  `json:"owned_clusters"`
+}
+
+// OrganizationsList struct is the internal object representing a list of
+// Organizations.
+type OrganizationsList struct {
+	Page  int64           `json:"page"`
+	Size  int64           `json:"size"`
+	Total int64           `json:"total"`
+	Items []*Organization `json:"items"`
+}
+
+// User struct is the internal object representing a single person that can
+// be member of organizations.
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership struct is the internal object representing the relationship
+// between an user and an organization.
+type Membership struct {
+	OrganizationID string    `json:"organization_id"`
+	User           *User     `json:"user"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// MembershipsList struct is the internal object representing a list of
+// Memberships.
+type MembershipsList struct {
+	Page  int64         `json:"page"`
+	Size  int64         `json:"size"`
+	Total int64         `json:"total"`
+	Items []*Membership `json:"items"`
+}
+
+// Invitation struct contains the details needed to invite an user to an
+// organization.
+type Invitation struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+	Role  string `json:"role"`
+}
+
+// MemberChanger identifies the caller that changes the memberships of an
+// organization. Privileged callers, like the ones that can access all the
+// organizations, can make any change. Other callers are identified by their
+// email, and their role in the organization decides the changes that they
+// can make.
+type MemberChanger struct {
+	Email      string
+	Privileged bool
+}
+
+// NotFoundError is returned when an operation refers to an object that
+// doesn't exist.
+type NotFoundError struct {
+	Kind string
+	ID   string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s '%s' doesn't exist", e.Kind, e.ID)
+}
+
+// OrganizationExistsError is returned when an organization is created for a
+// customer that already has one.
+type OrganizationExistsError struct {
+	ID string
+}
+
+func (e *OrganizationExistsError) Error() string {
+	return fmt.Sprintf("customer '%s' already has an organization", e.ID)
+}
+
+// LastOwnerError is returned when an operation would leave an organization
+// without owners.
+type LastOwnerError struct {
+	OrganizationID string
+}
+
+func (e *LastOwnerError) Error() string {
+	return fmt.Sprintf(
+		"can't remove the last owner of organization '%s'",
+		e.OrganizationID,
+	)
+}
+
+// Validate checks that the organization has all the mandatory fields.
+func (organization *Organization) Validate() error {
+	if organization.ID == "" {
+		return &ValidationError{Field: "id", Reason: "id is mandatory, it is the identifier of the customer"}
+	}
+	if organization.Name == "" {
+		return &ValidationError{Field: "name", Reason: "name is mandatory"}
+	}
+	return nil
+}
+
+// MemberRoleError is returned when the caller that changes a membership
+// doesn't have the role in the organization that the change requires.
+type MemberRoleError struct {
+	OrganizationID string
+	Role           string
+}
+
+func (e *MemberRoleError) Error() string {
+	return fmt.Sprintf(
+		"only members of organization '%s' with role '%s' can make this change",
+		e.OrganizationID, e.Role,
+	)
+}
+
+// checkMemberChange checks that a caller with the given role in the
+// organization can change the role of a member from the current role to the
+// new one. The current role is empty for new members, and the new role is
+// empty for removed members. Owners can make any change, admins can add and
+// remove admins and members, and other callers can't change memberships.
+func checkMemberChange(organizationID string, changer MemberChanger, changerRole, currentRole,
+	newRole string) error {
+	if changer.Privileged || changerRole == MemberRoleOwner {
+		return nil
+	}
+	required := MemberRoleAdmin
+	if currentRole == MemberRoleOwner || newRole == MemberRoleOwner {
+		required = MemberRoleOwner
+	}
+	if changerRole == MemberRoleAdmin && required == MemberRoleAdmin {
+		return nil
+	}
+	return &MemberRoleError{OrganizationID: organizationID, Role: required}
+}
+
+// Validate checks that the invitation has a valid email and role.
+func (invitation *Invitation) Validate() error {
+	if invitation.Email == "" {
+		return &ValidationError{Field: "email", Reason: "email is mandatory"}
+	}
+	address, err := mail.ParseAddress(invitation.Email)
+	if err != nil || address.Address != invitation.Email {
+		return &ValidationError{
+			Field:  "email",
+			Reason: fmt.Sprintf("'%s' is not a valid email address", invitation.Email),
+		}
+	}
+	if !isValidRole(invitation.Role) {
+		return &ValidationError{
+			Field:  "role",
+			Reason: fmt.Sprintf("unknown role '%s'", invitation.Role),
+		}
+	}
+	return nil
+}
+
+func isValidRole(role string) bool {
+	switch role {
+	case MemberRoleOwner, MemberRoleAdmin, MemberRoleMember:
+		return true
+	default:
+		return false
+	}
+}
+
+// newOrganization prepares an organization to be stored by the
+// organizations service: it fills the defaults and the timestamps, and then
+// validates the result.
+func newOrganization(organization Organization) (*Organization, error) {
+	now := time.Now().UTC()
+	result := organization
+	result.CreatedAt = now
+	result.UpdatedAt = now
+	if result.OwnedClusters == nil {
+		result.OwnedClusters = make([]string, 0)
+	}
+	err := result.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
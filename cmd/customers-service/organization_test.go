@@ -0,0 +1,495 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/gorilla/mux"
+)
+
+// fakeOrganizationsService is an in-memory organizations service used by the
+// tests of the handlers. Like the real service it only creates organizations
+// for existing customers, with the identifier of the customer.
+type fakeOrganizationsService struct {
+	customers     CustomersService
+	organizations map[string]*Organization
+	members       map[string][]*Membership
+}
+
+func newFakeOrganizationsService(customers CustomersService) *fakeOrganizationsService {
+	return &fakeOrganizationsService{
+		customers:     customers,
+		organizations: make(map[string]*Organization),
+		members:       make(map[string][]*Membership),
+	}
+}
+
+func (s *fakeOrganizationsService) ListOrganizations(ctx context.Context,
+	args *ListArguments) (*OrganizationsList, error) {
+	result := &OrganizationsList{Items: []*Organization{}}
+	for _, organization := range s.organizations {
+		result.Items = append(result.Items, organization)
+	}
+	result.Total = int64(len(result.Items))
+	result.Size = result.Total
+	return result, nil
+}
+
+func (s *fakeOrganizationsService) AddOrganization(ctx context.Context,
+	organization Organization) (*Organization, error) {
+	result, err := newOrganization(organization)
+	if err != nil {
+		return nil, err
+	}
+	customer, err := s.customers.Get(ctx, result.ID)
+	if err != nil {
+		return nil, err
+	}
+	if customer == nil {
+		return nil, &ValidationError{Field: "id", Reason: "customer doesn't exist"}
+	}
+	if s.organizations[result.ID] != nil {
+		return nil, &OrganizationExistsError{ID: result.ID}
+	}
+	if len(result.OwnedClusters) > 0 {
+		customer, err = s.customers.AddOwnedClusters(ctx, result.ID, result.OwnedClusters)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.OwnedClusters = customer.OwnedClusters
+	s.organizations[result.ID] = result
+	return result, nil
+}
+
+func (s *fakeOrganizationsService) GetOrganization(ctx context.Context, id string) (*Organization, error) {
+	return s.organizations[id], nil
+}
+
+func (s *fakeOrganizationsService) GetUser(ctx context.Context, id string) (*User, error) {
+	for _, memberships := range s.members {
+		for _, membership := range memberships {
+			if membership.User.ID == id {
+				return membership.User, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeOrganizationsService) InviteMember(ctx context.Context, organizationID string,
+	changer MemberChanger, invitation Invitation) (*Membership, error) {
+	if s.organizations[organizationID] == nil {
+		return nil, &NotFoundError{Kind: "organization", ID: organizationID}
+	}
+	current := s.member(organizationID, func(m *Membership) bool { return m.User.Email == invitation.Email })
+	err := s.checkChange(organizationID, changer, current, invitation.Role)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		current.Role = invitation.Role
+		return current, nil
+	}
+	membership := &Membership{
+		OrganizationID: organizationID,
+		User:           &User{ID: invitation.Email, Email: invitation.Email, CreatedAt: time.Now()},
+		Role:           invitation.Role,
+		CreatedAt:      time.Now(),
+	}
+	s.members[organizationID] = append(s.members[organizationID], membership)
+	return membership, nil
+}
+
+func (s *fakeOrganizationsService) ListMembers(ctx context.Context, organizationID string,
+	args *ListArguments) (*MembershipsList, error) {
+	if s.organizations[organizationID] == nil {
+		return nil, &NotFoundError{Kind: "organization", ID: organizationID}
+	}
+	items := append([]*Membership{}, s.members[organizationID]...)
+	return &MembershipsList{Size: int64(len(items)), Total: int64(len(items)), Items: items}, nil
+}
+
+func (s *fakeOrganizationsService) RemoveMember(ctx context.Context, organizationID string,
+	changer MemberChanger, userID string) error {
+	current := s.member(organizationID, func(m *Membership) bool { return m.User.ID == userID })
+	if current == nil {
+		return &NotFoundError{Kind: "member", ID: userID}
+	}
+	err := s.checkChange(organizationID, changer, current, "")
+	if err != nil {
+		return err
+	}
+	memberships := s.members[organizationID]
+	for i, membership := range memberships {
+		if membership == current {
+			s.members[organizationID] = append(memberships[:i], memberships[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// member returns the first member of the organization that matches the
+// given function, or nil if there is none.
+func (s *fakeOrganizationsService) member(organizationID string, matches func(*Membership) bool) *Membership {
+	for _, membership := range s.members[organizationID] {
+		if matches(membership) {
+			return membership
+		}
+	}
+	return nil
+}
+
+// checkChange checks the role of the changer like the real service does.
+func (s *fakeOrganizationsService) checkChange(organizationID string, changer MemberChanger,
+	current *Membership, newRole string) error {
+	var changerRole, currentRole string
+	if changerMembership := s.member(organizationID, func(m *Membership) bool {
+		return m.User.Email == changer.Email
+	}); changerMembership != nil {
+		changerRole = changerMembership.Role
+	}
+	if current != nil {
+		currentRole = current.Role
+	}
+	return checkMemberChange(organizationID, changer, changerRole, currentRole, newRole)
+}
+
+func (s *fakeOrganizationsService) Close() {
+}
+
+func TestInvitationValidate(t *testing.T) {
+	tests := []struct {
+		invitation Invitation
+		field      string
+	}{
+		{Invitation{Email: "a@example.com", Role: MemberRoleOwner}, ""},
+		{Invitation{Email: "a@example.com", Role: MemberRoleAdmin}, ""},
+		{Invitation{Email: "a@example.com", Role: MemberRoleMember}, ""},
+		{Invitation{Role: MemberRoleMember}, "email"},
+		{Invitation{Email: "a", Role: MemberRoleMember}, "email"},
+		{Invitation{Email: "a@example.com"}, "role"},
+		{Invitation{Email: "a@example.com", Role: "guest"}, "role"},
+	}
+	for _, test := range tests {
+		err := test.invitation.Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("expected invitation %+v to be valid, got %v", test.invitation, err)
+			}
+			continue
+		}
+		validationErr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("expected validation error for invitation %+v, got %v", test.invitation, err)
+			continue
+		}
+		if validationErr.Field != test.field {
+			t.Errorf("expected invalid field to be %s instead it was %s", test.field, validationErr.Field)
+		}
+	}
+}
+
+func TestCheckMemberChange(t *testing.T) {
+	privileged := MemberChanger{Privileged: true}
+	member := MemberChanger{Email: "a@example.com"}
+	tests := []struct {
+		changer     MemberChanger
+		changerRole string
+		currentRole string
+		newRole     string
+		allowed     bool
+	}{
+		{privileged, "", MemberRoleOwner, "", true},
+		{privileged, "", "", MemberRoleOwner, true},
+		{member, MemberRoleOwner, MemberRoleOwner, "", true},
+		{member, MemberRoleOwner, "", MemberRoleOwner, true},
+		{member, MemberRoleOwner, MemberRoleAdmin, MemberRoleMember, true},
+		{member, MemberRoleAdmin, "", MemberRoleAdmin, true},
+		{member, MemberRoleAdmin, MemberRoleMember, "", true},
+		{member, MemberRoleAdmin, "", MemberRoleOwner, false},
+		{member, MemberRoleAdmin, MemberRoleOwner, MemberRoleAdmin, false},
+		{member, MemberRoleAdmin, MemberRoleOwner, "", false},
+		{member, MemberRoleMember, "", MemberRoleMember, false},
+		{member, MemberRoleMember, MemberRoleMember, "", false},
+		{member, "", "", MemberRoleMember, false},
+	}
+	for _, test := range tests {
+		err := checkMemberChange("org", test.changer, test.changerRole, test.currentRole, test.newRole)
+		if test.allowed && err != nil {
+			t.Errorf("expected %+v to be allowed, got %v", test, err)
+		}
+		if !test.allowed {
+			if _, ok := err.(*MemberRoleError); !ok {
+				t.Errorf("expected %+v to fail with member role error, got %v", test, err)
+			}
+		}
+	}
+}
+
+func TestNewOrganization(t *testing.T) {
+	_, err := newOrganization(Organization{Name: "Example Inc."})
+	if validationErr, ok := err.(*ValidationError); !ok || validationErr.Field != "id" {
+		t.Errorf("expected validation error for organization without id, got %v", err)
+	}
+	_, err = newOrganization(Organization{ID: "id"})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("expected validation error for organization without name, got %v", err)
+	}
+	result, err := newOrganization(Organization{ID: "id", Name: "Example Inc."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "id" {
+		t.Errorf("expected id to be 'id' instead it was '%s'", result.ID)
+	}
+	if result.OwnedClusters == nil {
+		t.Errorf("expected owned clusters to be an empty list")
+	}
+}
+
+// newTestOrganizationsServer creates a test server with the given customers,
+// and an organization for each of them.
+func newTestOrganizationsServer(t *testing.T, names ...string) (*Server, []*Customer) {
+	server, customers := newTestServer(t, names...)
+	organizations := newFakeOrganizationsService(server.service)
+	server.organizations = organizations
+	for _, customer := range customers {
+		_, err := organizations.AddOrganization(context.Background(), Organization{ID: customer.ID,
+			Name: customer.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return server, customers
+}
+
+func TestAddOrganizationUsesCustomerID(t *testing.T) {
+	server, customers := newTestServer(t, "a")
+	server.organizations = newFakeOrganizationsService(server.service)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "Example Inc."}`, http.StatusBadRequest},
+		{`{"id": "unknown", "name": "Example Inc."}`, http.StatusBadRequest},
+		{`{"id": "` + customers[0].ID + `", "name": "Example Inc."}`, http.StatusCreated},
+		{`{"id": "` + customers[0].ID + `", "name": "Example Inc."}`, http.StatusConflict},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/organizations",
+			strings.NewReader(test.body))
+		recorder := serveAs(server, "organizations:create", server.addOrganization, "admin", "", request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d adding %s, got %d", test.status, test.body, recorder.Code)
+		}
+	}
+}
+
+func TestAddOrganizationWithOwnedCluster(t *testing.T) {
+	server, customers := newTestServer(t, "a", "b")
+	server.organizations = newFakeOrganizationsService(server.service)
+	_, err := server.service.AddOwnedClusters(context.Background(), customers[0].ID, []string{"cluster"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"id": "` + customers[1].ID + `", "name": "Example Inc.", "owned_clusters": ["free", "cluster"]}`
+	request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/organizations", strings.NewReader(body))
+	recorder := serveAs(server, "organizations:create", server.addOrganization, "admin", "", request)
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("expected status 409 adding a cluster owned by other customer, got %d", recorder.Code)
+	}
+
+	// The first cluster isn't left owned by the customer of the organization
+	// that wasn't created:
+	customer, err := server.service.Get(context.Background(), customers[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsString(customer.OwnedClusters, "free") {
+		t.Errorf("expected cluster 'free' not to be owned, got %v", customer.OwnedClusters)
+	}
+}
+
+func TestGetOrganizationScopedToCustomer(t *testing.T) {
+	server, customers := newTestOrganizationsServer(t, "a", "b")
+
+	tests := []struct {
+		action  string
+		handler http.HandlerFunc
+		path    string
+	}{
+		{"organizations:get", server.getOrganizationByID, "/api/customers_mgmt/v1/organizations/%s"},
+		{"members:list", server.getMembersList, "/api/customers_mgmt/v1/organizations/%s/members"},
+	}
+	for _, test := range tests {
+		for _, customer := range customers {
+			request := httptest.NewRequest("GET", fmt.Sprintf(test.path, customer.ID), nil)
+			request = mux.SetURLVars(request, map[string]string{"id": customer.ID})
+
+			// The organization of the caller is the identifier of its
+			// customer, and it is also the identifier of the organization of
+			// that customer:
+			recorder := serveAs(server, test.action, test.handler, "customer", customers[0].ID, request)
+			expected := http.StatusNotFound
+			if customer.ID == customers[0].ID {
+				expected = http.StatusOK
+			}
+			if recorder.Code != expected {
+				t.Errorf("expected status %d for %s of '%s', got %d", expected, test.action, customer.Name,
+					recorder.Code)
+			}
+		}
+	}
+}
+
+func TestChangeMembersChecksRole(t *testing.T) {
+	server, customers := newTestOrganizationsServer(t, "a")
+	policy, err := authz.ParsePolicy([]byte(`{
+	  "role_claim": "roles",
+	  "organization_claim": "org_id",
+	  "roles": {
+	    "user": {
+	      "scope": "organization",
+	      "actions": ["members:*"]
+	    }
+	  }
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.policy = policy
+	id := customers[0].ID
+	privileged := MemberChanger{Privileged: true}
+	for _, invitation := range []Invitation{
+		{Email: "owner@example.com", Role: MemberRoleOwner},
+		{Email: "admin@example.com", Role: MemberRoleAdmin},
+		{Email: "member@example.com", Role: MemberRoleMember},
+	} {
+		_, err = server.organizations.InviteMember(context.Background(), id, privileged, invitation)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invites := []struct {
+		caller string
+		body   string
+		status int
+	}{
+		{"member@example.com", `{"email": "new@example.com", "role": "member"}`, http.StatusForbidden},
+		{"admin@example.com", `{"email": "new@example.com", "role": "owner"}`, http.StatusForbidden},
+		{"admin@example.com", `{"email": "owner@example.com", "role": "member"}`, http.StatusForbidden},
+		{"admin@example.com", `{"email": "new@example.com", "role": "member"}`, http.StatusOK},
+		{"owner@example.com", `{"email": "new@example.com", "role": "owner"}`, http.StatusOK},
+		{"other@example.com", `{"email": "other@example.com", "role": "owner"}`, http.StatusForbidden},
+	}
+	for _, test := range invites {
+		request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/organizations/"+id+"/members",
+			strings.NewReader(test.body))
+		request = mux.SetURLVars(request, map[string]string{"id": id})
+		recorder := serveAsUser(server, "members:invite", server.inviteMember, "user", id, test.caller, request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d when '%s' invites %s, got %d", test.status, test.caller, test.body,
+				recorder.Code)
+		}
+	}
+
+	removals := []struct {
+		caller string
+		user   string
+		status int
+	}{
+		{"member@example.com", "admin@example.com", http.StatusForbidden},
+		{"admin@example.com", "owner@example.com", http.StatusForbidden},
+		{"admin@example.com", "member@example.com", http.StatusNoContent},
+		{"owner@example.com", "new@example.com", http.StatusNoContent},
+	}
+	for _, test := range removals {
+		request := httptest.NewRequest("DELETE", "/api/customers_mgmt/v1/organizations/"+id+"/members/"+test.user,
+			nil)
+		request = mux.SetURLVars(request, map[string]string{"id": id, "user_id": test.user})
+		recorder := serveAsUser(server, "members:remove", server.removeMember, "user", id, test.caller, request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d when '%s' removes '%s', got %d", test.status, test.caller, test.user,
+				recorder.Code)
+		}
+	}
+}
+
+func TestChangeMembersWithDefaultPolicy(t *testing.T) {
+	server, customers := newTestOrganizationsServer(t, "a")
+	id := customers[0].ID
+	privileged := MemberChanger{Privileged: true}
+	for _, invitation := range []Invitation{
+		{Email: "owner@example.com", Role: MemberRoleOwner},
+		{Email: "member@example.com", Role: MemberRoleMember},
+	} {
+		_, err := server.organizations.InviteMember(context.Background(), id, privileged, invitation)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		caller string
+		status int
+	}{
+		{"member@example.com", http.StatusForbidden},
+		{"owner@example.com", http.StatusOK},
+	}
+	for _, test := range tests {
+		// Send the request through the routes of the API, as a customer of
+		// the organization, so that the default policy is checked:
+		identity := &auth.Identity{
+			Subject: "user",
+			Email:   test.caller,
+			Claims: map[string]interface{}{
+				"roles":  "customer",
+				"org_id": id,
+			},
+		}
+		router := mux.NewRouter()
+		apiRouter := router.PathPrefix("/api/customers_mgmt/v1").Subrouter()
+		apiRouter.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(auth.ContextWithIdentity(r.Context(), identity)))
+			})
+		})
+		server.addAPIRoutes(apiRouter)
+
+		request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/organizations/"+id+"/members",
+			strings.NewReader(`{"email": "new@example.com", "role": "member"}`))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d when '%s' invites a member, got %d: %s", test.status, test.caller,
+				recorder.Code, recorder.Body.String())
+		}
+	}
+}
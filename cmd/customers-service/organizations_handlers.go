@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) getOrganizationsList(w http.ResponseWriter, r *http.Request) {
+	args, err := getListArguments(r)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error listing organizations, %v", err)
+		return
+	}
+	access := authz.AccessFromContext(r.Context())
+	if !access.All() {
+		server.getOwnOrganizationList(w, r, access.Organization(), args)
+		return
+	}
+	ret, err := server.organizations.ListOrganizations(r.Context(), args)
+	if err != nil {
+		api.WriteErrorf(w, http.StatusInternalServerError, "Error listing organizations, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewList(int(ret.Page), ret.Total, ret.Items))
+}
+
+// getOwnOrganizationList writes the list of organizations seen by callers
+// that can only access their own organization, which contains at most that
+// organization.
+func (server *Server) getOwnOrganizationList(w http.ResponseWriter, r *http.Request, id string,
+	args *ListArguments) {
+	organization, err := server.organizations.GetOrganization(r.Context(), id)
+	if err != nil {
+		api.WriteErrorf(w, http.StatusInternalServerError, "Error listing organizations, %v", err)
+		return
+	}
+	ret := &OrganizationsList{
+		Page:  args.Page,
+		Items: make([]*Organization, 0, 1),
+	}
+	if organization != nil {
+		ret.Total = 1
+		if args.Page == 0 && args.Size > 0 {
+			ret.Items = append(ret.Items, organization)
+		}
+	}
+	ret.Size = int64(len(ret.Items))
+	api.WriteJSON(w, http.StatusOK, api.NewList(int(ret.Page), ret.Total, ret.Items))
+}
+
+func (server *Server) addOrganization(w http.ResponseWriter, r *http.Request) {
+	// New organizations aren't the organization of the caller, so only
+	// callers that can access all the organizations can add them:
+	if !authz.AccessFromContext(r.Context()).All() {
+		api.WriteErrorf(w, http.StatusForbidden, "Error adding organization, only allowed for all organizations")
+		return
+	}
+	var organization Organization
+	err := api.DecodeJSON(w, r, &organization)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error decoding organization, %v", err)
+		return
+	}
+	ret, err := server.organizations.AddOrganization(r.Context(), organization)
+	if err != nil {
+		api.WriteErrorf(w, organizationErrorCode(err), "Error adding organization, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, ret)
+}
+
+func (server *Server) getOrganizationByID(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error getting organization", "organization", id)
+		return
+	}
+	ret, err := server.organizations.GetOrganization(r.Context(), id)
+	if err != nil {
+		api.WriteErrorf(w, http.StatusInternalServerError, "Error getting organization, %v", err)
+		return
+	}
+	if ret == nil {
+		writeNotFound(w, "Error getting organization", "organization", id)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (server *Server) getMembersList(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error listing members", "organization", id)
+		return
+	}
+	args, err := getListArguments(r)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error listing members, %v", err)
+		return
+	}
+	ret, err := server.organizations.ListMembers(r.Context(), id, args)
+	if err != nil {
+		api.WriteErrorf(w, organizationErrorCode(err), "Error listing members, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewList(int(ret.Page), ret.Total, ret.Items))
+}
+
+func (server *Server) inviteMember(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error inviting member", "organization", id)
+		return
+	}
+	var invitation Invitation
+	err := api.DecodeJSON(w, r, &invitation)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error decoding invitation, %v", err)
+		return
+	}
+	ret, err := server.organizations.InviteMember(r.Context(), id, memberChanger(r), invitation)
+	if err != nil {
+		api.WriteErrorf(w, organizationErrorCode(err), "Error inviting member, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (server *Server) removeMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authz.AccessFromContext(r.Context()).Allows(vars["id"]) {
+		writeNotFound(w, "Error removing member", "organization", vars["id"])
+		return
+	}
+	err := server.organizations.RemoveMember(r.Context(), vars["id"], memberChanger(r), vars["user_id"])
+	if err != nil {
+		api.WriteErrorf(w, organizationErrorCode(err), "Error removing member, %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memberChanger returns the caller of the request as a changer of the
+// memberships of organizations. Callers that can access all the
+// organizations are privileged, and the others are identified by the email
+// of their token.
+func memberChanger(r *http.Request) MemberChanger {
+	if authz.AccessFromContext(r.Context()).All() {
+		return MemberChanger{Privileged: true}
+	}
+	var changer MemberChanger
+	identity := auth.IdentityFromContext(r.Context())
+	if identity != nil {
+		changer.Email = identity.Email
+	}
+	return changer
+}
+
+func (server *Server) getUserByID(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	// Users can be members of several organizations, so only callers that
+	// can access all the organizations can retrieve them:
+	if !authz.AccessFromContext(r.Context()).All() {
+		writeNotFound(w, "Error getting user", "user", id)
+		return
+	}
+	ret, err := server.organizations.GetUser(r.Context(), id)
+	if err != nil {
+		api.WriteErrorf(w, http.StatusInternalServerError, "Error getting user, %v", err)
+		return
+	}
+	if ret == nil {
+		writeNotFound(w, "Error getting user", "user", id)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+// organizationErrorCode returns the HTTP status code that corresponds to an
+// error returned by the organizations service.
+func organizationErrorCode(err error) int {
+	switch err.(type) {
+	case *ValidationError:
+		return http.StatusBadRequest
+	case *MemberRoleError:
+		return http.StatusForbidden
+	case *NotFoundError:
+		return http.StatusNotFound
+	case *LastOwnerError, *OrganizationExistsError, *ClusterOwnedError:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
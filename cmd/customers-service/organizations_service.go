@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+)
+
+// OrganizationsService is an interface exposing the operations needed to
+// manage the organizations, their members and the clusters they own.
+type OrganizationsService interface {
+	// The operations are canceled, and their spans are part of the trace, of
+	// the given context.
+
+	// ListOrganizations returns a pointer to OrganizationsList or error in
+	// case some error occurred. If nil arguments are supplied it returns all
+	// the organizations.
+	ListOrganizations(ctx context.Context, args *ListArguments) (*OrganizationsList, error)
+
+	// AddOrganization creates the organization of the customer with the
+	// identifier of the supplied organization, with its name and (possibly)
+	// owned clusters, and returns the newly created organization. It fails
+	// with ValidationError if the customer doesn't exist, with
+	// OrganizationExistsError if the customer already has an organization,
+	// and with ClusterOwnedError if any of the clusters is owned by other
+	// customer.
+	AddOrganization(ctx context.Context, organization Organization) (*Organization, error)
+
+	// GetOrganization returns a pointer to the organization with the supplied
+	// id. If no such organization exist it returns nil pointer and nil error.
+	GetOrganization(ctx context.Context, id string) (*Organization, error)
+
+	// GetUser returns a pointer to the user with the supplied id. If no such
+	// user exist it returns nil pointer and nil error.
+	GetUser(ctx context.Context, id string) (*User, error)
+
+	// InviteMember adds the user with the email of the invitation to the
+	// organization, with the role of the invitation. The user is created if
+	// it doesn't exist yet. If the user is already a member its role is
+	// updated. It fails with MemberRoleError if the role of the changer in
+	// the organization doesn't allow the change.
+	InviteMember(ctx context.Context, organizationID string, changer MemberChanger,
+		invitation Invitation) (*Membership, error)
+
+	// ListMembers returns the memberships of the organization. If nil
+	// arguments are supplied it returns all the members.
+	ListMembers(ctx context.Context, organizationID string, args *ListArguments) (*MembershipsList, error)
+
+	// RemoveMember removes the user from the organization. It fails with
+	// LastOwnerError if the user is the last owner of the organization, and
+	// with MemberRoleError if the role of the changer in the organization
+	// doesn't allow the change.
+	RemoveMember(ctx context.Context, organizationID string, changer MemberChanger, userID string) error
+
+	// Close closes the service.
+	Close()
+}
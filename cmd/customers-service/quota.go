@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Quota struct is the internal object representing the limits on the
+// resources that a customer can use. Limits that aren't set (nil maximums
+// or empty lists) aren't enforced.
+type Quota struct {
+	CustomerID           string    `json:"customer_id"`
+	MaxClusters          *int64    `json:"max_clusters,omitempty"`
+	MaxNodes             *int64    `json:"max_nodes,omitempty"`
+	AllowedRegions       []string  `json:"allowed_regions"`
+	AllowedInstanceTypes []string  `json:"allowed_instance_types"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// Validate checks that the limits of the quota aren't negative.
+func (quota *Quota) Validate() error {
+	if quota.MaxClusters != nil && *quota.MaxClusters < 0 {
+		return &ValidationError{
+			Field:  "max_clusters",
+			Reason: fmt.Sprintf("%d is negative", *quota.MaxClusters),
+		}
+	}
+	if quota.MaxNodes != nil && *quota.MaxNodes < 0 {
+		return &ValidationError{
+			Field:  "max_nodes",
+			Reason: fmt.Sprintf("%d is negative", *quota.MaxNodes),
+		}
+	}
+	for _, region := range quota.AllowedRegions {
+		if region == "" {
+			return &ValidationError{Field: "allowed_regions", Reason: "region is empty"}
+		}
+	}
+	for _, instanceType := range quota.AllowedInstanceTypes {
+		if instanceType == "" {
+			return &ValidationError{Field: "allowed_instance_types", Reason: "instance type is empty"}
+		}
+	}
+	return nil
+}
+
+// QuotasService is an interface exposing the operations needed to manage the
+// quotas of the customers.
+type QuotasService interface {
+	// The operations are canceled, and their spans are part of the trace, of
+	// the given context.
+
+	// GetQuota returns the quota of the customer. If the customer doesn't
+	// have a quota it returns an unlimited quota. If the customer doesn't
+	// exist it returns NotFoundError.
+	GetQuota(ctx context.Context, customerID string) (*Quota, error)
+
+	// SetQuota replaces the quota of the customer with the supplied one, and
+	// returns the stored quota. If the customer doesn't exist it returns
+	// NotFoundError.
+	SetQuota(ctx context.Context, customerID string, quota Quota) (*Quota, error)
+
+	// Close closes the service.
+	Close()
+}
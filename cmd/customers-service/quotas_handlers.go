@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) getQuota(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error getting quota", "customer", id)
+		return
+	}
+	ret, err := server.quotas.GetQuota(r.Context(), id)
+	if err != nil {
+		api.WriteErrorf(w, quotaErrorCode(err), "Error getting quota, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (server *Server) setQuota(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !authz.AccessFromContext(r.Context()).Allows(id) {
+		writeNotFound(w, "Error setting quota", "customer", id)
+		return
+	}
+	var quota Quota
+	err := api.DecodeJSON(w, r, &quota)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error decoding quota, %v", err)
+		return
+	}
+	ret, err := server.quotas.SetQuota(r.Context(), id, quota)
+	if err != nil {
+		api.WriteErrorf(w, quotaErrorCode(err), "Error setting quota, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+// quotaErrorCode returns the HTTP status code that corresponds to an error
+// returned by the quotas service.
+func quotaErrorCode(err error) int {
+	switch err.(type) {
+	case *ValidationError:
+		return http.StatusBadRequest
+	case *NotFoundError:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
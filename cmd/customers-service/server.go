@@ -17,27 +17,38 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"time"
 
-	"github.com/golang/glog"
-	"github.com/gorilla/handlers"
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/container-mgmt/dedicated-portal/pkg/config"
+	"github.com/container-mgmt/dedicated-portal/pkg/health"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+	"github.com/container-mgmt/dedicated-portal/pkg/ratelimit"
+	"github.com/container-mgmt/dedicated-portal/pkg/signals"
+	"github.com/container-mgmt/dedicated-portal/pkg/tlsconfig"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
 	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
 )
 
 // Server serves REST API requests on clusters.
 type Server struct {
-	service CustomersService
+	service         CustomersService
+	organizations   OrganizationsService
+	quotas          QuotasService
+	serviceAccounts ServiceAccountsService
+	policy          *authz.Policy
 }
 
 var serveArgs struct {
-	host              string
-	port              int
-	sqlConnStr        string
-	notificationTopic string
+	printConfig bool
 }
 
 var serveCmd = &cobra.Command{
@@ -49,76 +60,242 @@ var serveCmd = &cobra.Command{
 
 func init() {
 	flags := serveCmd.Flags()
-	flags.StringVar(
-		&serveArgs.host,
-		"host",
-		"0.0.0.0",
-		"The IP address or host name of the server.",
-	)
-	flags.IntVar(
-		&serveArgs.port,
-		"port",
-		8000,
-		"The port number of the server.",
-	)
-	flags.StringVar(
-		&serveArgs.sqlConnStr,
-		"sql-connection-string",
-		"host=localhost port=5432 user=postgres password=1234 dbname=customers sslmode=disable",
-		"The connection string for connection to sql datastore.",
-	)
-	flags.StringVar(
-		&serveArgs.notificationTopic,
-		"notifications-topic",
-		"customers.notifications",
-		"The name of the topic listening to notifications, for example: customers.notifications",
+	flags.BoolVar(
+		&serveArgs.printConfig,
+		"print-config",
+		false,
+		"Print the effective configuration, with the secrets redacted, and exit.",
 	)
+	addConfigFlags(serveCmd, &customersConfig)
 }
 
-// InitServer is a constructor for the Server struct.
-func initServer(service CustomersService) (server *Server) {
+// InitServer is a constructor for the Server struct. Requests are authorized
+// with the given policy, or not authorized at all if it is nil.
+func initServer(service CustomersService, organizations OrganizationsService,
+	quotas QuotasService, serviceAccounts ServiceAccountsService, policy *authz.Policy) (server *Server) {
 	server = new(Server)
 	server.service = service
+	server.organizations = organizations
+	server.quotas = quotas
+	server.serviceAccounts = serviceAccounts
+	server.policy = policy
 	return server
 }
 
 func runServe(cmd *cobra.Command, args []string) {
-	service, err := NewSQLCustomersService(serveArgs.sqlConnStr)
+	loadConfig(cmd)
+	cfg := &customersConfig
+	if serveArgs.printConfig {
+		err := config.Print(os.Stdout, cfg)
+		if err != nil {
+			logging.Fatalf("Can't print configuration: %v", err)
+		}
+		return
+	}
+
+	// Set up signals so we handle the first shutdown signal gracefully. The
+	// components register the functions that release their resources in the
+	// shutdown hooks, and the functions that reload their configuration when
+	// SIGHUP is received in the reloader:
+	ctx, cancel := signals.Context(context.Background())
+	defer cancel()
+	stopCh := ctx.Done()
+	hooks := signals.NewShutdownHooks()
+	reloader := signals.NewReloader()
+	reloader.Add("configuration", reloadConfig(cmd))
+	checker := health.NewChecker()
+	checker.StopOn(stopCh)
+	registry := metrics.NewRegistry()
+	storeMetrics := metrics.NewStoreMetrics(registry)
+	dbMetrics := metrics.NewDBMetrics(registry)
+
+	tracer, err := tracing.New(cfg.Tracing.Config())
+	if err != nil {
+		panic(fmt.Sprintf("Can't create tracer: %v", err))
+	}
+	tracing.SetDefault(tracer)
+	hooks.Add("tracer", tracingShutdownTimeout, tracer.Shutdown)
+
+	// All the services that use the database share the same connection pool:
+	db, err := openDatabase()
+	if err != nil {
+		panic(fmt.Sprintf("Can't connect to database: %v", err))
+	}
+	hooks.Add("database", 0, func(context.Context) error {
+		return db.Close()
+	})
+	checker.Add("database", health.DatabaseCheck(db))
+	dbMetrics.Add("customers", db)
+
+	service, err := openCustomersService(cfg.Store, db, cfg.Etcd.Endpoint)
+	if err != nil {
+		panic(fmt.Sprintf("Can't connect to %s datastore: %v", cfg.Store, err))
+	}
+	addStoreCheck(checker, cfg.Store, service)
+	addCustomerMetrics(registry, service)
+	service = NewInstrumentedCustomersService(service, cfg.Store, storeMetrics)
+	if cfg.DualWriteStore != "" {
+		secondary, err := openCustomersService(cfg.DualWriteStore, db, cfg.Etcd.Endpoint)
+		if err != nil {
+			panic(fmt.Sprintf("Can't connect to %s datastore: %v", cfg.DualWriteStore, err))
+		}
+		addStoreCheck(checker, cfg.DualWriteStore, secondary)
+		secondary = NewInstrumentedCustomersService(secondary, cfg.DualWriteStore, storeMetrics)
+		logging.Infof("Writing customers to both the %s and %s datastores.", cfg.Store, cfg.DualWriteStore)
+		service = NewDualWriteCustomersService(service, secondary)
+	}
+
+	organizations := NewInstrumentedOrganizationsService(NewSQLOrganizationsService(db, service), storeSQL,
+		storeMetrics)
+	quotas := NewInstrumentedQuotasService(NewSQLQuotasService(db, service), storeSQL, storeMetrics)
+	serviceAccounts := NewInstrumentedServiceAccountsService(NewSQLServiceAccountsService(db), storeSQL,
+		storeMetrics)
+
+	// Requests are authenticated and authorized only when the JSON web key
+	// set is known. Service accounts can then also use their API keys:
+	var verifier auth.TokenVerifier
+	var policy *authz.Policy
+	if cfg.Auth.Enabled() {
+		tokens, err := auth.NewJWTVerifier(auth.JWTConfig{
+			KeysFile: cfg.Auth.JWKSFile,
+			KeysURL:  cfg.Auth.JWKSURL,
+			Issuer:   cfg.Auth.Issuer,
+			Audience: cfg.Auth.Audience,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("Can't create token verifier: %v", err))
+		}
+		policy, err = authz.LoadPolicy(cfg.Auth.PolicyFile)
+		if err != nil {
+			panic(fmt.Sprintf("Can't load authorization policy: %v", err))
+		}
+		apiKeys := auth.NewAPIKeyVerifier(&serviceAccountKeyStore{
+			service: serviceAccounts,
+			policy:  policy,
+		})
+		verifier = auth.NewCombinedVerifier(tokens, apiKeys)
+	} else {
+		logging.Warnf("No JSON web key set given, authentication is disabled.")
+	}
+
+	limits, err := cfg.RateLimits.Config()
 	if err != nil {
-		panic(fmt.Sprintf("Can't connect to etcd: %v", err))
+		panic(fmt.Sprintf("Can't load rate limits: %v", err))
+	}
+
+	tlsConfig, reloadTLS, err := tlsconfig.NewReloadable(cfg.TLS.Config())
+	if err != nil {
+		panic(fmt.Sprintf("Can't load TLS configuration: %v", err))
+	}
+	if tlsConfig != nil {
+		reloader.Add("TLS certificates", reloadTLS)
 	}
-	defer service.Close()
+	go reloader.Run(ctx)
 
 	// Create server URL.
-	serverAddress := fmt.Sprintf("%s:%d", serveArgs.host, serveArgs.port)
+	serverAddress := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 
 	// Inform user we are starting.
-	glog.Infof("Starting customers-service server at %s.", serverAddress)
+	logging.Infof("Starting customers-service server at %s.", serverAddress)
 
 	// Start server.
-	server := initServer(service)
-	defer server.Close()
+	server := initServer(service, organizations, quotas, serviceAccounts, policy)
+	hooks.Add("stores", 0, func(context.Context) error {
+		server.Close()
+		return nil
+	})
 
 	// Create the main router:
+	httpMetrics := metrics.NewHTTPMetrics(registry)
 	mainRouter := mux.NewRouter()
+	mainRouter.Use(tracing.Middleware(tracer))
+	mainRouter.Use(httpMetrics.Middleware)
+	mainRouter.NotFoundHandler = httpMetrics.Middleware(http.NotFoundHandler())
+	mainRouter.HandleFunc("/healthz", checker.ServeLive).Methods("GET")
+	mainRouter.HandleFunc("/readyz", checker.ServeReady).Methods("GET")
+	mainRouter.Handle("/metrics", registry).Methods("GET")
 
 	// Create the API router:
 	apiRouter := mainRouter.PathPrefix("/api/customers_mgmt/v1").Subrouter()
-	apiRouter.HandleFunc("/customers", server.getCustomersList).Methods("GET")
-	apiRouter.HandleFunc("/customers", server.addCustomer).Methods("POST")
-	apiRouter.HandleFunc("/customers/{id}", server.getCustomerByID).Methods("GET")
-	apiRouter.Path("/customers").
+	apiRouter.Use(api.Negotiate)
+	apiRouter.Use(ratelimit.AddressMiddleware(*limits))
+	if verifier != nil {
+		apiRouter.Use(auth.Middleware(verifier))
+	}
+	apiRouter.Use(ratelimit.Middleware(*limits))
+	server.addAPIRoutes(apiRouter)
+
+	// Assign identifiers to the requests and enable the access log:
+	loggedRouter := logging.Middleware(logging.Default())(mainRouter)
+
+	httpServer := &http.Server{
+		Addr:      serverAddress,
+		Handler:   loggedRouter,
+		TLSConfig: tlsConfig,
+	}
+
+	// The server is reported as not ready as soon as the stop signal is
+	// received, but keeps serving requests during the drain delay. Then it
+	// stops accepting connections and waits for the requests in progress:
+	serveErr := signals.Serve(stopCh, httpServer, cfg.Shutdown.ServeConfig())
+	if serveErr != nil {
+		logging.Errorf("Can't serve: %v", serveErr)
+	}
+
+	// Close the stores and the database and export the pending spans. The
+	// hooks log their own failures:
+	hooks.Run()
+	if serveErr != nil {
+		os.Exit(1)
+	}
+	logging.Infof("Stopped.")
+}
+
+// tracingShutdownTimeout is the maximum time spent exporting the pending spans
+// when the server stops.
+const tracingShutdownTimeout = 5 * time.Second
+
+// addAPIRoutes adds the routes of the API to the given router, that is
+// expected to have the prefix of the API and the authentication middleware.
+func (server *Server) addAPIRoutes(router *mux.Router) {
+	router.Handle("/customers", server.authorize("customers:list", server.getCustomersList)).Methods("GET")
+	router.Handle("/customers", server.authorize("customers:create", server.addCustomer)).Methods("POST")
+	router.Handle("/customers/{id}", server.authorize("customers:get", server.getCustomerByID)).Methods("GET")
+	router.Handle("/customers/{id}/owned_clusters", server.authorize("owned_clusters:create", server.addOwnedCluster)).Methods("POST")
+	router.Handle("/customers/{id}/quota", server.authorize("quotas:get", server.getQuota)).Methods("GET")
+	router.Handle("/customers/{id}/quota", server.authorize("quotas:update", server.setQuota)).Methods("PUT")
+	router.Path("/customers").
 		Queries("page", "{[0-9]+}", "size", "{[0-9]+}").
 		Methods("GET").
-		HandlerFunc(server.getCustomersList)
-
-	// Enable the access log:
-	loggedRouter := handlers.LoggingHandler(os.Stdout, mainRouter)
+		Handler(server.authorize("customers:list", server.getCustomersList))
+	router.Handle("/organizations", server.authorize("organizations:list", server.getOrganizationsList)).Methods("GET")
+	router.Handle("/organizations", server.authorize("organizations:create", server.addOrganization)).Methods("POST")
+	router.Handle("/organizations/{id}", server.authorize("organizations:get", server.getOrganizationByID)).Methods("GET")
+	router.Handle("/organizations/{id}/members", server.authorize("members:list", server.getMembersList)).Methods("GET")
+	router.Handle("/organizations/{id}/members", server.authorize("members:invite", server.inviteMember)).Methods("POST")
+	router.Handle("/organizations/{id}/members/{user_id}", server.authorize("members:remove", server.removeMember)).Methods("DELETE")
+	router.Handle("/users/{id}", server.authorize("users:get", server.getUserByID)).Methods("GET")
+	router.Handle("/service_accounts", server.authorize("service_accounts:list", server.getServiceAccountsList)).Methods("GET")
+	router.Handle("/service_accounts", server.authorize("service_accounts:create", server.addServiceAccount)).Methods("POST")
+	router.Handle("/service_accounts/{id}", server.authorize("service_accounts:get", server.getServiceAccountByID)).Methods("GET")
+	router.Handle("/service_accounts/{id}/rotate", server.authorize("service_accounts:rotate", server.rotateServiceAccountKey)).Methods("POST")
+	router.Handle("/service_accounts/{id}/revoke", server.authorize("service_accounts:revoke", server.revokeServiceAccount)).Methods("POST")
+	router.HandleFunc("/identity", server.getIdentity).Methods("GET")
+	levelHandler := logging.LevelHandler(logging.Default())
+	router.Handle("/log_level", server.authorize("log_level:get", levelHandler.ServeHTTP)).Methods("GET")
+	router.Handle("/log_level", server.authorize("log_level:update", levelHandler.ServeHTTP)).Methods("PUT")
+}
 
-	log.Fatal(http.ListenAndServe(serverAddress, loggedRouter))
+// authorize wraps the handler so that it is called only if the caller can
+// perform the given action.
+func (server *Server) authorize(action string, handler http.HandlerFunc) http.Handler {
+	return authz.NewHandler(server.policy, action, handler)
 }
 
 // Close server
 func (server *Server) Close() {
 	server.service.Close()
+	server.organizations.Close()
+	server.quotas.Close()
+	server.serviceAccounts.Close()
 }
@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+)
+
+// ServiceAccount struct is the internal object representing a non human
+// principal, used by automation, that authenticates with an API key. Only the
+// hash of the key is stored, the key itself is returned only when the
+// account is created and when the key is rotated.
+type ServiceAccount struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	OrganizationID string `json:"organization_id"`
+
+	// Roles are the roles of the authorization policy given to the account.
+	Roles []string `json:"roles"`
+
+	// Scopes restricts the actions allowed by the roles. If it is empty the
+	// account can perform all the actions allowed by its roles.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// KeyID is the public identifier of the current API key, which is also
+	// part of the key.
+	KeyID string `json:"key_id"`
+
+	// APIKey is the current API key, only present in the responses to the
+	// requests that create the account or rotate the key.
+	APIKey string `json:"api_key,omitempty"`
+
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  time.Time  `json:"rotated_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// keyHash is the hash of the current API key.
+	keyHash string
+}
+
+// ServiceAccountsList struct is the internal object representing a list of
+// ServiceAccounts.
+type ServiceAccountsList struct {
+	Page  int64             `json:"page"`
+	Size  int64             `json:"size"`
+	Total int64             `json:"total"`
+	Items []*ServiceAccount `json:"items"`
+}
+
+// ServiceAccountRevokedError is returned when trying to rotate the key of a
+// service account that has been revoked.
+type ServiceAccountRevokedError struct {
+	ID string
+}
+
+func (e *ServiceAccountRevokedError) Error() string {
+	return fmt.Sprintf("service account '%s' has been revoked", e.ID)
+}
+
+// Validate checks that the service account has all the mandatory fields and
+// that the scopes are well formed.
+func (account *ServiceAccount) Validate() error {
+	if account.Name == "" {
+		return &ValidationError{Field: "name", Reason: "name is mandatory"}
+	}
+	if account.OrganizationID == "" {
+		return &ValidationError{Field: "organization_id", Reason: "organization is mandatory"}
+	}
+	if len(account.Roles) == 0 {
+		return &ValidationError{Field: "roles", Reason: "at least one role is mandatory"}
+	}
+	for _, scope := range account.Scopes {
+		if scope != "*" && len(strings.Split(scope, ":")) != 2 {
+			return &ValidationError{
+				Field:  "scopes",
+				Reason: fmt.Sprintf("scope '%s' doesn't have the form 'resource:verb'", scope),
+			}
+		}
+	}
+	return nil
+}
+
+// newServiceAccount prepares a service account to be stored by the service
+// accounts service: it fills the identifier and the timestamps, generates
+// the API key, and then validates the result.
+func newServiceAccount(id string, account ServiceAccount) (*ServiceAccount, error) {
+	now := time.Now().UTC()
+	result := account
+	result.ID = id
+	result.CreatedAt = now
+	result.LastUsedAt = nil
+	result.RevokedAt = nil
+	if len(result.Scopes) == 0 {
+		result.Scopes = nil
+	}
+	err := result.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = result.generateKey(now, result.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// generateKey replaces the API key of the service account with a new one
+// that expires at the given time, or never if it is nil.
+func (account *ServiceAccount) generateKey(now time.Time, expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(now) {
+		return &ValidationError{Field: "expires_at", Reason: "expiration time must be in the future"}
+	}
+	id, key, err := auth.NewAPIKey()
+	if err != nil {
+		return err
+	}
+	account.KeyID = id
+	account.APIKey = key
+	account.keyHash = auth.HashAPIKey(key)
+	account.ExpiresAt = expiresAt
+	account.RotatedAt = now
+	return nil
+}
+
+// serviceAccountSubject returns the subject of the identity of the service
+// account with the given identifier.
+func serviceAccountSubject(id string) string {
+	return "service-account:" + id
+}
@@ -0,0 +1,264 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/gorilla/mux"
+)
+
+// fakeServiceAccountsService is an in-memory service accounts service used
+// by the tests of the handlers.
+type fakeServiceAccountsService struct {
+	accounts map[string]*ServiceAccount
+}
+
+func newFakeServiceAccountsService() *fakeServiceAccountsService {
+	return &fakeServiceAccountsService{accounts: make(map[string]*ServiceAccount)}
+}
+
+func (s *fakeServiceAccountsService) ListServiceAccounts(ctx context.Context, organizationID string,
+	args *ListArguments) (*ServiceAccountsList, error) {
+	items := make([]*ServiceAccount, 0)
+	for _, account := range s.accounts {
+		if organizationID == "" || account.OrganizationID == organizationID {
+			items = append(items, account)
+		}
+	}
+	return &ServiceAccountsList{Size: int64(len(items)), Total: int64(len(items)), Items: items}, nil
+}
+
+func (s *fakeServiceAccountsService) AddServiceAccount(ctx context.Context,
+	account ServiceAccount) (*ServiceAccount, error) {
+	result, err := newServiceAccount(account.Name, account)
+	if err != nil {
+		return nil, err
+	}
+	s.accounts[result.ID] = result
+	return result, nil
+}
+
+func (s *fakeServiceAccountsService) GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	return s.accounts[id], nil
+}
+
+func (s *fakeServiceAccountsService) GetServiceAccountByKey(ctx context.Context,
+	keyID string) (*ServiceAccount, error) {
+	for _, account := range s.accounts {
+		if account.KeyID == keyID {
+			return account, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeServiceAccountsService) RotateServiceAccountKey(ctx context.Context, id string,
+	expiresAt *time.Time) (*ServiceAccount, error) {
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, &NotFoundError{Kind: "service account", ID: id}
+	}
+	err := account.generateKey(time.Now().UTC(), expiresAt)
+	return account, err
+}
+
+func (s *fakeServiceAccountsService) RevokeServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, &NotFoundError{Kind: "service account", ID: id}
+	}
+	now := time.Now().UTC()
+	account.RevokedAt = &now
+	return account, nil
+}
+
+func (s *fakeServiceAccountsService) TouchServiceAccountKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	return nil
+}
+
+func (s *fakeServiceAccountsService) Close() {
+}
+
+func TestValidateServiceAccount(t *testing.T) {
+	tests := []struct {
+		account ServiceAccount
+		field   string
+	}{
+		{ServiceAccount{Name: "ci", OrganizationID: "org-1", Roles: []string{"customer"}}, ""},
+		{ServiceAccount{Name: "ci", OrganizationID: "org-1", Roles: []string{"customer"},
+			Scopes: []string{"clusters:list", "*"}}, ""},
+		{ServiceAccount{OrganizationID: "org-1", Roles: []string{"customer"}}, "name"},
+		{ServiceAccount{Name: "ci", Roles: []string{"customer"}}, "organization_id"},
+		{ServiceAccount{Name: "ci", OrganizationID: "org-1"}, "roles"},
+		{ServiceAccount{Name: "ci", OrganizationID: "org-1", Roles: []string{"customer"},
+			Scopes: []string{"clusters"}}, "scopes"},
+	}
+	for _, test := range tests {
+		err := test.account.Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("expected service account %+v to be valid, got %v", test.account, err)
+			}
+			continue
+		}
+		validationErr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("expected validation error for service account %+v, got %v", test.account, err)
+			continue
+		}
+		if validationErr.Field != test.field {
+			t.Errorf("expected invalid field to be %s instead it was %s", test.field, validationErr.Field)
+		}
+	}
+}
+
+func TestNewServiceAccountGeneratesKey(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	_, err := newServiceAccount("id", ServiceAccount{Name: "ci", OrganizationID: "org-1",
+		Roles: []string{"customer"}, ExpiresAt: &past})
+	if validationErr, ok := err.(*ValidationError); !ok || validationErr.Field != "expires_at" {
+		t.Errorf("expected expiration in the past to be rejected, got %v", err)
+	}
+
+	result, err := newServiceAccount("id", ServiceAccount{Name: "ci", OrganizationID: "org-1",
+		Roles: []string{"customer"}, Scopes: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := auth.ParseAPIKey(result.APIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != result.KeyID {
+		t.Errorf("expected key identifier '%s', got '%s'", result.KeyID, keyID)
+	}
+	if result.keyHash != auth.HashAPIKey(result.APIKey) {
+		t.Errorf("expected the hash of the key to be stored")
+	}
+	if result.Scopes != nil {
+		t.Errorf("expected empty scopes to be unrestricted, got %v", result.Scopes)
+	}
+}
+
+func TestServiceAccountKeyStore(t *testing.T) {
+	policy, err := authz.LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := newFakeServiceAccountsService()
+	account, err := service.AddServiceAccount(context.Background(), ServiceAccount{Name: "ci", OrganizationID: "org-1",
+		Roles: []string{"customer"}, Scopes: []string{"clusters:list"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := auth.NewAPIKeyVerifier(&serviceAccountKeyStore{service: service, policy: policy})
+	identity, err := verifier.Verify(context.Background(), account.APIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := policy.Authorize(identity, "clusters:list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.All() || !access.Allows("org-1") {
+		t.Errorf("expected access restricted to 'org-1'")
+	}
+	_, err = policy.Authorize(identity, "clusters:create")
+	if _, ok := err.(*authz.ForbiddenError); !ok {
+		t.Errorf("expected action outside the scopes to be forbidden, got %v", err)
+	}
+
+	_, err = service.RevokeServiceAccount(context.Background(), account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifier.Verify(context.Background(), account.APIKey)
+	if _, ok := err.(*auth.TokenError); !ok {
+		t.Errorf("expected revoked key to be rejected, got %v", err)
+	}
+}
+
+func TestAddServiceAccountChecksGrantedRoles(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.serviceAccounts = newFakeServiceAccountsService()
+
+	body := `{"name": "ci", "roles": ["admin"]}`
+	request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/service_accounts", strings.NewReader(body))
+	recorder := serveAs(server, "service_accounts:create", server.addServiceAccount, "customer", "org-1", request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 when granting a role the caller doesn't have, got %d", recorder.Code)
+	}
+
+	body = `{"name": "ci", "roles": ["customer"]}`
+	request = httptest.NewRequest("POST", "/api/customers_mgmt/v1/service_accounts", strings.NewReader(body))
+	recorder = serveAs(server, "service_accounts:create", server.addServiceAccount, "customer", "org-1", request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", recorder.Code)
+	}
+	var account ServiceAccount
+	err := json.Unmarshal(recorder.Body.Bytes(), &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OrganizationID != "org-1" {
+		t.Errorf("expected the account to belong to 'org-1', got '%s'", account.OrganizationID)
+	}
+	if account.APIKey == "" {
+		t.Errorf("expected the API key to be returned")
+	}
+}
+
+func TestRotateServiceAccountKeyScopedToOrganization(t *testing.T) {
+	server, _ := newTestServer(t)
+	service := newFakeServiceAccountsService()
+	server.serviceAccounts = service
+	admin, err := service.AddServiceAccount(context.Background(), ServiceAccount{Name: "admin", OrganizationID: "org-1",
+		Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := service.AddServiceAccount(context.Background(), ServiceAccount{Name: "other", OrganizationID: "org-2",
+		Roles: []string{"customer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		account *ServiceAccount
+		status  int
+	}{
+		{admin, http.StatusForbidden},
+		{other, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("POST", "/api/customers_mgmt/v1/service_accounts/"+test.account.ID+"/rotate", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": test.account.ID})
+		recorder := serveAs(server, "service_accounts:rotate", server.rotateServiceAccountKey, "customer", "org-1", request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d rotating '%s', got %d", test.status, test.account.Name, recorder.Code)
+		}
+	}
+}
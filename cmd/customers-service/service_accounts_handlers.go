@@ -0,0 +1,193 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+	"github.com/gorilla/mux"
+)
+
+// serviceAccountRotation contains the details needed to rotate the API key of
+// a service account.
+type serviceAccountRotation struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (server *Server) getServiceAccountsList(w http.ResponseWriter, r *http.Request) {
+	args, err := getListArguments(r)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error listing service accounts, %v", err)
+		return
+	}
+	organization := ""
+	access := authz.AccessFromContext(r.Context())
+	if !access.All() {
+		organization = access.Organization()
+	}
+	ret, err := server.serviceAccounts.ListServiceAccounts(r.Context(), organization, args)
+	if err != nil {
+		api.WriteErrorf(w, serviceAccountErrorCode(err), "Error listing service accounts, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewList(int(ret.Page), ret.Total, ret.Items))
+}
+
+func (server *Server) addServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var account ServiceAccount
+	err := api.DecodeJSON(w, r, &account)
+	if err != nil {
+		api.WriteErrorf(w, api.StatusOf(err), "Error decoding service account, %v", err)
+		return
+	}
+	if len(account.Scopes) == 0 {
+		account.Scopes = nil
+	}
+	access := authz.AccessFromContext(r.Context())
+	if !access.All() {
+		if account.OrganizationID == "" {
+			account.OrganizationID = access.Organization()
+		}
+		if !access.Allows(account.OrganizationID) {
+			writeNotFound(w, "Error adding service account", "organization", account.OrganizationID)
+			return
+		}
+	}
+	if !server.canGrant(w, r, "Error adding service account", "service_accounts:create", &account) {
+		return
+	}
+	ret, err := server.serviceAccounts.AddServiceAccount(r.Context(), account)
+	if err != nil {
+		api.WriteErrorf(w, serviceAccountErrorCode(err), "Error adding service account, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, ret)
+}
+
+func (server *Server) getServiceAccountByID(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	ret, ok := server.findServiceAccount(w, r, "Error getting service account", id)
+	if !ok {
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (server *Server) rotateServiceAccountKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account, ok := server.findServiceAccount(w, r, "Error rotating service account key", id)
+	if !ok {
+		return
+	}
+	// The new key has the roles of the account, so the caller must be
+	// allowed to grant them:
+	if !server.canGrant(w, r, "Error rotating service account key", "service_accounts:rotate", account) {
+		return
+	}
+	// The body is optional, without it the new key never expires:
+	var rotation serviceAccountRotation
+	if api.HasBody(r) {
+		err := api.DecodeJSON(w, r, &rotation)
+		if err != nil {
+			api.WriteErrorf(w, api.StatusOf(err), "Error decoding rotation, %v", err)
+			return
+		}
+	}
+	ret, err := server.serviceAccounts.RotateServiceAccountKey(r.Context(), id, rotation.ExpiresAt)
+	if err != nil {
+		api.WriteErrorf(w, serviceAccountErrorCode(err), "Error rotating service account key, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (server *Server) revokeServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	_, ok := server.findServiceAccount(w, r, "Error revoking service account", id)
+	if !ok {
+		return
+	}
+	ret, err := server.serviceAccounts.RevokeServiceAccount(r.Context(), id)
+	if err != nil {
+		api.WriteErrorf(w, serviceAccountErrorCode(err), "Error revoking service account, %v", err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, ret)
+}
+
+// getIdentity writes the identity of the caller. Other services use it to
+// verify the API keys, as only this service can access them.
+func (server *Server) getIdentity(w http.ResponseWriter, r *http.Request) {
+	identity := auth.IdentityFromContext(r.Context())
+	if identity == nil {
+		api.WriteErrorf(w, http.StatusUnauthorized, "Error getting identity, the request isn't authenticated")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, identity)
+}
+
+// findServiceAccount retrieves the service account, and writes the error
+// response if it doesn't exist or if it belongs to an organization that the
+// caller can't access.
+func (server *Server) findServiceAccount(w http.ResponseWriter, r *http.Request, message string,
+	id string) (*ServiceAccount, bool) {
+	account, err := server.serviceAccounts.GetServiceAccount(r.Context(), id)
+	if err != nil {
+		api.WriteErrorf(w, http.StatusInternalServerError, "%s, %v", message, err)
+		return nil, false
+	}
+	if account == nil || !authz.AccessFromContext(r.Context()).Allows(account.OrganizationID) {
+		writeNotFound(w, message, "service account", id)
+		return nil, false
+	}
+	return account, true
+}
+
+// canGrant checks that the caller can give the roles and scopes of the
+// service account, and writes the error response if it can't.
+func (server *Server) canGrant(w http.ResponseWriter, r *http.Request, message string, action string,
+	account *ServiceAccount) bool {
+	if server.policy == nil {
+		return true
+	}
+	identity := auth.IdentityFromContext(r.Context())
+	err := server.policy.CheckGrant(identity, action, account.Roles, account.Scopes)
+	if err != nil {
+		api.WriteErrorf(w, http.StatusForbidden, "%s, %v", message, err)
+		return false
+	}
+	return true
+}
+
+// serviceAccountErrorCode returns the HTTP status code that corresponds to an
+// error returned by the service accounts service.
+func serviceAccountErrorCode(err error) int {
+	switch err.(type) {
+	case *ValidationError:
+		return http.StatusBadRequest
+	case *NotFoundError:
+		return http.StatusNotFound
+	case *ServiceAccountRevokedError:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/container-mgmt/dedicated-portal/pkg/authz"
+)
+
+// ServiceAccountsService is an interface exposing the operations needed to
+// manage the service accounts and their API keys.
+type ServiceAccountsService interface {
+	// The operations are canceled, and their spans are part of the trace, of
+	// the given context.
+
+	// ListServiceAccounts returns a page of the service accounts of the
+	// organization, or of all the organizations if the organization is
+	// empty. If nil arguments are supplied it returns all the accounts.
+	ListServiceAccounts(ctx context.Context, organizationID string, args *ListArguments) (*ServiceAccountsList, error)
+
+	// AddServiceAccount creates a service account with the details of the
+	// supplied one and a new API key, and returns the newly created account,
+	// including the key.
+	AddServiceAccount(ctx context.Context, account ServiceAccount) (*ServiceAccount, error)
+
+	// GetServiceAccount returns a pointer to the service account with the
+	// supplied id. If no such account exist it returns nil pointer and nil
+	// error.
+	GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error)
+
+	// GetServiceAccountByKey returns a pointer to the service account whose
+	// current API key has the supplied identifier. If no such account exist
+	// it returns nil pointer and nil error.
+	GetServiceAccountByKey(ctx context.Context, keyID string) (*ServiceAccount, error)
+
+	// RotateServiceAccountKey replaces the API key of the service account
+	// with a new one that expires at the given time, or never if it is nil.
+	// The previous key stops working immediately. It returns the account,
+	// including the new key, NotFoundError if the account doesn't exist, and
+	// ServiceAccountRevokedError if it has been revoked.
+	RotateServiceAccountKey(ctx context.Context, id string, expiresAt *time.Time) (*ServiceAccount, error)
+
+	// RevokeServiceAccount revokes the service account, so that its API key
+	// stops working. Revoking an account that is already revoked has no
+	// effect. It returns NotFoundError if the account doesn't exist.
+	RevokeServiceAccount(ctx context.Context, id string) (*ServiceAccount, error)
+
+	// TouchServiceAccountKey records the time when the API key with the
+	// given identifier was used.
+	TouchServiceAccountKey(ctx context.Context, keyID string, usedAt time.Time) error
+
+	// Close closes the service.
+	Close()
+}
+
+// serviceAccountKeyStore adapts a service accounts service to the store of
+// API keys used to authenticate requests. The identity of each account
+// contains its roles and organization in the claims that the authorization
+// policy expects.
+type serviceAccountKeyStore struct {
+	service ServiceAccountsService
+	policy  *authz.Policy
+}
+
+// FindAPIKey returns the API key with the given identifier, or nil if no
+// service account has it.
+func (s *serviceAccountKeyStore) FindAPIKey(ctx context.Context, id string) (*auth.APIKey, error) {
+	account, err := s.service.GetServiceAccountByKey(ctx, id)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	roles := make([]interface{}, len(account.Roles))
+	for i, role := range account.Roles {
+		roles[i] = role
+	}
+	subject := serviceAccountSubject(account.ID)
+	return &auth.APIKey{
+		ID:        account.KeyID,
+		Hash:      account.keyHash,
+		ExpiresAt: account.ExpiresAt,
+		Revoked:   account.RevokedAt != nil,
+		Identity: &auth.Identity{
+			Subject: subject,
+			Claims: map[string]interface{}{
+				"sub":                      subject,
+				s.policy.RoleClaim:         roles,
+				s.policy.OrganizationClaim: account.OrganizationID,
+			},
+			Scopes: account.Scopes,
+		},
+	}, nil
+}
+
+// TouchAPIKey records the time when the API key was used.
+func (s *serviceAccountKeyStore) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return s.service.TouchServiceAccountKey(ctx, id, usedAt)
+}
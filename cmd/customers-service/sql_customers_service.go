@@ -17,11 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	_ "github.com/lib/pq"
+	"time"
+
+	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
-	"strings"
 )
 
 // SQLCustomersService is a struct implementing the customer service interface,
@@ -33,95 +34,303 @@ type SQLCustomersService struct {
 const defaultLimit = 1000
 
 // NewSQLCustomersService is a constructor for the SQLCustomersService struct.
-func NewSQLCustomersService(connStr string) (*SQLCustomersService, error) {
-	db, err := sql.Open("postgres", connStr)
-
-	if err != nil {
-		return nil, err
-	}
-
+// The service uses the given connection pool, but doesn't close it.
+func NewSQLCustomersService(db *sql.DB) *SQLCustomersService {
 	service := new(SQLCustomersService)
 	service.db = db
-	return service, nil
+	return service
 }
 
-// Close closes the etcd customers service client.
+// Close does nothing, the connection pool is shared with the other services
+// and it is closed by the code that opened it.
 func (service *SQLCustomersService) Close() {
-	service.db.Close()
 }
 
 // Add adds a single customer to psql database.
-func (service *SQLCustomersService) Add(customer Customer) (*Customer, error) {
+func (service *SQLCustomersService) Add(ctx context.Context, customer Customer) (*Customer, error) {
 	id, err := ksuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
 
-	result := Customer{
-		ID:   id.String(),
-		Name: customer.Name,
+	result, err := newCustomer(id.String(), customer)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	if customer.OwnedClusters == nil {
-		result.OwnedClusters = make([]string, 0)
-	} else {
-		result.OwnedClusters = customer.OwnedClusters
+	// Check that the email and the clusters aren't used by other customer, so
+	// that we can return a meaningful error. The unique constraints of the
+	// database are still the final guard against concurrent additions.
+	err = checkCustomerConflicts(ctx, tx, result)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = service.db.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		insert into customers (
-		id,
-		name
+			id,
+			name,
+			email,
+			organization_name,
+			contact_phone,
+			billing_account_id,
+			status,
+			created_at,
+			updated_at
 		) values (
 			$1,
-			$2
+			$2,
+			$3,
+			$4,
+			$5,
+			$6,
+			$7,
+			$8,
+			$9
 		)`,
 		result.ID,
-		result.Name)
+		result.Name,
+		result.Email,
+		result.OrganizationName,
+		result.ContactPhone,
+		result.BillingAccountID,
+		result.Status,
+		result.CreatedAt,
+		result.UpdatedAt)
+	if isUniqueViolation(err) {
+		return nil, &DuplicateEmailError{Email: result.Email}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertOwnedClusters(ctx, tx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Upsert creates or replaces a single customer in the psql database.
+func (service *SQLCustomersService) Upsert(ctx context.Context, customer Customer) (*Customer, error) {
+	if customer.ID == "" {
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		customer.ID = id.String()
+	}
+
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the existing customer, if any, so that concurrent replacements
+	// are serialized:
+	var existing *Customer
+	var current Customer
+	err = scanCustomer(tx.QueryRowContext(ctx, `select `+customerColumns+` from customers
+		where id=$1
+		for update`,
+		customer.ID), &current)
+	switch {
+	case err == nil:
+		existing = &current
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	result, err := replacementCustomer(customer, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkCustomerConflicts(ctx, tx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into customers (
+			id,
+			name,
+			email,
+			organization_name,
+			contact_phone,
+			billing_account_id,
+			status,
+			created_at,
+			updated_at
+		) values (
+			$1,
+			$2,
+			$3,
+			$4,
+			$5,
+			$6,
+			$7,
+			$8,
+			$9
+		)
+		on conflict (id) do update set
+			name = excluded.name,
+			email = excluded.email,
+			organization_name = excluded.organization_name,
+			contact_phone = excluded.contact_phone,
+			billing_account_id = excluded.billing_account_id,
+			status = excluded.status,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at`,
+		result.ID,
+		result.Name,
+		result.Email,
+		result.OrganizationName,
+		result.ContactPhone,
+		result.BillingAccountID,
+		result.Status,
+		result.CreatedAt,
+		result.UpdatedAt)
+	if isUniqueViolation(err) {
+		return nil, &DuplicateEmailError{Email: result.Email}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertOwnedClusters(ctx, tx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddOwnedClusters adds clusters to the clusters owned by a customer stored
+// in the psql database.
+func (service *SQLCustomersService) AddOwnedClusters(ctx context.Context, id string,
+	clusterIDs []string) (*Customer, error) {
+	for _, clusterID := range clusterIDs {
+		if clusterID == "" {
+			return nil, &ValidationError{Field: "cluster_id", Reason: "cluster identifier is empty"}
+		}
+	}
+
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the customer, so that the check of the owners and the inserts
+	// aren't interleaved with a replacement of the customer:
+	var customer Customer
+	err = scanCustomer(tx.QueryRowContext(ctx, `select `+customerColumns+` from customers
+		where id=$1
+		for update`,
+		id), &customer)
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Kind: "customer", ID: id}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	for _, cluster := range result.OwnedClusters {
-		_, err = service.db.Exec(`
+	// Check all the clusters before inserting any of them, so that either
+	// all of them are added or none is:
+	var owned ClusterOwnedError
+	err = tx.QueryRowContext(ctx, `select cluster_id, customer_id from owned_clusters
+		where cluster_id = any($1) and customer_id<>$2
+		limit 1`,
+		pq.Array(clusterIDs), id).Scan(&owned.ClusterID, &owned.CustomerID)
+	if err == nil {
+		return nil, &owned
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	added := false
+	for _, clusterID := range clusterIDs {
+		var result sql.Result
+		result, err = tx.ExecContext(ctx, `
 			insert into owned_clusters (
 				customer_id,
 				cluster_id
-			) values (
+			) select
 				$1,
 				$2
+			where not exists (
+				select 1 from owned_clusters where cluster_id=$2
 			)`,
-			result.ID,
-			cluster)
+			id,
+			clusterID)
+		if isUniqueViolation(err) {
+			// Added concurrently by other customer after the check.
+			return nil, &ClusterOwnedError{ClusterID: clusterID}
+		}
 		if err != nil {
 			return nil, err
 		}
+		var count int64
+		count, err = result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		added = added || count > 0
 	}
-	return &result, nil
+	if added {
+		_, err = tx.ExecContext(ctx, `update customers set updated_at=$2 where id=$1`,
+			id, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return service.Get(ctx, id)
 }
 
 // Get retrieves a single customer from psql database.
-func (service *SQLCustomersService) Get(id string) (*Customer, error) {
+func (service *SQLCustomersService) Get(ctx context.Context, id string) (*Customer, error) {
 	var result Customer
 
 	// Get the customer information
 	// If not customer found return nil pointer and nil error.
 	// (See customers_service.go for more details)
-	rows, err := service.db.Query(`select name from customers where id=$1`, id)
-	if err != nil {
+	row := service.db.QueryRowContext(ctx, `select `+customerColumns+` from customers
+		where id=$1`,
+		id)
+	err := scanCustomer(row, &result)
+	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-
-	for rows.Next() {
-		if err = rows.Scan(&result.Name); err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
 	}
-	rows.Close()
 
 	// Retrieve customer owned clusters.
 	ownedClusters := make([]string, 0)
-	rows, err = service.db.Query(`select cluster_id from owned_clusters
+	rows, err := service.db.QueryContext(ctx, `select cluster_id from owned_clusters
 		where customer_id=$1`,
 		id)
 	if err != nil {
@@ -141,38 +350,45 @@ func (service *SQLCustomersService) Get(id string) (*Customer, error) {
 }
 
 // List retrieves a list of current customers stored in datastore.
-func (service *SQLCustomersService) List(args *ListArguments) (*CustomersList, error) {
+func (service *SQLCustomersService) List(ctx context.Context, args *ListArguments) (*CustomersList, error) {
 	var result *CustomersList
 	var rows *sql.Rows
 	var err error
 	var page int64
 	var numOfItems int64
+	var filter *CustomerFilter
 
+	err = validateListArguments(args)
+	if err != nil {
+		return nil, err
+	}
+	page, numOfItems = pageAndSize(args)
 	if args != nil {
-		page = args.Page
-		numOfItems = args.Size
-	} else {
-		page = 0
-		numOfItems = defaultLimit
+		filter = args.Filter
 	}
 
-	// Retrieve customers id's and names.
-	rows, err = service.db.Query(`select id, name from customers
-		 limit $1 offset $2`,
-		numOfItems, numOfItems*page)
+	// Retrieve customers information.
+	where, whereArgs := filter.ToSQL(3)
+	queryArgs := append([]interface{}{numOfItems, numOfItems * page}, whereArgs...)
+	rows, err = service.db.QueryContext(ctx, `select `+customerColumns+` from customers
+		where `+where+`
+		order by created_at, id
+		limit $1 offset $2`,
+		queryArgs...)
 	if err != nil {
 		return nil, err
 	}
 
-	// Populate customers id's and names in their corresponding customers struct.
+	// Populate customers information in their corresponding customers struct.
 	items := make([]*Customer, 0, numOfItems)
 	ids := make([]string, 0, numOfItems)
 	for rows.Next() {
 		var customer Customer
-		if err = rows.Scan(&customer.ID, &customer.Name); err != nil {
+		if err = scanCustomer(rows, &customer); err != nil {
 			return nil, err
 		}
-		// Populate items with customer id and customer names.
+		// Populate items with customer information.
+		customer.OwnedClusters = make([]string, 0)
 		items = append(items, &customer)
 		// Keep id's to query for owned_clusters.
 		ids = append(ids, customer.ID)
@@ -180,20 +396,13 @@ func (service *SQLCustomersService) List(args *ListArguments) (*CustomersList, e
 	rows.Close()
 
 	if len(ids) > 0 {
-		qoutedIds := make([]string, len(ids))
-		for i, id := range ids {
-			qoutedIds[i] = fmt.Sprintf(`'%s'`, id)
-		}
-		idSet := strings.Join(qoutedIds, ", ")
-		query := fmt.Sprintf(`
-		select customer_id, cluster_id
-		from owned_clusters
-		where customer_id in (%s)`,
-			idSet)
-
 		// Retrieve customers owned clusters.
 		customersToClusters := make(map[string][]string)
-		rows, err = service.db.Query(query)
+		rows, err = service.db.QueryContext(ctx, `
+		select customer_id, cluster_id
+		from owned_clusters
+		where customer_id = any($1)`,
+			pq.Array(ids))
 		if err != nil {
 			return nil, err
 		}
@@ -209,13 +418,13 @@ func (service *SQLCustomersService) List(args *ListArguments) (*CustomersList, e
 
 		// Populate customers owned clusters
 		for _, customer := range items {
-			if customer != nil {
-				customer.OwnedClusters = (customersToClusters[customer.ID])
+			if clusters, ok := customersToClusters[customer.ID]; ok {
+				customer.OwnedClusters = clusters
 			}
 		}
 	}
 
-	total, err := service.getCustomersCount()
+	total, err := service.getCustomersCount(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -230,12 +439,107 @@ func (service *SQLCustomersService) List(args *ListArguments) (*CustomersList, e
 	return result, nil
 }
 
-func (service *SQLCustomersService) getCustomersCount() (int64, error) {
-	// retrieve total number of customers.
+func (service *SQLCustomersService) getCustomersCount(ctx context.Context, filter *CustomerFilter) (int64, error) {
+	// retrieve total number of customers matching the filter.
 	var total int64
-	err := service.db.QueryRow("select  count(*) from customers").Scan(&total)
+	where, whereArgs := filter.ToSQL(1)
+	err := service.db.QueryRowContext(ctx, "select count(*) from customers where "+where,
+		whereArgs...).Scan(&total)
 	if err != nil {
 		return 0, err
 	}
 	return total, nil
 }
+
+// checkCustomerConflicts checks that the email and the clusters of the given
+// customer aren't used by other customer.
+func checkCustomerConflicts(ctx context.Context, tx *sql.Tx, customer *Customer) error {
+	var count int64
+	err := tx.QueryRowContext(ctx, `select count(*) from customers
+		where lower(email)=lower($1) and id<>$2`,
+		customer.Email, customer.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &DuplicateEmailError{Email: customer.Email}
+	}
+
+	if len(customer.OwnedClusters) == 0 {
+		return nil
+	}
+	var owned ClusterOwnedError
+	err = tx.QueryRowContext(ctx, `select cluster_id, customer_id from owned_clusters
+		where cluster_id = any($1) and customer_id<>$2
+		limit 1`,
+		pq.Array(customer.OwnedClusters), customer.ID).Scan(&owned.ClusterID, &owned.CustomerID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &owned
+}
+
+// insertOwnedClusters replaces the clusters owned by the given customer.
+func insertOwnedClusters(ctx context.Context, tx *sql.Tx, customer *Customer) error {
+	_, err := tx.ExecContext(ctx, `delete from owned_clusters where customer_id=$1`, customer.ID)
+	if err != nil {
+		return err
+	}
+	for _, cluster := range customer.OwnedClusters {
+		_, err = tx.ExecContext(ctx, `
+			insert into owned_clusters (
+				customer_id,
+				cluster_id
+			) values (
+				$1,
+				$2
+			)`,
+			customer.ID,
+			cluster)
+		if isUniqueViolation(err) {
+			// Added concurrently by other customer after the check.
+			return &ClusterOwnedError{ClusterID: cluster}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// customerColumns are the columns of the customers table, in the order
+// expected by the scanCustomer function.
+const customerColumns = `id, name, email, organization_name, contact_phone,
+	billing_account_id, status, created_at, updated_at`
+
+// rowScanner is the part of the sql.Row and sql.Rows types used to read the
+// values of a single row.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCustomer reads the columns listed in customerColumns into the given
+// customer.
+func scanCustomer(row rowScanner, customer *Customer) error {
+	return row.Scan(
+		&customer.ID,
+		&customer.Name,
+		&customer.Email,
+		&customer.OrganizationName,
+		&customer.ContactPhone,
+		&customer.BillingAccountID,
+		&customer.Status,
+		&customer.CreatedAt,
+		&customer.UpdatedAt,
+	)
+}
+
+// isUniqueViolation checks if the given error was returned by the database
+// because an unique constraint was violated.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
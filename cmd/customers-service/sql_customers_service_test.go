@@ -17,36 +17,45 @@ limitations under the License.
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"testing"
-)
 
-var service *SQLCustomersService
-var localTesting = true
+	pgsql "github.com/container-mgmt/dedicated-portal/pkg/sql"
+)
 
-func TestMain(m *testing.M) {
-	var err error
-	if !localTesting {
-		os.Exit(0)
+// newSQLTestService creates a customers service connected to the database
+// given by the CUSTOMERS_SERVICE_TEST_SQL environment variable, and removes
+// all the existing customers. The test is skipped if the variable isn't set.
+func newSQLTestService(t *testing.T) *SQLCustomersService {
+	connStr := os.Getenv("CUSTOMERS_SERVICE_TEST_SQL")
+	if connStr == "" {
+		t.Skip("CUSTOMERS_SERVICE_TEST_SQL isn't set")
 	}
-	connStr := "host=localhost port=5432 user=postgres password=1234 dbname=customers sslmode=disable"
-	service, err = NewSQLCustomersService(connStr)
+	db, err := pgsql.Open(pgsql.ConnectionConfig{URL: connStr})
 	if err != nil {
-		fmt.Printf("An error occurred while trying to connect to database: %s\n", err)
+		t.Fatalf("An error occurred while trying to connect to database: %s", err)
 	}
-	defer deleteAll()
-	defer service.Close()
-	os.Exit(m.Run())
+	service := NewSQLCustomersService(db)
+	deleteAllSQL(service)
+	return service
+}
+
+func TestSQLCustomersServiceConformance(t *testing.T) {
+	runCustomersServiceConformance(t, func(t *testing.T) CustomersService {
+		return newSQLTestService(t)
+	})
 }
 
-func TestAdd(t *testing.T) {
-	deleteAll()
+func TestSQLAdd(t *testing.T) {
+	service := newSQLTestService(t)
+	defer service.Close()
 
 	customerToAdd := Customer{
-		Name: "test_customer",
+		Name:  "test_customer",
+		Email: "test_customer@example.com",
 	}
-	res, err := service.Add(customerToAdd)
+	res, err := service.Add(context.Background(), customerToAdd)
 	if err != nil {
 		t.Fatal(err)
 		t.Fail()
@@ -57,10 +66,37 @@ func TestAdd(t *testing.T) {
 	if len(res.OwnedClusters) != 0 {
 		t.Fail()
 	}
+	if res.Status != CustomerStatusActive {
+		t.Fatalf("expected customer status to be %s instead it was %s",
+			CustomerStatusActive, res.Status)
+	}
+	if res.CreatedAt.IsZero() || res.UpdatedAt.IsZero() {
+		t.Fatalf("expected customer timestamps to be set")
+	}
 }
 
-func TestGet(t *testing.T) {
-	deleteAll()
+func TestSQLAddDuplicateEmail(t *testing.T) {
+	service := newSQLTestService(t)
+	defer service.Close()
+
+	customerToAdd := Customer{
+		Name:  "test_customer",
+		Email: "test_customer@example.com",
+	}
+	_, err := service.Add(context.Background(), customerToAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerToAdd.Email = "Test_Customer@example.com"
+	_, err = service.Add(context.Background(), customerToAdd)
+	if _, ok := err.(*DuplicateEmailError); !ok {
+		t.Fatalf("expected a duplicate email error instead got %v", err)
+	}
+}
+
+func TestSQLGet(t *testing.T) {
+	service := newSQLTestService(t)
+	defer service.Close()
 
 	var err error
 	var customer *Customer
@@ -68,11 +104,12 @@ func TestGet(t *testing.T) {
 	expected := Customer{
 		ID:            "customer-fake-id",
 		Name:          "test_customer",
+		Email:         "test_customer@example.com",
 		OwnedClusters: []string{"fake-cluster-id0", "fake-cluster-id1"},
 	}
 
-	_, err = service.db.Exec("insert into customers (id, name) values ($1, $2)",
-		expected.ID, expected.Name)
+	_, err = service.db.Exec("insert into customers (id, name, email) values ($1, $2, $3)",
+		expected.ID, expected.Name, expected.Email)
 	if err != nil {
 		t.Fatal(err)
 		t.Fail()
@@ -90,7 +127,7 @@ func TestGet(t *testing.T) {
 		}
 	}
 
-	customer, err = service.Get(expected.ID)
+	customer, err = service.Get(context.Background(), expected.ID)
 	if err != nil {
 		t.Fatal(err)
 		t.Fail()
@@ -102,6 +139,11 @@ func TestGet(t *testing.T) {
 		t.Fail()
 	}
 
+	if customer.Email != expected.Email {
+		t.Fatalf("expected customer email to be: %s instead it was %s",
+			expected.Email, customer.Email)
+	}
+
 	if len(customer.OwnedClusters) != len(expected.OwnedClusters) {
 		t.Fatalf("expected customers number of clusters to be: %d instead it was %d",
 			len(customer.OwnedClusters), len(expected.OwnedClusters))
@@ -109,18 +151,21 @@ func TestGet(t *testing.T) {
 	}
 }
 
-func TestList(t *testing.T) {
-	deleteAll()
+func TestSQLList(t *testing.T) {
+	service := newSQLTestService(t)
+	defer service.Close()
 
 	items := []*Customer{
 		&Customer{
 			ID:            "test-id0",
 			Name:          "test-name0",
+			Email:         "test-name0@example.com",
 			OwnedClusters: []string{"test-cluster-id0", "test-cluster-id1"},
 		},
 		&Customer{
 			ID:            "test-id1",
 			Name:          "test-name1",
+			Email:         "test-name1@example.com",
 			OwnedClusters: []string{"test-cluster-id2"},
 		},
 	}
@@ -134,9 +179,9 @@ func TestList(t *testing.T) {
 	for _, item := range expected.Items {
 		_, err := service.db.Exec(`
 			insert into customers
-			 (id, name)
-			 values ($1, $2)`,
-			item.ID, item.Name)
+			 (id, name, email)
+			 values ($1, $2, $3)`,
+			item.ID, item.Name, item.Email)
 		if err != nil {
 			t.Fatal(err)
 			t.Fail()
@@ -155,7 +200,7 @@ func TestList(t *testing.T) {
 		}
 	}
 
-	result, err := service.List(nil)
+	result, err := service.List(context.Background(), nil)
 	if err != nil {
 		t.Fatal(err)
 		t.Fail()
@@ -168,7 +213,7 @@ func TestList(t *testing.T) {
 
 	args := &ListArguments{Page: 0, Size: 1}
 
-	result, err = service.List(args)
+	result, err = service.List(context.Background(), args)
 	if err != nil {
 		t.Fatal(err)
 		t.Fail()
@@ -188,7 +233,11 @@ func TestList(t *testing.T) {
 	}
 }
 
-func deleteAll() {
+func deleteAllSQL(service *SQLCustomersService) {
+	service.db.Exec("delete from memberships")
+	service.db.Exec("delete from users")
+	service.db.Exec("delete from organizations")
+	service.db.Exec("delete from quotas")
 	service.db.Exec("delete from owned_clusters")
 	service.db.Exec("delete from customers")
 }
@@ -0,0 +1,535 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// SQLOrganizationsService is a struct implementing the organizations service
+// interface, backed by an SQL database.
+type SQLOrganizationsService struct {
+	db        *sql.DB
+	customers CustomersService
+}
+
+// NewSQLOrganizationsService is a constructor for the SQLOrganizationsService
+// struct.
+// The service uses the given connection pool, but doesn't close it. The
+// customers of the organizations are retrieved from the given customers
+// service, so the organizations work with any of the datastores of the
+// customers.
+func NewSQLOrganizationsService(db *sql.DB, customers CustomersService) *SQLOrganizationsService {
+	service := new(SQLOrganizationsService)
+	service.db = db
+	service.customers = customers
+	return service
+}
+
+// Close does nothing, the connection pool is shared with the other services
+// and it is closed by the code that opened it.
+func (service *SQLOrganizationsService) Close() {
+}
+
+// AddOrganization adds a single organization to the database.
+func (service *SQLOrganizationsService) AddOrganization(ctx context.Context,
+	organization Organization) (*Organization, error) {
+	result, err := newOrganization(organization)
+	if err != nil {
+		return nil, err
+	}
+
+	customer, err := service.customers.Get(ctx, result.ID)
+	if err != nil {
+		return nil, err
+	}
+	if customer == nil {
+		return nil, &ValidationError{
+			Field:  "id",
+			Reason: fmt.Sprintf("customer '%s' doesn't exist", result.ID),
+		}
+	}
+
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		insert into organizations (
+			id,
+			name,
+			created_at,
+			updated_at
+		) values (
+			$1,
+			$2,
+			$3,
+			$4
+		)`,
+		result.ID,
+		result.Name,
+		result.CreatedAt,
+		result.UpdatedAt)
+	if isUniqueViolation(err) {
+		return nil, &OrganizationExistsError{ID: result.ID}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The clusters of the organization are the clusters owned by its
+	// customer, so they are added to the customer before committing. They
+	// are added all at once, so if one of them is owned by other customer
+	// neither the organization nor any of the clusters is added.
+	if len(result.OwnedClusters) > 0 {
+		customer, err = service.customers.AddOwnedClusters(ctx, result.ID, result.OwnedClusters)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.OwnedClusters = customer.OwnedClusters
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetOrganization retrieves a single organization from the database.
+func (service *SQLOrganizationsService) GetOrganization(ctx context.Context, id string) (*Organization, error) {
+	var result Organization
+	err := service.db.QueryRowContext(ctx, `select id, name, created_at, updated_at
+		from organizations
+		where id=$1`,
+		id).Scan(&result.ID, &result.Name, &result.CreatedAt, &result.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := service.getOwnedClusters(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	result.OwnedClusters = clusters[id]
+	if result.OwnedClusters == nil {
+		result.OwnedClusters = make([]string, 0)
+	}
+	return &result, nil
+}
+
+// ListOrganizations retrieves a page of the organizations stored in the
+// database.
+func (service *SQLOrganizationsService) ListOrganizations(ctx context.Context,
+	args *ListArguments) (*OrganizationsList, error) {
+	page, size := pageAndSize(args)
+
+	rows, err := service.db.QueryContext(ctx, `select id, name, created_at, updated_at
+		from organizations
+		order by created_at, id
+		limit $1 offset $2`,
+		size, size*page)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*Organization, 0, size)
+	ids := make([]string, 0, size)
+	for rows.Next() {
+		organization := new(Organization)
+		err = rows.Scan(
+			&organization.ID,
+			&organization.Name,
+			&organization.CreatedAt,
+			&organization.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, organization)
+		ids = append(ids, organization.ID)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := service.getOwnedClusters(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, organization := range items {
+		organization.OwnedClusters = clusters[organization.ID]
+		if organization.OwnedClusters == nil {
+			organization.OwnedClusters = make([]string, 0)
+		}
+	}
+
+	var total int64
+	err = service.db.QueryRowContext(ctx, `select count(*) from organizations`).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrganizationsList{
+		Page:  page,
+		Size:  int64(len(items)),
+		Total: total,
+		Items: items,
+	}, nil
+}
+
+// getOwnedClusters returns a map containing the identifiers of the clusters
+// owned by each of the given organizations, which are the clusters owned by
+// their customers. The customers are retrieved with a single list request.
+func (service *SQLOrganizationsService) getOwnedClusters(ctx context.Context,
+	ids []string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	if len(ids) == 0 {
+		return result, nil
+	}
+	var filter *CustomerFilter
+	customers, err := service.customers.List(ctx, &ListArguments{
+		Size:   int64(len(ids)),
+		Filter: filter.RestrictToIDs(ids),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, customer := range customers.Items {
+		result[customer.ID] = customer.OwnedClusters
+	}
+	return result, nil
+}
+
+// GetUser retrieves a single user from the database.
+func (service *SQLOrganizationsService) GetUser(ctx context.Context, id string) (*User, error) {
+	var result User
+	err := service.db.QueryRowContext(ctx, `select id, email, name, created_at
+		from users
+		where id=$1`,
+		id).Scan(&result.ID, &result.Email, &result.Name, &result.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// InviteMember adds an user to an organization, creating the user if it
+// doesn't exist yet.
+func (service *SQLOrganizationsService) InviteMember(ctx context.Context,
+	organizationID string, changer MemberChanger, invitation Invitation) (*Membership, error) {
+	err := invitation.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the organization, so that concurrent changes to the memberships
+	// can't leave it without owners:
+	err = lockOrganization(ctx, tx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findOrCreateUser(ctx, tx, invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the user is already a member then this invitation changes the role,
+	// so we need to check that the organization keeps at least one owner:
+	var currentRole string
+	err = tx.QueryRowContext(ctx, `select role from memberships
+		where organization_id=$1 and user_id=$2`,
+		organizationID, user.ID).Scan(&currentRole)
+	switch {
+	case err == sql.ErrNoRows:
+		currentRole = ""
+	case err != nil:
+		return nil, err
+	}
+	err = checkChangerRole(ctx, tx, organizationID, changer, currentRole, invitation.Role)
+	if err != nil {
+		return nil, err
+	}
+	if currentRole == MemberRoleOwner && invitation.Role != MemberRoleOwner {
+		err = checkOtherOwners(ctx, tx, organizationID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &Membership{
+		OrganizationID: organizationID,
+		User:           user,
+		Role:           invitation.Role,
+		CreatedAt:      time.Now().UTC(),
+	}
+	err = tx.QueryRowContext(ctx, `
+		insert into memberships (
+			organization_id,
+			user_id,
+			role,
+			created_at
+		) values (
+			$1,
+			$2,
+			$3,
+			$4
+		)
+		on conflict (organization_id, user_id) do update set role = excluded.role
+		returning created_at`,
+		result.OrganizationID,
+		result.User.ID,
+		result.Role,
+		result.CreatedAt).Scan(&result.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListMembers retrieves a page of the memberships of an organization.
+func (service *SQLOrganizationsService) ListMembers(ctx context.Context,
+	organizationID string, args *ListArguments) (*MembershipsList, error) {
+	page, size := pageAndSize(args)
+
+	organization, err := service.GetOrganization(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if organization == nil {
+		return nil, &NotFoundError{Kind: "organization", ID: organizationID}
+	}
+
+	rows, err := service.db.QueryContext(ctx, `select
+			m.role,
+			m.created_at,
+			u.id,
+			u.email,
+			u.name,
+			u.created_at
+		from memberships m
+		join users u on u.id = m.user_id
+		where m.organization_id=$1
+		order by m.created_at, u.id
+		limit $2 offset $3`,
+		organizationID, size, size*page)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*Membership, 0, size)
+	for rows.Next() {
+		membership := &Membership{
+			OrganizationID: organizationID,
+			User:           new(User),
+		}
+		err = rows.Scan(
+			&membership.Role,
+			&membership.CreatedAt,
+			&membership.User.ID,
+			&membership.User.Email,
+			&membership.User.Name,
+			&membership.User.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, membership)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	err = service.db.QueryRowContext(ctx, `select count(*) from memberships
+		where organization_id=$1`,
+		organizationID).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MembershipsList{
+		Page:  page,
+		Size:  int64(len(items)),
+		Total: total,
+		Items: items,
+	}, nil
+}
+
+// RemoveMember removes an user from an organization.
+func (service *SQLOrganizationsService) RemoveMember(ctx context.Context, organizationID string,
+	changer MemberChanger, userID string) error {
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockOrganization(ctx, tx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	var role string
+	err = tx.QueryRowContext(ctx, `select role from memberships
+		where organization_id=$1 and user_id=$2`,
+		organizationID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return &NotFoundError{Kind: "member", ID: userID}
+	}
+	if err != nil {
+		return err
+	}
+	err = checkChangerRole(ctx, tx, organizationID, changer, role, "")
+	if err != nil {
+		return err
+	}
+	if role == MemberRoleOwner {
+		err = checkOtherOwners(ctx, tx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from memberships
+		where organization_id=$1 and user_id=$2`,
+		organizationID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockOrganization locks the row of the organization till the end of the
+// transaction. It returns NotFoundError if the organization doesn't exist.
+func lockOrganization(ctx context.Context, tx *sql.Tx, organizationID string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `select id from organizations where id=$1 for update`,
+		organizationID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return &NotFoundError{Kind: "organization", ID: organizationID}
+	}
+	return err
+}
+
+// checkChangerRole returns MemberRoleError if the role of the changer in the
+// organization doesn't allow changing the role of a member from the current
+// role to the new one.
+func checkChangerRole(ctx context.Context, tx *sql.Tx, organizationID string, changer MemberChanger,
+	currentRole, newRole string) error {
+	var changerRole string
+	if !changer.Privileged {
+		err := tx.QueryRowContext(ctx, `select m.role from memberships m
+			join users u on u.id = m.user_id
+			where m.organization_id=$1 and lower(u.email)=lower($2)`,
+			organizationID, changer.Email).Scan(&changerRole)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return checkMemberChange(organizationID, changer, changerRole, currentRole, newRole)
+}
+
+// checkOtherOwners returns LastOwnerError if the organization doesn't have
+// owners other than the given user.
+func checkOtherOwners(ctx context.Context, tx *sql.Tx, organizationID string, userID string) error {
+	var owners int64
+	err := tx.QueryRowContext(ctx, `select count(*) from memberships
+		where organization_id=$1 and user_id<>$2 and role=$3`,
+		organizationID, userID, MemberRoleOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return &LastOwnerError{OrganizationID: organizationID}
+	}
+	return nil
+}
+
+// findOrCreateUser returns the user with the email of the invitation,
+// creating it if it doesn't exist.
+func findOrCreateUser(ctx context.Context, tx *sql.Tx, invitation Invitation) (*User, error) {
+	user := new(User)
+	err := tx.QueryRowContext(ctx, `select id, email, name, created_at
+		from users
+		where lower(email)=lower($1)`,
+		invitation.Email).Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
+	if err == nil {
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	user.ID = id.String()
+	user.Email = invitation.Email
+	user.Name = invitation.Name
+	user.CreatedAt = time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		insert into users (
+			id,
+			email,
+			name,
+			created_at
+		) values (
+			$1,
+			$2,
+			$3,
+			$4
+		)`,
+		user.ID,
+		user.Email,
+		user.Name,
+		user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
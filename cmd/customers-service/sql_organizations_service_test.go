@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSQLAddOrganizationWithOwnedCluster(t *testing.T) {
+	customers := newSQLTestService(t)
+	defer customers.Close()
+	service := NewSQLOrganizationsService(customers.db, customers)
+
+	first, err := customers.Add(context.Background(), Customer{Name: "first", Email: "first@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := customers.Add(context.Background(), Customer{
+		Name:          "second",
+		Email:         "second@example.com",
+		OwnedClusters: []string{"cluster"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = service.AddOrganization(context.Background(), Organization{
+		ID:            first.ID,
+		Name:          "Example Inc.",
+		OwnedClusters: []string{"free", "cluster"},
+	})
+	if _, ok := err.(*ClusterOwnedError); !ok {
+		t.Fatalf("expected cluster owned error, got %v", err)
+	}
+
+	// Neither the organization nor the first cluster are added:
+	organization, err := service.GetOrganization(context.Background(), first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if organization != nil {
+		t.Errorf("expected organization not to be added, got %+v", organization)
+	}
+	customer, err := customers.Get(context.Background(), first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsString(customer.OwnedClusters, "free") {
+		t.Errorf("expected cluster 'free' not to be owned, got %v", customer.OwnedClusters)
+	}
+	customer, err = customers.Get(context.Background(), second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(customer.OwnedClusters, "cluster") {
+		t.Errorf("expected cluster 'cluster' to stay owned by '%s', got %v", second.ID, customer.OwnedClusters)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SQLQuotasService is a struct implementing the quotas service interface,
+// backed by an SQL database.
+type SQLQuotasService struct {
+	db        *sql.DB
+	customers CustomersService
+}
+
+// NewSQLQuotasService is a constructor for the SQLQuotasService struct.
+// The service uses the given connection pool, but doesn't close it. The
+// customers are retrieved from the given customers service, so the quotas
+// work with any of the datastores of the customers.
+func NewSQLQuotasService(db *sql.DB, customers CustomersService) *SQLQuotasService {
+	service := new(SQLQuotasService)
+	service.db = db
+	service.customers = customers
+	return service
+}
+
+// Close does nothing, the connection pool is shared with the other services
+// and it is closed by the code that opened it.
+func (service *SQLQuotasService) Close() {
+}
+
+// GetQuota retrieves the quota of a customer from the database.
+func (service *SQLQuotasService) GetQuota(ctx context.Context, customerID string) (*Quota, error) {
+	exists, err := service.customerExists(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &NotFoundError{Kind: "customer", ID: customerID}
+	}
+
+	result := &Quota{
+		CustomerID:           customerID,
+		AllowedRegions:       make([]string, 0),
+		AllowedInstanceTypes: make([]string, 0),
+	}
+	var maxClusters sql.NullInt64
+	var maxNodes sql.NullInt64
+	err = service.db.QueryRowContext(ctx, `select
+			max_clusters,
+			max_nodes,
+			allowed_regions,
+			allowed_instance_types,
+			updated_at
+		from quotas
+		where customer_id=$1`,
+		customerID).Scan(
+		&maxClusters,
+		&maxNodes,
+		pq.Array(&result.AllowedRegions),
+		pq.Array(&result.AllowedInstanceTypes),
+		&result.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if maxClusters.Valid {
+		result.MaxClusters = &maxClusters.Int64
+	}
+	if maxNodes.Valid {
+		result.MaxNodes = &maxNodes.Int64
+	}
+	return result, nil
+}
+
+// SetQuota stores the quota of a customer in the database.
+func (service *SQLQuotasService) SetQuota(ctx context.Context, customerID string, quota Quota) (*Quota, error) {
+	err := quota.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := service.customerExists(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &NotFoundError{Kind: "customer", ID: customerID}
+	}
+
+	result := quota
+	result.CustomerID = customerID
+	result.UpdatedAt = time.Now().UTC()
+	if result.AllowedRegions == nil {
+		result.AllowedRegions = make([]string, 0)
+	}
+	if result.AllowedInstanceTypes == nil {
+		result.AllowedInstanceTypes = make([]string, 0)
+	}
+
+	_, err = service.db.ExecContext(ctx, `
+		insert into quotas (
+			customer_id,
+			max_clusters,
+			max_nodes,
+			allowed_regions,
+			allowed_instance_types,
+			updated_at
+		) values (
+			$1,
+			$2,
+			$3,
+			$4,
+			$5,
+			$6
+		)
+		on conflict (customer_id) do update set
+			max_clusters = excluded.max_clusters,
+			max_nodes = excluded.max_nodes,
+			allowed_regions = excluded.allowed_regions,
+			allowed_instance_types = excluded.allowed_instance_types,
+			updated_at = excluded.updated_at`,
+		result.CustomerID,
+		nullInt64(result.MaxClusters),
+		nullInt64(result.MaxNodes),
+		pq.Array(result.AllowedRegions),
+		pq.Array(result.AllowedInstanceTypes),
+		result.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// customerExists checks if the customer exists in the customers service.
+func (service *SQLQuotasService) customerExists(ctx context.Context, customerID string) (bool, error) {
+	customer, err := service.customers.Get(ctx, customerID)
+	if err != nil {
+		return false, err
+	}
+	return customer != nil, nil
+}
+
+// nullInt64 converts an optional integer into a value that can be stored in
+// a nullable column.
+func nullInt64(value *int64) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *value, Valid: true}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/ksuid"
+)
+
+// SQLServiceAccountsService is a struct implementing the service accounts
+// service interface, backed by an SQL database.
+type SQLServiceAccountsService struct {
+	db *sql.DB
+}
+
+// NewSQLServiceAccountsService is a constructor for the
+// SQLServiceAccountsService struct.
+// The service uses the given connection pool, but doesn't close it.
+func NewSQLServiceAccountsService(db *sql.DB) *SQLServiceAccountsService {
+	service := new(SQLServiceAccountsService)
+	service.db = db
+	return service
+}
+
+// Close does nothing, the connection pool is shared with the other services
+// and it is closed by the code that opened it.
+func (service *SQLServiceAccountsService) Close() {
+}
+
+// serviceAccountColumns are the columns of the service_accounts table, in the
+// order expected by the scanServiceAccount function.
+const serviceAccountColumns = `id, name, organization_id, roles, scopes, key_id,
+	key_hash, expires_at, created_at, rotated_at, last_used_at, revoked_at`
+
+// scanServiceAccount reads the columns listed in serviceAccountColumns into
+// the given service account.
+func scanServiceAccount(row rowScanner, account *ServiceAccount) error {
+	var roles pq.StringArray
+	var scopes pq.StringArray
+	var expiresAt pq.NullTime
+	var lastUsedAt pq.NullTime
+	var revokedAt pq.NullTime
+	err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&account.OrganizationID,
+		&roles,
+		&scopes,
+		&account.KeyID,
+		&account.keyHash,
+		&expiresAt,
+		&account.CreatedAt,
+		&account.RotatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return err
+	}
+	account.Roles = roles
+	if account.Roles == nil {
+		account.Roles = make([]string, 0)
+	}
+	account.Scopes = scopes
+	account.ExpiresAt = nullTimePointer(expiresAt)
+	account.LastUsedAt = nullTimePointer(lastUsedAt)
+	account.RevokedAt = nullTimePointer(revokedAt)
+	return nil
+}
+
+func nullTimePointer(value pq.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+// AddServiceAccount adds a single service account to the database.
+func (service *SQLServiceAccountsService) AddServiceAccount(ctx context.Context,
+	account ServiceAccount) (*ServiceAccount, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := newServiceAccount(id.String(), account)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = service.db.ExecContext(ctx, `
+		insert into service_accounts (
+			id,
+			name,
+			organization_id,
+			roles,
+			scopes,
+			key_id,
+			key_hash,
+			expires_at,
+			created_at,
+			rotated_at
+		) values (
+			$1,
+			$2,
+			$3,
+			$4,
+			$5,
+			$6,
+			$7,
+			$8,
+			$9,
+			$10
+		)`,
+		result.ID,
+		result.Name,
+		result.OrganizationID,
+		pq.Array(result.Roles),
+		pq.Array(result.Scopes),
+		result.KeyID,
+		result.keyHash,
+		result.ExpiresAt,
+		result.CreatedAt,
+		result.RotatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetServiceAccount retrieves a single service account from the database.
+func (service *SQLServiceAccountsService) GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	return service.getServiceAccount(ctx, `id=$1`, id)
+}
+
+// GetServiceAccountByKey retrieves from the database the service account
+// that has the given API key.
+func (service *SQLServiceAccountsService) GetServiceAccountByKey(ctx context.Context,
+	keyID string) (*ServiceAccount, error) {
+	return service.getServiceAccount(ctx, `key_id=$1`, keyID)
+}
+
+func (service *SQLServiceAccountsService) getServiceAccount(ctx context.Context,
+	where string, value string) (*ServiceAccount, error) {
+	var result ServiceAccount
+	row := service.db.QueryRowContext(ctx, `select `+serviceAccountColumns+` from service_accounts
+		where `+where,
+		value)
+	err := scanServiceAccount(row, &result)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListServiceAccounts retrieves a page of the service accounts stored in the
+// database.
+func (service *SQLServiceAccountsService) ListServiceAccounts(ctx context.Context, organizationID string,
+	args *ListArguments) (*ServiceAccountsList, error) {
+	err := validateListArguments(args)
+	if err != nil {
+		return nil, err
+	}
+	page, size := pageAndSize(args)
+
+	rows, err := service.db.QueryContext(ctx, `select `+serviceAccountColumns+` from service_accounts
+		where $3 = '' or organization_id = $3
+		order by created_at, id
+		limit $1 offset $2`,
+		size, size*page, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*ServiceAccount, 0, size)
+	for rows.Next() {
+		account := new(ServiceAccount)
+		err = scanServiceAccount(rows, account)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, account)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	err = service.db.QueryRowContext(ctx, `select count(*) from service_accounts
+		where $1 = '' or organization_id = $1`,
+		organizationID).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceAccountsList{
+		Page:  page,
+		Size:  int64(len(items)),
+		Total: total,
+		Items: items,
+	}, nil
+}
+
+// RotateServiceAccountKey replaces the API key of a service account.
+func (service *SQLServiceAccountsService) RotateServiceAccountKey(ctx context.Context, id string,
+	expiresAt *time.Time) (*ServiceAccount, error) {
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := lockServiceAccount(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RevokedAt != nil {
+		return nil, &ServiceAccountRevokedError{ID: id}
+	}
+	err = result.generateKey(time.Now().UTC(), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `update service_accounts set
+			key_id = $2,
+			key_hash = $3,
+			expires_at = $4,
+			rotated_at = $5,
+			last_used_at = null
+		where id=$1`,
+		result.ID,
+		result.KeyID,
+		result.keyHash,
+		result.ExpiresAt,
+		result.RotatedAt)
+	if err != nil {
+		return nil, err
+	}
+	result.LastUsedAt = nil
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RevokeServiceAccount revokes a service account.
+func (service *SQLServiceAccountsService) RevokeServiceAccount(ctx context.Context,
+	id string) (*ServiceAccount, error) {
+	tx, err := service.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := lockServiceAccount(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RevokedAt != nil {
+		return result, nil
+	}
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `update service_accounts set revoked_at = $2 where id=$1`, id, now)
+	if err != nil {
+		return nil, err
+	}
+	result.RevokedAt = &now
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// TouchServiceAccountKey updates the last used time of the service account
+// that has the given API key.
+func (service *SQLServiceAccountsService) TouchServiceAccountKey(ctx context.Context,
+	keyID string, usedAt time.Time) error {
+	_, err := service.db.ExecContext(ctx, `update service_accounts set last_used_at = $2
+		where key_id=$1 and (last_used_at is null or last_used_at < $2)`,
+		keyID, usedAt)
+	return err
+}
+
+// lockServiceAccount retrieves the service account and locks it till the end
+// of the transaction. It returns NotFoundError if the account doesn't exist.
+func lockServiceAccount(ctx context.Context, tx *sql.Tx, id string) (*ServiceAccount, error) {
+	var result ServiceAccount
+	row := tx.QueryRowContext(ctx, `select `+serviceAccountColumns+` from service_accounts
+		where id=$1
+		for update`,
+		id)
+	err := scanServiceAccount(row, &result)
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Kind: "service account", ID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/health"
+	pgsql "github.com/container-mgmt/dedicated-portal/pkg/sql"
+)
+
+// Names of the datastores that can be used to store customers.
+const (
+	storeSQL    = "sql"
+	storeEtcd   = "etcd"
+	storeMemory = "memory"
+)
+
+// defaultEtcdEndpoint is the etcd endpoint used by the commands when no other
+// is specified.
+const defaultEtcdEndpoint = "localhost:2379"
+
+// openDatabase opens the connection pool of the database, and waits till the
+// database accepts connections.
+func openDatabase() (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgsql.DefaultWaitTimeout)
+	defer cancel()
+	return pgsql.Connect(ctx, customersConfig.Database.Connection())
+}
+
+// openCustomersService creates the customers service backed by the datastore
+// with the given name, using the connection pool for the SQL datastore or
+// the endpoint for the etcd datastore. The memory datastore loses the
+// customers when the process finishes, so it is only useful for development.
+func openCustomersService(store string, db *sql.DB, etcdEndpoint string) (CustomersService, error) {
+	switch store {
+	case storeSQL:
+		return NewSQLCustomersService(db), nil
+	case storeEtcd:
+		return NewEtcdCustomersService(etcdEndpoint)
+	case storeMemory:
+		return NewMemoryCustomersService(), nil
+	default:
+		return nil, fmt.Errorf("unknown datastore '%s', valid datastores are '%s', '%s' and '%s'",
+			store, storeSQL, storeEtcd, storeMemory)
+	}
+}
+
+// pinger is implemented by the customers services that can check the
+// connection to their datastore.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// addStoreCheck adds a readiness check for the datastore with the given name,
+// if the service can check the connection to it. The SQL datastore isn't
+// checked here, as it uses the connection pool checked by the server.
+func addStoreCheck(checker *health.Checker, store string, service CustomersService) {
+	if service, ok := service.(pinger); ok {
+		checker.Add(store, health.Check(service.Ping))
+	}
+}
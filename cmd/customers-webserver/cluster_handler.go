@@ -17,39 +17,22 @@ limitations under the License.
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 
-	"github.com/golang/glog"
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
 )
 
+// mockClustersTotal is the total number of clusters reported by the mock
+// list of clusters.
+const mockClustersTotal = 10000
+
 // ClusterHandler returns an index of all clusters in the system
 func ClusterHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	page, err := getQueryParamInt("page", r)
-	if err != nil {
-		writeErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("Bad query param: page, %v", err))
-		return
-	}
-
-	size, err := getQueryParamInt("size", r)
+	page, err := api.ParsePage(r)
 	if err != nil {
-		writeErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("Bad query param: size, %v", err))
+		api.WriteErrorf(w, api.StatusOf(err), "Bad query param, %v", err)
 		return
 	}
-
-	ret, err := json.Marshal(map[string]interface{}{
-		"page":  page,
-		"size":  size,
-		"total": 10000,
-		"items": MockGetClusters(page, size)})
-
-	if err != nil {
-		glog.Errorf("Can't marshal json for cluster list response: %v", err)
-		writeErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Marshal error, %v", err))
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write(ret)
-	}
+	clusters := MockGetClusters(page.Page, page.Size)
+	api.WriteJSON(w, http.StatusOK, api.NewList(page.Page, mockClustersTotal, clusters))
 }
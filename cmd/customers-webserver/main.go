@@ -17,17 +17,106 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/health"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/metrics"
+	"github.com/container-mgmt/dedicated-portal/pkg/signals"
+	"github.com/container-mgmt/dedicated-portal/pkg/tlsconfig"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
 	"github.com/gorilla/mux"
 )
 
+// defaultDrainDelay is the time that the server keeps serving requests after
+// the stop signal, when the DRAIN_DELAY environment variable isn't set.
+const defaultDrainDelay = 10 * time.Second
+
 func main() {
+	// The web server has no dependencies, so it is ready until the stop
+	// signal is received:
+	ctx, cancel := signals.Context(context.Background())
+	defer cancel()
+	hooks := signals.NewShutdownHooks()
+	reloader := signals.NewReloader()
+	checker := health.NewChecker()
+	checker.StopOn(ctx.Done())
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		level, err := logging.ParseLevel(value)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid LOG_LEVEL: %v", err))
+		}
+		logging.SetLevel(level)
+	}
+	delay := defaultDrainDelay
+	if value := os.Getenv("DRAIN_DELAY"); value != "" {
+		var err error
+		delay, err = time.ParseDuration(value)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid DRAIN_DELAY '%s': %v", value, err))
+		}
+	}
+	timeout := signals.DefaultShutdownTimeout
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid SHUTDOWN_TIMEOUT '%s': %v", value, err))
+		}
+	}
+
+	tracingConfig, err := tracing.ConfigFromEnv("customers-webserver")
+	if err != nil {
+		panic(fmt.Sprintf("Error loading tracing configuration: %v", err))
+	}
+	tracer, err := tracing.New(tracingConfig)
+	if err != nil {
+		panic(fmt.Sprintf("Error creating tracer: %v", err))
+	}
+	tracing.SetDefault(tracer)
+	hooks.Add("tracer", 5*time.Second, tracer.Shutdown)
+
+	registry := metrics.NewRegistry()
+	httpMetrics := metrics.NewHTTPMetrics(registry)
+
 	r := mux.NewRouter()
-	r.HandleFunc("/api/clusters", ClusterHandler)
+	r.Use(tracing.Middleware(tracer))
+	r.Use(httpMetrics.Middleware)
+	r.HandleFunc("/healthz", checker.ServeLive).Methods("GET")
+	r.HandleFunc("/readyz", checker.ServeReady).Methods("GET")
+	r.Handle("/metrics", registry).Methods("GET")
+	r.Handle("/api/clusters", api.Negotiate(http.HandlerFunc(ClusterHandler)))
 	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("/usr/local/share/customers-portal"))))
 	http.Handle("/", r)
-	fmt.Println("Listening on http://localhost:8000")
-	http.ListenAndServe(":8000", r)
+	tlsConfig, reloadTLS, err := tlsconfig.NewReloadable(tlsconfig.ConfigFromEnv())
+	if err != nil {
+		panic(fmt.Sprintf("Error loading TLS configuration: %v", err))
+	}
+	if tlsConfig != nil {
+		reloader.Add("TLS certificates", reloadTLS)
+	}
+	go reloader.Run(ctx)
+	server := &http.Server{
+		Addr:      ":8000",
+		Handler:   logging.Middleware(logging.Default())(r),
+		TLSConfig: tlsConfig,
+	}
+	serveErr := signals.Serve(ctx.Done(), server, signals.ServeConfig{
+		DrainDelay:      delay,
+		ShutdownTimeout: timeout,
+	})
+	if serveErr != nil {
+		logging.Errorf("Can't serve: %v", serveErr)
+	}
+
+	// Export the pending spans, the hook logs its own failures:
+	hooks.Run()
+	if serveErr != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package api contains the helpers shared by the REST APIs of the services:
+// the envelopes of errors and lists, the parsing of the pagination
+// parameters, the decoding of request bodies and the negotiation of the
+// content type.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// ContentType is the media type of the bodies of requests and responses.
+const ContentType = "application/json"
+
+// Error is an error caused by the request, with the HTTP status of the
+// response that reports it.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf creates an error with the given HTTP status and message.
+func Errorf(status int, format string, args ...interface{}) *Error {
+	return &Error{
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// StatusOf returns the HTTP status of the error if it is an Error, and 500
+// Internal Server Error otherwise.
+func StatusOf(err error) int {
+	if err, ok := err.(*Error); ok {
+		return err.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorBody is the body of all the error responses. It contains the
+// identifier of the request, if it has one, so that users can report it and
+// it can be found in the logs.
+type ErrorBody struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WriteError writes an error response with the given status and the message
+// of the error.
+func WriteError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	logging.AddRequestID(w, body)
+	WriteJSON(w, status, ErrorBody{
+		Error:     body["error"],
+		RequestID: body["request_id"],
+	})
+}
+
+// WriteErrorf writes an error response with the given status and message.
+func WriteErrorf(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	WriteError(w, status, fmt.Errorf(format, args...))
+}
+
+// WriteJSON writes the payload as the JSON body of the response.
+func WriteJSON(w http.ResponseWriter, status int, payload interface{}) {
+	response, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		logging.Errorf("Can't marshal response: %v", err)
+		status = http.StatusInternalServerError
+		response = []byte(`{"error": "can't marshal response"}`)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// List is the envelope of the responses that contain a page of a list of
+// objects. The size is the number of items in the page, which can be less
+// than the requested page size, and the total is the number of objects in
+// all the pages.
+type List struct {
+	Page  int         `json:"page"`
+	Size  int         `json:"size"`
+	Total int64       `json:"total"`
+	Items interface{} `json:"items"`
+}
+
+// NewList creates the envelope of a page of a list. The items must be a
+// slice, a nil slice is written as an empty list.
+func NewList(page int, total int64, items interface{}) *List {
+	value := reflect.ValueOf(items)
+	if value.Kind() != reflect.Slice {
+		panic(fmt.Sprintf("items of a list must be a slice, but they are %T", items))
+	}
+	if value.IsNil() {
+		items = reflect.MakeSlice(value.Type(), 0, 0).Interface()
+	}
+	return &List{
+		Page:  page,
+		Size:  value.Len(),
+		Total: total,
+		Items: items,
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusOf(t *testing.T) {
+	if status := StatusOf(Errorf(http.StatusBadRequest, "bad")); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", status)
+	}
+	if status := StatusOf(fmt.Errorf("other")); status != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", status)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	WriteErrorf(recorder, http.StatusNotFound, "cluster '%s' doesn't exist", "123")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != ContentType {
+		t.Errorf("expected content type '%s', got '%s'", ContentType, contentType)
+	}
+	var body ErrorBody
+	err := json.Unmarshal(recorder.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "cluster '123' doesn't exist" {
+		t.Errorf("unexpected error message '%s'", body.Error)
+	}
+}
+
+func TestNewList(t *testing.T) {
+	var items []string
+	list := NewList(2, 10, items)
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"page":2,"size":0,"total":10,"items":[]}`
+	if string(data) != expected {
+		t.Errorf("expected '%s', got '%s'", expected, data)
+	}
+
+	list = NewList(0, 10, []string{"a", "b"})
+	if list.Size != 2 {
+		t.Errorf("expected size 2, got %d", list.Size)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxBodySize is the maximum size in bytes of the bodies of requests.
+const MaxBodySize = 1 << 20
+
+// DecodeJSON decodes the JSON body of the request into the value. The error
+// is an Error with status 413 Request Entity Too Large if the body is larger
+// than MaxBodySize, and with status 400 Bad Request if the body isn't valid
+// JSON, contains fields that the value doesn't have, or contains more than
+// one value.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, value interface{}) error {
+	body := http.MaxBytesReader(w, r.Body, MaxBodySize)
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err == nil {
+		// The body must contain only one value:
+		var extra json.RawMessage
+		err = decoder.Decode(&extra)
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			return Errorf(http.StatusBadRequest, "body must contain only one JSON value")
+		}
+	}
+	if err == io.EOF {
+		return Errorf(http.StatusBadRequest, "body is empty")
+	}
+	if strings.Contains(err.Error(), "request body too large") {
+		return Errorf(http.StatusRequestEntityTooLarge, "body is larger than %d bytes", MaxBodySize)
+	}
+	return Errorf(http.StatusBadRequest, "can't decode body: %v", err)
+}
+
+// Negotiate is a middleware that checks that the client accepts JSON
+// responses, and that requests that have a body send JSON. Other requests
+// are rejected with status 406 Not Acceptable or 415 Unsupported Media Type.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsJSON(r.Header.Get("Accept")) {
+			WriteErrorf(w, http.StatusNotAcceptable, "responses are only available as '%s'", ContentType)
+			return
+		}
+		if HasBody(r) {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != ContentType {
+				WriteErrorf(w, http.StatusUnsupportedMediaType, "body must be '%s'", ContentType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsJSON checks if the value of the Accept header of a request allows
+// JSON responses. A missing header accepts any media type.
+func acceptsJSON(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		if quality, ok := params["q"]; ok && strings.Trim(quality, "0.") == "" {
+			continue
+		}
+		switch mediaType {
+		case ContentType, "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// HasBody checks if the request has a body.
+func HasBody(r *http.Request) bool {
+	return r.ContentLength > 0 || len(r.TransferEncoding) > 0
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testObject struct {
+	Name string `json:"name"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"name": "my"}`},
+		{body: ``, status: http.StatusBadRequest},
+		{body: `{"name": `, status: http.StatusBadRequest},
+		{body: `{"name": "my", "color": "blue"}`, status: http.StatusBadRequest},
+		{body: `{"name": "my"} {"name": "other"}`, status: http.StatusBadRequest},
+		{body: `{"name": "` + strings.Repeat("x", MaxBodySize) + `"}`, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("POST", "/items", strings.NewReader(test.body))
+		var object testObject
+		err := DecodeJSON(httptest.NewRecorder(), request, &object)
+		if test.status != 0 {
+			if err == nil {
+				t.Errorf("expected error for body '%.20s'", test.body)
+			} else if status := StatusOf(err); status != test.status {
+				t.Errorf("expected status %d for body '%.20s', got %d: %v", test.status, test.body, status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for body '%s': %v", test.body, err)
+		} else if object.Name != "my" {
+			t.Errorf("expected name 'my', got '%s'", object.Name)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := Negotiate(next)
+	tests := []struct {
+		method      string
+		accept      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{method: "GET", status: http.StatusOK},
+		{method: "GET", accept: "application/json", status: http.StatusOK},
+		{method: "GET", accept: "text/html, */*;q=0.8", status: http.StatusOK},
+		{method: "GET", accept: "text/html", status: http.StatusNotAcceptable},
+		{method: "GET", accept: "application/json;q=0", status: http.StatusNotAcceptable},
+		{method: "POST", contentType: "application/json; charset=utf-8", body: `{}`, status: http.StatusOK},
+		{method: "POST", contentType: "text/plain", body: `{}`, status: http.StatusUnsupportedMediaType},
+		{method: "POST", body: `{}`, status: http.StatusUnsupportedMediaType},
+		{method: "POST", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/items", strings.NewReader(test.body))
+		if test.accept != "" {
+			request.Header.Set("Accept", test.accept)
+		}
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("expected status %d for %s with accept '%s' and content type '%s', got %d",
+				test.status, test.method, test.accept, test.contentType, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Limits of the size of the pages of lists.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// Page is the page of a list requested by the client. Pages are numbered
+// from zero.
+type Page struct {
+	Page int
+	Size int
+}
+
+// ParsePage parses the 'page' and 'size' query parameters of the request.
+// When they aren't given the first page and DefaultPageSize are used. The
+// error is an Error with status 400 Bad Request if the page is negative, or
+// the size isn't between one and MaxPageSize.
+func ParsePage(r *http.Request) (Page, error) {
+	page, err := queryInt(r, "page", 0)
+	if err != nil {
+		return Page{}, err
+	}
+	if page < 0 {
+		return Page{}, Errorf(http.StatusBadRequest, "query parameter 'page' can't be negative, but it is %d", page)
+	}
+	size, err := queryInt(r, "size", DefaultPageSize)
+	if err != nil {
+		return Page{}, err
+	}
+	if size < 1 || size > MaxPageSize {
+		return Page{}, Errorf(
+			http.StatusBadRequest,
+			"query parameter 'size' must be between 1 and %d, but it is %d",
+			MaxPageSize, size,
+		)
+	}
+	return Page{Page: page, Size: size}, nil
+}
+
+// queryInt returns the value of an integer query parameter, or the default
+// if it isn't given.
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	text := r.URL.Query().Get(name)
+	if text == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseInt(text, 10, 32)
+	if err != nil {
+		return 0, Errorf(http.StatusBadRequest, "query parameter '%s' must be an integer, but it is '%s'", name, text)
+	}
+	return int(value), nil
+}
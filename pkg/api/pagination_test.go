@@ -0,0 +1,57 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query  string
+		page   Page
+		status int
+	}{
+		{query: "", page: Page{Page: 0, Size: DefaultPageSize}},
+		{query: "page=3&size=10", page: Page{Page: 3, Size: 10}},
+		{query: "size=1000", page: Page{Page: 0, Size: MaxPageSize}},
+		{query: "page=-1", status: http.StatusBadRequest},
+		{query: "page=first", status: http.StatusBadRequest},
+		{query: "size=0", status: http.StatusBadRequest},
+		{query: "size=1001", status: http.StatusBadRequest},
+		{query: "size=99999999999", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/items?"+test.query, nil)
+		page, err := ParsePage(request)
+		if test.status != 0 {
+			if err == nil {
+				t.Errorf("expected error for query '%s', got %+v", test.query, page)
+			} else if status := StatusOf(err); status != test.status {
+				t.Errorf("expected status %d for query '%s', got %d", test.status, test.query, status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for query '%s': %v", test.query, err)
+		} else if page != test.page {
+			t.Errorf("expected %+v for query '%s', got %+v", test.page, test.query, page)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// APIKeyPrefix is the prefix of all the API keys, used to tell them apart
+// from JSON web tokens.
+const APIKeyPrefix = "dpk_"
+
+// DefaultTouchInterval is the minimum time between two updates of the last
+// used time of the same API key.
+const DefaultTouchInterval = time.Minute
+
+// APIKey is the information about an API key needed to verify it.
+type APIKey struct {
+	// ID is the public identifier of the key, included in the key itself.
+	ID string
+
+	// Hash is the hash of the complete key, as returned by HashAPIKey.
+	Hash string
+
+	// ExpiresAt is the time when the key expires, or nil if it doesn't
+	// expire.
+	ExpiresAt *time.Time
+
+	// Revoked indicates if the key has been revoked.
+	Revoked bool
+
+	// Identity is the identity of the owner of the key, returned when the
+	// key is verified.
+	Identity *Identity
+}
+
+// APIKeyStore retrieves the API keys.
+type APIKeyStore interface {
+	// FindAPIKey returns the key with the given identifier, or nil if it
+	// doesn't exist.
+	FindAPIKey(ctx context.Context, id string) (*APIKey, error)
+
+	// TouchAPIKey records the time when the key was used.
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// NewAPIKey generates a new random API key, and returns it together with its
+// identifier. Only the hash of the key should be stored.
+func NewAPIKey() (id string, key string, err error) {
+	idBytes := make([]byte, 8)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return "", "", err
+	}
+	secretBytes := make([]byte, 32)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(idBytes)
+	key = APIKeyPrefix + id + "_" + hex.EncodeToString(secretBytes)
+	return id, key, nil
+}
+
+// IsAPIKey checks if the given bearer token looks like an API key.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// ParseAPIKey extracts the identifier from an API key.
+func ParseAPIKey(key string) (id string, err error) {
+	if !IsAPIKey(key) {
+		return "", tokenError("API key doesn't start with '%s'", APIKeyPrefix)
+	}
+	parts := strings.Split(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", tokenError("malformed API key")
+	}
+	return parts[0], nil
+}
+
+// HashAPIKey returns the hash of the API key that should be stored instead of
+// the key. API keys are long random values, so a plain SHA-256 hash is enough
+// to protect them.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyVerifier is a TokenVerifier for API keys.
+type APIKeyVerifier struct {
+	store         APIKeyStore
+	touchInterval time.Duration
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+
+	// touched contains the last time that the last used time of each key
+	// was updated.
+	lock    sync.Mutex
+	touched map[string]time.Time
+}
+
+// NewAPIKeyVerifier creates a verifier that checks the API keys against the
+// given store.
+func NewAPIKeyVerifier(store APIKeyStore) *APIKeyVerifier {
+	verifier := new(APIKeyVerifier)
+	verifier.store = store
+	verifier.touchInterval = DefaultTouchInterval
+	verifier.now = time.Now
+	verifier.touched = make(map[string]time.Time)
+	return verifier
+}
+
+// Verify checks that the API key exists, that it hasn't expired or been
+// revoked, and returns the identity of its owner.
+func (v *APIKeyVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	id, err := ParseAPIKey(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.store.FindAPIKey(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("can't retrieve API key '%s': %v", id, err)
+	}
+	// Compare the hash even if the key doesn't exist, so that the time
+	// doesn't reveal which keys exist:
+	hash := ""
+	if key != nil {
+		hash = key.Hash
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(HashAPIKey(token))) != 1 || key == nil {
+		return nil, tokenError("unknown API key '%s'", id)
+	}
+	now := v.now()
+	if key.Revoked {
+		return nil, tokenError("API key '%s' has been revoked", id)
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, tokenError("API key '%s' expired at %s", id, key.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	v.touch(ctx, id, now)
+	return key.Identity, nil
+}
+
+// touch updates the last used time of the key, unless it was updated less
+// than the touch interval ago, so that the store isn't updated on every
+// request. Failures are logged, as they shouldn't prevent the use of the key.
+func (v *APIKeyVerifier) touch(ctx context.Context, id string, now time.Time) {
+	v.lock.Lock()
+	last, ok := v.touched[id]
+	if ok && now.Sub(last) < v.touchInterval {
+		v.lock.Unlock()
+		return
+	}
+	v.touched[id] = now
+	v.lock.Unlock()
+	err := v.store.TouchAPIKey(ctx, id, now)
+	if err != nil {
+		logging.Warnf("Can't update last used time of API key '%s': %v", id, err)
+	}
+}
+
+// CombinedVerifier is a TokenVerifier that verifies API keys with one
+// verifier and all the other tokens with another.
+type CombinedVerifier struct {
+	tokens  TokenVerifier
+	apiKeys TokenVerifier
+}
+
+// NewCombinedVerifier creates a verifier that uses the API keys verifier for
+// tokens that start with the API key prefix, and the tokens verifier for the
+// rest. Any of them can be nil, and then the corresponding tokens are
+// rejected.
+func NewCombinedVerifier(tokens TokenVerifier, apiKeys TokenVerifier) *CombinedVerifier {
+	verifier := new(CombinedVerifier)
+	verifier.tokens = tokens
+	verifier.apiKeys = apiKeys
+	return verifier
+}
+
+// Verify checks the token with the verifier that corresponds to its type.
+func (v *CombinedVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	if IsAPIKey(token) {
+		if v.apiKeys == nil {
+			return nil, tokenError("API keys aren't supported")
+		}
+		return v.apiKeys.Verify(ctx, token)
+	}
+	if v.tokens == nil {
+		return nil, tokenError("only API keys are supported")
+	}
+	return v.tokens.Verify(ctx, token)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKeyStore is an in-memory APIKeyStore that records the touches.
+type fakeKeyStore struct {
+	lock    sync.Mutex
+	keys    map[string]*APIKey
+	touches int
+}
+
+func newFakeKeyStore() *fakeKeyStore {
+	return &fakeKeyStore{keys: make(map[string]*APIKey)}
+}
+
+func (s *fakeKeyStore) add(t *testing.T, subject string) (*APIKey, string) {
+	id, key, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &APIKey{
+		ID:       id,
+		Hash:     HashAPIKey(key),
+		Identity: &Identity{Subject: subject},
+	}
+	s.keys[id] = stored
+	return stored, key
+}
+
+func (s *fakeKeyStore) FindAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return s.keys[id], nil
+}
+
+func (s *fakeKeyStore) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.touches++
+	return nil
+}
+
+func TestNewAPIKey(t *testing.T) {
+	id, key, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) {
+		t.Errorf("expected key '%s' to start with '%s'", key, APIKeyPrefix)
+	}
+	parsed, err := ParseAPIKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != id {
+		t.Errorf("expected identifier '%s', got '%s'", id, parsed)
+	}
+	_, other, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Errorf("expected different keys")
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	store := newFakeKeyStore()
+	_, key := store.add(t, "service-account:1")
+	verifier := NewAPIKeyVerifier(store)
+	identity, err := verifier.Verify(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "service-account:1" {
+		t.Errorf("expected subject 'service-account:1', got '%s'", identity.Subject)
+	}
+}
+
+func TestVerifyRejectsInvalidAPIKeys(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	store := newFakeKeyStore()
+	_, valid := store.add(t, "valid")
+	revoked, revokedKey := store.add(t, "revoked")
+	revoked.Revoked = true
+	expired, expiredKey := store.add(t, "expired")
+	expired.ExpiresAt = &past
+	_, unknown, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewAPIKeyVerifier(store)
+	verifier.now = func() time.Time { return now }
+
+	tests := map[string]string{
+		"unknown":      unknown,
+		"wrong secret": valid[:len(valid)-1] + "x",
+		"malformed":    APIKeyPrefix + "nosecret",
+		"revoked":      revokedKey,
+		"expired":      expiredKey,
+	}
+	for name, key := range tests {
+		_, err := verifier.Verify(context.Background(), key)
+		if _, ok := err.(*TokenError); !ok {
+			t.Errorf("expected %s key to be rejected with a token error, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyAPIKeyThrottlesTouches(t *testing.T) {
+	now := time.Now()
+	store := newFakeKeyStore()
+	_, key := store.add(t, "subject")
+	verifier := NewAPIKeyVerifier(store)
+	verifier.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.touches != 1 {
+		t.Errorf("expected 1 touch, got %d", store.touches)
+	}
+	now = now.Add(DefaultTouchInterval)
+	_, err := verifier.Verify(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.touches != 2 {
+		t.Errorf("expected 2 touches after the interval, got %d", store.touches)
+	}
+}
+
+// fixedVerifier is a TokenVerifier that accepts any token and returns an
+// identity whose subject is the given prefix followed by the token.
+type fixedVerifier string
+
+func (v fixedVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	return &Identity{Subject: fmt.Sprintf("%s%s", v, token)}, nil
+}
+
+func TestCombinedVerifier(t *testing.T) {
+	verifier := NewCombinedVerifier(fixedVerifier("jwt:"), fixedVerifier("key:"))
+	identity, err := verifier.Verify(context.Background(), "header.payload.signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(identity.Subject, "jwt:") {
+		t.Errorf("expected token to be verified as a JWT, got '%s'", identity.Subject)
+	}
+	identity, err = verifier.Verify(context.Background(), APIKeyPrefix+"id_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(identity.Subject, "key:") {
+		t.Errorf("expected token to be verified as an API key, got '%s'", identity.Subject)
+	}
+
+	verifier = NewCombinedVerifier(fixedVerifier("jwt:"), nil)
+	_, err = verifier.Verify(context.Background(), APIKeyPrefix+"id_secret")
+	if _, ok := err.(*TokenError); !ok {
+		t.Errorf("expected API keys to be rejected, got %v", err)
+	}
+}
+
+func TestIdentityAllowsAction(t *testing.T) {
+	unrestricted := &Identity{}
+	if !unrestricted.AllowsAction("clusters:create") {
+		t.Errorf("expected identity without scopes to allow all actions")
+	}
+	restricted := &Identity{Scopes: []string{"clusters:list", "usage:*"}}
+	if !restricted.AllowsAction("clusters:list") || !restricted.AllowsAction("usage:get") {
+		t.Errorf("expected scoped identity to allow its scopes")
+	}
+	if restricted.AllowsAction("clusters:create") {
+		t.Errorf("expected scoped identity not to allow other actions")
+	}
+	empty := &Identity{Scopes: []string{}}
+	if empty.AllowsAction("clusters:list") {
+		t.Errorf("expected identity with empty scopes not to allow any action")
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// Handler is an HTTP handler that checks the bearer token of each request
+// before passing it to the next handler. Requests without a valid token are
+// rejected with status 401. The identity of the caller is added to the
+// context of the request, and can be retrieved with the IdentityFromContext
+// function.
+type Handler struct {
+	verifier TokenVerifier
+	next     http.Handler
+}
+
+// NewHandler creates a handler that checks the tokens with the given verifier
+// and then calls the next handler.
+func NewHandler(verifier TokenVerifier, next http.Handler) *Handler {
+	handler := new(Handler)
+	handler.verifier = verifier
+	handler.next = next
+	return handler
+}
+
+// Middleware returns a function that wraps handlers with an authentication
+// handler using the given verifier, suitable for the Use method of the
+// gorilla/mux routers.
+func Middleware(verifier TokenVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return NewHandler(verifier, next)
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, err := bearerToken(r)
+	if err != nil {
+		writeUnauthorized(w, "invalid_request", err)
+		return
+	}
+	identity, err := h.verifier.Verify(r.Context(), token)
+	if _, ok := err.(*TokenError); ok {
+		writeUnauthorized(w, "invalid_token", err)
+		return
+	}
+	if err != nil {
+		logging.LoggerFromContext(r.Context()).Errorf("Can't verify token: %v", err)
+		api.WriteErrorf(w, http.StatusInternalServerError, "can't verify token")
+		return
+	}
+	h.next.ServeHTTP(w, r.WithContext(ContextWithIdentity(r.Context(), identity)))
+}
+
+// bearerToken extracts the token from the Authorization header.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", fmt.Errorf("authorization header is missing")
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return "", fmt.Errorf("authorization header doesn't contain a bearer token")
+	}
+	return fields[1], nil
+}
+
+func writeUnauthorized(w http.ResponseWriter, code string, err error) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q", code))
+	api.WriteError(w, http.StatusUnauthorized, err)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	key := newRSATestKey(t, "rsa")
+	verifier, path := newTestVerifier(t, key)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	var identity *Identity
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = IdentityFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := Middleware(verifier)(next)
+
+	tests := []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic dXNlcjpwYXNzd29yZA==", http.StatusUnauthorized},
+		{"Bearer not-a-token", http.StatusUnauthorized},
+		{"Bearer " + key.mint(t, validClaims()), http.StatusNoContent},
+		{"bearer " + key.mint(t, validClaims()), http.StatusNoContent},
+	}
+	for _, test := range tests {
+		identity = nil
+		request := httptest.NewRequest("GET", "/api/clusters_mgmt/v1/clusters", nil)
+		if test.header != "" {
+			request.Header.Set("Authorization", test.header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.code {
+			t.Errorf("expected status %d for header '%s', got %d", test.code, test.header, recorder.Code)
+		}
+		if test.code == http.StatusUnauthorized {
+			if recorder.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected authenticate header for header '%s'", test.header)
+			}
+			continue
+		}
+		if identity == nil || identity.Subject != "user-1" {
+			t.Errorf("expected identity of 'user-1' in the context, got %+v", identity)
+		}
+	}
+}
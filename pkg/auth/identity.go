@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Identity contains the verified information about the caller of a request.
+type Identity struct {
+	// Subject is the identifier of the caller, taken from the 'sub' claim of
+	// the token.
+	Subject string `json:"subject"`
+
+	// Issuer is the authority that issued the token, taken from the 'iss'
+	// claim.
+	Issuer string `json:"issuer,omitempty"`
+
+	// Email is the email address of the caller, if the token contains the
+	// 'email' claim.
+	Email string `json:"email,omitempty"`
+
+	// Claims contains all the claims of the token, including the ones
+	// already copied to the other fields.
+	Claims map[string]interface{} `json:"claims"`
+
+	// Scopes restricts the actions that the caller can perform, with the
+	// same format used by the authorization policy. If it is nil the caller
+	// can perform all the actions allowed by its roles. Note that an empty
+	// list, unlike nil, doesn't allow any action.
+	Scopes []string `json:"scopes"`
+}
+
+// AllowsAction checks if the scopes of the identity allow the given action.
+// It doesn't check the roles, that is the job of the authorization policy.
+func (i *Identity) AllowsAction(action string) bool {
+	if i.Scopes == nil {
+		return true
+	}
+	for _, scope := range i.Scopes {
+		if MatchAction(scope, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAction checks if an action, with the form 'resource:verb', matches a
+// pattern. An asterisk in the resource or the verb of the pattern matches any
+// value, and a single asterisk matches any action.
+func MatchAction(pattern string, action string) bool {
+	if pattern == "*" {
+		return true
+	}
+	patternParts := strings.Split(pattern, ":")
+	actionParts := strings.Split(action, ":")
+	if len(patternParts) != len(actionParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != actionParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// contextKey is the type of the keys used to store values in the context, so
+// that they don't collide with keys defined in other packages.
+type contextKey int
+
+const identityKey contextKey = iota
+
+// ContextWithIdentity returns a copy of the context that contains the given
+// identity.
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+// IdentityFromContext returns the identity stored in the context, or nil if
+// the context doesn't contain an identity.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey).(*Identity)
+	return identity
+}
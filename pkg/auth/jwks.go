@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// jsonWebKeySet is the JSON representation of a set of keys, as described in
+// RFC 7517.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// jsonWebKey is the JSON representation of a single key. Only the fields of
+// RSA and elliptic curve public keys are included.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet contains the public keys used to verify token signatures, indexed by
+// key identifier. Keys without identifier are stored with the empty string as
+// identifier.
+type keySet map[string][]crypto.PublicKey
+
+// parseKeySet parses a JSON web key set. Keys that aren't used for signatures
+// or that have an unsupported type are ignored.
+func parseKeySet(data []byte) (keySet, error) {
+	var raw jsonWebKeySet
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse key set: %v", err)
+	}
+	keys := make(keySet)
+	for i, jwk := range raw.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch jwk.Kty {
+		case "RSA":
+			key, err = parseRSAKey(jwk)
+		case "EC":
+			key, err = parseECKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't parse key %d of key set: %v", i, err)
+		}
+		keys[jwk.Kid] = append(keys[jwk.Kid], key)
+	}
+	return keys, nil
+}
+
+func parseRSAKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	e, err := decodeBigInt(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %v", err)
+	}
+	if !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid exponent '%s'", jwk.E)
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func parseECKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	if jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+	}
+	x, err := decodeBigInt(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %v", err)
+	}
+	y, err := decodeBigInt(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %v", err)
+	}
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point isn't on curve '%s'", jwk.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(text string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("value is empty")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// keySource loads the content of a key set.
+type keySource interface {
+	load() ([]byte, error)
+}
+
+// fileKeySource loads the key set from a local file.
+type fileKeySource struct {
+	path string
+}
+
+func (s *fileKeySource) load() ([]byte, error) {
+	return ioutil.ReadFile(s.path)
+}
+
+// maxKeySetSize is the maximum size in bytes of a key set downloaded from an
+// URL. Real key sets are a few kilobytes, so larger responses are rejected
+// instead of being read completely into memory.
+const maxKeySetSize = 1024 * 1024
+
+// urlKeySource downloads the key set from an URL.
+type urlKeySource struct {
+	url    string
+	client *http.Client
+}
+
+func newURLKeySource(url string) *urlKeySource {
+	return &urlKeySource{
+		url: url,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *urlKeySource) load() ([]byte, error) {
+	response, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with status %d", response.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maxKeySetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeySetSize {
+		return nil, fmt.Errorf("key set is larger than %d bytes", maxKeySetSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,368 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// TokenVerifier checks the bearer tokens sent by the callers, and returns the
+// identity they contain.
+type TokenVerifier interface {
+	// Verify checks the token and returns the identity of the caller. It
+	// returns TokenError if the token isn't valid.
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+// TokenError is returned when a token can't be accepted, because it is
+// malformed, the signature is wrong or the claims aren't acceptable.
+type TokenError struct {
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("invalid token: %s", e.Reason)
+}
+
+func tokenError(format string, args ...interface{}) error {
+	return &TokenError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Default values of the optional fields of JWTConfig.
+const (
+	DefaultRefreshInterval    = time.Hour
+	DefaultMinRefreshInterval = time.Minute
+	DefaultLeeway             = time.Minute
+)
+
+// JWTConfig contains the configuration of a JWTVerifier.
+type JWTConfig struct {
+	// KeysFile is the path of a local file containing the JSON web key set
+	// used to verify the signatures. Exactly one of KeysFile and KeysURL
+	// must be set.
+	KeysFile string
+
+	// KeysURL is the URL of the JSON web key set used to verify the
+	// signatures.
+	KeysURL string
+
+	// Issuer is the expected value of the 'iss' claim.
+	Issuer string
+
+	// Audience is the value that the 'aud' claim must contain.
+	Audience string
+
+	// RefreshInterval is how often the key set is reloaded, so that new keys
+	// are used and removed keys are rejected.
+	RefreshInterval time.Duration
+
+	// MinRefreshInterval is the minimum time between reloads of the key set
+	// caused by tokens signed with unknown keys, so that those tokens can't
+	// be used to flood the source of the keys.
+	MinRefreshInterval time.Duration
+
+	// Leeway is the clock skew tolerated when checking the expiration and
+	// not before times.
+	Leeway time.Duration
+}
+
+// JWTVerifier is a TokenVerifier for JSON web tokens signed with the RS256 or
+// ES256 algorithms.
+type JWTVerifier struct {
+	source             keySource
+	issuer             string
+	audience           string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	leeway             time.Duration
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+
+	// reloadLock prevents concurrent reloads of the key set, and lock
+	// protects the key set and the time it was loaded.
+	reloadLock sync.Mutex
+	lock       sync.RWMutex
+	keys       keySet
+	loadedAt   time.Time
+}
+
+// NewJWTVerifier creates a verifier with the given configuration, and loads
+// the key set for the first time.
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	verifier := new(JWTVerifier)
+	switch {
+	case config.KeysFile != "" && config.KeysURL != "":
+		return nil, fmt.Errorf("only one of the keys file and the keys URL can be used")
+	case config.KeysFile != "":
+		verifier.source = &fileKeySource{path: config.KeysFile}
+	case config.KeysURL != "":
+		verifier.source = newURLKeySource(config.KeysURL)
+	default:
+		return nil, fmt.Errorf("the keys file or the keys URL is mandatory")
+	}
+	if config.Issuer == "" {
+		return nil, fmt.Errorf("the token issuer is mandatory")
+	}
+	if config.Audience == "" {
+		return nil, fmt.Errorf("the token audience is mandatory")
+	}
+	verifier.issuer = config.Issuer
+	verifier.audience = config.Audience
+	verifier.refreshInterval = durationOrDefault(config.RefreshInterval, DefaultRefreshInterval)
+	verifier.minRefreshInterval = durationOrDefault(config.MinRefreshInterval, DefaultMinRefreshInterval)
+	verifier.leeway = durationOrDefault(config.Leeway, DefaultLeeway)
+	verifier.now = time.Now
+
+	err := verifier.reload()
+	if err != nil {
+		return nil, fmt.Errorf("can't load key set: %v", err)
+	}
+	return verifier, nil
+}
+
+func durationOrDefault(value, defaultValue time.Duration) time.Duration {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// reload loads the key set from the source, replacing the current one.
+func (v *JWTVerifier) reload() error {
+	data, err := v.source.load()
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeySet(data)
+	if err != nil {
+		return err
+	}
+	v.lock.Lock()
+	v.keys = keys
+	v.loadedAt = v.now()
+	v.lock.Unlock()
+	return nil
+}
+
+// findKeys returns the keys with the given identifier, or all the keys if
+// the identifier is empty. The key set is reloaded first if it is older than
+// the refresh interval, or if there is no key with that identifier and the
+// key set is older than the minimum refresh interval, as that usually means
+// that the keys have been rotated. Failures to reload are logged and the
+// previous keys are kept.
+func (v *JWTVerifier) findKeys(kid string) []crypto.PublicKey {
+	keys, fresh := v.lookup(kid)
+	if fresh {
+		return keys
+	}
+	v.reloadLock.Lock()
+	defer v.reloadLock.Unlock()
+
+	// Other request may have reloaded the key set while this one was
+	// waiting for the lock:
+	keys, fresh = v.lookup(kid)
+	if fresh {
+		return keys
+	}
+	err := v.reload()
+	if err != nil {
+		logging.Warnf("Can't reload key set, will keep using the previous keys: %v", err)
+		return keys
+	}
+	keys, _ = v.lookup(kid)
+	return keys
+}
+
+// lookup returns the keys with the given identifier, and a flag indicating
+// if they can be used without reloading the key set.
+func (v *JWTVerifier) lookup(kid string) (keys []crypto.PublicKey, fresh bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	if kid == "" {
+		for _, values := range v.keys {
+			keys = append(keys, values...)
+		}
+	} else {
+		keys = v.keys[kid]
+	}
+	age := v.now().Sub(v.loadedAt)
+	fresh = age < v.refreshInterval && (len(keys) > 0 || age < v.minRefreshInterval)
+	return
+}
+
+// jwtHeader contains the fields of the header of a token that are used to
+// verify it.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature and the claims of the token, and returns the
+// identity of the caller.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, tokenError("expected 3 parts but found %d", len(parts))
+	}
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, tokenError("can't decode header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, tokenError("can't decode signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	var check func(key crypto.PublicKey) bool
+	switch header.Alg {
+	case "RS256":
+		check = func(key crypto.PublicKey) bool {
+			rsaKey, ok := key.(*rsa.PublicKey)
+			return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) == nil
+		}
+	case "ES256":
+		check = func(key crypto.PublicKey) bool {
+			ecKey, ok := key.(*ecdsa.PublicKey)
+			if !ok || len(signature) != 64 {
+				return false
+			}
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			return ecdsa.Verify(ecKey, digest[:], r, s)
+		}
+	default:
+		return nil, tokenError("unsupported signing algorithm '%s'", header.Alg)
+	}
+	verified := false
+	for _, key := range v.findKeys(header.Kid) {
+		if check(key) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, tokenError("signature can't be verified with key '%s'", header.Kid)
+	}
+
+	var claims map[string]interface{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, tokenError("can't decode claims: %v", err)
+	}
+	err = v.checkClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	identity := &Identity{Claims: claims}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Issuer, _ = claims["iss"].(string)
+	identity.Email, _ = claims["email"].(string)
+	return identity, nil
+}
+
+// checkClaims checks the time, issuer, audience and subject claims.
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return tokenError("expiration time is mandatory")
+	}
+	if now.After(exp.Add(v.leeway)) {
+		return tokenError("token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return tokenError("token isn't valid before %s", nbf.UTC().Format(time.RFC3339))
+	}
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return tokenError("unexpected issuer '%s'", iss)
+	}
+	if !hasAudience(claims["aud"], v.audience) {
+		return tokenError("audience doesn't contain '%s'", v.audience)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return tokenError("subject is mandatory")
+	}
+	return nil
+}
+
+// timeClaim returns the value of a claim that contains a number of seconds
+// since the epoch, and a flag indicating if the claim is present.
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, tokenError("claim '%s' isn't a number", name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, tokenError("claim '%s' isn't a number", name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// hasAudience checks if the 'aud' claim, which can be a string or an array of
+// strings, contains the given audience.
+func hasAudience(claim interface{}, audience string) bool {
+	switch value := claim.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a base64 encoded JSON segment of a token.
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
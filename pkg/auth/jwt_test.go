@@ -0,0 +1,362 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "dedicated-portal"
+)
+
+// testKey is a private key used to mint tokens in the tests.
+type testKey struct {
+	kid     string
+	alg     string
+	private crypto.Signer
+}
+
+func newRSATestKey(t *testing.T, kid string) *testKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, alg: "RS256", private: key}
+}
+
+func newECTestKey(t *testing.T, kid string) *testKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, alg: "ES256", private: key}
+}
+
+// jwk returns the JSON web key representation of the public part of the key.
+func (k *testKey) jwk() map[string]string {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": k.kid,
+			"use": "sig",
+			"n":   encode(public.N),
+			"e":   encode(big.NewInt(int64(public.E))),
+		}
+	case *ecdsa.PublicKey:
+		return map[string]string{
+			"kty": "EC",
+			"kid": k.kid,
+			"crv": "P-256",
+			"x":   encode(public.X),
+			"y":   encode(public.Y),
+		}
+	}
+	return nil
+}
+
+// mint creates a token with the given claims signed with the key.
+func (k *testKey) mint(t *testing.T, claims map[string]interface{}) string {
+	encode := func(value interface{}) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	header := map[string]string{"alg": k.alg, "typ": "JWT"}
+	if k.kid != "" {
+		header["kid"] = k.kid
+	}
+	input := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns a set of claims accepted by the test verifiers.
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "user-1",
+		"email": "user-1@example.com",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+// writeKeySet writes the public parts of the keys to a key set file.
+func writeKeySet(t *testing.T, path string, keys ...*testKey) {
+	jwks := make([]map[string]string, len(keys))
+	for i, key := range keys {
+		jwks[i] = key.jwk()
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": jwks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestVerifier creates a verifier that loads the given keys from a
+// temporary file, and returns it together with the path of the file.
+func newTestVerifier(t *testing.T, keys ...*testKey) (*JWTVerifier, string) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "jwks.json")
+	writeKeySet(t, path, keys...)
+	verifier, err := NewJWTVerifier(JWTConfig{
+		KeysFile: path,
+		Issuer:   testIssuer,
+		Audience: testAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier, path
+}
+
+func TestVerifyValidTokens(t *testing.T) {
+	rsaKey := newRSATestKey(t, "rsa")
+	ecKey := newECTestKey(t, "ec")
+	verifier, path := newTestVerifier(t, rsaKey, ecKey)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	for _, key := range []*testKey{rsaKey, ecKey} {
+		identity, err := verifier.Verify(context.Background(), key.mint(t, validClaims()))
+		if err != nil {
+			t.Errorf("expected %s token to be valid, got %v", key.alg, err)
+			continue
+		}
+		if identity.Subject != "user-1" || identity.Email != "user-1@example.com" || identity.Issuer != testIssuer {
+			t.Errorf("unexpected identity %+v", identity)
+		}
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	key := newRSATestKey(t, "rsa")
+	other := newRSATestKey(t, "rsa")
+	verifier, path := newTestVerifier(t, key)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := key.mint(t, validClaims())
+	parts := strings.Split(valid, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tests := map[string]string{
+		"malformed":       "not-a-token",
+		"algorithm none":  unsigned,
+		"wrong key":       other.mint(t, validClaims()),
+		"bad signature":   parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-4] + "AAAA",
+		"expired":         key.mint(t, withClaim("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiration":   key.mint(t, withClaim("exp", nil)),
+		"not yet valid":   key.mint(t, withClaim("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong issuer":    key.mint(t, withClaim("iss", "https://evil.example.com")),
+		"wrong audience":  key.mint(t, withClaim("aud", "other")),
+		"no subject":      key.mint(t, withClaim("sub", nil)),
+		"swapped payload": parts[0] + "." + strings.Split(key.mint(t, withClaim("sub", "admin")), ".")[1] + "." + parts[2],
+	}
+	for name, token := range tests {
+		_, err := verifier.Verify(context.Background(), token)
+		if _, ok := err.(*TokenError); !ok {
+			t.Errorf("expected token error for %s token, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyAudienceList(t *testing.T) {
+	key := newECTestKey(t, "")
+	verifier, path := newTestVerifier(t, key)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	claims := validClaims()
+	claims["aud"] = []string{"other", testAudience}
+	_, err := verifier.Verify(context.Background(), key.mint(t, claims))
+	if err != nil {
+		t.Errorf("expected token with audience list to be valid, got %v", err)
+	}
+}
+
+func TestVerifyToleratesClockSkew(t *testing.T) {
+	key := newRSATestKey(t, "rsa")
+	verifier, path := newTestVerifier(t, key)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-DefaultLeeway / 2).Unix()
+	_, err := verifier.Verify(context.Background(), key.mint(t, claims))
+	if err != nil {
+		t.Errorf("expected recently expired token to be accepted, got %v", err)
+	}
+}
+
+func TestVerifyReloadsRotatedKeys(t *testing.T) {
+	oldKey := newRSATestKey(t, "old")
+	newKey := newECTestKey(t, "new")
+	verifier, path := newTestVerifier(t, oldKey)
+	defer os.RemoveAll(filepath.Dir(path))
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	// The new key isn't used before the minimum refresh interval:
+	writeKeySet(t, path, newKey)
+	_, err := verifier.Verify(context.Background(), newKey.mint(t, validClaims()))
+	if err == nil {
+		t.Errorf("expected unknown key to be rejected before the minimum refresh interval")
+	}
+	_, err = verifier.Verify(context.Background(), oldKey.mint(t, validClaims()))
+	if err != nil {
+		t.Errorf("expected old key to be used until the key set is reloaded, got %v", err)
+	}
+
+	// After the minimum refresh interval the unknown key causes a reload:
+	now = now.Add(DefaultMinRefreshInterval)
+	_, err = verifier.Verify(context.Background(), newKey.mint(t, validClaims()))
+	if err != nil {
+		t.Errorf("expected new key to be loaded, got %v", err)
+	}
+	_, err = verifier.Verify(context.Background(), oldKey.mint(t, validClaims()))
+	if err == nil {
+		t.Errorf("expected removed key to be rejected")
+	}
+}
+
+func TestVerifyKeepsKeysWhenReloadFails(t *testing.T) {
+	key := newRSATestKey(t, "rsa")
+	verifier, path := newTestVerifier(t, key)
+	defer os.RemoveAll(filepath.Dir(path))
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	err := ioutil.WriteFile(path, []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(DefaultRefreshInterval)
+	_, err = verifier.Verify(context.Background(), key.mint(t, validClaims()))
+	if err != nil {
+		t.Errorf("expected previous keys to be kept, got %v", err)
+	}
+}
+
+func TestKeySetFromURL(t *testing.T) {
+	key := newECTestKey(t, "ec")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{key.jwk()},
+		})
+	}))
+	defer server.Close()
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		KeysURL:  server.URL,
+		Issuer:   testIssuer,
+		Audience: testAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifier.Verify(context.Background(), key.mint(t, validClaims()))
+	if err != nil {
+		t.Errorf("expected token to be valid, got %v", err)
+	}
+}
+
+func TestKeySetFromURLTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte(" "), maxKeySetSize+1))
+	}))
+	defer server.Close()
+
+	_, err := newURLKeySource(server.URL).load()
+	if err == nil {
+		t.Errorf("expected key set larger than %d bytes to be rejected", maxKeySetSize)
+	}
+}
+
+func TestNewJWTVerifierChecksConfig(t *testing.T) {
+	configs := []JWTConfig{
+		{Issuer: testIssuer, Audience: testAudience},
+		{KeysFile: "a", KeysURL: "b", Issuer: testIssuer, Audience: testAudience},
+		{KeysFile: "/does/not/exist", Issuer: testIssuer, Audience: testAudience},
+		{KeysFile: "a", Audience: testAudience},
+		{KeysFile: "a", Issuer: testIssuer},
+	}
+	for _, config := range configs {
+		_, err := NewJWTVerifier(config)
+		if err == nil {
+			t.Errorf("expected configuration %+v to be rejected", config)
+		}
+	}
+}
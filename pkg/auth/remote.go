@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
+)
+
+// DefaultRemoteCacheTTL is the time that the identities returned by the
+// remote service are cached.
+const DefaultRemoteCacheTTL = time.Minute
+
+// DefaultRemoteNegativeCacheTTL is the time that the tokens rejected by the
+// remote service are cached. It is shorter than the time that identities are
+// cached, so that keys created right after being rejected work soon.
+const DefaultRemoteNegativeCacheTTL = 10 * time.Second
+
+// maxRemoteCacheSize is the number of cached identities above which expired
+// entries are removed from the cache.
+const maxRemoteCacheSize = 1024
+
+// maxRemoteIdentitySize is the maximum size in bytes of an identity returned
+// by the remote service. Real identities are much smaller, so larger
+// responses are rejected instead of being read completely into memory.
+const maxRemoteIdentitySize = 64 * 1024
+
+// RemoteVerifier is a TokenVerifier that sends the tokens to a remote service,
+// which verifies them and returns the identity of the caller. It is used by
+// services that don't have access to the API keys, which are stored by the
+// customers service. Identities are cached for a short time, so revoking a
+// key may take that long to be effective. Rejected tokens are also cached,
+// for a shorter time, so that requests repeating an invalid key don't all
+// reach the remote service.
+type RemoteVerifier struct {
+	url         string
+	client      *http.Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]cachedIdentity
+}
+
+// cachedIdentity is an entry of the cache. The identity is nil for tokens
+// rejected by the remote service.
+type cachedIdentity struct {
+	identity  *Identity
+	expiresAt time.Time
+}
+
+// NewRemoteVerifier creates a verifier that sends the tokens in the
+// Authorization header of a GET request to the given URL, for example
+// 'http://customers-service:8000/api/customers_mgmt/v1/identity'. The
+// service should respond with the JSON representation of the identity.
+func NewRemoteVerifier(url string) *RemoteVerifier {
+	verifier := new(RemoteVerifier)
+	verifier.url = url
+	verifier.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: tracing.NewTransport(nil, nil),
+	}
+	verifier.ttl = DefaultRemoteCacheTTL
+	verifier.negativeTTL = DefaultRemoteNegativeCacheTTL
+	verifier.now = time.Now
+	verifier.cache = make(map[string]cachedIdentity)
+	return verifier
+}
+
+// Verify sends the token to the remote service, unless it has been verified
+// recently, and returns the identity of the caller. The trace context of the
+// given context is sent with the request.
+func (v *RemoteVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	// The cache is indexed by the hash of the token, so that the tokens
+	// themselves aren't kept in memory:
+	hash := HashAPIKey(token)
+	now := v.now()
+	v.lock.Lock()
+	cached, ok := v.cache[hash]
+	v.lock.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		if cached.identity == nil {
+			return nil, tokenError("rejected by remote service")
+		}
+		return cached.identity, nil
+	}
+
+	request, err := http.NewRequest(http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := v.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("can't verify token with remote service: %v", err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		v.store(hash, cachedIdentity{expiresAt: now.Add(v.negativeTTL)}, now)
+		return nil, tokenError("rejected by remote service")
+	default:
+		return nil, fmt.Errorf("can't verify token, remote service responded with status %d",
+			response.StatusCode)
+	}
+	identity := new(Identity)
+	err = json.NewDecoder(io.LimitReader(response.Body, maxRemoteIdentitySize)).Decode(identity)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode identity returned by remote service: %v", err)
+	}
+
+	v.store(hash, cachedIdentity{identity: identity, expiresAt: now.Add(v.ttl)}, now)
+	return identity, nil
+}
+
+// store adds the entry to the cache, removing first the expired entries if
+// the cache is full. Entries aren't added if the cache is still full, so that
+// requests with many different keys can't make it grow without limit.
+func (v *RemoteVerifier) store(hash string, entry cachedIdentity, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if len(v.cache) >= maxRemoteCacheSize {
+		for key, cached := range v.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(v.cache, key)
+			}
+		}
+		if len(v.cache) >= maxRemoteCacheSize {
+			return
+		}
+	}
+	v.cache[hash] = entry
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteVerifier(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer "+APIKeyPrefix+"good_key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(&Identity{
+			Subject: "service-account:1",
+			Claims:  map[string]interface{}{"roles": []string{"customer"}},
+			Scopes:  []string{},
+		})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	verifier := NewRemoteVerifier(server.URL)
+	verifier.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		identity, err := verifier.Verify(context.Background(), APIKeyPrefix+"good_key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Subject != "service-account:1" {
+			t.Errorf("expected subject 'service-account:1', got '%s'", identity.Subject)
+		}
+		if identity.Scopes == nil || len(identity.Scopes) != 0 {
+			t.Errorf("expected empty scopes to be preserved, got %v", identity.Scopes)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the identity to be cached, got %d requests", requests)
+	}
+
+	now = now.Add(DefaultRemoteCacheTTL)
+	_, err := verifier.Verify(context.Background(), APIKeyPrefix+"good_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected the cached identity to expire, got %d requests", requests)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = verifier.Verify(context.Background(), APIKeyPrefix+"bad_key")
+		if _, ok := err.(*TokenError); !ok {
+			t.Errorf("expected rejected key to return a token error, got %v", err)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("expected the rejected key to be cached, got %d requests", requests)
+	}
+
+	now = now.Add(DefaultRemoteNegativeCacheTTL)
+	_, err = verifier.Verify(context.Background(), APIKeyPrefix+"bad_key")
+	if _, ok := err.(*TokenError); !ok {
+		t.Errorf("expected rejected key to return a token error, got %v", err)
+	}
+	if requests != 4 {
+		t.Errorf("expected the cached rejection to expire, got %d requests", requests)
+	}
+}
+
+func TestRemoteVerifierCacheSize(t *testing.T) {
+	now := time.Now()
+	verifier := NewRemoteVerifier("http://localhost")
+	verifier.now = func() time.Time { return now }
+	for i := 0; i < maxRemoteCacheSize; i++ {
+		verifier.store(fmt.Sprintf("hash-%d", i), cachedIdentity{
+			identity:  &Identity{Subject: "service-account:1"},
+			expiresAt: now.Add(DefaultRemoteCacheTTL),
+		}, now)
+	}
+
+	// Neither identities nor rejections are added while the cache is full:
+	verifier.store("identity", cachedIdentity{identity: &Identity{}, expiresAt: now.Add(time.Minute)}, now)
+	verifier.store("rejection", cachedIdentity{expiresAt: now.Add(time.Minute)}, now)
+	if len(verifier.cache) != maxRemoteCacheSize {
+		t.Errorf("expected %d cached entries, got %d", maxRemoteCacheSize, len(verifier.cache))
+	}
+
+	// Expired entries are removed to make room:
+	now = now.Add(DefaultRemoteCacheTTL)
+	verifier.store("identity", cachedIdentity{identity: &Identity{}, expiresAt: now.Add(time.Minute)}, now)
+	if len(verifier.cache) != 1 {
+		t.Errorf("expected only the new entry to be cached, got %d entries", len(verifier.cache))
+	}
+}
+
+func TestRemoteVerifierLargeIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Identity{
+			Subject: "service-account:1",
+			Email:   strings.Repeat("x", maxRemoteIdentitySize),
+		})
+	}))
+	defer server.Close()
+
+	verifier := NewRemoteVerifier(server.URL)
+	_, err := verifier.Verify(context.Background(), APIKeyPrefix+"good_key")
+	if err == nil {
+		t.Errorf("expected identity larger than %d bytes to be rejected", maxRemoteIdentitySize)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"context"
+)
+
+// Access describes the objects that an authorized action can access: either
+// the objects of all the organizations, or only the objects of the
+// organization of the caller.
+type Access struct {
+	all          bool
+	organization string
+}
+
+// AllAccess returns an access to the objects of all the organizations.
+func AllAccess() *Access {
+	return &Access{all: true}
+}
+
+// OrganizationAccess returns an access to the objects of the given
+// organization only.
+func OrganizationAccess(organization string) *Access {
+	return &Access{organization: organization}
+}
+
+// All checks if the access isn't restricted to an organization.
+func (a *Access) All() bool {
+	return a != nil && a.all
+}
+
+// Organization returns the organization that the access is restricted to, or
+// an empty string if it isn't restricted.
+func (a *Access) Organization() string {
+	if a == nil {
+		return ""
+	}
+	return a.organization
+}
+
+// Allows checks if the objects of the given organization can be accessed. A
+// nil access doesn't allow anything.
+func (a *Access) Allows(organization string) bool {
+	if a == nil {
+		return false
+	}
+	return a.all || a.organization == organization
+}
+
+// contextKey is the type of the keys used to store values in the context, so
+// that they don't collide with keys defined in other packages.
+type contextKey int
+
+const accessKey contextKey = iota
+
+// ContextWithAccess returns a copy of the context that contains the given
+// access.
+func ContextWithAccess(ctx context.Context, access *Access) context.Context {
+	return context.WithValue(ctx, accessKey, access)
+}
+
+// AccessFromContext returns the access stored in the context, or nil if the
+// request wasn't authorized.
+func AccessFromContext(ctx context.Context) *Access {
+	access, _ := ctx.Value(accessKey).(*Access)
+	return access
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"net/http"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+)
+
+// Handler is an HTTP handler that checks that the caller can perform an
+// action before passing the request to the next handler. Requests that
+// aren't allowed are rejected with status 403. The resulting access is added
+// to the context of the request, and can be retrieved with the
+// AccessFromContext function, so that the next handler can restrict the
+// objects it uses to the organization of the caller.
+type Handler struct {
+	policy *Policy
+	action string
+	next   http.Handler
+}
+
+// NewHandler creates a handler that authorizes the action with the given
+// policy and then calls the next handler. The identity of the caller must
+// have been added to the context by the authentication handler. If the policy
+// is nil, because authentication is disabled, all the requests get access to
+// all the objects.
+func NewHandler(policy *Policy, action string, next http.HandlerFunc) *Handler {
+	handler := new(Handler)
+	handler.policy = policy
+	handler.action = action
+	handler.next = next
+	return handler
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	access := AllAccess()
+	if h.policy != nil {
+		var err error
+		access, err = h.policy.Authorize(auth.IdentityFromContext(r.Context()), h.action)
+		if err != nil {
+			api.WriteError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+	h.next.ServeHTTP(w, r.WithContext(ContextWithAccess(r.Context(), access)))
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+)
+
+func TestHandler(t *testing.T) {
+	policy, err := LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var access *Access
+	next := func(w http.ResponseWriter, r *http.Request) {
+		access = AccessFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	tests := []struct {
+		policy   *Policy
+		identity *auth.Identity
+		code     int
+		all      bool
+	}{
+		{policy, identityWith("customer", "org-1"), http.StatusNoContent, false},
+		{policy, identityWith("admin", ""), http.StatusNoContent, true},
+		{policy, identityWith("unknown", "org-1"), http.StatusForbidden, false},
+		{policy, nil, http.StatusForbidden, false},
+		{nil, nil, http.StatusNoContent, true},
+	}
+	for _, test := range tests {
+		access = nil
+		request := httptest.NewRequest("GET", "/api/clusters_mgmt/v1/clusters", nil)
+		if test.identity != nil {
+			request = request.WithContext(auth.ContextWithIdentity(request.Context(), test.identity))
+		}
+		recorder := httptest.NewRecorder()
+		NewHandler(test.policy, "clusters:list", next).ServeHTTP(recorder, request)
+		if recorder.Code != test.code {
+			t.Errorf("expected status %d for %+v, got %d", test.code, test.identity, recorder.Code)
+			continue
+		}
+		if test.code != http.StatusNoContent {
+			continue
+		}
+		if access == nil || access.All() != test.all {
+			t.Errorf("expected access to all organizations to be %v for %+v, got %+v",
+				test.all, test.identity, access)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+)
+
+// Scopes of the roles of a policy.
+const (
+	// ScopeAll gives access to the objects of all the organizations.
+	ScopeAll = "all"
+
+	// ScopeOrganization gives access only to the objects of the organization
+	// of the caller.
+	ScopeOrganization = "organization"
+)
+
+// DefaultPolicy is the policy used when no policy file is given. Customers
+// can read and create clusters, read their own details and manage their
+// service accounts, support engineers
+// can read everything, the clusters service can check quotas and register
+// the owners of clusters, and administrators can do anything.
+const DefaultPolicy = `{
+  "role_claim": "roles",
+  "organization_claim": "org_id",
+  "roles": {
+    "customer": {
+      "scope": "organization",
+      "actions": [
+        "clusters:list",
+        "clusters:get",
+        "clusters:create",
+        "usage:get",
+        "customers:list",
+        "customers:get",
+        "quotas:get",
+        "organizations:list",
+        "organizations:get",
+        "members:list",
+        "members:invite",
+        "members:remove",
+        "service_accounts:list",
+        "service_accounts:get",
+        "service_accounts:create",
+        "service_accounts:rotate",
+        "service_accounts:revoke"
+      ]
+    },
+    "support-readonly": {
+      "scope": "all",
+      "actions": [
+        "*:list",
+        "*:get"
+      ]
+    },
+    "clusters-service": {
+      "scope": "all",
+      "actions": [
+        "quotas:get",
+        "owned_clusters:create"
+      ]
+    },
+    "admin": {
+      "scope": "all",
+      "actions": [
+        "*"
+      ]
+    }
+  }
+}`
+
+// Policy decides which actions can be performed by each caller. The roles
+// and the organization of the caller are taken from the claims of the token.
+type Policy struct {
+	// RoleClaim is the name of the claim that contains the roles of the
+	// caller, either a single string or an array of strings.
+	RoleClaim string `json:"role_claim"`
+
+	// OrganizationClaim is the name of the claim that contains the
+	// identifier of the organization of the caller.
+	OrganizationClaim string `json:"organization_claim"`
+
+	// Roles contains the roles indexed by name.
+	Roles map[string]*Role `json:"roles"`
+}
+
+// Role is a set of actions and the scope where they can be performed.
+type Role struct {
+	// Scope is ScopeAll or ScopeOrganization.
+	Scope string `json:"scope"`
+
+	// Actions are the allowed actions, with the form 'resource:verb', for
+	// example 'clusters:list'. An asterisk in the resource or the verb
+	// matches any value, and a single asterisk matches any action.
+	Actions []string `json:"actions"`
+}
+
+// ForbiddenError is returned when the caller isn't allowed to perform an
+// action.
+type ForbiddenError struct {
+	Action string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("not allowed to perform action '%s'", e.Action)
+}
+
+// GrantError is returned when the caller isn't allowed to give a role or an
+// action scope to other principal, for example to a service account.
+type GrantError struct {
+	Reason string
+}
+
+func (e *GrantError) Error() string {
+	return e.Reason
+}
+
+// ParsePolicy parses and checks a JSON policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := new(Policy)
+	err := json.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse policy: %v", err)
+	}
+	if policy.RoleClaim == "" {
+		return nil, fmt.Errorf("the role claim of the policy is mandatory")
+	}
+	if policy.OrganizationClaim == "" {
+		return nil, fmt.Errorf("the organization claim of the policy is mandatory")
+	}
+	for name, role := range policy.Roles {
+		if role == nil {
+			return nil, fmt.Errorf("role '%s' is empty", name)
+		}
+		if role.Scope != ScopeAll && role.Scope != ScopeOrganization {
+			return nil, fmt.Errorf("role '%s' has unknown scope '%s', valid scopes are '%s' and '%s'",
+				name, role.Scope, ScopeAll, ScopeOrganization)
+		}
+		for _, action := range role.Actions {
+			if action != "*" && len(strings.Split(action, ":")) != 2 {
+				return nil, fmt.Errorf("action '%s' of role '%s' doesn't have the form 'resource:verb'",
+					action, name)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// LoadPolicy loads the policy from the given file, or returns the default
+// policy if the path is empty.
+func LoadPolicy(path string) (*Policy, error) {
+	if path == "" {
+		return ParsePolicy([]byte(DefaultPolicy))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// Authorize checks if the caller can perform the action, and returns the
+// objects that the action can access. If several roles of the caller allow
+// the action the widest scope is used. It returns ForbiddenError if no role
+// allows the action, or if the action is allowed only in the organization of
+// the caller and the token doesn't contain the organization.
+func (p *Policy) Authorize(identity *auth.Identity, action string) (*Access, error) {
+	if identity == nil || !identity.AllowsAction(action) {
+		return nil, &ForbiddenError{Action: action}
+	}
+	allowed := false
+	for _, name := range p.roles(identity) {
+		role, ok := p.Roles[name]
+		if !ok || !role.allows(action) {
+			continue
+		}
+		if role.Scope == ScopeAll {
+			return AllAccess(), nil
+		}
+		allowed = true
+	}
+	if !allowed {
+		return nil, &ForbiddenError{Action: action}
+	}
+	organization, _ := identity.Claims[p.OrganizationClaim].(string)
+	if organization == "" {
+		return nil, &ForbiddenError{Action: action}
+	}
+	return OrganizationAccess(organization), nil
+}
+
+// roles returns the names of the roles of the caller.
+func (p *Policy) roles(identity *auth.Identity) []string {
+	switch value := identity.Claims[p.RoleClaim].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		names := make([]string, 0, len(value))
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// CheckGrant checks that the caller can give the roles and the action scopes
+// to other principal. Callers can give any role if they have a role with
+// scope ScopeAll that allows the given action, otherwise they can only give
+// roles that they have themselves. Callers whose actions are restricted by
+// scopes can only give narrower scopes. It returns GrantError if any of the
+// roles or scopes can't be given.
+func (p *Policy) CheckGrant(identity *auth.Identity, action string, roles []string, scopes []string) error {
+	if identity == nil {
+		return &GrantError{Reason: "anonymous callers can't grant roles"}
+	}
+	current := p.roles(identity)
+	all := false
+	for _, name := range current {
+		role, ok := p.Roles[name]
+		if ok && role.Scope == ScopeAll && role.allows(action) {
+			all = true
+		}
+	}
+	for _, name := range roles {
+		if _, ok := p.Roles[name]; !ok {
+			return &GrantError{Reason: fmt.Sprintf("role '%s' doesn't exist", name)}
+		}
+		if !all && !containsString(current, name) {
+			return &GrantError{Reason: fmt.Sprintf("not allowed to grant role '%s'", name)}
+		}
+	}
+	if identity.Scopes == nil {
+		return nil
+	}
+	if scopes == nil {
+		return &GrantError{Reason: "callers with restricted scopes can't grant unrestricted access"}
+	}
+	for _, scope := range scopes {
+		if !identity.AllowsAction(scope) {
+			return &GrantError{Reason: fmt.Sprintf("not allowed to grant scope '%s'", scope)}
+		}
+	}
+	return nil
+}
+
+// allows checks if any of the actions of the role matches the given action.
+func (r *Role) allows(action string) bool {
+	for _, pattern := range r.Actions {
+		if auth.MatchAction(pattern, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
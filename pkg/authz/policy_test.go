@@ -0,0 +1,219 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+)
+
+func identityWith(roles interface{}, organization string) *auth.Identity {
+	claims := map[string]interface{}{"sub": "user-1"}
+	if roles != nil {
+		claims["roles"] = roles
+	}
+	if organization != "" {
+		claims["org_id"] = organization
+	}
+	return &auth.Identity{Subject: "user-1", Claims: claims}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	policy, err := LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		identity *auth.Identity
+		action   string
+		allowed  bool
+		all      bool
+	}{
+		{identityWith("customer", "org-1"), "clusters:list", true, false},
+		{identityWith("customer", "org-1"), "clusters:create", true, false},
+		{identityWith("customer", "org-1"), "quotas:update", false, false},
+		{identityWith("customer", "org-1"), "customers:create", false, false},
+		{identityWith("customer", "org-1"), "members:invite", true, false},
+		{identityWith("customer", "org-1"), "members:remove", true, false},
+		{identityWith("customer", ""), "clusters:list", false, false},
+		{identityWith("support-readonly", ""), "clusters:list", true, true},
+		{identityWith("support-readonly", ""), "clusters:create", false, false},
+		{identityWith("clusters-service", ""), "owned_clusters:create", true, true},
+		{identityWith("clusters-service", ""), "clusters:create", false, false},
+		{identityWith("admin", ""), "quotas:update", true, true},
+		{identityWith([]interface{}{"customer", "support-readonly"}, "org-1"), "clusters:get", true, true},
+		{identityWith([]interface{}{"customer", "support-readonly"}, "org-1"), "clusters:create", true, false},
+		{identityWith("unknown", "org-1"), "clusters:list", false, false},
+		{identityWith(nil, "org-1"), "clusters:list", false, false},
+		{nil, "clusters:list", false, false},
+	}
+	for _, test := range tests {
+		access, err := policy.Authorize(test.identity, test.action)
+		if !test.allowed {
+			if _, ok := err.(*ForbiddenError); !ok {
+				t.Errorf("expected %s to be forbidden for %+v, got %v", test.action, test.identity, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected %s to be allowed for %+v, got %v", test.action, test.identity, err)
+			continue
+		}
+		if access.All() != test.all {
+			t.Errorf("expected access to all organizations to be %v for %s and %+v",
+				test.all, test.action, test.identity)
+		}
+		if !test.all && (!access.Allows("org-1") || access.Allows("org-2")) {
+			t.Errorf("expected access restricted to 'org-1' for %s and %+v, got %+v",
+				test.action, test.identity, access)
+		}
+	}
+}
+
+func TestLoadPolicyFromFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{
+		"role_claim": "groups",
+		"organization_claim": "tenant",
+		"roles": {
+			"operator": {"scope": "organization", "actions": ["clusters:*"]}
+		}
+	}`)
+	file.Close()
+
+	policy, err := LoadPolicy(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := &auth.Identity{Claims: map[string]interface{}{
+		"groups": []interface{}{"operator"},
+		"tenant": "org-1",
+	}}
+	access, err := policy.Authorize(identity, "clusters:create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.All() || access.Organization() != "org-1" {
+		t.Errorf("expected access restricted to 'org-1', got %+v", access)
+	}
+	_, err = policy.Authorize(identity, "customers:list")
+	if _, ok := err.(*ForbiddenError); !ok {
+		t.Errorf("expected customers:list to be forbidden, got %v", err)
+	}
+}
+
+func TestParsePolicyRejectsInvalid(t *testing.T) {
+	invalid := []string{
+		`not json`,
+		`{"organization_claim": "org", "roles": {}}`,
+		`{"role_claim": "roles", "roles": {}}`,
+		`{"role_claim": "roles", "organization_claim": "org", "roles": {"a": {"scope": "world"}}}`,
+		`{"role_claim": "roles", "organization_claim": "org", "roles": {"a": {"scope": "all", "actions": ["list"]}}}`,
+		`{"role_claim": "roles", "organization_claim": "org", "roles": {"a": null}}`,
+	}
+	for _, data := range invalid {
+		_, err := ParsePolicy([]byte(data))
+		if err == nil {
+			t.Errorf("expected policy '%s' to be rejected", data)
+		}
+	}
+}
+
+func TestMatchAction(t *testing.T) {
+	tests := []struct {
+		pattern string
+		action  string
+		matches bool
+	}{
+		{"*", "clusters:list", true},
+		{"clusters:list", "clusters:list", true},
+		{"clusters:*", "clusters:create", true},
+		{"*:get", "quotas:get", true},
+		{"*:get", "quotas:update", false},
+		{"clusters:list", "customers:list", false},
+	}
+	for _, test := range tests {
+		if auth.MatchAction(test.pattern, test.action) != test.matches {
+			t.Errorf("expected match of '%s' and '%s' to be %v", test.pattern, test.action, test.matches)
+		}
+	}
+}
+
+func TestAuthorizeChecksScopes(t *testing.T) {
+	policy, err := LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := identityWith("admin", "")
+	identity.Scopes = []string{"clusters:list"}
+	_, err = policy.Authorize(identity, "clusters:list")
+	if err != nil {
+		t.Errorf("expected action in scopes to be allowed, got %v", err)
+	}
+	_, err = policy.Authorize(identity, "clusters:create")
+	if _, ok := err.(*ForbiddenError); !ok {
+		t.Errorf("expected action outside scopes to be forbidden, got %v", err)
+	}
+}
+
+func TestCheckGrant(t *testing.T) {
+	policy, err := LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := identityWith("customer", "org-1")
+	scoped.Scopes = []string{"clusters:*"}
+	tests := []struct {
+		identity *auth.Identity
+		roles    []string
+		scopes   []string
+		allowed  bool
+	}{
+		{identityWith("customer", "org-1"), []string{"customer"}, nil, true},
+		{identityWith("customer", "org-1"), []string{"admin"}, nil, false},
+		{identityWith("customer", "org-1"), []string{"unknown"}, nil, false},
+		{identityWith("admin", ""), []string{"admin", "support-readonly"}, nil, true},
+		{identityWith("support-readonly", ""), []string{"admin"}, nil, false},
+		{scoped, []string{"customer"}, []string{"clusters:list"}, true},
+		{scoped, []string{"customer"}, []string{"usage:get"}, false},
+		{scoped, []string{"customer"}, nil, false},
+		{nil, []string{"customer"}, nil, false},
+	}
+	for _, test := range tests {
+		err := policy.CheckGrant(test.identity, "service_accounts:create", test.roles, test.scopes)
+		if test.allowed && err != nil {
+			t.Errorf("expected %v and %v to be granted by %+v, got %v", test.roles, test.scopes, test.identity, err)
+		}
+		if _, ok := err.(*GrantError); !test.allowed && !ok {
+			t.Errorf("expected %v and %v not to be granted by %+v, got %v", test.roles, test.scopes, test.identity, err)
+		}
+	}
+}
+
+func TestNilAccessAllowsNothing(t *testing.T) {
+	var access *Access
+	if access.All() || access.Allows("") || access.Allows("org-1") {
+		t.Errorf("expected nil access not to allow anything")
+	}
+}
@@ -0,0 +1,436 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the configuration of the services from a YAML file,
+// the environment and the command line, in increasing order of precedence.
+//
+// The configuration is a struct whose fields are described with tags:
+//
+//	yaml:     Key of the field in the configuration file. Fields that are
+//	          structs are nested maps.
+//	env:      Environment variable that contains the value.
+//	flag:     Command line flag that contains the value.
+//	help:     Description of the flag.
+//	secret:   If "true" the value is redacted when the configuration is
+//	          printed, and it can also be read from the file whose name is
+//	          in the environment variable with the "_FILE" suffix.
+//	required: If "true" the value can't be empty.
+//	sep:      Separator of the items of lists in environment variables, a
+//	          comma by default. Lists given in flags are separated by commas
+//	          or given repeating the flag.
+//
+// The supported types are strings, booleans, integers, floating point
+// numbers, durations and lists of strings.
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// FileEnv is the environment variable that contains the name of the
+// configuration file, when it isn't given with the command line flag.
+const FileEnv = "CONFIG_FILE"
+
+// Redacted replaces the values of the secrets when the configuration is
+// printed.
+const Redacted = "REDACTED"
+
+// Validator is implemented by configuration structs that check their own
+// values. Load calls it for the configuration and for all its nested
+// structs, after all the sources have been applied.
+type Validator interface {
+	Validate() error
+}
+
+// lookupEnv returns the value of an environment variable, replaced by the
+// tests.
+var lookupEnv = os.LookupEnv
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a configurable field of a configuration struct.
+type field struct {
+	path     string
+	value    reflect.Value
+	env      string
+	flag     string
+	help     string
+	sep      string
+	secret   bool
+	required bool
+}
+
+// fieldsOf returns the configurable fields of the struct that the target
+// points to, including the fields of nested structs. The structs themselves
+// are returned in the second result.
+func fieldsOf(target interface{}) ([]*field, []reflect.Value, error) {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("configuration must be a pointer to a struct, but it is %T", target)
+	}
+	var fields []*field
+	structs := []reflect.Value{value}
+	err := collectFields(value.Elem(), "", &fields, &structs)
+	return fields, structs, err
+}
+
+func collectFields(value reflect.Value, prefix string, fields *[]*field, structs *[]reflect.Value) error {
+	kind := value.Type()
+	for i := 0; i < kind.NumField(); i++ {
+		structField := kind.Field(i)
+		name := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Struct {
+			*structs = append(*structs, fieldValue.Addr())
+			err := collectFields(fieldValue, path+".", fields, structs)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !supported(fieldValue.Type()) {
+			return fmt.Errorf("type %s of configuration field '%s' isn't supported", fieldValue.Type(), path)
+		}
+		result := &field{
+			path:     path,
+			value:    fieldValue,
+			env:      structField.Tag.Get("env"),
+			flag:     structField.Tag.Get("flag"),
+			help:     structField.Tag.Get("help"),
+			sep:      structField.Tag.Get("sep"),
+			secret:   structField.Tag.Get("secret") == "true",
+			required: structField.Tag.Get("required") == "true",
+		}
+		if result.sep == "" {
+			result.sep = ","
+		}
+		*fields = append(*fields, result)
+	}
+	return nil
+}
+
+func supported(kind reflect.Type) bool {
+	switch kind.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return kind.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// set parses the text and assigns it to the field. Lists are split with the
+// given separator.
+func (f *field) set(text string, sep string) error {
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(text)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a boolean", text)
+		}
+		f.value.SetBool(value)
+	case reflect.Int, reflect.Int64:
+		if f.value.Type() == durationType {
+			value, err := time.ParseDuration(text)
+			if err != nil {
+				return fmt.Errorf("'%s' isn't a duration", text)
+			}
+			f.value.SetInt(int64(value))
+			return nil
+		}
+		value, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't an integer", text)
+		}
+		f.value.SetInt(value)
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a number", text)
+		}
+		f.value.SetFloat(value)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(text, sep) {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// text returns the value of the field as it would be given in a flag.
+func (f *field) text() string {
+	switch {
+	case f.value.Kind() == reflect.Slice:
+		return strings.Join(f.value.Interface().([]string), ",")
+	case f.value.Type() == durationType:
+		return time.Duration(f.value.Int()).String()
+	default:
+		return fmt.Sprintf("%v", f.value.Interface())
+	}
+}
+
+// isZero checks if the field has the zero value of its type.
+func (f *field) isZero() bool {
+	if f.value.Kind() == reflect.Slice {
+		return f.value.Len() == 0
+	}
+	return f.value.Interface() == reflect.Zero(f.value.Type()).Interface()
+}
+
+// flagValue is the pflag.Value of the flags added by AddFlags. It only
+// remembers the text given in the command line, that is applied to the
+// configuration by Load after the file and the environment.
+type flagValue struct {
+	kind    string
+	text    string
+	list    bool
+	changed bool
+}
+
+func (v *flagValue) String() string {
+	return v.text
+}
+
+func (v *flagValue) Set(text string) error {
+	if v.list && v.changed && text != "" {
+		text = v.text + "," + text
+	}
+	v.text = text
+	v.changed = true
+	return nil
+}
+
+func (v *flagValue) Type() string {
+	return v.kind
+}
+
+// AddFlags adds to the flag set a flag for each field of the configuration
+// that has a flag tag, using the current values of the fields as defaults.
+// The flags don't change the configuration until Load is called.
+func AddFlags(flags *pflag.FlagSet, target interface{}) error {
+	fields, _, err := fieldsOf(target)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.flag == "" {
+			continue
+		}
+		value := &flagValue{
+			kind: flagType(f.value.Type()),
+			text: f.text(),
+			list: f.value.Kind() == reflect.Slice,
+		}
+		if f.secret && !f.isZero() {
+			value.text = Redacted
+		}
+		help := f.help
+		if f.env != "" {
+			help = fmt.Sprintf("%s Environment variable %s.", help, f.env)
+		}
+		flags.Var(value, f.flag, strings.TrimSpace(help))
+		if value.kind == "bool" {
+			flags.Lookup(f.flag).NoOptDefVal = "true"
+		}
+	}
+	return nil
+}
+
+func flagType(kind reflect.Type) string {
+	switch {
+	case kind == durationType:
+		return "duration"
+	case kind.Kind() == reflect.Slice:
+		return "strings"
+	case kind.Kind() == reflect.Float64:
+		return "float"
+	default:
+		return kind.Kind().String()
+	}
+}
+
+// Load applies to the configuration, that should contain the default
+// values, the YAML file, if the name isn't empty, the environment variables
+// and the flags that were given in the command line, if the flag set isn't
+// nil. Then it checks the required values and calls the Validate method of
+// the configuration and its nested structs. Keys of the file that don't
+// correspond to any field are rejected.
+func Load(target interface{}, file string, flags *pflag.FlagSet) error {
+	fields, structs, err := fieldsOf(target)
+	if err != nil {
+		return err
+	}
+
+	// Configuration file:
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("can't read configuration file: %v", err)
+		}
+		err = yaml.UnmarshalStrict(data, target)
+		if err != nil {
+			return fmt.Errorf("can't parse configuration file '%s': %v", file, err)
+		}
+	}
+
+	// Environment, including secrets read from files:
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if text, ok := lookupEnv(f.env); ok {
+			err = f.set(text, f.sep)
+			if err != nil {
+				return fmt.Errorf("invalid value of environment variable %s: %v", f.env, err)
+			}
+		}
+		if !f.secret {
+			continue
+		}
+		if name, ok := lookupEnv(f.env + "_FILE"); ok && name != "" {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				return fmt.Errorf("can't read %s from file: %v", f.env, err)
+			}
+			f.value.SetString(strings.TrimRight(string(data), "\r\n"))
+		}
+	}
+
+	// Command line:
+	if flags != nil {
+		for _, f := range fields {
+			if f.flag == "" {
+				continue
+			}
+			flag := flags.Lookup(f.flag)
+			if flag == nil || !flag.Changed {
+				continue
+			}
+			err = f.set(flag.Value.String(), ",")
+			if err != nil {
+				return fmt.Errorf("invalid value of flag --%s: %v", f.flag, err)
+			}
+		}
+	}
+
+	return validate(fields, structs)
+}
+
+// validate checks the required fields and calls the Validate method of the
+// structs that have it, returning all the problems found.
+func validate(fields []*field, structs []reflect.Value) error {
+	var problems []string
+	for _, f := range fields {
+		if f.required && f.isZero() {
+			problems = append(problems, fmt.Sprintf("%s is required%s", f.path, f.sources()))
+		}
+	}
+	for _, value := range structs {
+		if validator, ok := value.Interface().(Validator); ok {
+			err := validator.Validate()
+			if err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// sources describes where the value of the field can be given.
+func (f *field) sources() string {
+	var sources []string
+	if f.env != "" {
+		sources = append(sources, "environment variable "+f.env)
+	}
+	if f.flag != "" {
+		sources = append(sources, "flag --"+f.flag)
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(sources, ", ") + ")"
+}
+
+// Print writes the configuration in YAML format, with the values of the
+// secrets redacted.
+func Print(out io.Writer, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("configuration must be a pointer to a struct, but it is %T", target)
+	}
+	data, err := yaml.Marshal(redact(value.Elem()))
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// redact converts a configuration struct to a map that preserves the order of
+// the fields, replacing the values of the secrets.
+func redact(value reflect.Value) yaml.MapSlice {
+	result := yaml.MapSlice{}
+	kind := value.Type()
+	for i := 0; i < kind.NumField(); i++ {
+		structField := kind.Field(i)
+		name := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldValue := value.Field(i)
+		var item interface{}
+		switch {
+		case fieldValue.Kind() == reflect.Struct:
+			item = redact(fieldValue)
+		case structField.Tag.Get("secret") == "true":
+			item = ""
+			if fieldValue.Len() > 0 {
+				item = Redacted
+			}
+		case fieldValue.Type() == durationType:
+			item = time.Duration(fieldValue.Int()).String()
+		default:
+			item = fieldValue.Interface()
+		}
+		result = append(result, yaml.MapItem{Key: name, Value: item})
+	}
+	return result
+}
@@ -0,0 +1,320 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/ratelimit"
+	"github.com/container-mgmt/dedicated-portal/pkg/signals"
+	"github.com/container-mgmt/dedicated-portal/pkg/sql"
+	"github.com/container-mgmt/dedicated-portal/pkg/tlsconfig"
+	"github.com/container-mgmt/dedicated-portal/pkg/tracing"
+)
+
+// Logging is the configuration of the log messages.
+type Logging struct {
+	Level string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" help:"The level of the log messages, 'debug', 'info', 'warn' or 'error'. It can also be changed later using the API."`
+}
+
+// Validate checks that the level is known.
+func (c *Logging) Validate() error {
+	_, err := logging.ParseLevel(c.Level)
+	return err
+}
+
+// sslModes are the values of the sslmode parameter supported by the
+// PostgreSQL driver.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Database is the configuration of the connection to the PostgreSQL
+// database.
+type Database struct {
+	URL             string        `yaml:"url" env:"DATABASE_URL" flag:"sql-connection-string" secret:"true" help:"The URL or connection string of the database. If given the other connection settings are ignored."`
+	Host            string        `yaml:"host" env:"POSTGRESQL_HOST" flag:"db-host" help:"The host name of the database server."`
+	Port            int           `yaml:"port" env:"POSTGRESQL_PORT" flag:"db-port" help:"The port number of the database server."`
+	Name            string        `yaml:"name" env:"POSTGRESQL_DATABASE" flag:"db-name" help:"The name of the database."`
+	User            string        `yaml:"user" env:"POSTGRESQL_USER" flag:"db-user" help:"The user name used to connect to the database."`
+	Password        string        `yaml:"password" env:"POSTGRESQL_PASSWORD" flag:"db-password" secret:"true" help:"The password used to connect to the database."`
+	SSLMode         string        `yaml:"sslmode" env:"POSTGRESQL_SSLMODE" flag:"db-sslmode" help:"The TLS mode of the connection, 'disable', 'require', 'verify-ca' or 'verify-full'."`
+	SSLRootCert     string        `yaml:"sslrootcert" env:"POSTGRESQL_SSLROOTCERT" flag:"db-sslrootcert" help:"File containing the PEM encoded certificates of the authorities that sign the certificate of the database server."`
+	SSLCert         string        `yaml:"sslcert" env:"POSTGRESQL_SSLCERT" flag:"db-sslcert" help:"File containing the PEM encoded client certificate used to connect to the database."`
+	SSLKey          string        `yaml:"sslkey" env:"POSTGRESQL_SSLKEY" flag:"db-sslkey" help:"File containing the PEM encoded private key of the client certificate."`
+	ApplicationName string        `yaml:"application_name" env:"POSTGRESQL_APPLICATION_NAME" flag:"db-application-name" help:"The name that identifies the service in the activity views and logs of the database server."`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" env:"POSTGRESQL_CONNECT_TIMEOUT" flag:"db-connect-timeout" help:"Maximum time waiting for each new connection to the database. Zero means no timeout."`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"POSTGRESQL_MAX_OPEN_CONNS" flag:"db-max-open-conns" help:"Maximum number of open connections to the database."`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"POSTGRESQL_MAX_IDLE_CONNS" flag:"db-max-idle-conns" help:"Maximum number of idle connections kept open."`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"POSTGRESQL_CONN_MAX_LIFETIME" flag:"db-conn-max-lifetime" help:"Maximum time that a connection is reused before it is replaced by a new one."`
+}
+
+// DefaultDatabase returns the configuration of a database on the local
+// host, without TLS.
+func DefaultDatabase() Database {
+	defaults := sql.DefaultConnectionConfig()
+	return Database{
+		Host:            defaults.Host,
+		Port:            defaults.Port,
+		SSLMode:         defaults.SSLMode,
+		MaxOpenConns:    defaults.MaxOpenConns,
+		MaxIdleConns:    defaults.MaxIdleConns,
+		ConnMaxLifetime: defaults.ConnMaxLifetime,
+	}
+}
+
+// Connection returns the configuration used to open the connection pool of
+// the database.
+func (c *Database) Connection() sql.ConnectionConfig {
+	return sql.ConnectionConfig{
+		URL:             c.URL,
+		Host:            c.Host,
+		Port:            c.Port,
+		Name:            c.Name,
+		User:            c.User,
+		Password:        c.Password,
+		SSLMode:         c.SSLMode,
+		SSLRootCert:     c.SSLRootCert,
+		SSLCert:         c.SSLCert,
+		SSLKey:          c.SSLKey,
+		ApplicationName: c.ApplicationName,
+		ConnectTimeout:  c.ConnectTimeout,
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime,
+	}
+}
+
+// ConnectionURL returns the URL used to connect to the database.
+func (c *Database) ConnectionURL() string {
+	connection := c.Connection()
+	return connection.DSN()
+}
+
+// Validate checks the address of the server, the TLS settings and the size
+// of the connection pool.
+func (c *Database) Validate() error {
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return fmt.Errorf("database connection pool sizes can't be negative")
+	}
+	if c.URL != "" {
+		return nil
+	}
+	if c.Host == "" {
+		return fmt.Errorf("database host is required")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("database port %d isn't between 1 and 65535", c.Port)
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		return fmt.Errorf("database client certificate and key files must be given together")
+	}
+	for _, mode := range sslModes {
+		if c.SSLMode == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"database TLS mode '%s' isn't valid, valid modes are '%s'",
+		c.SSLMode, strings.Join(sslModes, "', '"),
+	)
+}
+
+// TLS is the configuration of the TLS certificates of the server.
+type TLS struct {
+	CertFile     string `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" help:"File containing the PEM encoded TLS certificate of the server. If neither this nor the key file are given the server doesn't use TLS. The file is reloaded when it changes."`
+	KeyFile      string `yaml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" help:"File containing the PEM encoded private key of the TLS certificate."`
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca-file" help:"File containing the PEM encoded certificates of the authorities that sign the certificates of the clients. If given clients must present a valid certificate."`
+	MinVersion   string `yaml:"min_version" env:"TLS_MIN_VERSION" flag:"tls-min-version" help:"The minimum TLS version accepted, '1.0', '1.1', '1.2' or '1.3'."`
+}
+
+// DefaultTLS returns the configuration of a server without TLS.
+func DefaultTLS() TLS {
+	return TLS{
+		MinVersion: tlsconfig.DefaultMinVersion,
+	}
+}
+
+// Config returns the configuration used to create the TLS configuration of
+// the server.
+func (c *TLS) Config() tlsconfig.Config {
+	return tlsconfig.Config{
+		CertFile:     c.CertFile,
+		KeyFile:      c.KeyFile,
+		ClientCAFile: c.ClientCAFile,
+		MinVersion:   c.MinVersion,
+	}
+}
+
+// Validate checks that the certificate and the key are given together, and
+// that the minimum version is known.
+func (c *TLS) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("TLS certificate and key files must be given together")
+	}
+	if c.MinVersion == "" {
+		return nil
+	}
+	_, err := tlsconfig.ParseVersion(c.MinVersion)
+	return err
+}
+
+// Auth is the configuration of the authentication and authorization of the
+// requests.
+type Auth struct {
+	JWKSFile   string `yaml:"jwks_file" env:"JWKS_FILE" flag:"jwks-file" help:"Local file containing the JSON web key set used to verify the bearer tokens."`
+	JWKSURL    string `yaml:"jwks_url" env:"JWKS_URL" flag:"jwks-url" help:"URL of the JSON web key set used to verify the bearer tokens. If neither this nor the keys file are given authentication is disabled."`
+	Issuer     string `yaml:"token_issuer" env:"TOKEN_ISSUER" flag:"token-issuer" help:"The issuer that bearer tokens must contain."`
+	Audience   string `yaml:"token_audience" env:"TOKEN_AUDIENCE" flag:"token-audience" help:"The audience that bearer tokens must contain."`
+	PolicyFile string `yaml:"policy_file" env:"POLICY_FILE" flag:"policy-file" help:"File containing the authorization policy. If empty the default policy is used."`
+}
+
+// Enabled checks if the requests should be authenticated, which happens
+// when the JSON web key set is known.
+func (c *Auth) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// RateLimits is the configuration of the rate limits of the requests of each
+// client.
+type RateLimits struct {
+	Read              string   `yaml:"read" env:"RATE_LIMIT_READ" flag:"rate-limit-read" help:"Maximum rate of read requests of each client, for example '100/s' or '600/m:20' where the number after the colon is the burst size. Empty to disable."`
+	Write             string   `yaml:"write" env:"RATE_LIMIT_WRITE" flag:"rate-limit-write" help:"Maximum rate of write requests of each client. Empty to disable."`
+	Routes            []string `yaml:"routes" env:"RATE_LIMIT_ROUTES" sep:";" flag:"rate-limit-route" help:"Rate limit for a specific route, replacing the read or write limit, for example 'POST /api/customers_mgmt/v1/customers=10/m'. Can be repeated. The environment variable separates routes with semicolons."`
+	Address           string   `yaml:"address" env:"RATE_LIMIT_ADDRESS" flag:"rate-limit-address" help:"Maximum rate of all the requests sent from each address, checked before the credentials. Empty to disable."`
+	TrustForwardedFor bool     `yaml:"trust_forwarded_for" env:"RATE_LIMIT_TRUST_FORWARDED_FOR" flag:"rate-limit-trust-forwarded-for" help:"Identify anonymous clients by the last address of the X-Forwarded-For header. Enable only when the service is behind a proxy that sets it."`
+}
+
+// Config parses the limits.
+func (c *RateLimits) Config() (*ratelimit.Config, error) {
+	read, err := ratelimit.ParseLimit(c.Read)
+	if err != nil {
+		return nil, err
+	}
+	write, err := ratelimit.ParseLimit(c.Write)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := ratelimit.ParseRoutes(c.Routes)
+	if err != nil {
+		return nil, err
+	}
+	address, err := ratelimit.ParseLimit(c.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &ratelimit.Config{
+		Read:              read,
+		Write:             write,
+		Routes:            routes,
+		Address:           address,
+		TrustForwardedFor: c.TrustForwardedFor,
+	}, nil
+}
+
+// Validate checks that the limits can be parsed.
+func (c *RateLimits) Validate() error {
+	_, err := c.Config()
+	if err != nil {
+		return fmt.Errorf("invalid rate limits: %v", err)
+	}
+	return nil
+}
+
+// Tracing is the configuration of the export of the spans of the traces. The
+// environment variables are the standard ones of OpenTelemetry.
+type Tracing struct {
+	ServiceName  string  `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+	Exporter     string  `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" flag:"tracing-exporter" help:"Where the spans of the traces are sent, 'none', 'otlp', 'stdout' or 'file'."`
+	Endpoint     string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT" flag:"tracing-endpoint" help:"Traces URL of the OpenTelemetry collector used by the 'otlp' exporter. If empty the base URL of the collector is used, or else http://localhost:4318/v1/traces."`
+	CollectorURL string  `yaml:"collector_url" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	File         string  `yaml:"file" env:"TRACES_FILE" flag:"tracing-file" help:"File where the 'file' exporter writes the spans."`
+	SampleRatio  float64 `yaml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" flag:"tracing-sample-ratio" help:"Ratio of the traces started by this service that are sampled, between 0 and 1. Traces started by other services are sampled if they were sampled there."`
+}
+
+// DefaultTracing returns the configuration of a service that doesn't export
+// spans.
+func DefaultTracing(serviceName string) Tracing {
+	return Tracing{
+		ServiceName: serviceName,
+		Exporter:    tracing.ExporterNone,
+		SampleRatio: 1,
+	}
+}
+
+// Config returns the configuration used to create the tracer.
+func (c *Tracing) Config() tracing.Config {
+	endpoint := c.Endpoint
+	if endpoint == "" && c.CollectorURL != "" {
+		endpoint = strings.TrimRight(c.CollectorURL, "/") + "/v1/traces"
+	}
+	return tracing.Config{
+		ServiceName: c.ServiceName,
+		Exporter:    c.Exporter,
+		Endpoint:    endpoint,
+		File:        c.File,
+		SampleRatio: c.SampleRatio,
+	}
+}
+
+// Validate checks the exporter and the sample ratio.
+func (c *Tracing) Validate() error {
+	switch strings.ToLower(c.Exporter) {
+	case "", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		if c.File == "" {
+			return fmt.Errorf("tracing file is required by the '%s' exporter", tracing.ExporterFile)
+		}
+	default:
+		return fmt.Errorf("unknown tracing exporter '%s'", c.Exporter)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample ratio %g isn't between 0 and 1", c.SampleRatio)
+	}
+	return nil
+}
+
+// Shutdown is the configuration of the graceful shutdown of the server.
+type Shutdown struct {
+	DrainDelay      time.Duration `yaml:"drain_delay" env:"DRAIN_DELAY" flag:"drain-delay" help:"Time that the server keeps serving requests after the stop signal, while it is reported as not ready, so that load balancers stop sending new requests."`
+	ShutdownTimeout time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" help:"Maximum time that the requests in progress are given to finish after the drain delay, before their connections are closed."`
+}
+
+// DefaultShutdown returns the default drain delay and shutdown timeout.
+func DefaultShutdown() Shutdown {
+	return Shutdown{
+		DrainDelay:      10 * time.Second,
+		ShutdownTimeout: signals.DefaultShutdownTimeout,
+	}
+}
+
+// ServeConfig returns the configuration used to serve the requests.
+func (c *Shutdown) ServeConfig() signals.ServeConfig {
+	return signals.ServeConfig{
+		DrainDelay:      c.DrainDelay,
+		ShutdownTimeout: c.ShutdownTimeout,
+	}
+}
+
+// Validate checks that the durations aren't negative.
+func (c *Shutdown) Validate() error {
+	if c.DrainDelay < 0 || c.ShutdownTimeout < 0 {
+		return fmt.Errorf("drain delay and shutdown timeout can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health implements the liveness and readiness endpoints of the
+// services.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is the maximum time that the readiness checks can take.
+const DefaultTimeout = 5 * time.Second
+
+// Check checks that a dependency of the service is usable. It should return
+// when the context is cancelled.
+type Check func(ctx context.Context) error
+
+// Status is the result of the readiness checks, returned by the readiness
+// endpoint.
+type Status struct {
+	// Status is 'ready', 'not ready' or 'stopping'.
+	Status string `json:"status"`
+
+	// Checks contains the result of each check, indexed by name.
+	Checks map[string]*CheckStatus `json:"checks"`
+}
+
+// CheckStatus is the result of a single check.
+type CheckStatus struct {
+	// Status is 'ok' or 'failed'.
+	Status string `json:"status"`
+
+	// Error is the reason of the failure.
+	Error string `json:"error,omitempty"`
+}
+
+// Checker runs the readiness checks of a service.
+type Checker struct {
+	timeout time.Duration
+
+	lock   sync.Mutex
+	checks map[string]Check
+
+	// stopping is set to one when the service starts to shut down.
+	stopping int32
+}
+
+// NewChecker creates a checker without checks.
+func NewChecker() *Checker {
+	checker := new(Checker)
+	checker.timeout = DefaultTimeout
+	checker.checks = make(map[string]Check)
+	return checker
+}
+
+// Add adds a readiness check with the given name, replacing any check that
+// had that name.
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks[name] = check
+}
+
+// Stop marks the service as stopping, so that it isn't ready anymore and
+// the load balancers stop sending it new requests.
+func (c *Checker) Stop() {
+	atomic.StoreInt32(&c.stopping, 1)
+}
+
+// StopOn marks the service as stopping when the given channel is closed,
+// like the stop channel returned by signals.SetupHandler or the Done channel
+// of the context returned by signals.Context.
+func (c *Checker) StopOn(stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		c.Stop()
+	}()
+}
+
+// Run runs all the checks concurrently and returns their results.
+func (c *Checker) Run(ctx context.Context) *Status {
+	if atomic.LoadInt32(&c.stopping) == 1 {
+		return &Status{Status: "stopping", Checks: map[string]*CheckStatus{}}
+	}
+	c.lock.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	status := &Status{
+		Status: "ready",
+		Checks: make(map[string]*CheckStatus, len(checks)),
+	}
+	var lock sync.Mutex
+	var group sync.WaitGroup
+	for name, check := range checks {
+		group.Add(1)
+		go func(name string, check Check) {
+			defer group.Done()
+			result := &CheckStatus{Status: "ok"}
+			err := check(ctx)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+				status.Status = "not ready"
+			}
+			status.Checks[name] = result
+		}(name, check)
+	}
+	group.Wait()
+	return status
+}
+
+// ServeLive responds to the liveness probes. The service is alive while it
+// can respond to requests, even if its dependencies aren't usable, as
+// restarting it wouldn't fix them.
+func (c *Checker) ServeLive(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ServeReady responds to the readiness probes, running all the checks. The
+// status is 200 if all of them succeed and 503 if any fails or the service
+// is stopping.
+func (c *Checker) ServeReady(w http.ResponseWriter, r *http.Request) {
+	status := c.Run(r.Context())
+	code := http.StatusOK
+	if status.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+// DatabaseCheck returns a check that verifies the connection to the
+// database.
+func DatabaseCheck(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.MarshalIndent(payload, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
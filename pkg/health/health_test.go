@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveReady(t *testing.T, checker *Checker) (int, *Status) {
+	recorder := httptest.NewRecorder()
+	checker.ServeReady(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	status := new(Status)
+	err := json.Unmarshal(recorder.Body.Bytes(), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, status
+}
+
+func TestReady(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", func(ctx context.Context) error { return nil })
+	code, status := serveReady(t, checker)
+	if code != http.StatusOK || status.Status != "ready" {
+		t.Errorf("expected ready with status 200, got %d and %+v", code, status)
+	}
+	if status.Checks["database"] == nil || status.Checks["database"].Status != "ok" {
+		t.Errorf("expected database check to be ok, got %+v", status.Checks)
+	}
+}
+
+func TestNotReadyWhenCheckFails(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", func(ctx context.Context) error { return nil })
+	checker.Add("etcd", func(ctx context.Context) error { return fmt.Errorf("connection refused") })
+	code, status := serveReady(t, checker)
+	if code != http.StatusServiceUnavailable || status.Status != "not ready" {
+		t.Errorf("expected not ready with status 503, got %d and %+v", code, status)
+	}
+	etcd := status.Checks["etcd"]
+	if etcd == nil || etcd.Status != "failed" || etcd.Error != "connection refused" {
+		t.Errorf("expected etcd check to fail, got %+v", etcd)
+	}
+	if status.Checks["database"].Status != "ok" {
+		t.Errorf("expected database check to be ok, got %+v", status.Checks["database"])
+	}
+}
+
+func TestChecksTimeOut(t *testing.T) {
+	checker := NewChecker()
+	checker.timeout = 10 * time.Millisecond
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	code, _ := serveReady(t, checker)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 for slow check, got %d", code)
+	}
+}
+
+func TestNotReadyWhenStopping(t *testing.T) {
+	checker := NewChecker()
+	stopCh := make(chan struct{})
+	checker.StopOn(stopCh)
+	code, _ := serveReady(t, checker)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200 before stopping, got %d", code)
+	}
+	close(stopCh)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		code, status := serveReady(t, checker)
+		if code == http.StatusServiceUnavailable && status.Status == "stopping" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("expected not ready after the stop channel is closed")
+}
+
+func TestLive(t *testing.T) {
+	checker := NewChecker()
+	checker.Stop()
+	recorder := httptest.NewRecorder()
+	checker.ServeLive(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", recorder.Code)
+	}
+}
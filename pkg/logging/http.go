@@ -0,0 +1,196 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// RequestIDHeader is the header that contains the identifier of the request,
+// both in the request and in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of the request identifiers
+// accepted from the clients.
+const maxRequestIDLength = 128
+
+// contextKey is the type of the keys of the values added to the context.
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// ContextWithLogger returns a copy of the context that contains the logger.
+func ContextWithLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// LoggerFromContext returns the logger of the context, or the default logger
+// if the context doesn't contain one.
+func LoggerFromContext(ctx context.Context) *Logger {
+	logger, ok := ctx.Value(loggerKey).(*Logger)
+	if !ok {
+		return defaultLogger
+	}
+	return logger
+}
+
+// RequestIDFromContext returns the identifier of the request of the context,
+// or an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// AddRequestID adds the identifier of the request to the body of an error
+// response, in the 'request_id' field, if the response has one.
+func AddRequestID(w http.ResponseWriter, body map[string]string) {
+	id := w.Header().Get(RequestIDHeader)
+	if id != "" {
+		body["request_id"] = id
+	}
+}
+
+// validRequestID checks if a request identifier sent by a client can be
+// used, so that clients can't inject arbitrary content into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware returns a function that wraps handlers so that each request has
+// an identifier and is written to the access log. The identifier is taken
+// from the X-Request-ID header of the request, or generated if it is missing
+// or invalid, and is returned in the same header of the response. The
+// handlers can get a logger that adds it to all the messages with the
+// LoggerFromContext function. It should wrap the main router, so that all
+// the requests are logged.
+func Middleware(logger *Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = ksuid.New().String()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			requestLogger := logger.With("request_id", id)
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			ctx = ContextWithLogger(ctx, requestLogger)
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+			requestLogger.
+				With("method", r.Method).
+				With("path", r.URL.Path).
+				With("status", recorder.status).
+				With("bytes", recorder.bytes).
+				With("duration", time.Since(start).Seconds()).
+				With("remote_addr", r.RemoteAddr).
+				Infof("%s %s %d", r.Method, r.URL.Path, recorder.status)
+		})
+	}
+}
+
+// responseRecorder remembers the status code and the size of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int
+	written bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.written {
+		r.status = status
+		r.written = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.written = true
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += n
+	return n, err
+}
+
+// levelBody is the body of the requests and responses of the level handler.
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler returns a handler that returns the current level of the
+// logger for GET requests, and changes it for PUT requests containing the
+// new level, for example {"level": "debug"}.
+func LevelHandler(logger *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				writeLevelError(w, http.StatusBadRequest, err)
+				return
+			}
+			var body levelBody
+			err = json.Unmarshal(data, &body)
+			if err != nil {
+				writeLevelError(w, http.StatusBadRequest, fmt.Errorf("can't decode level: %v", err))
+				return
+			}
+			level, err := ParseLevel(body.Level)
+			if err != nil {
+				writeLevelError(w, http.StatusBadRequest, err)
+				return
+			}
+			old := logger.Level()
+			logger.SetLevel(level)
+			LoggerFromContext(r.Context()).Infof("Changed log level from '%s' to '%s'", old, level)
+		}
+		writeLevelJSON(w, http.StatusOK, levelBody{Level: logger.Level().String()})
+	})
+}
+
+func writeLevelError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	AddRequestID(w, body)
+	writeLevelJSON(w, status, body)
+}
+
+func writeLevelJSON(w http.ResponseWriter, status int, payload interface{}) {
+	response, _ := json.MarshalIndent(payload, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewarePropagatesRequestID(t *testing.T) {
+	logger, buffer := newTestLogger(InfoLevel)
+	var contextID string
+	handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextID = RequestIDFromContext(r.Context())
+		LoggerFromContext(r.Context()).Infof("handling")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	request := httptest.NewRequest("GET", "/clusters", nil)
+	request.Header.Set(RequestIDHeader, "client-id-1")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if contextID != "client-id-1" || recorder.Header().Get(RequestIDHeader) != "client-id-1" {
+		t.Errorf("expected request identifier to be propagated, got '%s' and '%s'",
+			contextID, recorder.Header().Get(RequestIDHeader))
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two log lines, got %d: %s", len(lines), buffer.String())
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry["request_id"] != "client-id-1" {
+			t.Errorf("expected log line to contain the request identifier, got '%s'", line)
+		}
+	}
+	if !strings.Contains(lines[1], `"status":418`) {
+		t.Errorf("expected access log to contain the status, got '%s'", lines[1])
+	}
+}
+
+func TestMiddlewareReplacesInvalidRequestID(t *testing.T) {
+	logger, _ := newTestLogger(InfoLevel)
+	handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, id := range []string{"", "bad\"id", strings.Repeat("a", 200)} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set(RequestIDHeader, id)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		generated := recorder.Header().Get(RequestIDHeader)
+		if generated == "" || generated == id {
+			t.Errorf("expected identifier '%s' to be replaced, got '%s'", id, generated)
+		}
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	logger, _ := newTestLogger(InfoLevel)
+	handler := LevelHandler(logger)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/log_level", strings.NewReader(`{"level": "debug"}`)))
+	if recorder.Code != http.StatusOK || logger.Level() != DebugLevel {
+		t.Errorf("expected level to be changed to debug, got %d and %s", recorder.Code, logger.Level())
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/log_level", strings.NewReader(`{"level": "loud"}`)))
+	if recorder.Code != http.StatusBadRequest || logger.Level() != DebugLevel {
+		t.Errorf("expected invalid level to be rejected, got %d and %s", recorder.Code, logger.Level())
+	}
+}
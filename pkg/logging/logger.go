@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging contains the structured logger used by all the services.
+// Each message is written as a JSON object in a single line, containing the
+// time, the level, the message and the fields added to the logger, for
+// example the identifier of the request being processed.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log message.
+type Level int32
+
+// Levels of the log messages, from the least to the most severe.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "fatal"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > FatalLevel {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name, 'debug', 'info', 'warn'
+// or 'error', ignoring case.
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return WarnLevel, nil
+	}
+	for i, levelName := range levelNames[:FatalLevel] {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s', valid levels are 'debug', 'info', 'warn' and 'error'", name)
+}
+
+// Logger writes structured log messages. Loggers created with the With
+// method share the output and the level of the logger they were created
+// from, so changing the level of one changes the level of all of them.
+type Logger struct {
+	sink   *sink
+	fields []field
+}
+
+// sink is the output and level shared by related loggers.
+type sink struct {
+	lock  sync.Mutex
+	out   io.Writer
+	level int32
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// New creates a logger that writes the messages with the given level or
+// more severe to the given writer.
+func New(out io.Writer, level Level) *Logger {
+	logger := new(Logger)
+	logger.sink = &sink{
+		out:   out,
+		level: int32(level),
+		now:   time.Now,
+	}
+	return logger
+}
+
+// defaultLogger is the logger used by the package level functions.
+var defaultLogger = New(os.Stderr, InfoLevel)
+
+// Default returns the logger used by the package level functions, that
+// writes to the standard error stream.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// Level returns the current level of the logger.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.sink.level))
+}
+
+// SetLevel changes the level of the logger, and of all the loggers that share
+// its output. It is safe to call it while other goroutines write messages.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.sink.level, int32(level))
+}
+
+// Enabled checks if messages with the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// With returns a logger that adds the given field to all its messages.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{
+		sink:   l.sink,
+		fields: append(fields, field{key: key, value: value}),
+	}
+}
+
+// Debugf writes a message with the debug level.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, format, args)
+}
+
+// Infof writes a message with the info level.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, format, args)
+}
+
+// Warnf writes a message with the warn level.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, format, args)
+}
+
+// Errorf writes a message with the error level.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, format, args)
+}
+
+// Fatalf writes a message with the fatal level, that is always written, and
+// then exits the process with code 1.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(FatalLevel, format, args)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	buffer := new(bytes.Buffer)
+	buffer.WriteString(`{"time":`)
+	writeValue(buffer, l.sink.now().UTC().Format(time.RFC3339Nano))
+	buffer.WriteString(`,"level":`)
+	writeValue(buffer, level.String())
+	buffer.WriteString(`,"msg":`)
+	writeValue(buffer, fmt.Sprintf(format, args...))
+	for _, f := range l.fields {
+		buffer.WriteString(",")
+		writeValue(buffer, f.key)
+		buffer.WriteString(":")
+		writeValue(buffer, f.value)
+	}
+	buffer.WriteString("}\n")
+	l.sink.lock.Lock()
+	defer l.sink.lock.Unlock()
+	l.sink.out.Write(buffer.Bytes())
+}
+
+// writeValue writes the value encoded as JSON. Errors are written as their
+// message, and values that can't be encoded as their default format.
+func writeValue(buffer *bytes.Buffer, value interface{}) {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	buffer.Write(data)
+}
+
+// SetLevel changes the level of the default logger.
+func SetLevel(level Level) {
+	defaultLogger.SetLevel(level)
+}
+
+// Debugf writes a message with the debug level to the default logger.
+func Debugf(format string, args ...interface{}) {
+	defaultLogger.log(DebugLevel, format, args)
+}
+
+// Infof writes a message with the info level to the default logger.
+func Infof(format string, args ...interface{}) {
+	defaultLogger.log(InfoLevel, format, args)
+}
+
+// Warnf writes a message with the warn level to the default logger.
+func Warnf(format string, args ...interface{}) {
+	defaultLogger.log(WarnLevel, format, args)
+}
+
+// Errorf writes a message with the error level to the default logger.
+func Errorf(format string, args ...interface{}) {
+	defaultLogger.log(ErrorLevel, format, args)
+}
+
+// Fatalf writes a message with the fatal level to the default logger, and
+// then exits the process with code 1.
+func Fatalf(format string, args ...interface{}) {
+	defaultLogger.Fatalf(format, args...)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestLogger creates a logger that writes to the returned buffer, with a
+// fixed time.
+func newTestLogger(level Level) (*Logger, *bytes.Buffer) {
+	buffer := new(bytes.Buffer)
+	logger := New(buffer, level)
+	logger.sink.now = func() time.Time {
+		return time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	}
+	return logger, buffer
+}
+
+func TestLoggerWritesJSON(t *testing.T) {
+	logger, buffer := newTestLogger(InfoLevel)
+	logger.With("request_id", "abc").With("error", fmt.Errorf("failed")).Infof("Hello %s", "world")
+	expected := `{"time":"2018-07-01T10:00:00Z","level":"info","msg":"Hello world",` +
+		`"request_id":"abc","error":"failed"}` + "\n"
+	if buffer.String() != expected {
+		t.Errorf("expected '%s', got '%s'", expected, buffer.String())
+	}
+	var decoded map[string]interface{}
+	err := json.Unmarshal(buffer.Bytes(), &decoded)
+	if err != nil {
+		t.Errorf("expected valid JSON, got %v", err)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	logger, buffer := newTestLogger(WarnLevel)
+	child := logger.With("request_id", "abc")
+	child.Infof("hidden")
+	child.Debugf("hidden")
+	if buffer.Len() != 0 {
+		t.Errorf("expected messages below the level to be discarded, got '%s'", buffer.String())
+	}
+	logger.SetLevel(DebugLevel)
+	child.Debugf("shown")
+	if buffer.Len() == 0 {
+		t.Errorf("expected level change to apply to derived loggers")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+		valid bool
+	}{
+		{"debug", DebugLevel, true},
+		{"INFO", InfoLevel, true},
+		{"warning", WarnLevel, true},
+		{" error ", ErrorLevel, true},
+		{"fatal", 0, false},
+		{"verbose", 0, false},
+	}
+	for _, test := range tests {
+		level, err := ParseLevel(test.name)
+		if test.valid && (err != nil || level != test.level) {
+			t.Errorf("expected '%s' to be %s, got %s and %v", test.name, test.level, level, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected '%s' to be rejected", test.name)
+		}
+	}
+}
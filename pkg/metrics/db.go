@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+)
+
+// DBMetrics reports the statistics of database connection pools, by pool
+// name.
+type DBMetrics struct {
+	lock  sync.Mutex
+	pools map[string]*sql.DB
+}
+
+// NewDBMetrics creates and registers the metrics of the connection pools.
+// The pools are added with the Add method.
+func NewDBMetrics(registry *Registry) *DBMetrics {
+	m := new(DBMetrics)
+	m.pools = make(map[string]*sql.DB)
+	pool := []string{"pool"}
+	registry.NewGaugeFunc(
+		"db_connections",
+		"Number of connections of the database pool by state, 'in_use' or 'idle'.",
+		[]string{"pool", "state"},
+		func() []Sample {
+			var samples []Sample
+			m.each(func(name string, stats sql.DBStats) {
+				samples = append(samples,
+					Sample{Labels: []string{name, "in_use"}, Value: float64(stats.InUse)},
+					Sample{Labels: []string{name, "idle"}, Value: float64(stats.Idle)},
+				)
+			})
+			return samples
+		},
+	)
+	registry.NewGaugeFunc(
+		"db_max_open_connections",
+		"Maximum number of open connections of the database pool, zero if unlimited.",
+		pool,
+		m.collect(func(stats sql.DBStats) float64 { return float64(stats.MaxOpenConnections) }),
+	)
+	registry.NewCounterFunc(
+		"db_wait_count_total",
+		"Number of times that a connection of the database pool had to be waited for.",
+		pool,
+		m.collect(func(stats sql.DBStats) float64 { return float64(stats.WaitCount) }),
+	)
+	registry.NewCounterFunc(
+		"db_wait_duration_seconds_total",
+		"Total time spent waiting for connections of the database pool.",
+		pool,
+		m.collect(func(stats sql.DBStats) float64 { return stats.WaitDuration.Seconds() }),
+	)
+	return m
+}
+
+// Add adds a connection pool with the given name.
+func (m *DBMetrics) Add(name string, db *sql.DB) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pools[name] = db
+}
+
+// collect returns a function that calculates one value for each pool.
+func (m *DBMetrics) collect(value func(stats sql.DBStats) float64) func() []Sample {
+	return func() []Sample {
+		var samples []Sample
+		m.each(func(name string, stats sql.DBStats) {
+			samples = append(samples, Sample{Labels: []string{name}, Value: value(stats)})
+		})
+		return samples
+	}
+}
+
+// each calls the function with the statistics of each pool, sorted by name.
+func (m *DBMetrics) each(f func(name string, stats sql.DBStats)) {
+	m.lock.Lock()
+	names := make([]string, 0, len(m.pools))
+	for name := range m.pools {
+		names = append(names, name)
+	}
+	pools := make(map[string]*sql.DB, len(m.pools))
+	for name, db := range m.pools {
+		pools[name] = db
+	}
+	m.lock.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		f(name, pools[name].Stats())
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// UnmatchedRoute is the value of the route label of the requests that don't
+// match any route.
+const UnmatchedRoute = "unmatched"
+
+// knownMethods are the HTTP methods used as values of the method label. The
+// rest are reported as 'OTHER', so that the number of series is bounded.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// HTTPMetrics counts the HTTP requests and measures their duration, by
+// method, route and status code. The route is the path template of the
+// gorilla/mux route, for example '/api/clusters_mgmt/v1/clusters/{uuid}',
+// never the actual path, so that the number of series is bounded.
+type HTTPMetrics struct {
+	requests *CounterVec
+	duration *HistogramVec
+}
+
+// NewHTTPMetrics creates and registers the metrics of the HTTP requests.
+func NewHTTPMetrics(registry *Registry) *HTTPMetrics {
+	m := new(HTTPMetrics)
+	m.requests = registry.NewCounterVec(
+		"http_requests_total",
+		"Number of HTTP requests by method, route and status code.",
+		"method", "route", "code",
+	)
+	m.duration = registry.NewHistogramVec(
+		"http_request_duration_seconds",
+		"Duration of the HTTP requests by method, route and status code.",
+		nil,
+		"method", "route", "code",
+	)
+	return m
+}
+
+// Middleware wraps the handler so that its requests are measured. It is
+// suitable for the Use method of the gorilla/mux routers, which call it after
+// matching the route. Requests that don't match any route can be measured
+// wrapping the NotFoundHandler of the router.
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		method := r.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+		route := UnmatchedRoute
+		if current := mux.CurrentRoute(r); current != nil {
+			template, err := current.GetPathTemplate()
+			if err == nil {
+				route = template
+			}
+		}
+		code := strconv.Itoa(recorder.status)
+		m.requests.Inc(method, route, code)
+		m.duration.Observe(time.Since(start).Seconds(), method, route, code)
+	})
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.written {
+		r.status = status
+		r.written = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.written = true
+	return r.ResponseWriter.Write(data)
+}
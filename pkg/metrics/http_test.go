@@ -0,0 +1,53 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHTTPMetricsUseRouteTemplate(t *testing.T) {
+	registry := NewRegistry()
+	httpMetrics := NewHTTPMetrics(registry)
+	router := mux.NewRouter()
+	router.Use(httpMetrics.Middleware)
+	router.NotFoundHandler = httpMetrics.Middleware(http.NotFoundHandler())
+	router.HandleFunc("/clusters/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	for _, path := range []string{"/clusters/1", "/clusters/2", "/other"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/clusters/3", nil))
+	output := scrape(t, registry)
+	expected := []string{
+		`http_requests_total{method="GET",route="/clusters/{id}",code="202"} 2`,
+		`http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`http_requests_total{method="OTHER",route="/clusters/{id}",code="202"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/clusters/{id}",code="202"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected output to contain '%s', got:\n%s", line, output)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains a minimal implementation of the Prometheus
+// metrics used by the services, and serves them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default upper bounds of the buckets of histograms,
+// in seconds, suitable for the latency of requests.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Sample is a value of a metric calculated by a function, together with the
+// values of its labels.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Registry contains the metrics of a service, and serves them over HTTP.
+type Registry struct {
+	lock    sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is implemented by all the types of metrics.
+type metric interface {
+	// write writes the current values of the metric.
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	registry := new(Registry)
+	registry.names = make(map[string]bool)
+	return registry
+}
+
+// register adds a metric to the registry. It panics if the name is already
+// used, as that is a programming error.
+func (r *Registry) register(name string, m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric '%s' is already registered", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes the current values of all the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.lock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buffer := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffer)
+	}
+	buffer.Flush()
+}
+
+// desc contains the description of a metric, common to all the types.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// writeHeader writes the help and type lines of the metric.
+func (d *desc) writeHeader(w *bufio.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// writeSample writes a line with the value of the metric with the given
+// suffix, label values, and additional label.
+func (d *desc) writeSample(w *bufio.Writer, suffix string, values []string, extra string, value float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || extra != "" {
+		w.WriteString("{")
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString(extra)
+		}
+		w.WriteString("}")
+	}
+	w.WriteString(" ")
+	w.WriteString(formatValue(value))
+	w.WriteString("\n")
+}
+
+// checkValues panics if the number of label values doesn't match the labels
+// of the metric, as that is a programming error.
+func (d *desc) checkValues(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf(
+			"metric '%s' has %d labels, but %d values were given",
+			d.name, len(d.labels), len(values),
+		))
+	}
+}
+
+// seriesKey joins the label values so that they can be used as a map key.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of the series sorted, so that the output is
+// stable.
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// CounterVec is a counter partitioned by the values of its labels.
+type CounterVec struct {
+	desc
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounterVec creates and registers a counter with the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counter := new(CounterVec)
+	counter.desc = desc{name: name, help: help, kind: "counter", labels: labels}
+	counter.series = make(map[string]*counterSeries)
+	r.register(name, counter)
+	return counter
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds the given amount, that can't be negative, to the counter with
+// the given label values.
+func (c *CounterVec) Add(amount float64, values ...string) {
+	c.checkValues(values)
+	if amount < 0 {
+		panic(fmt.Sprintf("counter '%s' can't be decreased", c.name))
+	}
+	key := seriesKey(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	series, ok := c.series[key]
+	if !ok {
+		series = &counterSeries{values: values}
+		c.series[key] = series
+	}
+	series.value += amount
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		series := c.series[key]
+		c.writeSample(w, "", series.values, "", series.value)
+	}
+}
+
+// HistogramVec is a histogram partitioned by the values of its labels.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram with the given bucket
+// upper bounds, in increasing order, and labels. If the buckets are nil the
+// default buckets are used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of histogram '%s' aren't sorted", name))
+	}
+	histogram := new(HistogramVec)
+	histogram.desc = desc{name: name, help: help, kind: "histogram", labels: labels}
+	histogram.buckets = buckets
+	histogram.series = make(map[string]*histogramSeries)
+	r.register(name, histogram)
+	return histogram
+}
+
+// Observe adds a value to the histogram with the given label values.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.checkValues(values)
+	key := seriesKey(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	index := sort.SearchFloat64s(h.buckets, value)
+	if index < len(series.counts) {
+		series.counts[index]++
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		series := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			le := fmt.Sprintf("le=\"%s\"", formatValue(bound))
+			h.writeSample(w, "_bucket", series.values, le, float64(cumulative))
+		}
+		h.writeSample(w, "_bucket", series.values, `le="+Inf"`, float64(series.count))
+		h.writeSample(w, "_sum", series.values, "", series.sum)
+		h.writeSample(w, "_count", series.values, "", float64(series.count))
+	}
+}
+
+// funcMetric is a gauge or counter whose samples are calculated by a
+// function each time that the metrics are collected.
+type funcMetric struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers a gauge whose samples are returned by the given
+// function each time that the metrics are collected. The function must be
+// safe to call concurrently.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	r.newFunc("gauge", name, help, labels, collect)
+}
+
+// NewCounterFunc is like NewGaugeFunc, but for values that never decrease,
+// like the totals kept by other packages.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func() []Sample) {
+	r.newFunc("counter", name, help, labels, collect)
+}
+
+func (r *Registry) newFunc(kind, name, help string, labels []string, collect func() []Sample) {
+	m := new(funcMetric)
+	m.desc = desc{name: name, help: help, kind: kind, labels: labels}
+	m.collect = collect
+	r.register(name, m)
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	for _, sample := range m.collect() {
+		m.checkValues(sample.Labels)
+		m.writeSample(w, "", sample.Labels, "", sample.Value)
+	}
+}
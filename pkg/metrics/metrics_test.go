@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the metrics served by the registry.
+func scrape(t *testing.T, registry *Registry) string {
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCounterVec(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Number of requests.", "method")
+	counter.Inc("GET")
+	counter.Add(2, "GET")
+	counter.Inc("POST")
+	expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET"} 3
+requests_total{method="POST"} 1
+`
+	if actual := scrape(t, registry); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogramVec("duration_seconds", "Duration.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.1)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	expected := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 2
+duration_seconds_bucket{le="1"} 3
+duration_seconds_bucket{le="+Inf"} 4
+duration_seconds_sum 2.65
+duration_seconds_count 4
+`
+	if actual := scrape(t, registry); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestGaugeFuncEscapesLabels(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGaugeFunc("items", "Number of items.", []string{"name"}, func() []Sample {
+		return []Sample{{Labels: []string{"a \"b\"\n"}, Value: 7}}
+	})
+	expected := `items{name="a \"b\"\n"} 7`
+	if actual := scrape(t, registry); !strings.Contains(actual, expected) {
+		t.Errorf("expected output to contain '%s', got:\n%s", expected, actual)
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("requests_total", "Number of requests.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected duplicated metric to panic")
+		}
+	}()
+	registry.NewCounterVec("requests_total", "Number of requests.")
+}
@@ -0,0 +1,50 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+)
+
+// StoreMetrics measures the duration of the operations of the datastores, by
+// backend, operation and result.
+type StoreMetrics struct {
+	duration *HistogramVec
+}
+
+// NewStoreMetrics creates and registers the metrics of the datastores.
+func NewStoreMetrics(registry *Registry) *StoreMetrics {
+	m := new(StoreMetrics)
+	m.duration = registry.NewHistogramVec(
+		"store_operation_duration_seconds",
+		"Duration of the datastore operations by backend, operation and result.",
+		nil,
+		"backend", "operation", "result",
+	)
+	return m
+}
+
+// Observe records an operation of the given backend that started at the
+// given time. The result is 'error' if the error isn't nil, and 'success'
+// otherwise.
+func (m *StoreMetrics) Observe(backend, operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.duration.Observe(time.Since(start).Seconds(), backend, operation, result)
+}
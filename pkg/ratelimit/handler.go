@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/api"
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+	"github.com/gorilla/mux"
+)
+
+// Config describes the limits applied to the requests.
+type Config struct {
+	// Read is the limit of the requests that use the GET, HEAD and OPTIONS
+	// methods, nil if they aren't limited.
+	Read *Limit
+
+	// Write is the limit of the requests that use other methods, nil if
+	// they aren't limited.
+	Write *Limit
+
+	// Routes contains limits that replace the read and write limits for
+	// specific routes, indexed by the method and the path template of the
+	// route, for example 'POST /api/clusters_mgmt/v1/clusters', with the
+	// method in upper case and a single space. A nil limit
+	// means that the route isn't limited.
+	Routes map[string]*Limit
+
+	// Address is the limit of all the requests sent from each address, nil
+	// if they aren't limited. It is applied by AddressMiddleware, before the
+	// authentication, so that requests with invalid credentials are also
+	// limited.
+	Address *Limit
+
+	// TrustForwardedFor indicates if the address of the client should be
+	// taken from the last entry of the X-Forwarded-For header, which is
+	// only safe when the service is behind a proxy that sets it.
+	TrustForwardedFor bool
+}
+
+// ParseRoutes parses route limits with the form 'METHOD TEMPLATE=LIMIT', for
+// example 'POST /api/clusters_mgmt/v1/clusters=10/m', as used in the Routes
+// field of the configuration.
+func ParseRoutes(specs []string) (map[string]*Limit, error) {
+	routes := make(map[string]*Limit)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		index := strings.LastIndex(spec, "=")
+		if index < 0 {
+			return nil, fmt.Errorf("route limit '%s' doesn't have the form 'METHOD TEMPLATE=LIMIT'", spec)
+		}
+		fields := strings.Fields(spec[:index])
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("route limit '%s' doesn't have the form 'METHOD TEMPLATE=LIMIT'", spec)
+		}
+		limit, err := ParseLimit(spec[index+1:])
+		if err != nil {
+			return nil, err
+		}
+		routes[routeKey(fields[0], fields[1])] = limit
+	}
+	return routes, nil
+}
+
+// limiters contains the limiters for each of the limits of a configuration.
+type limiters struct {
+	read              *Limiter
+	write             *Limiter
+	routes            map[string]*Limiter
+	address           *Limiter
+	trustForwardedFor bool
+}
+
+func newLimiters(config Config) *limiters {
+	result := new(limiters)
+	result.read = newOptionalLimiter(config.Read)
+	result.write = newOptionalLimiter(config.Write)
+	result.routes = make(map[string]*Limiter)
+	for route, limit := range config.Routes {
+		result.routes[route] = newOptionalLimiter(limit)
+	}
+	result.address = newOptionalLimiter(config.Address)
+	result.trustForwardedFor = config.TrustForwardedFor
+	return result
+}
+
+func newOptionalLimiter(limit *Limit) *Limiter {
+	if limit == nil {
+		return nil
+	}
+	return NewLimiter(*limit)
+}
+
+// find returns the limiter for the request, or nil if it isn't limited.
+func (l *limiters) find(r *http.Request) *Limiter {
+	if route := mux.CurrentRoute(r); route != nil {
+		template, err := route.GetPathTemplate()
+		if err == nil {
+			if limiter, ok := l.routes[routeKey(r.Method, template)]; ok {
+				return limiter
+			}
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return l.read
+	default:
+		return l.write
+	}
+}
+
+// client returns the key that identifies the client that sent the request:
+// the subject of the caller if the request has been authenticated, or the
+// address of the client otherwise.
+func (l *limiters) client(r *http.Request) string {
+	if identity := auth.IdentityFromContext(r.Context()); identity != nil {
+		return "subject:" + identity.Subject
+	}
+	return l.clientAddress(r)
+}
+
+// clientAddress returns the key that identifies the address of the client
+// that sent the request.
+func (l *limiters) clientAddress(r *http.Request) string {
+	if l.trustForwardedFor {
+		entries := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		address := strings.TrimSpace(entries[len(entries)-1])
+		if address != "" {
+			return "address:" + address
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "address:" + host
+}
+
+func routeKey(method string, template string) string {
+	return strings.ToUpper(method) + " " + template
+}
+
+// Handler is an HTTP handler that limits the rate of the requests of each
+// client before passing them to the next handler. Requests that exceed the
+// limit are rejected with status 429. Responses to limited requests contain
+// the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// rejected requests also the Retry-After header. Clients are identified by
+// the subject of the caller, so the handler should be used after the
+// authentication handler, or by the address of the client if there is no
+// identity.
+type Handler struct {
+	limiters *limiters
+	next     http.Handler
+}
+
+// NewHandler creates a handler that applies the limits of the given
+// configuration and then calls the next handler.
+func NewHandler(config Config, next http.Handler) *Handler {
+	handler := new(Handler)
+	handler.limiters = newLimiters(config)
+	handler.next = next
+	return handler
+}
+
+// Middleware returns a function that wraps handlers with a rate limiting
+// handler using the given configuration, suitable for the Use method of the
+// gorilla/mux routers. All the wrapped handlers share the same buckets.
+func Middleware(config Config) func(http.Handler) http.Handler {
+	shared := newLimiters(config)
+	return func(next http.Handler) http.Handler {
+		return &Handler{limiters: shared, next: next}
+	}
+}
+
+// AddressMiddleware returns a function that wraps handlers with a handler that
+// applies the address limit of the given configuration, suitable for the Use
+// method of the gorilla/mux routers. Clients are always identified by their
+// address, so it should be used before the authentication handler, to reject
+// floods of requests before checking their credentials.
+func AddressMiddleware(config Config) func(http.Handler) http.Handler {
+	shared := newLimiters(config)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter := shared.address
+			if limiter == nil || allow(w, limiter, shared.clientAddress(r)) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limiter := h.limiters.find(r)
+	if limiter == nil || allow(w, limiter, h.limiters.client(r)) {
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// allow takes a token from the bucket of the given client and adds the rate
+// limit headers to the response. If there is no token available it writes
+// the error response and returns false.
+func allow(w http.ResponseWriter, limiter *Limiter, client string) bool {
+	result := limiter.Take(client)
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	if !result.Allowed {
+		retryAfter := seconds(result.RetryAfter)
+		header.Set("Retry-After", strconv.Itoa(retryAfter))
+		api.WriteErrorf(w, http.StatusTooManyRequests, "too many requests, retry after %d seconds", retryAfter)
+		return false
+	}
+	return true
+}
+
+// seconds rounds the duration up to whole seconds, as used in the headers.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
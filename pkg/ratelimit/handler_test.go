@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/auth"
+)
+
+func TestHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	middleware := Middleware(Config{
+		Read:  &Limit{Rate: 1, Burst: 2},
+		Write: &Limit{Rate: 1, Burst: 1},
+	})
+	first := middleware(next)
+	second := middleware(next)
+
+	send := func(handler http.Handler, method string, subject string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/api/clusters_mgmt/v1/clusters", nil)
+		if subject != "" {
+			identity := &auth.Identity{Subject: subject}
+			request = request.WithContext(auth.ContextWithIdentity(request.Context(), identity))
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// Handlers created by the same middleware share the buckets:
+	recorder := send(first, "GET", "user-1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("RateLimit-Limit") != "2" || recorder.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("expected limit 2 and 1 remaining, got headers %v", recorder.Header())
+	}
+	send(second, "GET", "user-1")
+	recorder = send(first, "GET", "user-1")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After of 1 second, got '%s'", recorder.Header().Get("Retry-After"))
+	}
+
+	// Writes and other clients have separate buckets:
+	recorder = send(first, "POST", "user-1")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected write to be allowed, got %d", recorder.Code)
+	}
+	recorder = send(first, "GET", "user-2")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected other caller to be allowed, got %d", recorder.Code)
+	}
+	recorder = send(first, "GET", "")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected anonymous caller to be allowed, got %d", recorder.Code)
+	}
+}
+
+func TestHandlerClientAddress(t *testing.T) {
+	limits := newLimiters(Config{})
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "192.168.0.1, 172.16.0.1")
+	if client := limits.client(request); client != "address:10.0.0.1" {
+		t.Errorf("expected address of the connection, got '%s'", client)
+	}
+	limits.trustForwardedFor = true
+	if client := limits.client(request); client != "address:172.16.0.1" {
+		t.Errorf("expected last forwarded address, got '%s'", client)
+	}
+}
+
+func TestAddressMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := AddressMiddleware(Config{
+		Read:    &Limit{Rate: 1, Burst: 10},
+		Address: &Limit{Rate: 1, Burst: 2},
+	})(next)
+
+	send := func(address string, subject string) int {
+		request := httptest.NewRequest("GET", "/api/clusters_mgmt/v1/clusters", nil)
+		request.RemoteAddr = address + ":1234"
+		identity := &auth.Identity{Subject: subject}
+		request = request.WithContext(auth.ContextWithIdentity(request.Context(), identity))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	// The requests of the same address share the bucket, whatever the
+	// identity of the caller:
+	for i, subject := range []string{"user-1", "user-2"} {
+		if code := send("10.0.0.1", subject); code != http.StatusOK {
+			t.Fatalf("expected request %d to be allowed, got %d", i, code)
+		}
+	}
+	if code := send("10.0.0.1", "user-3"); code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", code)
+	}
+	if code := send("10.0.0.2", "user-1"); code != http.StatusOK {
+		t.Errorf("expected other address to be allowed, got %d", code)
+	}
+
+	// Without address limit the requests aren't limited:
+	handler = AddressMiddleware(Config{Read: &Limit{Rate: 1, Burst: 1}})(next)
+	for i := 0; i < 3; i++ {
+		if code := send("10.0.0.1", "user-1"); code != http.StatusOK {
+			t.Fatalf("expected request %d to be allowed, got %d", i, code)
+		}
+	}
+}
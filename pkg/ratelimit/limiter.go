@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit limits the rate of the requests that each client can send
+// to the REST APIs, using token buckets.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the minimum time between two removals of the buckets of
+// clients that haven't sent requests recently.
+const sweepInterval = time.Minute
+
+// Limit is the rate and the burst size of a token bucket.
+type Limit struct {
+	// Rate is the number of tokens added to the bucket per second.
+	Rate float64
+
+	// Burst is the capacity of the bucket, the maximum number of requests
+	// that can be sent at once.
+	Burst int
+}
+
+// ParseLimit parses a limit with the form 'requests/unit' or
+// 'requests/unit:burst', where the unit is 's', 'm' or 'h', for example
+// '10/s' or '600/m:20'. When the burst isn't given it is the number of
+// requests. An empty text or 'none' means that there is no limit, and then it
+// returns nil.
+func ParseLimit(text string) (*Limit, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || text == "none" {
+		return nil, nil
+	}
+	rate := text
+	burst := ""
+	if index := strings.Index(text, ":"); index >= 0 {
+		rate = text[:index]
+		burst = text[index+1:]
+	}
+	parts := strings.Split(rate, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("limit '%s' doesn't have the form 'requests/unit'", text)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		return nil, fmt.Errorf("number of requests of limit '%s' must be a positive integer", text)
+	}
+	var unit time.Duration
+	switch parts[1] {
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	default:
+		return nil, fmt.Errorf("unit of limit '%s' must be 's', 'm' or 'h'", text)
+	}
+	limit := &Limit{
+		Rate:  float64(requests) / unit.Seconds(),
+		Burst: requests,
+	}
+	if burst != "" {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return nil, fmt.Errorf("burst of limit '%s' must be a positive integer", text)
+		}
+	}
+	return limit, nil
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	// Allowed indicates if there was a token available.
+	Allowed bool
+
+	// Limit is the capacity of the bucket.
+	Limit int
+
+	// Remaining is the number of tokens left in the bucket.
+	Remaining int
+
+	// Reset is the time till the bucket is full again.
+	Reset time.Duration
+
+	// RetryAfter is the time till the next token is available, zero if the
+	// request was allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter keeps a token bucket for each client.
+type Limiter struct {
+	limit Limit
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter creates a limiter that gives each client a bucket with the given
+// limit.
+func NewLimiter(limit Limit) *Limiter {
+	limiter := new(Limiter)
+	limiter.limit = limit
+	limiter.now = time.Now
+	limiter.buckets = make(map[string]*bucket)
+	return limiter
+}
+
+// Take takes a token from the bucket of the given client, if there is one
+// available.
+func (l *Limiter) Take(key string) Result {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	burst := float64(l.limit.Burst)
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*l.limit.Rate)
+	b.updated = now
+	result := Result{
+		Limit: l.limit.Burst,
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.duration(1 - b.tokens)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = l.duration(burst - b.tokens)
+	return result
+}
+
+// sweep removes the buckets that are full, as they are equivalent to new
+// buckets, so that clients that stop sending requests don't use memory.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	burst := float64(l.limit.Burst)
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.limit.Rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// duration returns the time needed to add the given number of tokens to a
+// bucket.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
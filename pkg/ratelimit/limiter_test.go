@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		text  string
+		rate  float64
+		burst int
+	}{
+		{"10/s", 10, 10},
+		{"600/m", 10, 600},
+		{"600/m:20", 10, 20},
+		{"3600/h:1", 1, 1},
+	}
+	for _, test := range tests {
+		limit, err := ParseLimit(test.text)
+		if err != nil {
+			t.Errorf("can't parse limit '%s': %v", test.text, err)
+			continue
+		}
+		if limit.Rate != test.rate || limit.Burst != test.burst {
+			t.Errorf("expected limit '%s' to have rate %v and burst %d, got %+v",
+				test.text, test.rate, test.burst, limit)
+		}
+	}
+	for _, text := range []string{"", "none"} {
+		limit, err := ParseLimit(text)
+		if err != nil || limit != nil {
+			t.Errorf("expected '%s' to mean no limit, got %+v and %v", text, limit, err)
+		}
+	}
+	for _, text := range []string{"10", "10/d", "0/s", "-1/s", "x/s", "10/s:0", "10/s:x"} {
+		_, err := ParseLimit(text)
+		if err == nil {
+			t.Errorf("expected limit '%s' to be rejected", text)
+		}
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes([]string{
+		"post /api/clusters_mgmt/v1/clusters=10/m",
+		"GET /api/clusters_mgmt/v1/clusters/{uuid}=none",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, ok := routes["POST /api/clusters_mgmt/v1/clusters"]
+	if !ok || limit == nil || limit.Burst != 10 {
+		t.Errorf("expected limit of 10 requests for cluster creation, got %+v", limit)
+	}
+	limit, ok = routes["GET /api/clusters_mgmt/v1/clusters/{uuid}"]
+	if !ok || limit != nil {
+		t.Errorf("expected no limit for cluster retrieval, got %+v", limit)
+	}
+	_, err = ParseRoutes([]string{"/api/clusters_mgmt/v1/clusters=10/m"})
+	if err == nil {
+		t.Errorf("expected route without method to be rejected")
+	}
+}
+
+func TestLimiterTake(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 2})
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		result := limiter.Take("client")
+		if !result.Allowed {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+		if result.Remaining != 1-i {
+			t.Errorf("expected %d remaining requests, got %d", 1-i, result.Remaining)
+		}
+	}
+	result := limiter.Take("client")
+	if result.Allowed {
+		t.Fatalf("expected request exceeding the burst to be rejected")
+	}
+	if result.RetryAfter != time.Second || result.Reset != 2*time.Second {
+		t.Errorf("expected retry after 1s and reset after 2s, got %v and %v", result.RetryAfter, result.Reset)
+	}
+
+	// Other clients have their own buckets:
+	if !limiter.Take("other").Allowed {
+		t.Errorf("expected request of other client to be allowed")
+	}
+
+	// Tokens are added at the rate of the limit:
+	now = now.Add(time.Second)
+	if !limiter.Take("client").Allowed {
+		t.Errorf("expected request to be allowed after a second")
+	}
+	if limiter.Take("client").Allowed {
+		t.Errorf("expected only one token to be added after a second")
+	}
+}
+
+func TestLimiterRemovesIdleBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 1})
+	limiter.now = func() time.Time { return now }
+	limiter.Take("first")
+	now = now.Add(sweepInterval)
+	limiter.Take("second")
+	if _, ok := limiter.buckets["first"]; ok {
+		t.Errorf("expected bucket of idle client to be removed")
+	}
+	if _, ok := limiter.buckets["second"]; !ok {
+		t.Errorf("expected bucket of active client to be kept")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// DefaultHookTimeout is the maximum time that a shutdown hook is given to
+// finish, if it is added without its own timeout.
+const DefaultHookTimeout = 10 * time.Second
+
+// ShutdownHooks is an ordered registry of the functions that release the
+// resources of the components of the process when it stops, like flushing
+// pending data or closing connection pools.
+type ShutdownHooks struct {
+	lock  sync.Mutex
+	hooks []shutdownHook
+	ran   bool
+}
+
+// shutdownHook is a function registered in the shutdown hooks, with the name
+// used in the log messages.
+type shutdownHook struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// NewShutdownHooks creates an empty registry of shutdown hooks.
+func NewShutdownHooks() *ShutdownHooks {
+	return new(ShutdownHooks)
+}
+
+// Add registers a function that is called when the process stops. The
+// context given to the function is canceled after the timeout, or after
+// DefaultHookTimeout if it is zero. Hooks run in the reverse order that they
+// were added, like deferred calls, so a component is stopped before the
+// components that were created before it, and that it may use.
+func (h *ShutdownHooks) Add(name string, timeout time.Duration, run func(ctx context.Context) error) {
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hooks = append(h.hooks, shutdownHook{name: name, timeout: timeout, run: run})
+}
+
+// Run calls the hooks, one at a time. A hook that doesn't return within its
+// timeout is abandoned and the next one is called. All the hooks are called
+// even if some fail, and the errors are returned together. Hooks run only
+// once, calling Run again does nothing.
+func (h *ShutdownHooks) Run() error {
+	h.lock.Lock()
+	if h.ran {
+		h.lock.Unlock()
+		return nil
+	}
+	h.ran = true
+	hooks := h.hooks
+	h.lock.Unlock()
+	var problems []string
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		logging.Debugf("Running shutdown hook '%s'.", hook.name)
+		err := hook.call()
+		if err != nil {
+			logging.Warnf("Shutdown hook '%s' failed: %v", hook.name, err)
+			problems = append(problems, fmt.Sprintf("%s: %v", hook.name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("shutdown failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// call runs the hook in a separate goroutine, so that it can be abandoned
+// if it ignores the cancellation of its context.
+func (hook shutdownHook) call() error {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- hook.run(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("didn't finish within %s", hook.timeout)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownHooksOrder(t *testing.T) {
+	var calls []string
+	hooks := NewShutdownHooks()
+	for _, name := range []string{"database", "tracer", "server"} {
+		name := name
+		hooks.Add(name, 0, func(ctx context.Context) error {
+			calls = append(calls, name)
+			return nil
+		})
+	}
+	err := hooks.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "server,tracer,database" {
+		t.Errorf("expected hooks in reverse order, got %v", calls)
+	}
+	err = hooks.Run()
+	if err != nil || len(calls) != 3 {
+		t.Errorf("expected hooks to run only once, got %v", calls)
+	}
+}
+
+func TestShutdownHooksTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	called := false
+	hooks := NewShutdownHooks()
+	hooks.Add("first", 0, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	hooks.Add("stuck", 50*time.Millisecond, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	hooks.Add("failing", 0, func(ctx context.Context) error {
+		return fmt.Errorf("broken")
+	})
+	start := time.Now()
+	err := hooks.Run()
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the stuck hook to be abandoned")
+	}
+	if !called {
+		t.Errorf("expected the hooks after the stuck one to run")
+	}
+	if err == nil || !strings.Contains(err.Error(), "stuck: didn't finish") ||
+		!strings.Contains(err.Error(), "failing: broken") {
+		t.Errorf("expected all the errors, got %v", err)
+	}
+}
+
+func TestShutdownHookContextDeadline(t *testing.T) {
+	hooks := NewShutdownHooks()
+	var deadline time.Time
+	hooks.Add("test", time.Minute, func(ctx context.Context) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	})
+	hooks.Run()
+	remaining := time.Until(deadline)
+	if remaining < 50*time.Second || remaining > time.Minute {
+		t.Errorf("expected a deadline in about a minute, got %s", remaining)
+	}
+}
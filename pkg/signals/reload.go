@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// Reloader calls the functions registered by the components of the process
+// when it receives SIGHUP, so that they reload their configuration or their
+// certificates without restarting.
+type Reloader struct {
+	lock    sync.Mutex
+	reloads []reload
+}
+
+// reload is a function registered in the reloader, with the name used in the
+// log messages.
+type reload struct {
+	name string
+	run  func() error
+}
+
+// NewReloader creates a reloader without functions.
+func NewReloader() *Reloader {
+	return new(Reloader)
+}
+
+// Add registers a function that is called each time the process receives
+// SIGHUP. If it fails the component should keep using its previous
+// configuration.
+func (r *Reloader) Add(name string, run func() error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.reloads = append(r.reloads, reload{name: name, run: run})
+}
+
+// Reload calls the registered functions in the order that they were added.
+// All of them are called even if some fail, and the errors are logged and
+// returned together.
+func (r *Reloader) Reload() error {
+	r.lock.Lock()
+	reloads := make([]reload, len(r.reloads))
+	copy(reloads, r.reloads)
+	r.lock.Unlock()
+	var problems []string
+	for _, reload := range reloads {
+		err := reload.run()
+		if err != nil {
+			logging.Errorf("Can't reload %s: %v", reload.name, err)
+			problems = append(problems, fmt.Sprintf("%s: %v", reload.name, err))
+			continue
+		}
+		logging.Infof("Reloaded %s.", reload.name)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("reload failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Run calls Reload each time the process receives SIGHUP, till the context
+// is done.
+func (r *Reloader) Run(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+	r.runOn(ctx, c)
+}
+
+func (r *Reloader) runOn(ctx context.Context, c <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			logging.Infof("Reload signal received.")
+			r.Reload()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadCallsAll(t *testing.T) {
+	var calls []string
+	reloader := NewReloader()
+	reloader.Add("configuration", func() error {
+		calls = append(calls, "configuration")
+		return fmt.Errorf("invalid file")
+	})
+	reloader.Add("certificates", func() error {
+		calls = append(calls, "certificates")
+		return nil
+	})
+	err := reloader.Reload()
+	if strings.Join(calls, ",") != "configuration,certificates" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+	if err == nil || !strings.Contains(err.Error(), "configuration: invalid file") {
+		t.Errorf("expected the error of the failed reload, got %v", err)
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	reloaded := make(chan struct{}, 1)
+	reloader := NewReloader()
+	reloader.Add("test", func() error {
+		reloaded <- struct{}{}
+		return nil
+	})
+	c := make(chan os.Signal, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.runOn(ctx, c)
+		close(done)
+	}()
+	c <- syscall.SIGHUP
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected reload after the signal")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the reloader to stop with the context")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/container-mgmt/dedicated-portal/pkg/tlsconfig"
+)
+
+// DefaultShutdownTimeout is the maximum time that the requests in progress
+// are given to finish when the server stops, if no other is configured.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// ServeConfig describes how a server is stopped.
+type ServeConfig struct {
+	// DrainDelay is the time that the server keeps accepting connections
+	// after the stop signal, so that the load balancers notice that it
+	// isn't ready and stop sending it new requests.
+	DrainDelay time.Duration
+
+	// ShutdownTimeout is the maximum time that the requests in progress
+	// are given to finish after the server stops accepting connections. If
+	// zero DefaultShutdownTimeout is used.
+	ShutdownTimeout time.Duration
+}
+
+// Serve listens on the address of the server and serves requests, using TLS
+// if the server has a TLS configuration, until the stop channel is closed.
+// Errors listening on the address are returned immediately. When the stop
+// channel is closed the server keeps serving during the drain delay, then
+// stops accepting connections and waits for the requests in progress to
+// finish. It returns an error if the server fails before the stop signal, or
+// if the requests don't finish within the shutdown timeout, in which case
+// their connections are closed.
+func Serve(stopCh <-chan struct{}, server *http.Server, config ServeConfig) error {
+	address := server.Addr
+	if address == "" {
+		address = ":http"
+		if server.TLSConfig != nil {
+			address = ":https"
+		}
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- tlsconfig.Serve(server, listener)
+	}()
+	logging.Infof("Listening on '%s'.", listener.Addr())
+
+	// Wait for the stop signal and then for the drain delay, unless the
+	// server fails before:
+	select {
+	case err = <-served:
+		return fmt.Errorf("can't serve: %v", err)
+	case <-stopCh:
+	}
+	if config.DrainDelay > 0 {
+		logging.Infof("Stop signal received, waiting %s for requests to drain.", config.DrainDelay)
+		select {
+		case err = <-served:
+			return fmt.Errorf("can't serve: %v", err)
+		case <-time.After(config.DrainDelay):
+		}
+	}
+
+	// Stop accepting connections and wait for the requests in progress:
+	timeout := config.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	logging.Infof("Shutting down, waiting up to %s for requests in progress.", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+		return fmt.Errorf("requests didn't finish within %s: %v", timeout, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddress returns an address where nothing is listening.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestServeReportsBindErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	server := &http.Server{Addr: listener.Addr().String()}
+	result := make(chan error, 1)
+	go func() {
+		result <- Serve(make(chan struct{}), server, ServeConfig{})
+	}()
+	select {
+	case err = <-result:
+		if err == nil {
+			t.Errorf("expected an error listening on a used address")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Serve to return immediately")
+	}
+}
+
+func TestServeFinishesRequestsInProgress(t *testing.T) {
+	started := make(chan struct{})
+	server := &http.Server{
+		Addr: freeAddress(t),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+	}
+	stopCh := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- Serve(stopCh, server, ServeConfig{ShutdownTimeout: 5 * time.Second})
+	}()
+
+	// Send a request, retrying until the server is listening, and stop the
+	// server while the request is in progress:
+	body := make(chan string, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			response, err := http.Get("http://" + server.Addr)
+			if err != nil {
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			data, _ := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			body <- string(data)
+			return
+		}
+		body <- ""
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected request to start")
+	}
+	close(stopCh)
+	err := <-result
+	if err != nil {
+		t.Errorf("expected graceful shutdown, got %v", err)
+	}
+	if data := <-body; data != "done" {
+		t.Errorf("expected request in progress to finish, got '%s'", data)
+	}
+	_, err = http.Get("http://" + server.Addr)
+	if err == nil {
+		t.Errorf("expected server to stop accepting connections")
+	}
+}
+
+func TestServeTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	server := &http.Server{
+		Addr: freeAddress(t),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}),
+	}
+	stopCh := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- Serve(stopCh, server, ServeConfig{ShutdownTimeout: 100 * time.Millisecond})
+	}()
+	go func() {
+		for i := 0; i < 50; i++ {
+			response, err := http.Get("http://" + server.Addr)
+			if err == nil {
+				response.Body.Close()
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected request to start")
+	}
+	close(stopCh)
+	if err := <-result; err == nil {
+		t.Errorf("expected an error when requests don't finish in time")
+	}
+}
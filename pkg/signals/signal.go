@@ -17,29 +17,70 @@ limitations under the License.
 package signals
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
-var onlyOneSignalHandler = make(chan struct{})
+// stopSignals are the signals that stop the process gracefully.
+var stopSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
 
-// SetupHandler registered for SIGTERM and SIGINT. A stop channel is returned
-// which is closed on one of these signals. If a second signal is caught, the program
-// is terminated with exit code 1.
-//
+var (
+	setupOnce sync.Once
+	stopCh    chan struct{}
+)
+
+// exit is called when the second stop signal is received, replaced by the
+// tests.
+var exit = os.Exit
+
+// SetupHandler registers for SIGTERM and SIGINT. A stop channel is returned
+// which is closed on one of these signals. If a second signal is caught, the
+// program is terminated with exit code 1. The handler is registered only
+// once, all the calls return the same channel.
 func SetupHandler() (stopCh <-chan struct{}) {
-	close(onlyOneSignalHandler) // panics when called twice
+	return setup()
+}
+
+// Context returns a copy of the parent context that is canceled when the
+// process receives SIGTERM or SIGINT, using the same handler as
+// SetupHandler. Calling the returned cancel function releases the resources
+// of the context, but doesn't remove the handler.
+func Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return contextOn(parent, setup())
+}
 
-	stop := make(chan struct{})
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+func setup() chan struct{} {
+	setupOnce.Do(func() {
+		stopCh = make(chan struct{})
+		c := make(chan os.Signal, 2)
+		signal.Notify(c, stopSignals...)
+		go handleStop(c, stopCh)
+	})
+	return stopCh
+}
+
+// handleStop closes the stop channel on the first signal, and exits on the
+// second.
+func handleStop(c <-chan os.Signal, stop chan struct{}) {
+	<-c
+	close(stop)
+	<-c
+	exit(1) // Second signal, exit directly.
+}
+
+// contextOn returns a copy of the parent context that is canceled when the
+// stop channel is closed.
+func contextOn(parent context.Context, stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
 	go func() {
-		<-c
-		close(stop)
-		<-c
-		os.Exit(1) // Second signal, exit directly.
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
-
-	return stop
+	return ctx, cancel
 }
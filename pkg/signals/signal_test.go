@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signals
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetupHandlerCanBeCalledTwice(t *testing.T) {
+	first := SetupHandler()
+	second := SetupHandler()
+	if first != second {
+		t.Errorf("expected the same stop channel")
+	}
+}
+
+func TestHandleStop(t *testing.T) {
+	codes := make(chan int, 1)
+	exit = func(code int) {
+		codes <- code
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+	c := make(chan os.Signal, 2)
+	stop := make(chan struct{})
+	go handleStop(c, stop)
+	c <- syscall.SIGTERM
+	select {
+	case <-stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the first signal to close the stop channel")
+	}
+	c <- syscall.SIGINT
+	select {
+	case code := <-codes:
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second signal to exit")
+	}
+}
+
+func TestContextOn(t *testing.T) {
+	stop := make(chan struct{})
+	ctx, cancel := contextOn(context.Background(), stop)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatalf("expected context not to be canceled before the signal")
+	}
+	close(stop)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected context to be canceled by the signal")
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/lib/pq"
+)
+
+// Settings of the connection pool used when the configuration doesn't give
+// others. The lifetime makes the pool open new connections from time to
+// time, so that they are spread across the replicas of the database after it
+// is scaled or fails over.
+const (
+	DefaultMaxOpenConns    = 20
+	DefaultMaxIdleConns    = 5
+	DefaultConnMaxLifetime = 30 * time.Minute
+)
+
+// Delays between the attempts to connect to the database, or to run an
+// operation that failed with a transient error. The delay is doubled after
+// each failed attempt, up to the maximum.
+const (
+	initialRetryDelay = 100 * time.Millisecond
+	maxRetryDelay     = 5 * time.Second
+)
+
+// retryAttempts is the number of times that Retry runs an operation that
+// fails with transient errors.
+const retryAttempts = 3
+
+// ConnectionConfig describes how to connect to a PostgreSQL database, and
+// the size of the connection pool.
+type ConnectionConfig struct {
+	// URL is a complete connection URL. If given the other connection
+	// settings are ignored, but the pool settings are still used.
+	URL string
+
+	Host     string
+	Port     int
+	Name     string
+	User     string
+	Password string
+
+	// SSLMode is the TLS mode of the connection, for example 'disable' or
+	// 'verify-full'. SSLRootCert is the file containing the certificates of
+	// the authorities that sign the certificate of the server. SSLCert and
+	// SSLKey are the files containing the client certificate and its key.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// ApplicationName identifies the service in the activity views and logs
+	// of the database server.
+	ApplicationName string
+
+	// ConnectTimeout is the maximum time waiting for each new connection. It
+	// is rounded up to whole seconds. If zero there is no timeout.
+	ConnectTimeout time.Duration
+
+	// Settings of the connection pool. If zero the defaults are used.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultConnectionConfig returns the configuration of a database on the
+// local host, without TLS.
+func DefaultConnectionConfig() ConnectionConfig {
+	return ConnectionConfig{
+		Host:            "localhost",
+		Port:            5432,
+		SSLMode:         "disable",
+		MaxOpenConns:    DefaultMaxOpenConns,
+		MaxIdleConns:    DefaultMaxIdleConns,
+		ConnMaxLifetime: DefaultConnMaxLifetime,
+	}
+}
+
+// ConnectionURL returns the URL of the database used when nothing else is
+// configured.
+func ConnectionURL() string {
+	config := DefaultConnectionConfig()
+	return config.DSN()
+}
+
+// DSN returns the connection URL built from the configuration. The settings
+// that aren't given are left out, so that the defaults of the driver apply.
+func (c *ConnectionConfig) DSN() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	query := url.Values{}
+	setIfNotEmpty(query, "sslmode", c.SSLMode)
+	setIfNotEmpty(query, "sslrootcert", c.SSLRootCert)
+	setIfNotEmpty(query, "sslcert", c.SSLCert)
+	setIfNotEmpty(query, "sslkey", c.SSLKey)
+	setIfNotEmpty(query, "application_name", c.ApplicationName)
+	if c.ConnectTimeout > 0 {
+		seconds := int(math.Ceil(c.ConnectTimeout.Seconds()))
+		query.Set("connect_timeout", strconv.Itoa(seconds))
+	}
+	result := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Name,
+		RawQuery: query.Encode(),
+	}
+	return result.String()
+}
+
+func setIfNotEmpty(query url.Values, name, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
+
+// Open creates the connection pool of the database. Like sql.Open it doesn't
+// connect to the database, use Connect to also wait till it is available.
+func Open(config ConnectionConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", config.DSN())
+	if err != nil {
+		return nil, err
+	}
+	maxOpen := config.MaxOpenConns
+	if maxOpen == 0 {
+		maxOpen = DefaultMaxOpenConns
+	}
+	maxIdle := config.MaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = DefaultMaxIdleConns
+	}
+	lifetime := config.ConnMaxLifetime
+	if lifetime == 0 {
+		lifetime = DefaultConnMaxLifetime
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(lifetime)
+	return db, nil
+}
+
+// Connect creates the connection pool of the database, and waits till the
+// database accepts connections or the context is done.
+func Connect(ctx context.Context, config ConnectionConfig) (*sql.DB, error) {
+	db, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+	err = waitFor(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// WaitForDatabase tries to connect to the database till it succeeds or the
+// context is done, waiting longer after each attempt that fails.
+func WaitForDatabase(ctx context.Context, connectionURL string) error {
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return waitFor(ctx, db)
+}
+
+func waitFor(ctx context.Context, db *sql.DB) error {
+	start := time.Now()
+	delay := initialRetryDelay
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		logging.Warnf("Can't connect to database, will retry in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database isn't available after %s: %v", time.Since(start).Round(time.Millisecond), err)
+		case <-time.After(delay):
+		}
+		delay = nextRetryDelay(delay)
+	}
+}
+
+// Retry runs the operation, and runs it again if it fails with a transient
+// error, up to three times in total. Only operations that can be safely
+// repeated, like queries or complete transactions that failed before the
+// commit, should be retried.
+func Retry(ctx context.Context, operation func() error) error {
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || attempt == retryAttempts || !IsTransient(err) {
+			return err
+		}
+		logging.Debugf("Transient database error, will retry in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = nextRetryDelay(delay)
+	}
+}
+
+// IsTransient checks if the error is one that can go away when the
+// operation is repeated: a broken connection, a database server that is
+// starting or shutting down or has too many connections, or a transaction
+// that was aborted because of a serialization failure or a deadlock.
+func IsTransient(err error) bool {
+	if err == driver.ErrBadConn {
+		return true
+	}
+	switch err := err.(type) {
+	case *pq.Error:
+		if err.Code.Class() == "08" {
+			return true
+		}
+		switch err.Code {
+		case "40001", "40P01", "53300", "57P01", "57P02", "57P03":
+			return true
+		}
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// nextRetryDelay returns the delay before the attempt that follows one made
+// after the given delay.
+func nextRetryDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,330 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/golang-migrate/migrate"
+)
+
+// DefaultWaitTimeout is the maximum time that NewMigrator waits for the
+// database to accept connections, when no other is given.
+const DefaultWaitTimeout = 2 * time.Minute
+
+// DefaultLockTimeout is the maximum time that the operations of the migrator
+// wait for other process that is running migrations, when no other is given.
+const DefaultLockTimeout = 5 * time.Minute
+
+// migrationsLockID is the key of the PostgreSQL advisory lock held while
+// migrations run, so that only one of the replicas of a service runs them.
+// Advisory locks are local to each database, so the same key can be used by
+// all the services.
+const migrationsLockID int64 = 4419387626045221734
+
+// ErrNoChange is returned by the operations of the migrator when there are
+// no migrations to apply or roll back.
+var ErrNoChange = migrate.ErrNoChange
+
+// DirtyError is returned when a previous migration failed, leaving the schema
+// in an unknown state. No other migration can run until the database is
+// fixed manually and its version is forced.
+type DirtyError struct {
+	Version uint
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf(
+		"migration to version %d failed and the schema is dirty, fix the database "+
+			"manually and then force the version to %d, or to the previous version "+
+			"if the changes of the migration were reverted",
+		e.Version, e.Version,
+	)
+}
+
+// MigratorConfig describes the migrations and the database where they are
+// applied.
+type MigratorConfig struct {
+	// FS is the file system containing the migrations in its root directory,
+	// usually embedded in the binary.
+	FS fs.FS
+
+	// Dir is a directory containing the migrations. If given it is used
+	// instead of the file system.
+	Dir string
+
+	// URL is the connection URL of the database.
+	URL string
+
+	// WaitTimeout is the maximum time waiting for the database to accept
+	// connections. If zero DefaultWaitTimeout is used.
+	WaitTimeout time.Duration
+
+	// LockTimeout is the maximum time waiting for other process that is
+	// running migrations to finish. If zero DefaultLockTimeout is used.
+	LockTimeout time.Duration
+}
+
+// Migrator applies and rolls back the migrations of the schema of a
+// database. The operations that change the schema hold an advisory lock, so
+// processes that run them at the same time, for example the replicas of a
+// service that start together, wait for each other instead of competing.
+type Migrator struct {
+	fsys        fs.FS
+	migrate     *migrate.Migrate
+	db          *sql.DB
+	lockTimeout time.Duration
+}
+
+// NewMigrator waits till the database accepts connections, and then creates
+// the migrator. It fails if the database isn't available before the wait
+// timeout or the end of the context.
+func NewMigrator(ctx context.Context, config MigratorConfig) (*Migrator, error) {
+	timeout := config.WaitTimeout
+	if timeout == 0 {
+		timeout = DefaultWaitTimeout
+	}
+	fsys := config.FS
+	if config.Dir != "" {
+		fsys = os.DirFS(config.Dir)
+	}
+	if fsys == nil {
+		return nil, fmt.Errorf("neither the migrations file system nor a directory are given")
+	}
+	src, err := newFSSource(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("can't load migrations: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = WaitForDatabase(ctx, config.URL)
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.NewWithSourceInstance("fs", src, config.URL)
+	if err != nil {
+		return nil, err
+	}
+	m.Log = migrateLogger{}
+	db, err := sql.Open("postgres", config.URL)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	lockTimeout := config.LockTimeout
+	if lockTimeout == 0 {
+		lockTimeout = DefaultLockTimeout
+	}
+	return &Migrator{
+		fsys:        fsys,
+		migrate:     m,
+		db:          db,
+		lockTimeout: lockTimeout,
+	}, nil
+}
+
+// Version returns the version of the schema, and whether the last migration
+// failed leaving it dirty. The version is zero if no migration has been
+// applied.
+func (m *Migrator) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = m.migrate.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+	return
+}
+
+// LatestVersion returns the version of the newest migration, or zero if there
+// are no migrations.
+func (m *Migrator) LatestVersion() (uint, error) {
+	return LatestVersionFS(m.fsys)
+}
+
+// CheckVersion checks that the schema isn't dirty, and that its version
+// isn't newer than the latest migration. A newer version means that the
+// database was migrated by a newer release of the binary, that this one may
+// not be compatible with.
+func (m *Migrator) CheckVersion() error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("can't get schema version: %v", err)
+	}
+	return m.checkVersion(version, dirty)
+}
+
+func (m *Migrator) checkVersion(version uint, dirty bool) error {
+	latest, err := m.LatestVersion()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return &DirtyError{Version: version}
+	}
+	if version > latest {
+		return fmt.Errorf(
+			"schema version %d is newer than the latest migration %d of this binary, "+
+				"update the binary or roll back the schema with the binary that migrated it",
+			version, latest,
+		)
+	}
+	return nil
+}
+
+// Up applies all the pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, m.migrate.Up)
+}
+
+// Down rolls back all the migrations.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, m.migrate.Down)
+}
+
+// Steps applies the given number of migrations, or rolls them back if the
+// number is negative.
+func (m *Migrator) Steps(ctx context.Context, n int) error {
+	return m.run(ctx, func() error {
+		return m.migrate.Steps(n)
+	})
+}
+
+// Goto applies or rolls back migrations till the schema has the given
+// version.
+func (m *Migrator) Goto(ctx context.Context, version uint) error {
+	return m.run(ctx, func() error {
+		return m.migrate.Migrate(version)
+	})
+}
+
+// Force sets the version of the schema and clears the dirty flag, without
+// running any migration. The version -1 means that no migration is applied.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	if version < -1 {
+		return fmt.Errorf("version %d isn't valid", version)
+	}
+	unlock, _, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return m.migrate.Force(version)
+}
+
+// Close releases the connections of the migrator.
+func (m *Migrator) Close() error {
+	sourceErr, databaseErr := m.migrate.Close()
+	dbErr := m.db.Close()
+	switch {
+	case sourceErr != nil:
+		return sourceErr
+	case databaseErr != nil:
+		return databaseErr
+	default:
+		return dbErr
+	}
+}
+
+// run runs an operation that changes the schema while holding the lock, if
+// the schema isn't dirty or newer than the migrations. When other process
+// held the lock the version is checked after acquiring it, so a migration
+// that failed there isn't retried here.
+func (m *Migrator) run(ctx context.Context, operation func() error) error {
+	unlock, waited, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	version, dirty, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("can't get schema version: %v", err)
+	}
+	if waited {
+		logging.Infof("Migrations finished in other process, schema version is %d, dirty %v", version, dirty)
+	}
+	err = m.checkVersion(version, dirty)
+	if err != nil {
+		return err
+	}
+	err = operation()
+	if dirty, ok := err.(migrate.ErrDirty); ok {
+		return &DirtyError{Version: uint(dirty.Version)}
+	}
+	return err
+}
+
+// lock acquires the advisory lock of the migrations, waiting for the process
+// that holds it till the lock timeout. The lock belongs to the database
+// session, so it is held in a dedicated connection. The second result
+// indicates if other process held the lock.
+func (m *Migrator) lock(ctx context.Context) (unlock func(), waited bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, m.lockTimeout)
+	defer cancel()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't connect to lock migrations: %v", err)
+	}
+	delay := initialRetryDelay
+	for {
+		var acquired bool
+		err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, migrationsLockID).Scan(&acquired)
+		if err != nil {
+			conn.Close()
+			return nil, false, fmt.Errorf("can't lock migrations: %v", err)
+		}
+		if acquired {
+			break
+		}
+		if !waited {
+			logging.Infof("Migrations are running in other process, waiting for them to finish")
+			waited = true
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, true, fmt.Errorf("migrations are still running in other process after %s", m.lockTimeout)
+		case <-time.After(delay):
+		}
+		delay = nextRetryDelay(delay)
+	}
+	unlock = func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationsLockID)
+		if err != nil {
+			logging.Warnf("Can't unlock migrations: %v", err)
+		}
+		conn.Close()
+	}
+	return unlock, waited, nil
+}
+
+// migrateLogger sends the messages of the migrations library to the debug
+// log.
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, args ...interface{}) {
+	logging.Debugf(strings.TrimSpace(format), args...)
+}
+
+func (migrateLogger) Verbose() bool {
+	return false
+}
@@ -0,0 +1,61 @@
+package sql
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextRetryDelay(t *testing.T) {
+	delay := initialRetryDelay
+	var delays []time.Duration
+	for i := 0; i < 8; i++ {
+		delay = nextRetryDelay(delay)
+		delays = append(delays, delay)
+	}
+	if delays[0] != 2*initialRetryDelay {
+		t.Errorf("expected the delay to double, got %s", delays[0])
+	}
+	if delays[len(delays)-1] != maxRetryDelay {
+		t.Errorf("expected the delay to be limited to %s, got %s", maxRetryDelay, delays[len(delays)-1])
+	}
+}
+
+func TestWaitForDatabaseDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := WaitForDatabase(ctx, "postgres://user@127.0.0.1:1/db?sslmode=disable")
+	if err == nil {
+		t.Fatal("expected an error as nothing listens on the port")
+	}
+	if !strings.Contains(err.Error(), "isn't available") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected to stop at the deadline, but waited %s", elapsed)
+	}
+}
+
+func TestNewMigratorWaitTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := NewMigrator(context.Background(), MigratorConfig{
+		Dir:         "migrations",
+		URL:         "postgres://user@127.0.0.1:1/db?sslmode=disable",
+		WaitTimeout: 200 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("expected an error as nothing listens on the port")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected to stop after the wait timeout, but waited %s", elapsed)
+	}
+}
+
+func TestDirtyError(t *testing.T) {
+	err := &DirtyError{Version: 1531224000}
+	if !strings.Contains(err.Error(), "1531224000") || !strings.Contains(err.Error(), "force") {
+		t.Errorf("expected the version and how to fix it in the message, got: %v", err)
+	}
+}
@@ -1,90 +1,84 @@
 package sql
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"time"
 
-	"github.com/golang-migrate/migrate"
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
 	// Register the migrate postgresl driver
 	_ "github.com/golang-migrate/migrate/database/postgres"
-	_ "github.com/golang-migrate/migrate/source/file"
 	_ "github.com/lib/pq"
 )
 
-// EnsureSchema makes sure our DB's schema matches that defined by schemaPath.
-// It migrates it if needed and returns error if it can't do so.
-func EnsureSchema(schemaPath, connectionUrl string) error {
-	waitForDatabase(connectionUrl)
-	m, err := migrate.New(
-		fmt.Sprintf("file:///%s", schemaPath),
-		connectionUrl,
-	)
+// EnsureSchema makes sure our DB's schema matches that defined by the
+// migrations. It waits for the database, migrates it if needed and returns
+// error if it can't do so. When other replica is already running the
+// migrations it waits for it to finish, and then checks the version of the
+// schema that it left.
+func EnsureSchema(ctx context.Context, config MigratorConfig) error {
+	migrator, err := NewMigrator(ctx, config)
 	if err != nil {
-		return fmt.Errorf("migrate.New: %v", err)
+		return err
 	}
-	defer m.Close()
-
-	err = outputVersion(m)
-	if err != nil {
-		return fmt.Errorf("outputVersion: %v", err)
-	}
-	runMigration(m)
+	defer migrator.Close()
+	err = outputVersion(migrator)
 	if err != nil {
-		return fmt.Errorf("migrate: %v", err)
+		return err
 	}
-	outputVersion(m)
-	if err != nil {
-		return fmt.Errorf("outputVersion: %v", err)
-	}
-	return nil
-}
-
-func waitForDatabase(connectionUrl string) {
-	for {
-		err := tryConnect(connectionUrl)
-		if err != nil {
-			fmt.Println("Could not connect: ", err)
-			time.Sleep(500 * time.Millisecond)
-		} else {
-			return
-		}
+	err = migrator.Up(ctx)
+	if err == ErrNoChange {
+		logging.Infof("Schema is already fully migrated")
+	} else if err != nil {
+		return err
+	} else {
+		logging.Infof("Schema migrated successfully")
 	}
+	return checkVersion(migrator)
 }
 
-func tryConnect(connectionURL string) error {
-	db, err := sql.Open("postgres", connectionURL)
-	defer db.Close()
+// checkVersion checks that the schema has the version of the latest
+// migration.
+func checkVersion(migrator *Migrator) error {
+	latest, err := migrator.LatestVersion()
 	if err != nil {
-		return fmt.Errorf(fmt.Sprintf("sql.Open: %v...", err))
+		return err
 	}
-	err = db.Ping()
+	version, dirty, err := migrator.Version()
 	if err != nil {
-		return fmt.Errorf(fmt.Sprintf("db.Ping: %v...", err))
+		return fmt.Errorf("can't get schema version: %v", err)
+	}
+	logging.Infof("Current schema version is %d, dirty %v", version, dirty)
+	if dirty {
+		return &DirtyError{Version: version}
+	}
+	if version < latest {
+		return fmt.Errorf("schema version %d is older than the latest migration %d", version, latest)
 	}
 	return nil
 }
 
-func outputVersion(m *migrate.Migrate) error {
-	ver, dirty, err := m.Version()
-	if err == migrate.ErrNilVersion {
-		fmt.Print("Current version [0], dirty [false]\n")
-	} else if err != nil {
-		return fmt.Errorf("m.Version: %v", err)
-	} else {
-		fmt.Printf("Current version [%v], dirty [%v]\n", ver, dirty)
+func outputVersion(migrator *Migrator) error {
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		return fmt.Errorf("can't get schema version: %v", err)
 	}
+	logging.Infof("Current schema version is %d, dirty %v", version, dirty)
 	return nil
 }
 
-func runMigration(m *migrate.Migrate) error {
-	err := m.Up()
-	if err == migrate.ErrNoChange {
-		fmt.Printf("Already fully migrated!\n")
-	} else if err != nil {
-		return fmt.Errorf("m.Up: %v", err)
-	} else {
-		fmt.Print("Migrated Successfully!\n")
+// CheckSchemaVersion checks, without migrating it, that the schema of the
+// database isn't dirty and that its version isn't newer than the latest
+// migration. It is used by the services when the migrations are applied by a
+// separate job.
+func CheckSchemaVersion(ctx context.Context, config MigratorConfig) error {
+	migrator, err := NewMigrator(ctx, config)
+	if err != nil {
+		return err
 	}
-	return nil
+	defer migrator.Close()
+	err = outputVersion(migrator)
+	if err != nil {
+		return err
+	}
+	return migrator.CheckVersion()
 }
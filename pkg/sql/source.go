@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sql
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/golang-migrate/migrate/source"
+)
+
+// fsSource is a source of migrations that reads them from the root directory
+// of a file system, for example one embedded in the binary.
+type fsSource struct {
+	fsys       fs.FS
+	migrations *source.Migrations
+}
+
+// newFSSource loads the names of the migrations of the file system. Files
+// whose names don't follow the format of migrations are ignored.
+func newFSSource(fsys fs.FS) (*fsSource, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	migrations := source.NewMigrations()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		migration, err := source.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+		if !migrations.Append(migration) {
+			return nil, fmt.Errorf("migration '%s' is duplicated", entry.Name())
+		}
+	}
+	return &fsSource{
+		fsys:       fsys,
+		migrations: migrations,
+	}, nil
+}
+
+// Open is part of the source.Driver interface. The source isn't registered,
+// so it can't be opened with a URL.
+func (s *fsSource) Open(url string) (source.Driver, error) {
+	return nil, fmt.Errorf("migrations file system can't be opened with URL '%s'", url)
+}
+
+func (s *fsSource) Close() error {
+	return nil
+}
+
+func (s *fsSource) First() (uint, error) {
+	version, ok := s.migrations.First()
+	if !ok {
+		return 0, notExist("first", 0)
+	}
+	return version, nil
+}
+
+func (s *fsSource) Prev(version uint) (uint, error) {
+	prev, ok := s.migrations.Prev(version)
+	if !ok {
+		return 0, notExist("prev", version)
+	}
+	return prev, nil
+}
+
+func (s *fsSource) Next(version uint) (uint, error) {
+	next, ok := s.migrations.Next(version)
+	if !ok {
+		return 0, notExist("next", version)
+	}
+	return next, nil
+}
+
+func (s *fsSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	migration, ok := s.migrations.Up(version)
+	if !ok {
+		return nil, "", notExist("read up", version)
+	}
+	return s.read(migration)
+}
+
+func (s *fsSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	migration, ok := s.migrations.Down(version)
+	if !ok {
+		return nil, "", notExist("read down", version)
+	}
+	return s.read(migration)
+}
+
+func (s *fsSource) read(migration *source.Migration) (io.ReadCloser, string, error) {
+	file, err := s.fsys.Open(migration.Raw)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, migration.Identifier, nil
+}
+
+// notExist returns the error that the migrations library expects when there
+// is no migration for a version.
+func notExist(operation string, version uint) error {
+	return &os.PathError{
+		Op:   operation,
+		Path: fmt.Sprintf("migration %d", version),
+		Err:  os.ErrNotExist,
+	}
+}
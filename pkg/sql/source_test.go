@@ -0,0 +1,73 @@
+package sql
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+// testMigrations is a file system with the same layout as the migrations
+// embedded in the services.
+var testMigrations = fstest.MapFS{
+	"1530102671_create_schema.up.sql":     {Data: []byte("CREATE TABLE clusters (id text);")},
+	"1530102671_create_schema.down.sql":   {Data: []byte("DROP TABLE clusters;")},
+	"1531224000_add_cluster_owner.up.sql": {Data: []byte("ALTER TABLE clusters ADD COLUMN owner text;")},
+	"README.md":                           {Data: []byte("Not a migration.")},
+}
+
+func TestFSSourceVersions(t *testing.T) {
+	src, err := newFSSource(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := src.First()
+	if err != nil || first != 1530102671 {
+		t.Errorf("expected first version 1530102671, got %d, %v", first, err)
+	}
+	next, err := src.Next(first)
+	if err != nil || next != 1531224000 {
+		t.Errorf("expected next version 1531224000, got %d, %v", next, err)
+	}
+	_, err = src.Next(next)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error after the last version, got %v", err)
+	}
+	_, err = src.Prev(first)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error before the first version, got %v", err)
+	}
+}
+
+func TestFSSourceRead(t *testing.T) {
+	src, err := newFSSource(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, identifier, err := src.ReadUp(1530102671)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identifier != "create_schema" || string(data) != "CREATE TABLE clusters (id text);" {
+		t.Errorf("unexpected migration '%s': %s", identifier, data)
+	}
+	_, _, err = src.ReadDown(1531224000)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing down migration, got %v", err)
+	}
+}
+
+func TestLatestVersionFS(t *testing.T) {
+	version, err := LatestVersionFS(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1531224000 {
+		t.Errorf("expected latest version 1531224000, got %d", version)
+	}
+}
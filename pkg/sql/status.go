@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// migrationFileRE matches the names of the files of the up migrations, and
+// extracts their version.
+var migrationFileRE = regexp.MustCompile(`^([0-9]+)_.*\.up\.sql$`)
+
+// LatestVersion returns the version of the newest migration in the schema
+// directory, or zero if there are no migrations.
+func LatestVersion(schemaPath string) (uint, error) {
+	return LatestVersionFS(os.DirFS(schemaPath))
+}
+
+// LatestVersionFS returns the version of the newest migration in the root
+// directory of the file system, or zero if there are no migrations.
+func LatestVersionFS(fsys fs.FS) (uint, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return 0, err
+	}
+	var latest uint64
+	for _, file := range files {
+		match := migrationFileRE.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version in migration '%s': %v", file.Name(), err)
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+	return uint(latest), nil
+}
+
+// SchemaVersion returns the version of the schema of the database, and
+// whether the last migration failed leaving it dirty. The version is zero if
+// no migration has been applied yet.
+func SchemaVersion(ctx context.Context, db *sql.DB) (version uint, dirty bool, err error) {
+	var value int64
+	err = db.QueryRowContext(ctx, `select version, dirty from schema_migrations limit 1`).Scan(&value, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42P01" {
+		// The table that contains the version doesn't exist yet:
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(value), dirty, nil
+}
+
+// CheckSchema checks that all the migrations of the schema directory have
+// been applied to the database, and that none of them failed.
+func CheckSchema(ctx context.Context, db *sql.DB, schemaPath string) error {
+	return CheckSchemaFS(ctx, db, os.DirFS(schemaPath))
+}
+
+// CheckSchemaFS checks that all the migrations of the root directory of the
+// file system have been applied to the database, and that none of them
+// failed.
+func CheckSchemaFS(ctx context.Context, db *sql.DB, fsys fs.FS) error {
+	latest, err := LatestVersionFS(fsys)
+	if err != nil {
+		return err
+	}
+	version, dirty, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration to version %d failed, the schema is dirty", version)
+	}
+	if version < latest {
+		return fmt.Errorf("schema version %d is older than the latest migration %d", version, latest)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package sql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLatestVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"1530102671_create_schema.up.sql",
+		"1530102671_create_schema.down.sql",
+		"1531224000_add_cluster_owner.up.sql",
+		"1599999999_not_a_migration.sql",
+		"README.md",
+	} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	version, err := LatestVersion(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1531224000 {
+		t.Errorf("expected latest version 1531224000, got %d", version)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// reloader keeps the certificate and client authorities loaded from the
+// files, and reloads them when the files change.
+type reloader struct {
+	config Config
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+
+	lock        sync.Mutex
+	checked     time.Time
+	stamps      map[string]fileStamp
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// fileStamp is the information used to detect changes in a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newReloader(config Config) (*reloader, error) {
+	r := new(reloader)
+	r.config = config
+	r.now = time.Now
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.checked = r.now()
+	return r, nil
+}
+
+func (r *reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.check()
+	return r.certificate, nil
+}
+
+func (r *reloader) getClientCAs() *x509.CertPool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.check()
+	return r.clientCAs
+}
+
+// check reloads the files if they have changed, at most once per reload
+// interval. Must be called with the lock held.
+func (r *reloader) check() {
+	now := r.now()
+	if now.Sub(r.checked) < r.config.ReloadInterval {
+		return
+	}
+	r.checked = now
+	stamps, err := r.stat()
+	if err != nil {
+		logging.Errorf("Can't check TLS files: %v", err)
+		return
+	}
+	if !r.changed(stamps) {
+		return
+	}
+	err = r.load()
+	if err != nil {
+		logging.Errorf("Can't reload TLS files, will keep using the previous ones: %v", err)
+		return
+	}
+	logging.Infof("Reloaded TLS certificate from '%s'", r.config.CertFile)
+}
+
+// reload loads the files again, even if they haven't changed or the reload
+// interval hasn't passed. If they can't be loaded the previous ones are kept.
+func (r *reloader) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err := r.load()
+	if err != nil {
+		return err
+	}
+	r.checked = r.now()
+	return nil
+}
+
+// files returns the names of the files used by the configuration.
+func (r *reloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *reloader) stat() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, file := range r.files() {
+		// Stat follows symbolic links, so changes are also detected when the
+		// files are replaced by changing a link, as done when secrets are
+		// updated in Kubernetes:
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+func (r *reloader) changed(stamps map[string]fileStamp) bool {
+	for file, stamp := range stamps {
+		if r.stamps[file] != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads all the files. The stamps are taken before reading, so that
+// changes made while reading are detected by the next check.
+func (r *reloader) load() error {
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("can't load certificate and key: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("file '%s' doesn't contain any certificate", r.config.ClientCAFile)
+		}
+	}
+	r.stamps = stamps
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	return nil
+}
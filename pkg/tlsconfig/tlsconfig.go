@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tlsconfig creates the TLS configuration of the servers, reloading
+// the certificates when they change on disk.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultMinVersion is the minimum TLS version accepted when no other is
+// configured.
+const DefaultMinVersion = "1.2"
+
+// DefaultReloadInterval is the minimum time between two checks of the
+// certificate files.
+const DefaultReloadInterval = 10 * time.Second
+
+// Config describes the TLS configuration of a server.
+type Config struct {
+	// CertFile and KeyFile are the files containing the PEM encoded
+	// certificate and private key of the server. If both are empty TLS is
+	// disabled.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the file containing the PEM encoded certificates of
+	// the authorities that sign the certificates of the clients. If it isn't
+	// empty clients must present a certificate signed by one of them.
+	ClientCAFile string
+
+	// MinVersion is the minimum TLS version accepted, '1.0', '1.1', '1.2'
+	// or '1.3'. If empty DefaultMinVersion is used.
+	MinVersion string
+
+	// ReloadInterval is the minimum time between two checks of the files.
+	// If zero DefaultReloadInterval is used.
+	ReloadInterval time.Duration
+}
+
+// ConfigFromEnv creates the configuration from the TLS_CERT_FILE,
+// TLS_KEY_FILE, TLS_CLIENT_CA_FILE and TLS_MIN_VERSION environment
+// variables.
+func ConfigFromEnv() Config {
+	return Config{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		MinVersion:   os.Getenv("TLS_MIN_VERSION"),
+	}
+}
+
+// ParseVersion converts a TLS version like '1.2' to the corresponding
+// constant of the crypto/tls package.
+func ParseVersion(text string) (uint16, error) {
+	switch text {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version '%s', valid versions are '1.0', '1.1', '1.2' and '1.3'", text)
+	}
+}
+
+// New creates the TLS configuration of a server, or returns nil if TLS is
+// disabled. The certificate, the key and the client authorities are loaded
+// immediately, and are reloaded during the handshakes when the files
+// change. If the new files can't be loaded the error is logged and the
+// previous ones are kept.
+func New(config Config) (*tls.Config, error) {
+	result, _, err := NewReloadable(config)
+	return result, err
+}
+
+// NewReloadable is like New, but also returns a function that reloads the
+// files immediately, without waiting for them to change, for example when
+// the process receives SIGHUP. The function does nothing if TLS is disabled.
+func NewReloadable(config Config) (*tls.Config, func() error, error) {
+	noReload := func() error {
+		return nil
+	}
+	if config.CertFile == "" && config.KeyFile == "" {
+		if config.ClientCAFile != "" {
+			return nil, nil, fmt.Errorf("client certificates can't be verified without a server certificate")
+		}
+		return nil, noReload, nil
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, nil, fmt.Errorf("both the certificate and the key files are mandatory")
+	}
+	if config.MinVersion == "" {
+		config.MinVersion = DefaultMinVersion
+	}
+	version, err := ParseVersion(config.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = DefaultReloadInterval
+	}
+	files, err := newReloader(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &tls.Config{
+		MinVersion:     version,
+		GetCertificate: files.getCertificate,
+	}
+	if config.ClientCAFile != "" {
+		// The authorities are part of the configuration, so it needs to be
+		// created for each connection to use the reloaded ones:
+		result.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:     version,
+				GetCertificate: files.getCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      files.getClientCAs(),
+			}, nil
+		}
+	}
+	return result, files.reload, nil
+}
+
+// Serve calls the ServeTLS method of the server if it has a TLS
+// configuration, and the Serve method otherwise.
+func Serve(server *http.Server, listener net.Listener) error {
+	if server.TLSConfig != nil {
+		return server.ServeTLS(listener, "", "")
+	}
+	return server.Serve(listener)
+}
@@ -0,0 +1,300 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, generated for the tests.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert generates a certificate signed by the given parent, or a self
+// signed authority if the parent is nil.
+func newTestCert(t *testing.T, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer := key
+	parentCert := template
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer = parent.key
+		parentCert = parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and the key in PEM format to the given files.
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	err := ioutil.WriteFile(certFile, certPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestNewChecksConfig(t *testing.T) {
+	result, err := New(Config{})
+	if err != nil || result != nil {
+		t.Errorf("expected TLS to be disabled without files, got %v and %v", result, err)
+	}
+	invalid := []Config{
+		{CertFile: "tls.crt"},
+		{KeyFile: "tls.key"},
+		{ClientCAFile: "ca.crt"},
+		{CertFile: "missing.crt", KeyFile: "missing.key"},
+	}
+	for _, config := range invalid {
+		_, err := New(config)
+		if err == nil {
+			t.Errorf("expected configuration %+v to be rejected", config)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	version, err := ParseVersion("1.2")
+	if err != nil || version != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2, got %x and %v", version, err)
+	}
+	version, err = ParseVersion("1.3")
+	if err != nil || version != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3, got %x and %v", version, err)
+	}
+	_, err = ParseVersion("1.5")
+	if err == nil {
+		t.Errorf("expected unknown version to be rejected")
+	}
+}
+
+func TestReloadsChangedCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	ca := newTestCert(t, 1, nil)
+	newTestCert(t, 2, ca).write(t, certFile, keyFile)
+
+	now := time.Now()
+	files, err := newReloader(Config{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.now = func() time.Time { return now }
+	files.checked = now
+	serial := func() int64 {
+		certificate, err := files.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+	if serial() != 2 {
+		t.Fatalf("expected initial certificate")
+	}
+
+	// Files are checked only once per interval:
+	rotated := newTestCert(t, 3, ca)
+	rotated.write(t, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if serial() != 2 {
+		t.Errorf("expected certificate not to be reloaded before the interval")
+	}
+	now = now.Add(time.Second)
+	if serial() != 3 {
+		t.Errorf("expected rotated certificate to be reloaded")
+	}
+
+	// Broken files are ignored, and the previous certificate is kept:
+	err = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	if serial() != 3 {
+		t.Errorf("expected previous certificate to be kept when the new one is broken")
+	}
+}
+
+func TestForcedReload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	ca := newTestCert(t, 1, nil)
+	newTestCert(t, 2, ca).write(t, certFile, keyFile)
+
+	config, reload, err := NewReloadable(Config{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		certificate, err := config.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+
+	// The reload doesn't wait for the interval:
+	newTestCert(t, 3, ca).write(t, certFile, keyFile)
+	err = reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial() != 3 {
+		t.Errorf("expected certificate to be reloaded immediately")
+	}
+
+	// Broken files are reported, and the previous certificate is kept:
+	err = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reload() == nil {
+		t.Errorf("expected an error reloading a broken key")
+	}
+	if serial() != 3 {
+		t.Errorf("expected previous certificate to be kept when the new one is broken")
+	}
+}
+
+func TestRequiresClientCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := newTestCert(t, 1, nil)
+	ca.write(t, caFile, "")
+	newTestCert(t, 2, ca).write(t, certFile, keyFile)
+	client := newTestCert(t, 3, ca)
+
+	config, err := New(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(certificates []tls.Certificate) error {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: certificates,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Read(make([]byte, 2))
+		return err
+	}
+	err = dial([]tls.Certificate{client.tlsCertificate()})
+	if err != nil {
+		t.Errorf("expected client with certificate to connect, got %v", err)
+	}
+	err = dial(nil)
+	if err == nil {
+		t.Errorf("expected client without certificate to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Names of the exporters.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config describes how the spans of a service are exported.
+type Config struct {
+	// ServiceName is the name of the service reported in the spans.
+	ServiceName string
+
+	// Exporter is 'none' (the default), 'otlp', 'stdout' or 'file'.
+	Exporter string
+
+	// Endpoint is the traces URL of the OTLP collector. The default is
+	// DefaultOTLPEndpoint.
+	Endpoint string
+
+	// File is the file where the spans are written by the file exporter.
+	File string
+
+	// SampleRatio is the ratio of the traces started by the service that
+	// are exported, between 0 and 1.
+	SampleRatio float64
+}
+
+// ConfigFromEnv returns the configuration given in the standard
+// OpenTelemetry environment variables: OTEL_SERVICE_NAME, which replaces the
+// given default service name, OTEL_TRACES_EXPORTER,
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT, and
+// OTEL_TRACES_SAMPLER_ARG. The file of the file exporter is given in
+// TRACES_FILE.
+func ConfigFromEnv(serviceName string) (Config, error) {
+	config := Config{
+		ServiceName: serviceName,
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+		Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		File:        os.Getenv("TRACES_FILE"),
+		SampleRatio: 1,
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		config.ServiceName = name
+	}
+	if config.Endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			config.Endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	if value := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG '%s': %v", value, err)
+		}
+		config.SampleRatio = ratio
+	}
+	return config, nil
+}
+
+// New creates the tracer described by the configuration. It returns nil,
+// which doesn't create spans, if the exporter is 'none' or empty.
+func New(config Config) (*Tracer, error) {
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample ratio %g isn't between 0 and 1", config.SampleRatio)
+	}
+	var exporter Exporter
+	switch strings.ToLower(config.Exporter) {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		endpoint := config.Endpoint
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		exporter = NewOTLPExporter(endpoint)
+	case ExporterStdout:
+		exporter = NewWriterExporter(os.Stdout)
+	case ExporterFile:
+		if config.File == "" {
+			return nil, fmt.Errorf("the file exporter needs a file")
+		}
+		var err error
+		exporter, err = NewFileExporter(config.File)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf(
+			"unknown exporter '%s', valid exporters are 'none', 'otlp', 'stdout' and 'file'",
+			config.Exporter,
+		)
+	}
+	return NewTracer(config.ServiceName, exporter, config.SampleRatio), nil
+}
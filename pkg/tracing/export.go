@@ -0,0 +1,365 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+)
+
+// Defaults of the OTLP exporter.
+const (
+	DefaultOTLPEndpoint  = "http://localhost:4318/v1/traces"
+	defaultBatchSize     = 512
+	defaultQueueSize     = 4096
+	defaultFlushInterval = 5 * time.Second
+)
+
+// WriterExporter writes each span as a JSON object in a line, for offline
+// debugging.
+type WriterExporter struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// NewWriterExporter creates an exporter that writes the spans to the given
+// writer, for example the standard output. If the writer is also an
+// io.Closer it is closed when the exporter shuts down.
+func NewWriterExporter(out io.Writer) *WriterExporter {
+	exporter := new(WriterExporter)
+	exporter.out = out
+	return exporter
+}
+
+// NewFileExporter creates an exporter that appends the spans to the given
+// file, creating it if it doesn't exist.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterExporter(file), nil
+}
+
+// writerSpan is the representation of the spans written by the writer
+// exporter.
+type writerSpan struct {
+	Service    string                 `json:"service"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	Duration   float64                `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+var kindNames = map[SpanKind]string{
+	SpanKindInternal: "internal",
+	SpanKindServer:   "server",
+	SpanKindClient:   "client",
+}
+
+// Export writes the span.
+func (e *WriterExporter) Export(span *SpanData) {
+	record := writerSpan{
+		Service:  span.Service,
+		TraceID:  span.Context.TraceID.String(),
+		SpanID:   span.Context.SpanID.String(),
+		Name:     span.Name,
+		Kind:     kindNames[span.Kind],
+		Start:    span.Start.UTC(),
+		Duration: span.End.Sub(span.Start).Seconds(),
+	}
+	if span.Parent.IsValid() {
+		record.ParentID = span.Parent.String()
+	}
+	if len(span.Attributes) > 0 {
+		record.Attributes = make(map[string]interface{}, len(span.Attributes))
+		for _, attribute := range span.Attributes {
+			record.Attributes[attribute.Key] = attribute.Value
+		}
+	}
+	if span.Failed {
+		record.Error = span.StatusMessage
+		if record.Error == "" {
+			record.Error = "failed"
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		logging.Errorf("Can't encode span '%s': %v", span.Name, err)
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.out.Write(append(data, '\n'))
+}
+
+// Shutdown closes the writer, if it can be closed and it isn't one of the
+// standard streams.
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.out == os.Stdout || e.out == os.Stderr {
+		return nil
+	}
+	if closer, ok := e.out.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// OTLPExporter sends the spans in batches to an OpenTelemetry collector,
+// using the JSON encoding of the OTLP/HTTP protocol. Spans are dropped, with
+// a warning, if the queue is full because the collector isn't available.
+type OTLPExporter struct {
+	endpoint      string
+	client        *http.Client
+	batchSize     int
+	flushInterval time.Duration
+	queue         chan *SpanData
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewOTLPExporter creates an exporter that sends the spans to the given
+// traces URL of a collector, for example 'http://collector:4318/v1/traces',
+// and starts sending them in the background.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	exporter := new(OTLPExporter)
+	exporter.endpoint = endpoint
+	exporter.client = &http.Client{Timeout: 10 * time.Second}
+	exporter.batchSize = defaultBatchSize
+	exporter.flushInterval = defaultFlushInterval
+	exporter.queue = make(chan *SpanData, defaultQueueSize)
+	exporter.done = make(chan struct{})
+	go exporter.run()
+	return exporter
+}
+
+// Export queues the span to be sent.
+func (e *OTLPExporter) Export(span *SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+		logging.Warnf("Dropping span '%s', the export queue is full", span.Name)
+	}
+}
+
+// Shutdown sends the queued spans and stops the exporter. Spans exported
+// after calling it are lost.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.closeOnce.Do(func() {
+		close(e.queue)
+	})
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends the queued spans when there are enough for a batch, or
+// periodically.
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*SpanData, 0, e.batchSize)
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// send sends a batch of spans. Failures are logged, and the spans are lost.
+func (e *OTLPExporter) send(batch []*SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+	data, err := json.Marshal(otlpRequest(batch))
+	if err != nil {
+		logging.Errorf("Can't encode %d spans: %v", len(batch), err)
+		return
+	}
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logging.Warnf("Can't send %d spans to '%s': %v", len(batch), e.endpoint, err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		logging.Warnf("Can't send %d spans to '%s', status is %d: %s",
+			len(batch), e.endpoint, response.StatusCode, body)
+	}
+}
+
+// Types used to encode the OTLP export requests, as described by the JSON
+// mapping of the OTLP protocol buffers. Identifiers are hexadecimal, and 64
+// bit integers are strings.
+type (
+	otlpExportRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		TraceState        string         `json:"traceState,omitempty"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// Status codes of the OTLP spans.
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+// otlpRequest converts the spans to an OTLP export request, grouping them by
+// service.
+func otlpRequest(batch []*SpanData) *otlpExportRequest {
+	request := new(otlpExportRequest)
+	index := make(map[string]int)
+	for _, span := range batch {
+		i, ok := index[span.Service]
+		if !ok {
+			i = len(request.ResourceSpans)
+			index[span.Service] = i
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{
+					Attributes: []otlpKeyValue{otlpAttribute("service.name", span.Service)},
+				},
+				ScopeSpans: []otlpScopeSpans{{
+					Scope: otlpScope{Name: "github.com/container-mgmt/dedicated-portal/pkg/tracing"},
+				}},
+			})
+		}
+		scope := &request.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, otlpSpanOf(span))
+	}
+	return request
+}
+
+func otlpSpanOf(span *SpanData) otlpSpan {
+	result := otlpSpan{
+		TraceID:           span.Context.TraceID.String(),
+		SpanID:            span.Context.SpanID.String(),
+		TraceState:        span.Context.TraceState,
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusUnset},
+	}
+	if span.Parent.IsValid() {
+		result.ParentSpanID = span.Parent.String()
+	}
+	for _, attribute := range span.Attributes {
+		result.Attributes = append(result.Attributes, otlpAttribute(attribute.Key, attribute.Value))
+	}
+	if span.Failed {
+		result.Status = otlpStatus{Code: otlpStatusError, Message: span.StatusMessage}
+	}
+	return result
+}
+
+// otlpAttribute converts an attribute to the OTLP representation. Values of
+// unsupported types are converted to strings.
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	result := otlpKeyValue{Key: key}
+	switch typed := value.(type) {
+	case string:
+		result.Value.StringValue = &typed
+	case bool:
+		result.Value.BoolValue = &typed
+	case int:
+		text := strconv.Itoa(typed)
+		result.Value.IntValue = &text
+	case int64:
+		text := strconv.FormatInt(typed, 10)
+		result.Value.IntValue = &text
+	case float64:
+		result.Value.DoubleValue = &typed
+	default:
+		text := fmt.Sprintf("%v", value)
+		result.Value.StringValue = &text
+	}
+	return result
+}
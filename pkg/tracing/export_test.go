@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request to '%s'", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer("clusters-service", NewOTLPExporter(collector.URL+"/v1/traces"), 1)
+	start := time.Unix(1500000000, 0)
+	tracer.now = func() time.Time { return start }
+	_, span := tracer.Start(context.Background(), "sql list", SpanKindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.rows", 3)
+	span.SetError(errors.New("timeout"))
+	span.End()
+	err := tracer.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	err = json.Unmarshal(<-bodies, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	resource, _ := json.Marshal(request.ResourceSpans[0].Resource.Attributes)
+	if string(resource) != `[{"key":"service.name","value":{"stringValue":"clusters-service"}}]` {
+		t.Errorf("unexpected resource attributes %s", resource)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	exported := spans[0]
+	if exported["traceId"] != span.Context().TraceID.String() || exported["name"] != "sql list" ||
+		exported["kind"] != float64(SpanKindClient) || exported["startTimeUnixNano"] != "1500000000000000000" {
+		t.Errorf("unexpected span %+v", exported)
+	}
+	if _, ok := exported["parentSpanId"]; ok {
+		t.Errorf("expected root span not to have a parent")
+	}
+	attributes, _ := json.Marshal(exported["attributes"])
+	expected := `[{"key":"db.system","value":{"stringValue":"postgresql"}},{"key":"db.rows","value":{"intValue":"3"}}]`
+	if string(attributes) != expected {
+		t.Errorf("expected attributes %s, got %s", expected, attributes)
+	}
+	status, _ := json.Marshal(exported["status"])
+	if string(status) != `{"code":2,"message":"timeout"}` {
+		t.Errorf("unexpected status %s", status)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	tracer := NewTracer("customers-service", NewWriterExporter(buffer), 1)
+	_, span := tracer.Start(context.Background(), "GET /customers", SpanKindServer)
+	span.End()
+	var record map[string]interface{}
+	err := json.Unmarshal(buffer.Bytes(), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["service"] != "customers-service" || record["name"] != "GET /customers" ||
+		record["kind"] != "server" || record["trace_id"] != span.Context().TraceID.String() {
+		t.Errorf("unexpected record %s", buffer.String())
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	tracer, err := New(Config{Exporter: "none"})
+	if err != nil || tracer != nil {
+		t.Errorf("expected no tracer for exporter 'none', got %v and %v", tracer, err)
+	}
+	invalid := []Config{
+		{Exporter: "zipkin", SampleRatio: 1},
+		{Exporter: "file", SampleRatio: 1},
+		{Exporter: "stdout", SampleRatio: 2},
+	}
+	for _, config := range invalid {
+		_, err := New(config)
+		if err == nil {
+			t.Errorf("expected configuration %+v to be rejected", config)
+		}
+	}
+}
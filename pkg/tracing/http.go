@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/container-mgmt/dedicated-portal/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+// Middleware returns a function that wraps handlers so that each request has
+// a server span, child of the span context received in the request headers,
+// if any. It is suitable for the Use method of the gorilla/mux routers, as
+// the name of the span contains the path template of the route. The trace and
+// span identifiers are added to the logger of the request context.
+func Middleware(tracer *Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if remote, ok := Extract(r.Header); ok {
+				ctx = ContextWithRemoteSpanContext(ctx, remote)
+			}
+			route := r.URL.Path
+			if current := mux.CurrentRoute(r); current != nil {
+				template, err := current.GetPathTemplate()
+				if err == nil {
+					route = template
+				}
+			}
+			ctx, span := tracer.Start(ctx, r.Method+" "+route, SpanKindServer)
+			if span != nil {
+				sc := span.Context()
+				logger := logging.LoggerFromContext(ctx).
+					With("trace_id", sc.TraceID.String()).
+					With("span_id", sc.SpanID.String())
+				ctx = logging.ContextWithLogger(ctx, logger)
+			}
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", r.URL.Path)
+			span.SetAttribute("net.peer.addr", r.RemoteAddr)
+			if id := logging.RequestIDFromContext(ctx); id != "" {
+				span.SetAttribute("http.request_id", id)
+			}
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+			span.SetAttribute("http.status_code", recorder.status)
+			if recorder.status >= http.StatusInternalServerError {
+				span.SetError(fmt.Errorf("request failed with status %d", recorder.status))
+			}
+			span.End()
+		})
+	}
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.written {
+		r.status = status
+		r.written = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.written = true
+	return r.ResponseWriter.Write(data)
+}
+
+// Transport is an HTTP transport that creates a client span for each
+// request, and sends the trace context to the server in the request headers.
+type Transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+// NewTransport creates a transport that uses the given tracer, or the default
+// tracer if it is nil, and sends the requests with the given base transport,
+// or the default transport if it is nil. The trace context received by the
+// service is propagated even if there is no tracer.
+func NewTransport(tracer *Tracer, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport := new(Transport)
+	transport.tracer = tracer
+	transport.base = base
+	return transport
+}
+
+// RoundTrip sends the request with the trace context headers.
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	tracer := t.tracer
+	if tracer == nil {
+		tracer = Default()
+	}
+	ctx, span := tracer.Start(request.Context(), "HTTP "+request.Method, SpanKindClient)
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.url", request.URL.Scheme+"://"+request.URL.Host+request.URL.Path)
+	defer span.End()
+
+	// The round tripper must not modify the original request, so the
+	// headers are copied before adding the trace context:
+	outgoing := request.WithContext(ctx)
+	outgoing.Header = make(http.Header, len(request.Header)+2)
+	for name, values := range request.Header {
+		outgoing.Header[name] = values
+	}
+	Inject(ctx, outgoing.Header)
+	response, err := t.base.RoundTrip(outgoing)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", response.StatusCode)
+	if response.StatusCode >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("request failed with status %d", response.StatusCode))
+	}
+	return response, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareAndTransport(t *testing.T) {
+	exporter := new(recordingExporter)
+	tracer := NewTracer("test", exporter, 1)
+
+	// The backend records the trace context that it receives:
+	var received string
+	backend := httptest.NewServer(Middleware(tracer)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Get(TraceParentHeader)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	)))
+	defer backend.Close()
+
+	// The frontend calls the backend with a traced client:
+	client := &http.Client{Transport: NewTransport(tracer, nil)}
+	frontend := Middleware(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, _ := http.NewRequest("GET", backend.URL+"/backend", nil)
+		response, err := client.Do(request.WithContext(r.Context()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}))
+	request := httptest.NewRequest("GET", "/frontend", nil)
+	request.Header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	frontend.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("expected three spans, got %d", len(spans))
+	}
+	server, clientSpan, root := spans[0], spans[1], spans[2]
+	for _, span := range spans {
+		if span.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected span '%s' to be part of the incoming trace", span.Name)
+		}
+	}
+	if root.Name != "GET /frontend" || root.Kind != SpanKindServer || root.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected frontend span %+v", root)
+	}
+	if clientSpan.Kind != SpanKindClient || clientSpan.Parent != root.Context.SpanID || !clientSpan.Failed {
+		t.Errorf("unexpected client span %+v", clientSpan)
+	}
+	if server.Parent != clientSpan.Context.SpanID || !server.Failed {
+		t.Errorf("unexpected backend span %+v", server)
+	}
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + clientSpan.Context.SpanID.String() + "-01"
+	if received != expected {
+		t.Errorf("expected backend to receive '%s', got '%s'", expected, received)
+	}
+}
+
+func TestTransportPropagatesWithoutTracer(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(TraceParentHeader)
+	}))
+	defer backend.Close()
+
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	client := &http.Client{Transport: NewTransport(nil, nil)}
+	handler := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, _ := http.NewRequest("GET", backend.URL, nil)
+		response, err := client.Do(request.WithContext(r.Context()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}))
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(TraceParentHeader, incoming)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if received != incoming {
+		t.Errorf("expected trace context '%s' to be propagated, got '%s'", incoming, received)
+	}
+}
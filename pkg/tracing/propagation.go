@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Headers of the W3C trace context.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// sampledFlag is the bit of the trace flags that indicates that the trace is
+// sampled.
+const sampledFlag = 0x01
+
+// Inject adds the span context of the context to the headers of an outgoing
+// request, so that the spans of the other service are part of the same
+// trace.
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := 0
+	if sc.Sampled {
+		flags |= sampledFlag
+	}
+	header.Set(TraceParentHeader, fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags))
+	if sc.TraceState != "" {
+		header.Set(TraceStateHeader, sc.TraceState)
+	}
+}
+
+// Extract returns the span context contained in the headers of an incoming
+// request. The second result is false if there is no valid span context.
+func Extract(header http.Header) (SpanContext, bool) {
+	var sc SpanContext
+	value := strings.TrimSpace(header.Get(TraceParentHeader))
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	// Version 00 has exactly four parts, later versions may add more:
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 {
+		return sc, false
+	}
+	if !decodeHex(parts[1], sc.TraceID[:]) || !decodeHex(parts[2], sc.SpanID[:]) {
+		return sc, false
+	}
+	var flags [1]byte
+	if !decodeHex(parts[3], flags[:]) {
+		return sc, false
+	}
+	if !sc.IsValid() {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&sampledFlag != 0
+	sc.TraceState = header.Get(TraceStateHeader)
+	return sc, true
+}
+
+// decodeHex decodes a lower case hexadecimal value with exactly the size of
+// the destination.
+func decodeHex(value string, destination []byte) bool {
+	if len(value) != 2*len(destination) || strings.ToLower(value) != value {
+		return false
+	}
+	_, err := hex.Decode(destination, []byte(value))
+	return err == nil
+}
@@ -0,0 +1,324 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing contains a minimal implementation of OpenTelemetry
+// distributed tracing: spans for the HTTP handlers, the HTTP clients and the
+// datastores, propagation of the W3C trace context between services, and
+// exporters that send the spans to an OTLP collector or write them to a
+// file.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID is the identifier of a trace, shared by all its spans.
+type TraceID [16]byte
+
+// String returns the identifier in hexadecimal.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks that the identifier isn't all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID is the identifier of a span.
+type SpanID [8]byte
+
+// String returns the identifier in hexadecimal.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks that the identifier isn't all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span that is propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+
+	// Sampled indicates if the spans of the trace are exported.
+	Sampled bool
+
+	// TraceState is the content of the tracestate header received with the
+	// span context, forwarded unchanged.
+	TraceState string
+}
+
+// IsValid checks that both identifiers are valid.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship of the span with other spans.
+type SpanKind int
+
+// Kinds of spans, with the values used by OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute is a key and value that describes a span. The value can be a
+// string, a boolean, an integer or a floating point number.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is the finished span, as given to the exporters.
+type SpanData struct {
+	Service       string
+	Context       SpanContext
+	Parent        SpanID
+	Name          string
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Failed        bool
+	StatusMessage string
+}
+
+// Span is an operation within a trace. All the methods can be called on a
+// nil span, and then do nothing, so code doesn't need to check if tracing is
+// enabled.
+type Span struct {
+	tracer  *Tracer
+	lock    sync.Mutex
+	data    SpanData
+	ended   bool
+	sampled bool
+}
+
+// Context returns the span context of the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetName replaces the name of the span.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute adds an attribute to the span, replacing the previous value
+// if there is already an attribute with the same key.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed with the message of the error, if it
+// isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Failed = true
+	s.data.StatusMessage = err.Error()
+}
+
+// End finishes the span and exports it, if it is sampled. Calls after the
+// first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	data.Attributes = append([]Attribute(nil), s.data.Attributes...)
+	s.lock.Unlock()
+	if s.sampled {
+		s.tracer.exporter.Export(&data)
+	}
+}
+
+// Exporter sends the finished spans to their destination.
+type Exporter interface {
+	// Export sends or queues the span. It must not block for long, as it
+	// is called when the span ends.
+	Export(span *SpanData)
+
+	// Shutdown sends the queued spans and releases the resources of the
+	// exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer creates the spans of a service. All the methods can be called on a
+// nil tracer, that doesn't create spans.
+type Tracer struct {
+	service  string
+	exporter Exporter
+
+	// threshold is the maximum value of the first eight bytes of the
+	// identifiers of the traces that are sampled.
+	threshold uint64
+
+	// now returns the current time, replaced by the tests.
+	now func() time.Time
+}
+
+// NewTracer creates a tracer for the given service that sends the spans to
+// the given exporter. Only the given ratio of the traces started by this
+// service are sampled, between 0 and 1. Traces started by other services are
+// sampled if they were sampled there.
+func NewTracer(service string, exporter Exporter, ratio float64) *Tracer {
+	tracer := new(Tracer)
+	tracer.service = service
+	tracer.exporter = exporter
+	switch {
+	case ratio >= 1:
+		tracer.threshold = math.MaxUint64
+	case ratio > 0:
+		tracer.threshold = uint64(ratio * math.MaxUint64)
+	}
+	tracer.now = time.Now
+	return tracer
+}
+
+// Start starts a span with the given name and kind, child of the span of
+// the context, or of the remote span context of the context, if any. It
+// returns a copy of the context that contains the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := new(Span)
+	span.tracer = t
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.data.Context.TraceID = parent.TraceID
+		span.data.Context.TraceState = parent.TraceState
+		span.data.Parent = parent.SpanID
+		span.sampled = parent.Sampled
+	} else {
+		rand.Read(span.data.Context.TraceID[:])
+		span.sampled = t.threshold > 0 &&
+			binary.BigEndian.Uint64(span.data.Context.TraceID[:8]) <= t.threshold
+	}
+	rand.Read(span.data.Context.SpanID[:])
+	span.data.Context.Sampled = span.sampled
+	span.data.Service = t.service
+	span.data.Name = name
+	span.data.Kind = kind
+	span.data.Start = t.now()
+	return ContextWithSpan(ctx, span), span
+}
+
+// Shutdown sends the spans that haven't been exported yet.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// defaultTracer contains the tracer used by the package level functions.
+var defaultTracer atomic.Value
+
+// SetDefault replaces the tracer used by the package level functions. The
+// initial one is nil, so no spans are created until it is set.
+func SetDefault(tracer *Tracer) {
+	defaultTracer.Store(tracer)
+}
+
+// Default returns the tracer used by the package level functions.
+func Default() *Tracer {
+	tracer, _ := defaultTracer.Load().(*Tracer)
+	return tracer
+}
+
+// Start starts a span with the default tracer.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return Default().Start(ctx, name, kind)
+}
+
+// contextKey is the type of the keys of the values added to the context.
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// ContextWithSpan returns a copy of the context that contains the span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+// SpanFromContext returns the span of the context, or nil if it doesn't
+// contain one.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of the context that contains a
+// span context received from other service, that will be the parent of the
+// spans started with the context.
+func ContextWithRemoteSpanContext(ctx context.Context, remote SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, remote)
+}
+
+// SpanContextFromContext returns the span context of the span of the
+// context, or the remote span context if there is no span.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context()
+	}
+	remote, _ := ctx.Value(remoteKey).(SpanContext)
+	return remote
+}
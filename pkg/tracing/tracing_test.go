@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2018 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// recordingExporter keeps the exported spans in memory.
+type recordingExporter struct {
+	lock  sync.Mutex
+	spans []*SpanData
+}
+
+func (e *recordingExporter) Export(span *SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *recordingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (e *recordingExporter) Spans() []*SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
+
+func TestChildSpansShareTrace(t *testing.T) {
+	exporter := new(recordingExporter)
+	tracer := NewTracer("test", exporter, 1)
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindClient)
+	child.SetAttribute("db.system", "postgresql")
+	child.SetError(errors.New("broken"))
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected two spans to be exported, got %d", len(spans))
+	}
+	childData, parentData := spans[0], spans[1]
+	if childData.Context.TraceID != parentData.Context.TraceID {
+		t.Errorf("expected child to have the trace of the parent")
+	}
+	if childData.Parent != parentData.Context.SpanID || parentData.Parent.IsValid() {
+		t.Errorf("expected child to be a child of the parent")
+	}
+	if !childData.Failed || childData.StatusMessage != "broken" {
+		t.Errorf("expected child to be failed, got %+v", childData)
+	}
+	if len(childData.Attributes) != 1 || childData.Attributes[0].Value != "postgresql" {
+		t.Errorf("expected child to have one attribute, got %+v", childData.Attributes)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exporter := new(recordingExporter)
+	tracer := NewTracer("test", exporter, 0)
+	_, span := tracer.Start(context.Background(), "root", SpanKindServer)
+	span.End()
+	if len(exporter.Spans()) != 0 {
+		t.Errorf("expected no spans to be exported with ratio zero")
+	}
+
+	// Traces sampled by other services are sampled regardless of the ratio:
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	remote, ok := Extract(header)
+	if !ok {
+		t.Fatal("expected header to be valid")
+	}
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+	_, span = tracer.Start(ctx, "child", SpanKindServer)
+	span.End()
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("expected span of the remote trace to be exported, got %+v", spans)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "nothing", SpanKindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Errorf("expected nil tracer not to create spans")
+	}
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("ignored"))
+	span.End()
+	if tracer.Shutdown(context.Background()) != nil {
+		t.Errorf("expected nil tracer to shut down")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set(TraceParentHeader, test.header)
+		header.Set(TraceStateHeader, "vendor=value")
+		sc, ok := Extract(header)
+		if ok != test.valid {
+			t.Errorf("expected validity of '%s' to be %v", test.header, test.valid)
+			continue
+		}
+		if !ok || test.header[:2] != "00" {
+			continue
+		}
+		outgoing := http.Header{}
+		Inject(ContextWithRemoteSpanContext(context.Background(), sc), outgoing)
+		if outgoing.Get(TraceParentHeader) != test.header {
+			t.Errorf("expected '%s' to be injected, got '%s'", test.header, outgoing.Get(TraceParentHeader))
+		}
+		if outgoing.Get(TraceStateHeader) != "vendor=value" {
+			t.Errorf("expected trace state to be injected, got '%s'", outgoing.Get(TraceStateHeader))
+		}
+	}
+}